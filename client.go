@@ -12,6 +12,8 @@ import (
 	"net/textproto"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Client interface {
@@ -20,19 +22,35 @@ type Client interface {
 	GetTub(ctx context.Context, tub string) (Tub, error)                                                                                                                                             // Get /tubs/{tub}
 	UpdateTub(ctx context.Context, tub Tub) (Tub, error)                                                                                                                                             // Put /tubs/{tub}
 	DeleteTub(ctx context.Context, tub string) (Tub, error)                                                                                                                                          // Delete /tubs/{tub}
+	GetTubSettings(ctx context.Context) (TubSettings, error)                                                                                                                                         // Get /tub-settings
+	GetTubTokenUsage(ctx context.Context, tub string, since time.Time) ([]TokenUsage, error)                                                                                                         // Get /tubs/{tub}/usage
+	ReindexTub(ctx context.Context, tub string, stage string) (Reindex, error)                                                                                                                       // Post /tubs/{tub}/reindex
+	GetTubReindexes(ctx context.Context, tub string, limit, offset int) ([]Reindex, error)                                                                                                           // Get /tubs/{tub}/reindex
+	GetTubReindex(ctx context.Context, tub, reindexId string) (Reindex, error)                                                                                                                       // Get /tubs/{tub}/reindex/{reindex_id}
+	CancelTubReindex(ctx context.Context, tub, reindexId string) (Reindex, error)                                                                                                                    // Delete /tubs/{tub}/reindex/{reindex_id}
 	GetTubDocuments(ctx context.Context, tub string, filter DocumentFilter, sort DocumentSort, limit, offset int) ([]Document, error)                                                                      // Get /tubs/{tub}/documents
 	GetTubDocument(ctx context.Context, tub, documentId string) (Document, error)                                                                                                                    // Get /tubs/{tub}/documents/{document_id}
 	GetTubDocumentStatus(ctx context.Context, tub, documentId string) (DocumentStatus, error)                                                                                                        // Get /tubs/{tub}/documents/{document_id}
 	CreateTubDocument(ctx context.Context, tub string, file io.Reader, contentType string, headers map[string]string) (Document, error)                                                              // Post /tubs/{tub}/documents
+	CreateTubDocumentsFromArchive(ctx context.Context, tub string, archive io.Reader, contentType string, headers map[string]string) (ArchiveUpload, error)                                          // Post /tubs/{tub}/documents
 	CreateTubDocumentWithOptionals(ctx context.Context, tub string, file io.Reader, contentType string, markdown io.Reader, chunks []Chunk, headers map[string]string) (Document, error)             // Post /tubs/{tub}/documents (multipart)
 	UpdateTubDocument(ctx context.Context, tub, documentId string, file io.Reader, contentType string, headers map[string]string) (Document, error)                                                  // Put /tubs/{tub}/documents/{document_id}
 	UpdateTubDocumentWithOptionals(ctx context.Context, tub, documentId string, file io.Reader, contentType string, markdown io.Reader, chunks []Chunk, headers map[string]string) (Document, error) // Put /tubs/{tub}/documents/{document_id} (multipart)
 	DownloadTubDocument(ctx context.Context, tub, documentId string) (io.ReadCloser, error)                                                                                                          // Get /tubs/{tub}/documents/{document_id}/download
 	DownloadTubDocumentMarkdown(ctx context.Context, tub, documentId string) (io.ReadCloser, error)                                                                                                  // Get /tubs/{tub}/documents/{document_id}/download/markdown
+	GetTubDocumentPage(ctx context.Context, tub, documentId string, page int) (string, error)                                                                                                        // Get /tubs/{tub}/documents/{document_id}/pages/{page}
 	DeleteTubDocument(ctx context.Context, tub, documentId string) error                                                                                                                             // Delete /tubs/{tub}/documents/{document_id}
 	GetTubDocumentChunks(ctx context.Context, tub, documentId string, limit, offset int) ([]Chunk, error)                                                                                            // Get /tubs/{tub}/documents/{document_id}/chunks
 	GetTubDocumentChunk(ctx context.Context, tub, documentId string, index int) (Chunk, error)                                                                                                       // Get /tubs/{tub}/document/{document_id}/chunks/{index}
+	PreviewTubChunking(ctx context.Context, tub string, req ChunkPreviewRequest) (ChunkPreview, error)                                                                                               // Post /tubs/{tub}/chunking/preview
 	SearchTubDocumentChunks(ctx context.Context, tub, query string, documentFilter DocumentFilter, limit, offset int) ([]Chunk, error)                                                               // Get /search/xnn/{tub}
+	GetTubDocumentsWithFacets(ctx context.Context, tub string, filter DocumentFilter, sort DocumentSort, limit, offset int, facets ...FacetField) (FacetedDocuments, error)                          // Get /tubs/{tub}/documents/faceted
+	SearchTubDocumentChunksWithFacets(ctx context.Context, tub, query string, filter DocumentFilter, limit, offset int, facets ...FacetField) (FacetedChunks, error)                                 // Get /search/xnn/{tub}/faceted
+	BatchSearchTubDocumentChunks(ctx context.Context, tub string, queries []SearchQuery) ([]BatchSearchResult, error)                                                                                // Post /search/xnn/{tub}/batch
+	UpsertTubEvalSet(ctx context.Context, tub string, evalSet EvalSet) (EvalSet, error)                                                                                                              // Put /tubs/{tub}/evals/{eval_set}
+	GetTubEvalSet(ctx context.Context, tub, evalSetName string) (EvalSet, error)                                                                                                                     // Get /tubs/{tub}/evals/{eval_set}
+	RunTubEvalSet(ctx context.Context, tub, evalSetName string, k int) (EvalRun, error)                                                                                                              // Post /tubs/{tub}/evals/{eval_set}/runs
+	GetTubEvalRuns(ctx context.Context, tub, evalSetName string, limit, offset int) ([]EvalRun, error)                                                                                               // Get /tubs/{tub}/evals/{eval_set}/runs
 }
 
 type httpClient struct {
@@ -170,6 +188,12 @@ func (c *httpClient) DeleteTub(ctx context.Context, tub string) (Tub, error) {
 	return result, err
 }
 
+func (c *httpClient) GetTubSettings(ctx context.Context) (TubSettings, error) {
+	var result TubSettings
+	err := c.doJSONRequest(ctx, "GET", "/tub-settings", nil, nil, &result)
+	return result, err
+}
+
 func (c *httpClient) GetTubDocuments(ctx context.Context, tub string, filter DocumentFilter, sort DocumentSort, limit, offset int) ([]Document, error) {
 	path := fmt.Sprintf("/tubs/%s/documents", url.PathEscape(tub))
 
@@ -236,6 +260,32 @@ func (c *httpClient) CreateTubDocument(ctx context.Context, tub string, file io.
 	return document, nil
 }
 
+// CreateTubDocumentsFromArchive uploads a ZIP or tar(.gz) archive, by contentType, which is expanded into one document
+// per supported file with the headers applied to each
+func (c *httpClient) CreateTubDocumentsFromArchive(ctx context.Context, tub string, archive io.Reader, contentType string, headers map[string]string) (ArchiveUpload, error) {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers["Content-Type"] = contentType
+
+	resp, err := c.doRequest(ctx, "POST", fmt.Sprintf("/tubs/%s/documents", url.PathEscape(tub)), archive, nil, headers)
+	if err != nil {
+		return ArchiveUpload{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return ArchiveUpload{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	var upload ArchiveUpload
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return ArchiveUpload{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return upload, nil
+}
+
 func (c *httpClient) UpdateTubDocument(ctx context.Context, tub, documentId string, file io.Reader, contentType string, headers map[string]string) (Document, error) {
 	if headers == nil {
 		headers = make(map[string]string)
@@ -288,6 +338,24 @@ func (c *httpClient) DownloadTubDocumentMarkdown(ctx context.Context, tub, docum
 	return resp.Body, nil
 }
 
+func (c *httpClient) GetTubDocumentPage(ctx context.Context, tub, documentId string, page int) (string, error) {
+	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/tubs/%s/documents/%s/pages/%d", url.PathEscape(tub), url.PathEscape(documentId), page), nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	text, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return string(text), nil
+}
+
 func (c *httpClient) DeleteTubDocument(ctx context.Context, tub, documentId string) error {
 	resp, err := c.doRequest(ctx, "DELETE", fmt.Sprintf("/tubs/%s/documents/%s", url.PathEscape(tub), url.PathEscape(documentId)), nil, nil, nil)
 	if err != nil {
@@ -348,6 +416,171 @@ func (c *httpClient) SearchTubDocumentChunks(ctx context.Context, tub, query str
 	return chunks, err
 }
 
+func (c *httpClient) GetTubDocumentsWithFacets(ctx context.Context, tub string, filter DocumentFilter, sort DocumentSort, limit, offset int, facets ...FacetField) (FacetedDocuments, error) {
+	path := fmt.Sprintf("/tubs/%s/documents/faceted", url.PathEscape(tub))
+
+	params := map[string]string{"facets": formatFacetFields(facets)}
+	if len(filter) > 0 {
+		filterData, err := json.Marshal(filter)
+		if err != nil {
+			return FacetedDocuments{}, fmt.Errorf("failed to marshal filter: %w", err)
+		}
+		params["filter"] = string(filterData)
+	}
+	if len(sort) > 0 {
+		sortData, err := json.Marshal(sort)
+		if err != nil {
+			return FacetedDocuments{}, fmt.Errorf("failed to marshal sort: %w", err)
+		}
+		params["sort"] = string(sortData)
+	}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+	if offset > 0 {
+		params["offset"] = strconv.Itoa(offset)
+	}
+
+	var result FacetedDocuments
+	err := c.doJSONRequest(ctx, "GET", path, params, nil, &result)
+	return result, err
+}
+
+// SearchTubDocumentChunksWithFacets searches like SearchTubDocumentChunks, and counts the facets over the documents
+// of the top 100 hits
+func (c *httpClient) SearchTubDocumentChunksWithFacets(ctx context.Context, tub, query string, filter DocumentFilter, limit, offset int, facets ...FacetField) (FacetedChunks, error) {
+	path := fmt.Sprintf("/search/xnn/%s/faceted", url.PathEscape(tub))
+
+	params := map[string]string{"q": query, "facets": formatFacetFields(facets)}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+	if offset > 0 {
+		params["offset"] = strconv.Itoa(offset)
+	}
+	if len(filter) > 0 {
+		filterData, err := json.Marshal(filter)
+		if err != nil {
+			return FacetedChunks{}, fmt.Errorf("failed to marshal filter: %w", err)
+		}
+		params["filter"] = string(filterData)
+	}
+
+	var result FacetedChunks
+	err := c.doJSONRequest(ctx, "GET", path, params, nil, &result)
+	return result, err
+}
+
+func formatFacetFields(facets []FacetField) string {
+	parts := make([]string, 0, len(facets))
+	for _, f := range facets {
+		part := f.Field
+		if f.ValueType != "" {
+			part += ":" + string(f.ValueType)
+			if f.Buckets > 0 {
+				part += ":" + strconv.Itoa(f.Buckets)
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c *httpClient) PreviewTubChunking(ctx context.Context, tub string, req ChunkPreviewRequest) (ChunkPreview, error) {
+	var preview ChunkPreview
+	err := c.doJSONRequest(ctx, "POST", fmt.Sprintf("/tubs/%s/chunking/preview", url.PathEscape(tub)), nil, req, &preview)
+	return preview, err
+}
+
+func (c *httpClient) BatchSearchTubDocumentChunks(ctx context.Context, tub string, queries []SearchQuery) ([]BatchSearchResult, error) {
+	path := fmt.Sprintf("/search/xnn/%s/batch", url.PathEscape(tub))
+
+	var results []BatchSearchResult
+	err := c.doJSONRequest(ctx, "POST", path, nil, BatchSearchRequest{Queries: queries}, &results)
+	return results, err
+}
+
+func (c *httpClient) UpsertTubEvalSet(ctx context.Context, tub string, evalSet EvalSet) (EvalSet, error) {
+	var result EvalSet
+	err := c.doJSONRequest(ctx, "PUT", fmt.Sprintf("/tubs/%s/evals/%s", url.PathEscape(tub), url.PathEscape(evalSet.EvalSetName)), nil, evalSet, &result)
+	return result, err
+}
+
+func (c *httpClient) GetTubEvalSet(ctx context.Context, tub, evalSetName string) (EvalSet, error) {
+	var result EvalSet
+	err := c.doJSONRequest(ctx, "GET", fmt.Sprintf("/tubs/%s/evals/%s", url.PathEscape(tub), url.PathEscape(evalSetName)), nil, nil, &result)
+	return result, err
+}
+
+func (c *httpClient) RunTubEvalSet(ctx context.Context, tub, evalSetName string, k int) (EvalRun, error) {
+	var result EvalRun
+	err := c.doJSONRequest(ctx, "POST", fmt.Sprintf("/tubs/%s/evals/%s/runs", url.PathEscape(tub), url.PathEscape(evalSetName)), nil, EvalRunRequest{K: k}, &result)
+	return result, err
+}
+
+func (c *httpClient) GetTubEvalRuns(ctx context.Context, tub, evalSetName string, limit, offset int) ([]EvalRun, error) {
+	path := fmt.Sprintf("/tubs/%s/evals/%s/runs", url.PathEscape(tub), url.PathEscape(evalSetName))
+
+	params := map[string]string{}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+	if offset > 0 {
+		params["offset"] = strconv.Itoa(offset)
+	}
+
+	var runs []EvalRun
+	err := c.doJSONRequest(ctx, "GET", path, params, nil, &runs)
+	return runs, err
+}
+
+func (c *httpClient) GetTubTokenUsage(ctx context.Context, tub string, since time.Time) ([]TokenUsage, error) {
+	path := fmt.Sprintf("/tubs/%s/usage", url.PathEscape(tub))
+
+	params := map[string]string{}
+	if !since.IsZero() {
+		params["since"] = since.Format(time.RFC3339)
+	}
+
+	var usage []TokenUsage
+	err := c.doJSONRequest(ctx, "GET", path, params, nil, &usage)
+	return usage, err
+}
+
+func (c *httpClient) ReindexTub(ctx context.Context, tub string, stage string) (Reindex, error) {
+	var reindex Reindex
+	err := c.doJSONRequest(ctx, "POST", fmt.Sprintf("/tubs/%s/reindex", url.PathEscape(tub)), nil, ReindexRequest{Stage: stage}, &reindex)
+	return reindex, err
+}
+
+func (c *httpClient) GetTubReindexes(ctx context.Context, tub string, limit, offset int) ([]Reindex, error) {
+	path := fmt.Sprintf("/tubs/%s/reindex", url.PathEscape(tub))
+
+	params := map[string]string{}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+	if offset > 0 {
+		params["offset"] = strconv.Itoa(offset)
+	}
+
+	var reindexes []Reindex
+	err := c.doJSONRequest(ctx, "GET", path, params, nil, &reindexes)
+	return reindexes, err
+}
+
+func (c *httpClient) GetTubReindex(ctx context.Context, tub, reindexId string) (Reindex, error) {
+	var reindex Reindex
+	err := c.doJSONRequest(ctx, "GET", fmt.Sprintf("/tubs/%s/reindex/%s", url.PathEscape(tub), url.PathEscape(reindexId)), nil, nil, &reindex)
+	return reindex, err
+}
+
+func (c *httpClient) CancelTubReindex(ctx context.Context, tub, reindexId string) (Reindex, error) {
+	var reindex Reindex
+	err := c.doJSONRequest(ctx, "DELETE", fmt.Sprintf("/tubs/%s/reindex/%s", url.PathEscape(tub), url.PathEscape(reindexId)), nil, nil, &reindex)
+	return reindex, err
+}
+
 // CreateTubDocumentWithOptionals creates a document with optional markdown and chunks using multipart form data
 func (c *httpClient) CreateTubDocumentWithOptionals(ctx context.Context, tub string, file io.Reader, contentType string, markdown io.Reader, chunks []Chunk, headers map[string]string) (Document, error) {
 	return c.upsertTubDocumentWithOptionals(ctx, "POST", fmt.Sprintf("/tubs/%s/documents", url.PathEscape(tub)), file, contentType, markdown, chunks, headers)
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/modfin/ragnar"
+	"github.com/urfave/cli/v3"
+)
+
+// evalCommand runs an eval set against a running ragnar server through the HTTP API, so that
+// the exact same search path as regular clients is measured
+func evalCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "eval",
+		Usage: "run a retrieval evaluation set against a tub and print recall@k, MRR and nDCG",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "access-key",
+				Required: true,
+				Usage:    "the access key used to talk to the ragnar server",
+				Sources:  cli.EnvVars("RAGNAR_ACCESS_KEY"),
+			},
+			&cli.StringFlag{
+				Name:     "tub",
+				Required: true,
+				Usage:    "the tub to evaluate",
+			},
+			&cli.StringFlag{
+				Name:     "eval-set",
+				Required: true,
+				Usage:    "the name of the eval set",
+			},
+			&cli.StringFlag{
+				Name:  "file",
+				Usage: "optional json file with eval queries, uploaded as the eval set before running",
+			},
+			&cli.IntFlag{
+				Name:  "k",
+				Value: 10,
+				Usage: "the number of search results considered per query",
+			},
+			&cli.IntFlag{
+				Name:  "history",
+				Value: 10,
+				Usage: "the number of previous runs to print for comparison",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			client := ragnar.NewClient(ragnar.ClientConfig{
+				BaseURL:   cmd.String("http-uri"),
+				AccessKey: cmd.String("access-key"),
+			})
+			tub := cmd.String("tub")
+			evalSetName := cmd.String("eval-set")
+
+			if file := cmd.String("file"); file != "" {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read eval file: %w", err)
+				}
+				var queries ragnar.EvalQueries
+				err = json.Unmarshal(data, &queries)
+				if err != nil {
+					return fmt.Errorf("failed to parse eval file, expected a json array of queries: %w", err)
+				}
+				_, err = client.UpsertTubEvalSet(ctx, tub, ragnar.EvalSet{EvalSetName: evalSetName, Queries: queries})
+				if err != nil {
+					return fmt.Errorf("failed to upload eval set: %w", err)
+				}
+			}
+
+			run, err := client.RunTubEvalSet(ctx, tub, evalSetName, int(cmd.Int("k")))
+			if err != nil {
+				return fmt.Errorf("failed to run eval set: %w", err)
+			}
+			for _, r := range run.Results {
+				if r.Error != "" {
+					fmt.Fprintf(os.Stderr, "query failed: %q: %s\n", r.Query, r.Error)
+				}
+			}
+
+			runs, err := client.GetTubEvalRuns(ctx, tub, evalSetName, int(cmd.Int("history")), 0)
+			if err != nil {
+				return fmt.Errorf("failed to list eval runs: %w", err)
+			}
+			return printEvalRuns(runs)
+		},
+	}
+}
+
+func printEvalRuns(runs []ragnar.EvalRun) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tCREATED\tK\tQUERIES\tFAILED\tRECALL\tMRR\tNDCG\tEMBED MODEL\tSETTINGS")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%.4f\t%.4f\t%.4f\t%s\t%s\n",
+			run.EvalRunId,
+			run.CreatedAt.Format("2006-01-02 15:04"),
+			run.Metrics.K,
+			run.Metrics.Queries,
+			run.Metrics.Failed,
+			run.Metrics.Recall,
+			run.Metrics.MRR,
+			run.Metrics.NDCG,
+			run.EmbedModel,
+			formatSettings(run.Settings),
+		)
+	}
+	return w.Flush()
+}
+
+func formatSettings(settings map[string]*string) string {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if settings[k] == nil {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", k, *settings[k]))
+	}
+	return strings.Join(parts, " ")
+}
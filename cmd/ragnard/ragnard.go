@@ -18,6 +18,7 @@ import (
 	"github.com/modfin/ragnar/internal/ai"
 	"github.com/modfin/ragnar/internal/dao"
 	"github.com/modfin/ragnar/internal/dao/docket"
+	"github.com/modfin/ragnar/internal/document"
 	"github.com/modfin/ragnar/internal/storage"
 	"github.com/modfin/ragnar/internal/web"
 	"github.com/urfave/cli/v3"
@@ -27,11 +28,12 @@ type Config struct {
 	Log        *slog.Logger
 	Production bool `cli:"production"`
 
-	DAO     dao.Config
-	Storage storage.Config
-	Web     web.Config
-	Docket  docket.Config
-	AI      ai.Config
+	DAO        dao.Config
+	Storage    storage.Config
+	Web        web.Config
+	Docket     docket.Config
+	AI         ai.Config
+	Converters document.Config
 }
 
 func setLogger(level string, format string) {
@@ -133,6 +135,54 @@ func main() {
 				Usage:   "the maximum size of a post request",
 				Sources: cli.EnvVars("RAGNAR_HTTP_POST_LIMIT"),
 			},
+			&cli.IntFlag{
+				Name:    "archive-max-entries",
+				Value:   1000,
+				Usage:   "the maximum number of entries of an uploaded archive",
+				Sources: cli.EnvVars("RAGNAR_ARCHIVE_MAX_ENTRIES"),
+			},
+			&cli.IntFlag{
+				Name:    "archive-max-size",
+				Value:   1 << 30, // 1GB
+				Usage:   "the maximum total uncompressed size of the files of an uploaded archive",
+				Sources: cli.EnvVars("RAGNAR_ARCHIVE_MAX_SIZE"),
+			},
+			&cli.IntFlag{
+				Name:    "search-batch-max-queries",
+				Value:   1000,
+				Usage:   "the maximum number of queries in a batch search request",
+				Sources: cli.EnvVars("RAGNAR_SEARCH_BATCH_MAX_QUERIES"),
+			},
+			&cli.IntFlag{
+				Name:    "search-batch-parallelism",
+				Value:   8,
+				Usage:   "the maximum number of concurrent database searches per batch search request",
+				Sources: cli.EnvVars("RAGNAR_SEARCH_BATCH_PARALLELISM"),
+			},
+			&cli.IntFlag{
+				Name:    "reindex-concurrency",
+				Value:   10,
+				Usage:   "the maximum number of documents of a tub reindex processed at the same time",
+				Sources: cli.EnvVars("RAGNAR_REINDEX_CONCURRENCY"),
+			},
+
+			&cli.DurationFlag{
+				Name:    "converter-timeout",
+				Value:   30 * time.Second,
+				Usage:   "the timeout of pdftotext and pandoc converting a document, of reading a page or image with OCR, and of command converters without a timeout",
+				Sources: cli.EnvVars("RAGNAR_CONVERTER_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "ocr-timeout",
+				Value:   4 * time.Minute,
+				Usage:   "the timeout of reading all scanned pages of a PDF with OCR, pages not read in time are left out",
+				Sources: cli.EnvVars("RAGNAR_OCR_TIMEOUT"),
+			},
+			&cli.StringFlag{
+				Name:    "converters-file",
+				Usage:   "a JSON file of external command converters",
+				Sources: cli.EnvVars("RAGNAR_CONVERTERS_FILE"),
+			},
 
 			&cli.StringFlag{
 				Name:    "bellman-uri",
@@ -195,15 +245,22 @@ func main() {
 						return err
 					}
 
+					l.Info("Creating converters..")
+					converters, err := document.NewRegistry(cfg.Converters)
+					if err != nil {
+						l.Error("failed to create converters", "err", err)
+						return err
+					}
+
 					l.Info("Creating docket..")
-					d, err := docket.New(slog.Default().With("who", "pqdocket"), db, stor, ai_, cfg.Docket)
+					d, err := docket.New(slog.Default().With("who", "pqdocket"), db, stor, ai_, converters, cfg.Docket)
 					if err != nil {
 						l.Error("failed to create docket", "err", err)
 						return err
 					}
 
 					l.Info("Creating web..")
-					app := web.New(slog.Default().With("who", "web"), db, stor, d, ai_, cfg.Web)
+					app := web.New(slog.Default().With("who", "web"), db, stor, d, ai_, converters, cfg.Web)
 					if err != nil {
 						l.Error("failed to create web", "err", err)
 						return err
@@ -246,6 +303,7 @@ func main() {
 					return nil
 				},
 			},
+			evalCommand(),
 		},
 	}
 	if err := cmd.Run(context.Background(), os.Args); err != nil {
@@ -0,0 +1,500 @@
+package ragnar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The filter query language is a compact alternative to JSON filters, e.g.
+//
+//	department:legal AND priority>=10 AND (author:anna OR author:erik) -status:draft
+//
+// A term is field:value for equality or field>value, field>=value, field<value, field<=value for comparisons.
+// Values containing spaces or parentheses are double quoted. Terms are combined with AND, OR and parentheses,
+// adjacent terms are AND-ed, and NOT or a leading - negates. Comparisons against integer or decimal values
+// are made numerically. Since DocumentFilter is a conjunction, OR may only combine equality terms on the same field.
+
+// FilterExpr is a node of a parsed filter query
+type FilterExpr interface {
+	filterExpr()
+}
+
+// FilterAnd matches when all of its expressions match
+type FilterAnd struct {
+	Exprs []FilterExpr
+}
+
+// FilterOr matches when any of its expressions match
+type FilterOr struct {
+	Exprs []FilterExpr
+	Pos   int
+}
+
+// FilterNot matches when its expression does not match
+type FilterNot struct {
+	Expr FilterExpr
+	Pos  int
+}
+
+// FilterTerm compares a document header, or the document_id, with a value
+type FilterTerm struct {
+	Field    string
+	Operator FilterOperator
+	Value    string
+	Pos      int
+}
+
+func (FilterAnd) filterExpr()  {}
+func (FilterOr) filterExpr()   {}
+func (FilterNot) filterExpr()  {}
+func (FilterTerm) filterExpr() {}
+
+// FilterQueryError is returned for invalid filter queries, Pos is the 1-based character position of the problem
+type FilterQueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterQueryError) Error() string {
+	return fmt.Sprintf("filter query error at position %d: %s", e.Pos, e.Msg)
+}
+
+// ParseDocumentFilterQuery parses a filter query into a DocumentFilter
+func ParseDocumentFilterQuery(query string) (DocumentFilter, error) {
+	expr, err := ParseFilterQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return FilterExprToDocumentFilter(expr)
+}
+
+// ParseFilterQuery parses a filter query into its expression tree. An empty query gives an empty FilterAnd.
+func ParseFilterQuery(query string) (FilterExpr, error) {
+	tokens, err := lexFilterQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return FilterAnd{}, nil
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &FilterQueryError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+	return expr, nil
+}
+
+// FilterExprToDocumentFilter converts the expression to a DocumentFilter. Negations are pushed down to the terms,
+// and every OR must reduce to equality terms on a single field, which become an array ($in) filter. A negated
+// equality becomes $ne, a negated comparison becomes a $not condition, so that both also match documents without
+// the field.
+func FilterExprToDocumentFilter(expr FilterExpr) (DocumentFilter, error) {
+	filter := NewDocumentFilter()
+	err := addFilterExpr(filter, negationNormalForm(expr, false))
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// negationNormalForm pushes negations down to the terms using De Morgan's laws. Negated comparisons are kept as a
+// FilterNot of the term, since flipping the operator would leave out the documents without the field.
+func negationNormalForm(expr FilterExpr, negate bool) FilterExpr {
+	switch e := expr.(type) {
+	case FilterNot:
+		return negationNormalForm(e.Expr, !negate)
+	case FilterAnd:
+		exprs := make([]FilterExpr, len(e.Exprs))
+		for i, sub := range e.Exprs {
+			exprs[i] = negationNormalForm(sub, negate)
+		}
+		if negate {
+			return FilterOr{Exprs: exprs, Pos: firstFilterPos(e.Exprs)}
+		}
+		return FilterAnd{Exprs: exprs}
+	case FilterOr:
+		exprs := make([]FilterExpr, len(e.Exprs))
+		for i, sub := range e.Exprs {
+			exprs[i] = negationNormalForm(sub, negate)
+		}
+		if negate {
+			return FilterAnd{Exprs: exprs}
+		}
+		return FilterOr{Exprs: exprs, Pos: e.Pos}
+	case FilterTerm:
+		if !negate {
+			return e
+		}
+		switch e.Operator {
+		case OpEqual:
+			e.Operator = OpNotEqual
+		case OpNotEqual:
+			e.Operator = OpEqual
+		default:
+			return FilterNot{Expr: e, Pos: e.Pos}
+		}
+		return e
+	}
+	return expr
+}
+
+func firstFilterPos(exprs []FilterExpr) int {
+	for _, e := range exprs {
+		switch e := e.(type) {
+		case FilterTerm:
+			return e.Pos
+		case FilterOr:
+			return e.Pos
+		case FilterNot:
+			return e.Pos
+		case FilterAnd:
+			if pos := firstFilterPos(e.Exprs); pos > 0 {
+				return pos
+			}
+		}
+	}
+	return 1
+}
+
+func addFilterExpr(filter DocumentFilter, expr FilterExpr) error {
+	switch e := expr.(type) {
+	case FilterAnd:
+		for _, sub := range e.Exprs {
+			err := addFilterExpr(filter, sub)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case FilterOr:
+		terms, err := flattenFilterOr(e)
+		if err != nil {
+			return err
+		}
+		values := make([]string, 0, len(terms))
+		for _, t := range terms {
+			if t.Operator != OpEqual {
+				return &FilterQueryError{Pos: t.Pos, Msg: "OR can only combine equality terms such as field:value"}
+			}
+			if t.Field != terms[0].Field {
+				return &FilterQueryError{Pos: t.Pos, Msg: fmt.Sprintf("OR can only combine terms on the same field, got %s and %s", terms[0].Field, t.Field)}
+			}
+			values = append(values, t.Value)
+		}
+		filter[terms[0].Field] = append(filter[terms[0].Field], FilterValue{Array: values})
+		return nil
+	case FilterTerm:
+		filter[e.Field] = append(filter[e.Field], filterTermValue(e))
+		return nil
+	case FilterNot:
+		term, ok := e.Expr.(FilterTerm)
+		if !ok {
+			return &FilterQueryError{Pos: e.Pos, Msg: fmt.Sprintf("unsupported negated expression %T", e.Expr)}
+		}
+		value := filterTermValue(term)
+		value.Condition.Not = true
+		filter[term.Field] = append(filter[term.Field], value)
+		return nil
+	}
+	return &FilterQueryError{Pos: 1, Msg: fmt.Sprintf("unsupported expression %T", expr)}
+}
+
+func flattenFilterOr(or FilterOr) ([]FilterTerm, error) {
+	var terms []FilterTerm
+	for _, sub := range or.Exprs {
+		switch e := sub.(type) {
+		case FilterTerm:
+			terms = append(terms, e)
+		case FilterOr:
+			nested, err := flattenFilterOr(e)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, nested...)
+		case FilterAnd:
+			if len(e.Exprs) == 1 {
+				nested, err := flattenFilterOr(FilterOr{Exprs: e.Exprs, Pos: or.Pos})
+				if err != nil {
+					return nil, err
+				}
+				terms = append(terms, nested...)
+				continue
+			}
+			return nil, &FilterQueryError{Pos: firstFilterPos(e.Exprs), Msg: "AND inside OR is not supported"}
+		case FilterNot:
+			return nil, &FilterQueryError{Pos: e.Pos, Msg: "OR can only combine equality terms such as field:value"}
+		default:
+			return nil, &FilterQueryError{Pos: or.Pos, Msg: fmt.Sprintf("unsupported expression %T inside OR", sub)}
+		}
+	}
+	return terms, nil
+}
+
+func filterTermValue(t FilterTerm) FilterValue {
+	if t.Operator == OpEqual {
+		value := t.Value
+		return FilterValue{Simple: &value}
+	}
+	valueType := ValueTypeText
+	if t.Operator != OpNotEqual {
+		if _, err := strconv.ParseInt(t.Value, 10, 64); err == nil {
+			valueType = ValueTypeInteger
+		} else if _, err := strconv.ParseFloat(t.Value, 64); err == nil {
+			valueType = ValueTypeNumeric
+		}
+	}
+	return FilterValue{Condition: &FilterCondition{
+		Operator:  t.Operator,
+		Value:     t.Value,
+		ValueType: valueType,
+	}}
+}
+
+type filterTokenKind int
+
+const (
+	tokEOF filterTokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokTerm
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	pos  int
+	term FilterTerm
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokLParen:
+		return "'('"
+	case tokRParen:
+		return "')'"
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	}
+	return fmt.Sprintf("term %s", t.term.Field)
+}
+
+func isFilterFieldRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func lexFilterQuery(query string) ([]filterToken, error) {
+	runes := []rune(query)
+	var tokens []filterToken
+
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: tokLParen, pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: tokRParen, pos: pos})
+			i++
+		case r == '-' && (i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == '('):
+			tokens = append(tokens, filterToken{kind: tokNot, pos: pos})
+			i++
+		case isFilterFieldRune(r):
+			start := i
+			for i < len(runes) && isFilterFieldRune(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			if i == len(runes) || !strings.ContainsRune(":<>", runes[i]) {
+				switch word {
+				case "AND":
+					tokens = append(tokens, filterToken{kind: tokAnd, pos: pos})
+					continue
+				case "OR":
+					tokens = append(tokens, filterToken{kind: tokOr, pos: pos})
+					continue
+				case "NOT":
+					tokens = append(tokens, filterToken{kind: tokNot, pos: pos})
+					continue
+				}
+				return nil, &FilterQueryError{Pos: pos, Msg: fmt.Sprintf("expected an operator (:, >, >=, <, <=) after field %q", word)}
+			}
+
+			var op FilterOperator
+			switch {
+			case runes[i] == ':':
+				op = OpEqual
+				i++
+			case runes[i] == '>' && i+1 < len(runes) && runes[i+1] == '=':
+				op = OpGreaterThanOrEqual
+				i += 2
+			case runes[i] == '>':
+				op = OpGreaterThan
+				i++
+			case runes[i] == '<' && i+1 < len(runes) && runes[i+1] == '=':
+				op = OpLessThanOrEqual
+				i += 2
+			default:
+				op = OpLessThan
+				i++
+			}
+
+			value, next, err := lexFilterValue(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			tokens = append(tokens, filterToken{kind: tokTerm, pos: pos, term: FilterTerm{
+				Field:    strings.ToLower(word),
+				Operator: op,
+				Value:    value,
+				Pos:      pos,
+			}})
+		default:
+			return nil, &FilterQueryError{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, filterToken{kind: tokEOF, pos: len(runes) + 1}), nil
+}
+
+// lexFilterValue reads a bare or double quoted value starting at i, returning the value and the position after it
+func lexFilterValue(runes []rune, i int) (string, int, error) {
+	if i < len(runes) && runes[i] == '"' {
+		start := i
+		var sb strings.Builder
+		i++
+		for i < len(runes) {
+			switch runes[i] {
+			case '\\':
+				if i+1 < len(runes) {
+					sb.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				i++
+			case '"':
+				return sb.String(), i + 1, nil
+			default:
+				sb.WriteRune(runes[i])
+				i++
+			}
+		}
+		return "", 0, &FilterQueryError{Pos: start + 1, Msg: "unterminated quoted value"}
+	}
+
+	start := i
+	for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+		i++
+	}
+	if start == i {
+		return "", 0, &FilterQueryError{Pos: start + 1, Msg: "expected a value"}
+	}
+	return string(runes[start:i]), i, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	i      int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.i]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// parseOr parses: and ("OR" and)*
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	pos := p.peek().pos
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []FilterExpr{first}
+	for p.peek().kind == tokOr {
+		p.next()
+		expr, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	if len(exprs) == 1 {
+		return first, nil
+	}
+	return FilterOr{Exprs: exprs, Pos: pos}, nil
+}
+
+// parseAnd parses: unary (["AND"] unary)*
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []FilterExpr{first}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokTerm, tokNot, tokLParen:
+		default:
+			if len(exprs) == 1 {
+				return first, nil
+			}
+			return FilterAnd{Exprs: exprs}, nil
+		}
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+}
+
+// parseUnary parses: ("NOT" | "-") unary | "(" or ")" | term
+func (p *filterParser) parseUnary() (FilterExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNot:
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return FilterNot{Expr: expr, Pos: t.pos}, nil
+	case tokLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &FilterQueryError{Pos: closing.pos, Msg: fmt.Sprintf("expected ')' to close '(' at position %d, got %s", t.pos, closing)}
+		}
+		return expr, nil
+	case tokTerm:
+		return t.term, nil
+	}
+	return nil, &FilterQueryError{Pos: t.pos, Msg: fmt.Sprintf("expected a term, '(' or NOT, got %s", t)}
+}
@@ -0,0 +1,135 @@
+package ragnar
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDocumentFilterQuery(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		query   string
+		want    DocumentFilter
+		wantPos int
+	}{
+		{
+			name:  "empty",
+			query: "  ",
+			want:  DocumentFilter{},
+		},
+		{
+			name:  "request example",
+			query: `department:legal AND priority>=10 AND (author:anna OR author:erik) -status:draft`,
+			want: DocumentFilter{
+				"department": {{Simple: str("legal")}},
+				"priority":   {{Condition: &FilterCondition{Operator: OpGreaterThanOrEqual, Value: "10", ValueType: ValueTypeInteger}}},
+				"author":     {{Array: []string{"anna", "erik"}}},
+				"status":     {{Condition: &FilterCondition{Operator: OpNotEqual, Value: "draft", ValueType: ValueTypeText}}},
+			},
+		},
+		{
+			name:  "quoted value and implicit and",
+			query: `title:"annual report (2024)" score<1.5`,
+			want: DocumentFilter{
+				"title": {{Simple: str("annual report (2024)")}},
+				"score": {{Condition: &FilterCondition{Operator: OpLessThan, Value: "1.5", ValueType: ValueTypeNumeric}}},
+			},
+		},
+		{
+			name:  "negated or and comparison",
+			query: `NOT (status:draft OR status:deleted) -date<2024-01-01`,
+			want: DocumentFilter{
+				"status": {
+					{Condition: &FilterCondition{Operator: OpNotEqual, Value: "draft", ValueType: ValueTypeText}},
+					{Condition: &FilterCondition{Operator: OpNotEqual, Value: "deleted", ValueType: ValueTypeText}},
+				},
+				"date": {{Condition: &FilterCondition{Operator: OpLessThan, Value: "2024-01-01", ValueType: ValueTypeText, Not: true}}},
+			},
+		},
+		{
+			name:  "hyphenated field",
+			query: `project-id:p-1`,
+			want: DocumentFilter{
+				"project-id": {{Simple: str("p-1")}},
+			},
+		},
+		{
+			name:    "or across fields",
+			query:   `a:1 OR b:2`,
+			wantPos: 8,
+		},
+		{
+			name:    "or with comparison",
+			query:   `a:1 OR a>2`,
+			wantPos: 8,
+		},
+		{
+			name:    "missing close paren",
+			query:   `(a:1 OR a:2`,
+			wantPos: 12,
+		},
+		{
+			name:    "missing operator",
+			query:   `a:1 AND legal`,
+			wantPos: 9,
+		},
+		{
+			name:    "unterminated quote",
+			query:   `a:"x`,
+			wantPos: 3,
+		},
+		{
+			name:    "dangling and",
+			query:   `a:1 AND`,
+			wantPos: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDocumentFilterQuery(tt.query)
+			if tt.wantPos > 0 {
+				var qerr *FilterQueryError
+				if !errors.As(err, &qerr) {
+					t.Fatalf("expected FilterQueryError, got %v", err)
+				}
+				if qerr.Pos != tt.wantPos {
+					t.Fatalf("expected error at position %d, got %v", tt.wantPos, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegatedFilterJSON(t *testing.T) {
+	filter, err := ParseDocumentFilterQuery(`-priority>=10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"priority":{"$not":{"$gte":"10","type":"integer"}}}`; string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+
+	var got DocumentFilter
+	err = json.Unmarshal(data, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, filter) {
+		t.Fatalf("got %#v, want %#v", got, filter)
+	}
+}
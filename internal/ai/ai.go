@@ -4,12 +4,19 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/modfin/bellman"
+	"github.com/modfin/bellman/models"
 	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/bellman/models/gen"
+	"github.com/modfin/bellman/prompt"
+	"github.com/modfin/bellman/services/voyageai"
 	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/tokenizer"
 )
 
 type Config struct {
@@ -72,7 +79,68 @@ func (ai *AI) EmbedChunks(model embed.Model, chunks []ragnar.Chunk) ([][]float32
 	return resp.AsFloat32(), nil
 }
 
+// EmbedStrings embeds many independent texts, e.g. search queries, in as few requests as possible. Contextual
+// models only work with the contextual document embedding endpoint, so each text is embedded as a document of its
+// own with them, as EmbedString does, and the vectors are the same as those of EmbedString.
+func (ai *AI) EmbedStrings(model embed.Model, texts []string) ([][]float32, error) {
+	if contextualEmbedModel(model) {
+		return ai.embedDocumentStrings(model, texts)
+	}
+
+	result := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxTextsPerEmbedRequest {
+		end := min(start+maxTextsPerEmbedRequest, len(texts))
+		resp, err := ai.bell.Embed(embed.NewManyRequest(context.Background(), model, texts[start:end]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed texts %d-%d: %w", start, end-1, err)
+		}
+		vectors := resp.AsFloat32()
+		if len(vectors) != end-start {
+			return nil, fmt.Errorf("embedding API mismatch: sent %d texts but received %d embeddings", end-start, len(vectors))
+		}
+		result = append(result, vectors...)
+	}
+	return result, nil
+}
+
+// embedDocumentStrings embeds each text as a document of its own, running at most maxConcurrentEmbedRequests
+// requests at a time
+func (ai *AI) embedDocumentStrings(model embed.Model, texts []string) ([][]float32, error) {
+	result := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	sem := make(chan struct{}, maxConcurrentEmbedRequests)
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result[i], errs[i] = ai.embedDocumentString(model, text)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed text %d: %w", i, err)
+		}
+	}
+	return result, nil
+}
+
+// EmbedString embeds a single text, e.g. a search query, with the endpoint of the model, see EmbedStrings
 func (ai *AI) EmbedString(model embed.Model, s string) ([]float32, error) {
+	if contextualEmbedModel(model) {
+		return ai.embedDocumentString(model, s)
+	}
+	vectors, err := ai.EmbedStrings(model, []string{s})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (ai *AI) embedDocumentString(model embed.Model, s string) ([]float32, error) {
 	resp, err := ai.bell.EmbedDocument(embed.NewDocumentRequest(context.Background(), model, []string{s}))
 	if err != nil {
 		return nil, err
@@ -88,72 +156,53 @@ const (
 	// add some initial chunks to each batch to provide context
 	initialDocumentChunksPerBatch = 5
 	defaultMaxModelTokenLength    = 32000
-	charactersPerTokenEstimate    = 4
+	// share of the model token limit that is packed when the tokenizer only approximates the model's own,
+	// leaving room for the difference in counts
+	approximateTokenizerHeadroom = 0.9
+	// retries of a document with smaller batches, each retry packs batches of retryBatchShrink of the tokens
+	maxEmbedRetries  = 3
+	retryBatchShrink = 0.75
+	// upper bound of texts sent in a single many-embed request
+	maxTextsPerEmbedRequest = 128
+	// upper bound of concurrent requests when texts are embedded one by one
+	maxConcurrentEmbedRequests = 8
 )
 
+// EmbedDocument embeds the chunks of a document with the contextual document embedding endpoint. Chunks are
+// packed into batches by their token counts, each batch after the first repeats the initial chunks of the document.
+// When the tokenizer only approximates the model's own, a batch rejected with a 400, likely for exceeding the context
+// length, is retried with smaller batches. VoyageAI and the other providers neither publish a tokenizer usable from
+// Go nor count tokens through their APIs, so the headroom can fall short for text that their tokenizers split into
+// many more tokens than cl100k_base, such as some non-latin scripts, and the retry is what remains for those.
 func (ai *AI) EmbedDocument(model embed.Model, chunks []ragnar.Chunk) ([][]float32, error) {
 	if len(chunks) == 0 {
 		return [][]float32{}, nil
 	}
 	documentId := chunks[0].DocumentId
-	l := ai.log.With("document_id", documentId, "total_chunks", len(chunks), "model", model.FQN())
+	tok := tokenizer.ForModel(model)
+	l := ai.log.With("document_id", documentId, "total_chunks", len(chunks), "model", model.FQN(), "tokenizer", tok.Name())
 
-	chars := 0
 	chunkTexts := make([]string, len(chunks))
 	for i, chunk := range chunks {
 		chunkTexts[i] = chunk.Content
-		chars += len(chunk.Content)
-	}
-	modelMaxTokens := model.InputMaxTokens
-	if modelMaxTokens == 0 {
-		modelMaxTokens = defaultMaxModelTokenLength
-	}
-	charsPerToken := charactersPerTokenEstimate
-
-	var resp *embed.DocumentResponse
-	var err error
-	for charsPerToken >= 1 {
-		var tokens int
-		batches, initialBatchChunks := chunksToBatches(l, modelMaxTokens, chunkTexts, charsPerToken)
-		result := make([][]float32, 0, len(chunks))
-		for idx, batch := range batches {
-			resp, err = ai.bell.EmbedDocument(embed.NewDocumentRequest(context.Background(), model, batch))
-			if err != nil && strings.Contains(err.Error(), "400") {
-				// likely context length exceeded, try smaller batches
-				l.Warn("embedding batch failed, likely context length exceeded, will retry with smaller batches",
-					"batch_index", idx,
-					"batch_size", len(batch),
-					"used_chars_per_token_estimate", charsPerToken,
-					"error", err,
-				)
-				charsPerToken -= 1
-				break
-			}
-			if err != nil {
-				return nil, fmt.Errorf("failed to embed batch %d/%d: %w", idx+1, len(batches), err)
-			}
-
-			batchEmbeddings := resp.AsFloat32()
-			if len(batchEmbeddings) != len(batch) {
-				return nil, fmt.Errorf(
-					"embedding API mismatch in batch %d: sent %d chunks but received %d embeddings",
-					idx, len(batch), len(batchEmbeddings),
-				)
-			}
-			tokens += resp.Metadata.TotalTokens
+	}
+	maxTokens := model.InputMaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultMaxModelTokenLength
+	}
+	if !tok.Exact() {
+		maxTokens = int(float64(maxTokens) * approximateTokenizerHeadroom)
+	}
 
-			l.Info("embedded document chunk batch",
-				"batch_index", idx,
-				"tokens", resp.Metadata.TotalTokens,
-				"batch_size", len(batch),
+	for attempt := 0; ; attempt++ {
+		batches, countedTokens := chunksToBatches(l, tok, maxTokens, chunkTexts)
+		result, tokens, err := ai.embedBatches(l, model, batches)
+		if err != nil && !tok.Exact() && attempt < maxEmbedRetries && strings.Contains(err.Error(), "400") {
+			l.Warn("embedding batch failed, likely context length exceeded, will retry with smaller batches",
+				"max_tokens", maxTokens,
+				"error", err,
 			)
-			if idx != 0 && initialBatchChunks > 0 {
-				// remove initial chunks from subsequent batches
-				batchEmbeddings = batchEmbeddings[initialBatchChunks:]
-			}
-			result = append(result, batchEmbeddings...)
-		}
-		if err != nil && strings.Contains(err.Error(), "400") {
+			maxTokens = int(float64(maxTokens) * retryBatchShrink)
 			continue
 		}
 		if err != nil {
@@ -165,64 +214,216 @@ func (ai *AI) EmbedDocument(model embed.Model, chunks []ragnar.Chunk) ([][]float
 		l.Info("successfully embedded document",
 			"total_chunks", len(chunks),
 			"total_tokens", tokens,
-			"total_characters", chars,
-			"used_chars_per_token_estimate", charsPerToken,
+			"counted_tokens", countedTokens,
+			"batches", len(batches),
+			"max_tokens", maxTokens,
 		)
 		return result, nil
 	}
+}
+
+// embedBatches embeds the batches in order, returning the embeddings of the chunks without the repeated initial
+// chunks, and the tokens used
+func (ai *AI) embedBatches(l *slog.Logger, model embed.Model, batches []embedBatch) ([][]float32, int, error) {
+	var result [][]float32
+	tokens := 0
+	for idx, batch := range batches {
+		resp, err := ai.bell.EmbedDocument(embed.NewDocumentRequest(context.Background(), model, batch.texts))
+		if err != nil {
+			return nil, tokens, fmt.Errorf("failed to embed batch %d/%d: %w", idx+1, len(batches), err)
+		}
+
+		batchEmbeddings := resp.AsFloat32()
+		if len(batchEmbeddings) != len(batch.texts) {
+			return nil, tokens, fmt.Errorf(
+				"embedding API mismatch in batch %d: sent %d chunks but received %d embeddings",
+				idx, len(batch.texts), len(batchEmbeddings),
+			)
+		}
+		tokens += resp.Metadata.TotalTokens
+
+		l.Info("embedded document chunk batch",
+			"batch_index", idx,
+			"tokens", resp.Metadata.TotalTokens,
+			"batch_size", len(batch.texts),
+		)
+		// remove the repeated initial chunks
+		result = append(result, batchEmbeddings[batch.skip:]...)
+	}
+	return result, tokens, nil
+}
+
+// contextNeighbours is the number of chunks on each side of a changed chunk sent along with it to a contextual
+// embedding model, when only some chunks of a document are embedded
+const contextNeighbours = 2
+
+// contextualEmbedModels are the models, by FQN, that embed each chunk in the light of the rest of the document
+// rather than each chunk on its own. They only work with the contextual document embedding endpoint
+var contextualEmbedModels = map[string]bool{
+	voyageai.EmbedModel_voyage_context_3.FQN(): true,
+}
+
+func contextualEmbedModel(model embed.Model) bool {
+	return contextualEmbedModels[model.FQN()]
+}
+
+// EmbedChangedChunks embeds the chunks of a document that are marked as changed and returns their vectors in
+// order. For contextual models the changed chunks are sent together with their neighbours and the initial chunks
+// of the document, so that they are embedded in the same context as the rest of the document
+func (ai *AI) EmbedChangedChunks(model embed.Model, chunks []ragnar.Chunk, changed []bool) ([][]float32, error) {
+	if len(chunks) != len(changed) {
+		return nil, fmt.Errorf("got %d chunks but %d changed flags", len(chunks), len(changed))
+	}
+	send, keep := chunksToEmbed(changed, contextualEmbedModel(model))
+	if len(send) == 0 {
+		return [][]float32{}, nil
+	}
+	sendChunks := make([]ragnar.Chunk, len(send))
+	for i, idx := range send {
+		sendChunks[i] = chunks[idx]
+	}
+
+	vectors, err := ai.EmbedDocument(model, sendChunks)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]float32, 0, len(vectors))
+	for i, vector := range vectors {
+		if keep[i] {
+			result = append(result, vector)
+		}
+	}
+	return result, nil
+}
+
+// chunksToEmbed returns the indexes of the chunks to send for embedding, and for each of them whether its vector is
+// kept, when only the changed chunks need new vectors
+func chunksToEmbed(changed []bool, contextual bool) ([]int, []bool) {
+	var send []int
+	var keep []bool
+	for i := range changed {
+		include := changed[i]
+		if contextual && !include {
+			include = i < initialDocumentChunksPerBatch
+			for j := max(0, i-contextNeighbours); j <= min(len(changed)-1, i+contextNeighbours); j++ {
+				include = include || changed[j]
+			}
+		}
+		if include {
+			send = append(send, i)
+			keep = append(keep, changed[i])
+		}
+	}
+	if !slices.Contains(keep, true) {
+		return nil, nil
+	}
+	return send, keep
+}
 
-	return nil, fmt.Errorf("could not embed document, all chunk batching attempts failed, %w", err)
+// embedBatch is a batch of chunk texts sent in one request, the first skip texts are initial chunks of the
+// document repeated for context whose embeddings are discarded
+type embedBatch struct {
+	texts []string
+	skip  int
 }
 
-func chunksToBatches(log *slog.Logger, modelMaxTokens int, chunkTexts []string, charsPerToken int) ([][]string, int) {
-	var batches [][]string
-	var currentBatch []string
-	currentBatchTokens := 0
+// chunksToBatches packs chunks into batches of at most maxTokens, returning the batches and the number of tokens
+// counted over all chunks
+func chunksToBatches(log *slog.Logger, tok tokenizer.Tokenizer, maxTokens int, chunkTexts []string) ([]embedBatch, int) {
+	counts := make([]int, len(chunkTexts))
+	total := 0
+	for i, chunkText := range chunkTexts {
+		counts[i] = tok.Count(chunkText)
+		total += counts[i]
+	}
 
 	var initialBatchChunks []string
 	var initialBatchTokens int
 	for i := 0; i < len(chunkTexts) && i < initialDocumentChunksPerBatch; i++ {
 		initialBatchChunks = append(initialBatchChunks, chunkTexts[i])
-		initialBatchTokens += len(chunkTexts[i]) / charsPerToken
+		initialBatchTokens += counts[i]
 	}
-	if initialBatchTokens > modelMaxTokens/2 {
-		log.Warn("initial document chunks exceed half of estimated model token limit",
-			"estimated_tokens", initialBatchTokens,
-			"model_max_tokens", modelMaxTokens,
+	if initialBatchTokens > maxTokens/2 {
+		log.Warn("initial document chunks exceed half of model token limit",
+			"tokens", initialBatchTokens,
+			"max_tokens", maxTokens,
 		)
 		initialBatchChunks = nil
 		initialBatchTokens = 0
 	}
 
-	for _, chunkText := range chunkTexts {
-		chunkEstimatedTokens := len(chunkText) / charsPerToken
+	var batches []embedBatch
+	var current embedBatch
+	currentTokens := 0
+	// newBatch starts a batch after the first one, with the initial chunks for context
+	newBatch := func() {
+		current = embedBatch{texts: append([]string{}, initialBatchChunks...), skip: len(initialBatchChunks)}
+		currentTokens = initialBatchTokens
+	}
+	flush := func() {
+		if len(current.texts) > current.skip {
+			batches = append(batches, current)
+		}
+	}
 
-		if chunkEstimatedTokens > modelMaxTokens {
-			log.Warn("single document chunk exceeds estimated model token limit",
-				"estimated_tokens", chunkEstimatedTokens,
-				"model_max_tokens", modelMaxTokens,
+	for i, chunkText := range chunkTexts {
+		chunkTokens := counts[i]
+
+		if chunkTokens > maxTokens {
+			log.Warn("single document chunk exceeds model token limit",
+				"tokens", chunkTokens,
+				"max_tokens", maxTokens,
 			)
-			if len(currentBatch) > 0 {
-				batches = append(batches, currentBatch)
-			}
-			batches = append(batches, []string{chunkText})
-			currentBatch = initialBatchChunks
-			currentBatchTokens = initialBatchTokens
+			flush()
+			batches = append(batches, embedBatch{texts: []string{chunkText}})
+			newBatch()
 			continue
 		}
 
-		if len(currentBatch) > 0 && (currentBatchTokens+chunkEstimatedTokens) > modelMaxTokens {
-			batches = append(batches, currentBatch)
-			currentBatch = append(initialBatchChunks, chunkText)
-			currentBatchTokens = initialBatchTokens + chunkEstimatedTokens
-		} else {
-			currentBatch = append(currentBatch, chunkText)
-			currentBatchTokens += chunkEstimatedTokens
+		if len(current.texts) > current.skip && currentTokens+chunkTokens > maxTokens {
+			flush()
+			newBatch()
 		}
+		current.texts = append(current.texts, chunkText)
+		currentTokens += chunkTokens
 	}
+	flush()
+	return batches, total
+}
+
+const (
+	// documents are cut to at most this many bytes, at a character boundary, when sent as context for a chunk
+	maxContextualizeDocumentBytes = 400_000
+	contextualizeMaxTokens        = 300
+)
+
+const contextualizePrompt = `<document>
+%s
+</document>
+Here is the chunk we want to situate within the whole document
+<chunk>
+%s
+</chunk>
+Please give a short succinct context, one or two sentences, to situate this chunk within the overall document for the purposes of improving search retrieval of the chunk. Answer only with the succinct context and nothing else.`
 
-	if len(currentBatch) > 0 {
-		batches = append(batches, currentBatch)
+// ContextualizeChunk has the model write a short context situating the chunk within the document markdown,
+// returning the context and the token usage of the call
+func (ai *AI) ContextualizeChunk(model gen.Model, documentMarkdown string, chunk string) (string, models.Metadata, error) {
+	if len(documentMarkdown) > maxContextualizeDocumentBytes {
+		cut := maxContextualizeDocumentBytes
+		for cut > 0 && !utf8.RuneStart(documentMarkdown[cut]) {
+			cut--
+		}
+		documentMarkdown = documentMarkdown[:cut]
+	}
+	resp, err := ai.bell.Generator(gen.WithModel(model), gen.WithMaxTokens(contextualizeMaxTokens)).
+		Prompt(prompt.AsUser(fmt.Sprintf(contextualizePrompt, documentMarkdown, chunk)))
+	if err != nil {
+		return "", models.Metadata{}, err
+	}
+	text, err := resp.AsText()
+	if err != nil {
+		return "", resp.Metadata, fmt.Errorf("could not read contextualize response: %w", err)
 	}
-	return batches, len(initialBatchChunks)
+	return strings.TrimSpace(text), resp.Metadata, nil
 }
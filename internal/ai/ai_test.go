@@ -0,0 +1,98 @@
+package ai
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/services/voyageai"
+)
+
+// quarterTokenizer counts a token per 4 characters, so that the token counts of tests are easy to follow
+type quarterTokenizer struct{}
+
+func (quarterTokenizer) Count(text string) int { return (utf8.RuneCountInString(text) + 3) / 4 }
+func (quarterTokenizer) Exact() bool           { return false }
+func (quarterTokenizer) Name() string          { return "quarter" }
+
+func TestChunksToBatches(t *testing.T) {
+	chunk := func(tokens int, c string) string { return strings.Repeat(c, tokens*4) }
+	a, b, c, d, e, f := chunk(10, "a"), chunk(10, "b"), chunk(10, "c"), chunk(10, "d"), chunk(10, "e"), chunk(10, "f")
+	big := chunk(200, "x")
+
+	tests := []struct {
+		name      string
+		maxTokens int
+		chunks    []string
+		want      []embedBatch
+	}{
+		{
+			name:      "fits one batch",
+			maxTokens: 100,
+			chunks:    []string{a, b, c},
+			want:      []embedBatch{{texts: []string{a, b, c}}},
+		},
+		{
+			name:      "later batches repeat the initial chunks",
+			maxTokens: 110,
+			chunks:    []string{a, b, c, d, e, f, f, f, f, f, f, f},
+			want: []embedBatch{
+				{texts: []string{a, b, c, d, e, f, f, f, f, f, f}},
+				{texts: []string{a, b, c, d, e, f}, skip: 5},
+			},
+		},
+		{
+			name:      "oversize chunk is sent alone",
+			maxTokens: 110,
+			chunks:    []string{a, b, c, d, e, big, f},
+			want: []embedBatch{
+				{texts: []string{a, b, c, d, e}},
+				{texts: []string{big}},
+				{texts: []string{a, b, c, d, e, f}, skip: 5},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := chunksToBatches(slog.Default(), quarterTokenizer{}, tt.maxTokens, tt.chunks)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunksToBatches() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunksToEmbed(t *testing.T) {
+	changed := make([]bool, 12)
+	changed[9] = true
+
+	send, keep := chunksToEmbed(changed, false)
+	if !reflect.DeepEqual(send, []int{9}) || !reflect.DeepEqual(keep, []bool{true}) {
+		t.Errorf("independent model got send %v keep %v, want only the changed chunk", send, keep)
+	}
+
+	// contextual models get the initial chunks and the neighbours of the changed chunk as context
+	send, keep = chunksToEmbed(changed, true)
+	wantSend := []int{0, 1, 2, 3, 4, 7, 8, 9, 10, 11}
+	wantKeep := []bool{false, false, false, false, false, false, false, true, false, false}
+	if !reflect.DeepEqual(send, wantSend) || !reflect.DeepEqual(keep, wantKeep) {
+		t.Errorf("contextual model got send %v keep %v, want send %v keep %v", send, keep, wantSend, wantKeep)
+	}
+
+	send, keep = chunksToEmbed(make([]bool, 3), true)
+	if send != nil || keep != nil {
+		t.Errorf("no changed chunks got send %v keep %v, want nothing", send, keep)
+	}
+}
+
+func TestContextualEmbedModel(t *testing.T) {
+	if !contextualEmbedModel(voyageai.EmbedModel_voyage_context_3.WithType(embed.TypeDocument)) {
+		t.Errorf("voyage-context-3 should be contextual")
+	}
+	if contextualEmbedModel(embed.Model{Provider: "OpenAI", Name: "text-embedding-3-small-context"}) {
+		t.Errorf("models are contextual by configuration, not by name")
+	}
+}
@@ -2,65 +2,57 @@ package chunker
 
 import (
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/tokenizer"
 	"github.com/modfin/ragnar/internal/util"
 	"github.com/tmc/langchaingo/textsplitter"
 	"strconv"
-	"strings"
+	"unicode/utf8"
 )
 
-func GetTextSplitterFromTubSettings(settings pgtype.Hstore) textsplitter.TextSplitter {
+// GetTextSplitterFromTubSettings returns the splitter configured by the chunk_* settings of a tub.
+// embed is used by the semantic splitter and may be nil for the others, without it semantic falls back to markdown.
+// Settings are validated when a tub is saved, unknown keys and invalid values stored before that are ignored, see
+// ParseTubSettings, and the settings that parsed are used.
+func GetTextSplitterFromTubSettings(settings pgtype.Hstore, embed EmbedFunc) textsplitter.TextSplitter {
 	var ops []textsplitter.Option
 
-	splitter, ok := settings["chunk_splitter"]
-	if !ok || splitter == nil {
-		splitter = util.Ptr("markdown")
-	}
+	s, _ := ragnar.ParseTubSettings(settings)
+	def := ragnar.DefaultTubSettings()
 
-	chunkSize := 512
-	chunkSizeStr, ok := settings["chunk_size"]
-	if ok && chunkSizeStr != nil {
-		_chunkSize, err := strconv.Atoi(*chunkSizeStr)
-		if err == nil {
-			chunkSize = _chunkSize
-		}
-	}
+	chunkSize := valueOr(s.ChunkSize, *def.ChunkSize)
 	ops = append(ops, textsplitter.WithChunkSize(chunkSize))
 
-	chunkOverlap := 0 // Default no overlap, we want to use context aware chunking instead
-	chunkOverlapStr, ok := settings["chunk_overlap"]
-	if ok && chunkOverlapStr != nil {
-		_chunkOverlap, err := strconv.Atoi(*chunkOverlapStr)
-		if err == nil {
-			chunkOverlap = _chunkOverlap
-		}
-	}
-	ops = append(ops, textsplitter.WithChunkOverlap(chunkOverlap)) // Default chunk overlap is 0 so
-
-	chunkSeparators := []string{"\n\n"}                    // Default to only chunk in paragraphs in markdown
-	chunkSeparatorsStr, ok := settings["chunk_separators"] // comma separated string
-	if ok && chunkSeparatorsStr != nil {
-		chunkSeparators = []string{}
-		for _, sep := range strings.Split(*chunkSeparatorsStr, ",") {
-			chunkSeparators = append(chunkSeparators, sep)
-		}
-	}
-	ops = append(ops, textsplitter.WithSeparators(chunkSeparators))
+	lenFunc := lenFuncOf(s)
+	ops = append(ops, textsplitter.WithLenFunc(lenFunc))
 
-	headingHierarchy := true // Default to only chunk in paragraphs in markdown
-	headingHierarchyStr, ok := settings["chunk_heading_hierarchy"]
-	if ok && headingHierarchyStr != nil {
-		headingHierarchy = *headingHierarchyStr == "true"
-	}
-	ops = append(ops, textsplitter.WithHeadingHierarchy(headingHierarchy))
+	// Default no overlap, we want to use context aware chunking instead
+	ops = append(ops, textsplitter.WithChunkOverlap(valueOr(s.ChunkOverlap, *def.ChunkOverlap)))
 
-	joinTableRows := true // Default to only chunk in paragraphs in markdown
-	joinTableRowsStr, ok := settings["join_table_rows"]
-	if ok && joinTableRowsStr != nil {
-		joinTableRows = *joinTableRowsStr == "true"
+	// Default to only chunk in paragraphs in markdown
+	chunkSeparators := def.ChunkSeparators
+	if s.ChunkSeparators != nil {
+		chunkSeparators = s.ChunkSeparators
 	}
-	ops = append(ops, textsplitter.WithJoinTableRows(joinTableRows))
+	ops = append(ops, textsplitter.WithSeparators(chunkSeparators))
+	ops = append(ops, textsplitter.WithHeadingHierarchy(valueOr(s.ChunkHeadingHierarchy, *def.ChunkHeadingHierarchy)))
+	ops = append(ops, textsplitter.WithJoinTableRows(valueOr(s.JoinTableRows, *def.JoinTableRows)))
 
-	switch *splitter {
+	switch valueOr(s.ChunkSplitter, *def.ChunkSplitter) {
+	case "semantic":
+		if embed == nil {
+			return textsplitter.NewMarkdownTextSplitter(ops...)
+		}
+		return SemanticSplitter{
+			Embed:                embed,
+			LenFunc:              lenFunc,
+			BreakpointPercentile: valueOr(s.ChunkSemanticPercentile, *def.ChunkSemanticPercentile),
+			MinSize:              valueOr(s.ChunkSemanticMinSize, chunkSize/4),
+			MaxSize:              chunkSize,
+			BufferSize:           valueOr(s.ChunkSemanticBuffer, *def.ChunkSemanticBuffer),
+		}
+	case "code":
+		return CodeSplitter{MaxSize: chunkSize, LenFunc: lenFunc, Fallback: textsplitter.NewMarkdownTextSplitter(ops...)}
 	case "token":
 		return textsplitter.NewTokenSplitter(ops...)
 	case "recursive":
@@ -72,3 +64,54 @@ func GetTextSplitterFromTubSettings(settings pgtype.Hstore) textsplitter.TextSpl
 
 	}
 }
+
+// GetParentTextSplitterFromTubSettings returns the splitter of the parent chunks when chunk_parent_size is set,
+// parents are split by markdown sections. The child chunks are split from each parent with the regular splitter.
+func GetParentTextSplitterFromTubSettings(settings pgtype.Hstore) (textsplitter.TextSplitter, bool) {
+	s, _ := ragnar.ParseTubSettings(settings)
+	parentSize := valueOr(s.ChunkParentSize, 0)
+	if parentSize <= 0 {
+		return nil, false
+	}
+	parentSettings := pgtype.Hstore{}
+	for k, v := range settings {
+		parentSettings[k] = v
+	}
+	parentSettings["chunk_splitter"] = util.Ptr("markdown")
+	parentSettings["chunk_size"] = util.Ptr(strconv.Itoa(parentSize))
+	parentSettings["chunk_overlap"] = util.Ptr("0")
+	return GetTextSplitterFromTubSettings(parentSettings, nil), true
+}
+
+// GetTableSplitterFromTubSettings returns the row aware splitter of tabular files, see TabularFile. Rows are packed
+// into chunks of chunk_size unless join_table_rows is off, and whole parents of chunk_parent_size when parent is set.
+func GetTableSplitterFromTubSettings(settings pgtype.Hstore, parent bool) (TableSplitter, bool) {
+	s, _ := ragnar.ParseTubSettings(settings)
+	def := ragnar.DefaultTubSettings()
+	splitter := TableSplitter{
+		MaxSize:  valueOr(s.ChunkSize, *def.ChunkSize),
+		LenFunc:  lenFuncOf(s),
+		JoinRows: valueOr(s.JoinTableRows, *def.JoinTableRows),
+	}
+	if parent {
+		splitter.MaxSize = valueOr(s.ChunkParentSize, 0)
+		splitter.JoinRows = true
+	}
+	return splitter, splitter.MaxSize > 0
+}
+
+// lenFuncOf returns the size measure of the chunk_size_unit setting, chunk sizes are counted in characters (default)
+// or in tokens of the tokenizer of the embedding model
+func lenFuncOf(s ragnar.TubSettings) func(string) int {
+	if valueOr(s.ChunkSizeUnit, "") == "tokens" {
+		return tokenizer.ForModelFQN(valueOr(s.EmbedModel, "")).Count
+	}
+	return utf8.RuneCountInString
+}
+
+func valueOr[T any](v *T, def T) T {
+	if v == nil {
+		return def
+	}
+	return *v
+}
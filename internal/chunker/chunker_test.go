@@ -56,7 +56,7 @@ func TestSplitMarkdownText(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			//got, err := SplitMarkdownText(tt.args.text, tt.args.ops...)
-			got, err := GetTextSplitterFromTubSettings(tt.args.ops).SplitText(tt.args.text)
+			got, err := GetTextSplitterFromTubSettings(tt.args.ops, nil).SplitText(tt.args.text)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("SplitMarkdownText() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -71,3 +71,27 @@ func TestSplitMarkdownText(t *testing.T) {
 		})
 	}
 }
+
+func TestGetParentTextSplitterFromTubSettings(t *testing.T) {
+	if _, ok := GetParentTextSplitterFromTubSettings(pgtype.Hstore{"chunk_size": util.Ptr("100")}); ok {
+		t.Fatalf("expected no parent splitter without chunk_parent_size")
+	}
+
+	settings := pgtype.Hstore{"chunk_size": util.Ptr("60"), "chunk_parent_size": util.Ptr("2000")}
+	parentSplitter, ok := GetParentTextSplitterFromTubSettings(settings)
+	if !ok {
+		t.Fatalf("expected a parent splitter")
+	}
+	text := "# Title\n\nThe first paragraph is about one thing.\n\nThe second paragraph is about another.\n\n## Section\n\nA paragraph in a section."
+	parents, err := parentSplitter.SplitText(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := GetTextSplitterFromTubSettings(settings, nil).SplitText(parents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) <= 1 {
+		t.Errorf("expected the first parent %q to split into several children, got %q", parents[0], children)
+	}
+}
@@ -0,0 +1,360 @@
+package chunker
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// codeLanguages maps file extensions to the language names used for code fences and splitting
+var codeLanguages = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".pyi":   "python",
+	".rb":    "ruby",
+	".sql":   "sql",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".java":  "java",
+	".kt":    "kotlin",
+	".scala": "scala",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".rs":    "rust",
+	".swift": "swift",
+	".php":   "php",
+}
+
+// LanguageOf returns the programming language of a file name by its extension, "" when it is not a known code file
+func LanguageOf(filename string) string {
+	return codeLanguages[strings.ToLower(filepath.Ext(filename))]
+}
+
+// CodeChunk is a chunk of source code with the name of the top-level symbol(s) it declares
+type CodeChunk struct {
+	Content string
+	Symbol  string
+}
+
+// CodeSplitter splits source code on top-level declarations, so that functions, types and classes are kept whole.
+// Go is parsed with go/parser, other languages are split with indentation (python, ruby) or brace heuristics.
+// Declarations larger than MaxSize are split further, consecutive code without a symbol, e.g. imports, is packed
+// together up to MaxSize.
+type CodeSplitter struct {
+	// Language as returned by LanguageOf, when empty it is taken from the info string of a code fence around the
+	// text and if there is none the text is split with Fallback
+	Language string
+	MaxSize  int
+	// LenFunc measures sizes, characters when nil
+	LenFunc  func(string) int
+	Fallback textsplitter.TextSplitter
+}
+
+func (s CodeSplitter) SplitText(text string) ([]string, error) {
+	chunks, err := s.SplitCode(text)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Content
+	}
+	return texts, nil
+}
+
+type codeUnit struct {
+	symbol     string
+	start, end int
+}
+
+// SplitCode splits the text like SplitText, keeping the symbol of each chunk
+func (s CodeSplitter) SplitCode(text string) ([]CodeChunk, error) {
+	code, fenceLanguage := unfence(text)
+	language := s.Language
+	if language == "" {
+		language = fenceLanguage
+	}
+	if language == "" {
+		texts, err := s.Fallback.SplitText(text)
+		if err != nil {
+			return nil, err
+		}
+		chunks := make([]CodeChunk, len(texts))
+		for i, t := range texts {
+			chunks[i] = CodeChunk{Content: t}
+		}
+		return chunks, nil
+	}
+
+	var units []codeUnit
+	ok := false
+	if language == "go" {
+		units, ok = goUnits(code)
+	}
+	if !ok {
+		units = heuristicUnits(code, language)
+	}
+	return s.pack(code, units)
+}
+
+func (s CodeSplitter) pack(code string, units []codeUnit) ([]CodeChunk, error) {
+	maxSize := s.MaxSize
+	if maxSize <= 0 {
+		maxSize = 2000
+	}
+	oversize := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(maxSize),
+		textsplitter.WithLenFunc(s.size),
+		textsplitter.WithChunkOverlap(0),
+		textsplitter.WithSeparators([]string{"\n\n", "\n", " ", ""}),
+	)
+
+	var chunks []CodeChunk
+	packStart := -1 // start of the last chunk when it holds code without a symbol that more can be packed into
+	for _, u := range units {
+		content := strings.Trim(code[u.start:u.end], "\n")
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		if u.symbol == "" && packStart >= 0 {
+			packed := strings.Trim(code[packStart:u.end], "\n")
+			if s.size(packed) <= maxSize {
+				chunks[len(chunks)-1].Content = packed
+				continue
+			}
+		}
+		packStart = -1
+		if s.size(content) <= maxSize {
+			chunks = append(chunks, CodeChunk{Content: content, Symbol: u.symbol})
+			if u.symbol == "" {
+				packStart = u.start
+			}
+			continue
+		}
+		parts, err := oversize.SplitText(content)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parts {
+			chunks = append(chunks, CodeChunk{Content: p, Symbol: u.symbol})
+		}
+	}
+	return chunks, nil
+}
+
+func (s CodeSplitter) size(t string) int {
+	if s.LenFunc == nil {
+		return utf8.RuneCountInString(t)
+	}
+	return s.LenFunc(t)
+}
+
+// unfence removes a code fence around the whole text, as documents converted from code files are fenced
+func unfence(text string) (string, string) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") {
+		return text, ""
+	}
+	first, rest, ok := strings.Cut(trimmed, "\n")
+	if !ok {
+		return text, ""
+	}
+	body := strings.TrimSuffix(rest, "```")
+	if strings.Contains(body, "\n```") {
+		// several fenced blocks, this is markdown
+		return text, ""
+	}
+	return body, strings.TrimSpace(strings.TrimPrefix(first, "```"))
+}
+
+// goUnits splits Go source on its top-level declarations, comments and blank lines before a declaration belong to it
+func goUnits(code string) ([]codeUnit, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", code, parser.ParseComments)
+	if err != nil {
+		return nil, false
+	}
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+
+	var units []codeUnit
+	prev := 0
+	for _, decl := range file.Decls {
+		symbol := ""
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			symbol = d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				symbol = receiverName(d.Recv.List[0].Type) + "." + symbol
+			}
+		case *ast.GenDecl:
+			var names []string
+			for _, spec := range d.Specs {
+				switch sp := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, sp.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range sp.Names {
+						names = append(names, n.Name)
+					}
+				}
+			}
+			symbol = strings.Join(names, ", ")
+		}
+		end := offset(decl.End())
+		units = append(units, codeUnit{symbol: symbol, start: prev, end: end})
+		prev = end
+	}
+	if len(units) == 0 {
+		return []codeUnit{{start: 0, end: len(code)}}, true
+	}
+	// the package clause goes with the first declaration, trailing comments with the last
+	units[len(units)-1].end = len(code)
+	return units, true
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+var (
+	indentSymbolRegExp = map[string]*regexp.Regexp{
+		"python": regexp.MustCompile(`^(?:async\s+)?(?:def|class)\s+([A-Za-z_]\w*)`),
+		"ruby":   regexp.MustCompile(`^(?:def|class|module)\s+([A-Za-z_][\w.:]*[?!]?)`),
+	}
+	sqlSymbolRegExp     = regexp.MustCompile(`(?i)^(?:CREATE|ALTER|DROP)\s+(?:OR\s+REPLACE\s+)?(?:(?:TEMP|TEMPORARY|UNIQUE|MATERIALIZED)\s+)*(?:TABLE|VIEW|FUNCTION|PROCEDURE|INDEX|TRIGGER|TYPE|SCHEMA|SEQUENCE|EXTENSION)\s+(?:IF\s+(?:NOT\s+)?EXISTS\s+)?(?:CONCURRENTLY\s+)?([\w."]+)`)
+	braceKeywordRegExp  = regexp.MustCompile(`\b(?:func|function|class|interface|struct|enum|trait|impl|fn|type|record|object|module|namespace)\s+([A-Za-z_$][\w$]*)`)
+	braceFunctionRegExp = regexp.MustCompile(`([A-Za-z_$][\w$:]*)\s*\(`)
+	braceAssignRegExp   = regexp.MustCompile(`^(?:export\s+)?(?:const|let|var)\s+([A-Za-z_$][\w$]*)\s*=`)
+)
+
+// heuristicUnits splits code without a parser. A new unit starts at a line without indentation, for python and
+// ruby where the previous line is not a comment or decorator, for brace languages and SQL where the previous
+// line ended a block or statement at nesting depth zero.
+func heuristicUnits(code string, language string) []codeUnit {
+	_, indentLanguage := indentSymbolRegExp[language]
+	comment := "//"
+	if indentLanguage {
+		comment = "#"
+	}
+	if language == "sql" {
+		comment = "--"
+	}
+
+	var units []codeUnit
+	depth := 0
+	prevEnded := true // the previous line ended a unit
+	prevAttached := false
+	pos := 0
+	for _, line := range strings.SplitAfter(code, "\n") {
+		trimmed := strings.TrimSpace(line)
+		topLevel := trimmed != "" && line[0] != ' ' && line[0] != '\t' && depth == 0
+		isComment := strings.HasPrefix(trimmed, comment) || strings.HasPrefix(trimmed, "/*") || strings.HasPrefix(trimmed, "*")
+
+		var boundary bool
+		if indentLanguage {
+			boundary = topLevel && !prevAttached
+			prevAttached = topLevel && (isComment || strings.HasPrefix(trimmed, "@"))
+		} else {
+			boundary = topLevel && prevEnded && !strings.HasPrefix(trimmed, "}") && !strings.HasPrefix(trimmed, ")")
+		}
+		if boundary || len(units) == 0 {
+			units = append(units, codeUnit{start: pos})
+		}
+		u := &units[len(units)-1]
+		if u.symbol == "" && trimmed != "" && !isComment {
+			u.symbol = codeSymbol(trimmed, language, indentLanguage)
+		}
+
+		depth = max(0, depth+bracketDepth(trimmed, comment))
+		switch {
+		case trimmed == "":
+			prevEnded = depth == 0
+		case isComment:
+			// a comment belongs to the declaration that follows it
+			prevEnded = false
+		default:
+			prevEnded = depth == 0 && (strings.HasSuffix(trimmed, "}") || strings.HasSuffix(trimmed, ";"))
+		}
+		pos += len(line)
+		u.end = pos
+	}
+	return units
+}
+
+func codeSymbol(line string, language string, indentLanguage bool) string {
+	if indentLanguage {
+		if m := indentSymbolRegExp[language].FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+	if language == "sql" {
+		if m := sqlSymbolRegExp.FindStringSubmatch(line); m != nil {
+			return strings.Trim(m[1], `"`)
+		}
+		return ""
+	}
+	for _, re := range []*regexp.Regexp{braceKeywordRegExp, braceAssignRegExp} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	if strings.HasPrefix(line, "import ") || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "package ") || strings.HasPrefix(line, "using ") {
+		return ""
+	}
+	if m := braceFunctionRegExp.FindStringSubmatch(line); m != nil && !strings.HasSuffix(line, ";") {
+		return m[1]
+	}
+	return ""
+}
+
+// bracketDepth is the change of nesting depth over a line, ignoring strings and line comments
+func bracketDepth(line string, comment string) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case strings.HasPrefix(line[i:], comment):
+			return depth
+		case r == '{' || r == '(' || r == '[':
+			depth++
+		case r == '}' || r == ')' || r == ']':
+			depth--
+		}
+	}
+	return depth
+}
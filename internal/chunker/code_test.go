@@ -0,0 +1,100 @@
+package chunker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+func TestCodeSplitter_SplitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		text     string
+		want     []CodeChunk
+	}{
+		{
+			name:     "go declarations",
+			language: "go",
+			text: "package web\n\nimport \"fmt\"\n\n// Web serves requests\ntype Web struct {\n\tname string\n}\n\n" +
+				"// Hello says hello\nfunc (w *Web) Hello() {\n\tfmt.Println(\"hello\")\n}\n\nconst a, b = 1, 2\n",
+			want: []CodeChunk{
+				{Content: "package web\n\nimport \"fmt\""},
+				{Content: "// Web serves requests\ntype Web struct {\n\tname string\n}", Symbol: "Web"},
+				{Content: "// Hello says hello\nfunc (w *Web) Hello() {\n\tfmt.Println(\"hello\")\n}", Symbol: "Web.Hello"},
+				{Content: "const a, b = 1, 2", Symbol: "a, b"},
+			},
+		},
+		{
+			name:     "fenced python",
+			language: "",
+			text: "```python\nimport os\nimport sys\n\nX = 1\n\n# Greeter greets\n@dataclass\nclass Greeter:\n" +
+				"    name: str\n\n    def greet(self):\n        return self.name\n\nasync def main():\n    pass\n```",
+			want: []CodeChunk{
+				{Content: "import os\nimport sys\n\nX = 1"},
+				{Content: "# Greeter greets\n@dataclass\nclass Greeter:\n    name: str\n\n    def greet(self):\n        return self.name", Symbol: "Greeter"},
+				{Content: "async def main():\n    pass", Symbol: "main"},
+			},
+		},
+		{
+			name:     "sql statements",
+			language: "sql",
+			text: "-- users of the system\nCREATE TABLE IF NOT EXISTS public.users (\n    id   INT,\n    name TEXT\n);\n" +
+				"CREATE INDEX users_name_idx ON public.users (name);\n\nINSERT INTO public.users VALUES (1, 'a');\n",
+			want: []CodeChunk{
+				{Content: "-- users of the system\nCREATE TABLE IF NOT EXISTS public.users (\n    id   INT,\n    name TEXT\n);", Symbol: "public.users"},
+				{Content: "CREATE INDEX users_name_idx ON public.users (name);", Symbol: "users_name_idx"},
+				{Content: "INSERT INTO public.users VALUES (1, 'a');"},
+			},
+		},
+		{
+			name:     "typescript braces",
+			language: "typescript",
+			text: "import { x } from './x';\n\n// Point is a point\nexport interface Point {\n  x: number;\n}\n\n" +
+				"export function add(a: Point, b: Point): Point {\n  if (a) {\n    return { x: a.x + b.x };\n  }\n}\n",
+			want: []CodeChunk{
+				{Content: "import { x } from './x';"},
+				{Content: "// Point is a point\nexport interface Point {\n  x: number;\n}", Symbol: "Point"},
+				{Content: "export function add(a: Point, b: Point): Point {\n  if (a) {\n    return { x: a.x + b.x };\n  }\n}", Symbol: "add"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := CodeSplitter{Language: tt.language, MaxSize: 1000, Fallback: textsplitter.NewMarkdownTextSplitter()}
+			got, err := s.SplitCode(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitCode() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeSplitter_Oversize(t *testing.T) {
+	text := "package a\n\nfunc Long() {\n\tx := 1\n\ty := 2\n\tz := 3\n}\n"
+	s := CodeSplitter{Language: "go", MaxSize: 20, Fallback: textsplitter.NewMarkdownTextSplitter()}
+	got, err := s.SplitCode(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) < 2 {
+		t.Fatalf("expected the function to be split, got %q", got)
+	}
+	for _, c := range got {
+		if c.Symbol != "Long" {
+			t.Errorf("expected all parts to carry the symbol Long, got %q", c)
+		}
+	}
+}
+
+func TestLanguageOf(t *testing.T) {
+	for filename, want := range map[string]string{"main.go": "go", "x/Y.PY": "python", "q.sql": "sql", "a.md": "", "": ""} {
+		if got := LanguageOf(filename); got != want {
+			t.Errorf("LanguageOf(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package chunker
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar"
+)
+
+// ChunkDocument splits document markdown as configured by the chunk_* settings of a tub, returning the chunks with
+// their position metadata and, when chunk_parent_size is set, the parent chunks the children link to. The filename
+// of the uploaded document selects the language of the code splitter and may be empty. The tub and document ids of
+// the returned chunks are left for the caller to set. Tabular files, such as CSV, are split by rows whatever splitter
+// the tub uses, see TabularFile for contentType.
+func ChunkDocument(md string, contentType string, filename string, settings pgtype.Hstore, embed EmbedFunc) ([]ragnar.Chunk, []ragnar.ParentChunk, error) {
+	splitter := GetTextSplitterFromTubSettings(settings, embed)
+	parentSplitter, hasParents := GetParentTextSplitterFromTubSettings(settings)
+	if TabularFile(contentType, filename) {
+		splitter, _ = GetTableSplitterFromTubSettings(settings, false)
+		parentSplitter, hasParents = GetTableSplitterFromTubSettings(settings, true)
+	}
+
+	var symbols []string
+	split := func(text string) ([]string, error) {
+		code, ok := splitter.(CodeSplitter)
+		if !ok {
+			texts, err := splitter.SplitText(text)
+			symbols = append(symbols, make([]string, len(texts))...)
+			return texts, err
+		}
+		code.Language = LanguageOf(filename)
+		codeChunks, err := code.SplitCode(text)
+		if err != nil {
+			return nil, err
+		}
+		texts := make([]string, len(codeChunks))
+		for i, c := range codeChunks {
+			texts[i] = c.Content
+			symbols = append(symbols, c.Symbol)
+		}
+		return texts, nil
+	}
+
+	var chunks []string
+	var chunkParents []int
+	var parents []ragnar.ParentChunk
+	if hasParents {
+		// small-to-big, the children that are embedded are split from larger parent passages
+		parentTexts, err := parentSplitter.SplitText(md)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not split document into parents: %w", err)
+		}
+		parentPositions := LocateChunks(md, parentTexts)
+		for i, parent := range parentTexts {
+			parents = append(parents, ragnar.ParentChunk{
+				ParentId:    i,
+				Content:     parent,
+				HeadingPath: parentPositions[i].HeadingPath,
+			})
+			parents[i].StartOffset, parents[i].EndOffset, parents[i].PageStart, parents[i].PageEnd = parentPositions[i].fields()
+
+			children, err := split(parent)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not split parent chunk %d: %w", i, err)
+			}
+			for range children {
+				chunkParents = append(chunkParents, i)
+			}
+			chunks = append(chunks, children...)
+		}
+	} else {
+		var err error
+		chunks, err = split(md)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not split document: %w", err)
+		}
+	}
+
+	positions := LocateChunks(md, chunks)
+	result := make([]ragnar.Chunk, len(chunks))
+	for i, chunk := range chunks {
+		result[i] = ragnar.Chunk{
+			ChunkId:     i,
+			Content:     chunk,
+			HeadingPath: positions[i].HeadingPath,
+			Symbol:      symbols[i],
+		}
+		result[i].StartOffset, result[i].EndOffset, result[i].PageStart, result[i].PageEnd = positions[i].fields()
+		if chunkParents != nil {
+			result[i].ParentId = &chunkParents[i]
+		}
+	}
+	return result, parents, nil
+}
+
+// fields returns the offsets and pages of the position, nil when unknown
+func (pos ChunkPosition) fields() (start, end, pageStart, pageEnd *int) {
+	if pos.Start >= 0 {
+		start, end = &pos.Start, &pos.End
+	}
+	if pos.PageStart > 0 {
+		pageStart, pageEnd = &pos.PageStart, &pos.PageEnd
+	}
+	return start, end, pageStart, pageEnd
+}
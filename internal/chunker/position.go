@@ -0,0 +1,152 @@
+package chunker
+
+import (
+	"sort"
+	"strings"
+)
+
+// pageBreak separates pages in converted paged documents, pdftotext emits a form feed after each page
+const pageBreak = '\f'
+
+// ChunkPosition is where a chunk came from in the document markdown
+type ChunkPosition struct {
+	// HeadingPath is the breadcrumb of markdown headings the chunk starts under, e.g. "Report > Results"
+	HeadingPath string
+	// Start and End are byte offsets into the markdown, -1 when the chunk could not be located
+	Start int
+	End   int
+	// PageStart and PageEnd are 1-based page numbers, 0 when the markdown has no page breaks
+	PageStart int
+	PageEnd   int
+}
+
+// Pages splits the markdown of a paged document into its pages, in the numbering of ChunkPosition, or returns nil when
+// the markdown has no page breaks
+func Pages(md string) []string {
+	if strings.IndexByte(md, pageBreak) < 0 {
+		return nil
+	}
+	pages := strings.Split(md, string(pageBreak))
+	// pdftotext ends every page with a page break, leaving nothing after the last one
+	if strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	return pages
+}
+
+type heading struct {
+	pos   int
+	level int
+	text  string
+}
+
+// LocateChunks finds the position of each chunk in the markdown it was split from. Splitters may rewrite chunks,
+// e.g. by prepending the heading hierarchy or joining paragraphs, so each chunk is located by its body lines,
+// searched for in order from where the previous chunk started. Offsets span the chunk's body text.
+func LocateChunks(md string, chunks []string) []ChunkPosition {
+	headings := markdownHeadings(md)
+	var pageBreaks []int
+	for i := 0; i < len(md); i++ {
+		if md[i] == pageBreak {
+			pageBreaks = append(pageBreaks, i)
+		}
+	}
+	pageAt := func(pos int) int {
+		if len(pageBreaks) == 0 {
+			return 0
+		}
+		return 1 + sort.SearchInts(pageBreaks, pos)
+	}
+
+	positions := make([]ChunkPosition, len(chunks))
+	cursor := 0
+	for i, chunk := range chunks {
+		start, end := locateChunk(md, chunk, cursor)
+		if start < 0 {
+			positions[i] = ChunkPosition{Start: -1, End: -1}
+			continue
+		}
+		cursor = start
+		positions[i] = ChunkPosition{
+			HeadingPath: headingPathAt(headings, start),
+			Start:       start,
+			End:         end,
+			PageStart:   pageAt(start),
+			PageEnd:     pageAt(max(start, end-1)),
+		}
+	}
+	return positions
+}
+
+func locateChunk(md, chunk string, from int) (int, int) {
+	var lines, body []string
+	for _, line := range strings.Split(chunk, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+		if !strings.HasPrefix(line, "#") {
+			body = append(body, line)
+		}
+	}
+	if len(body) == 0 {
+		body = lines
+	}
+
+	start, end := -1, -1
+	pos := from
+	for _, line := range body {
+		idx := strings.Index(md[pos:], line)
+		if idx < 0 {
+			// the line was rewritten by the splitter, locate the rest of the chunk
+			continue
+		}
+		if start < 0 {
+			start = pos + idx
+		}
+		end = pos + idx + len(line)
+		pos = end
+	}
+	return start, end
+}
+
+func markdownHeadings(md string) []heading {
+	var headings []heading
+	inFence := false
+	pos := 0
+	for _, line := range strings.SplitAfter(md, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+		}
+		if !inFence && strings.HasPrefix(trimmed, "#") {
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			text := strings.TrimSpace(trimmed[level:])
+			// "#tag" is not a heading, a heading needs a space after the hashes
+			if level <= 6 && text != "" && trimmed[level] == ' ' {
+				headings = append(headings, heading{pos: pos, level: level, text: text})
+			}
+		}
+		pos += len(line)
+	}
+	return headings
+}
+
+func headingPathAt(headings []heading, pos int) string {
+	var stack []heading
+	for _, h := range headings {
+		if h.pos > pos {
+			break
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= h.level {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, h)
+	}
+	parts := make([]string, len(stack))
+	for i, h := range stack {
+		parts[i] = h.text
+	}
+	return strings.Join(parts, " > ")
+}
@@ -0,0 +1,71 @@
+package chunker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocateChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		md     string
+		chunks []string
+		want   []ChunkPosition
+	}{
+		{
+			name: "heading hierarchy prepended by splitter",
+			md:   "# Report\n\nIntro text.\n\n## Results\n\nRevenue grew.\n\nCosts fell.\n",
+			chunks: []string{
+				"# Report\nIntro text.",
+				"# Report\n## Results\nRevenue grew.\nCosts fell.",
+			},
+			want: []ChunkPosition{
+				{HeadingPath: "Report", Start: 10, End: 21},
+				{HeadingPath: "Report > Results", Start: 35, End: 61},
+			},
+		},
+		{
+			name:   "page breaks",
+			md:     "Page one text.\n\fPage two text.\n\fPage three.",
+			chunks: []string{"Page one text.", "Page two text.\n\fPage three."},
+			want: []ChunkPosition{
+				{Start: 0, End: 14, PageStart: 1, PageEnd: 1},
+				{Start: 16, End: 43, PageStart: 2, PageEnd: 3},
+			},
+		},
+		{
+			name:   "unknown chunk",
+			md:     "Some text.",
+			chunks: []string{"Rewritten beyond recognition"},
+			want:   []ChunkPosition{{Start: -1, End: -1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LocateChunks(tt.md, tt.chunks)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LocateChunks() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPages(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want []string
+	}{
+		{name: "pdftotext output", md: "Page one.\n\fPage two.\n\f", want: []string{"Page one.\n", "Page two.\n"}},
+		{name: "no break after the last page", md: "Page one.\n\fPage two.", want: []string{"Page one.\n", "Page two."}},
+		{name: "empty scanned page", md: "Page one.\n\f\fPage three.\n\f", want: []string{"Page one.\n", "", "Page three.\n"}},
+		{name: "not paged", md: "# Title\n\nText.", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Pages(tt.md); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pages() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
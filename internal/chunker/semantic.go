@@ -0,0 +1,207 @@
+package chunker
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// EmbedFunc embeds texts, returning one vector per text in the same order
+type EmbedFunc func(texts []string) ([][]float32, error)
+
+// SemanticSplitter splits text into sentences and starts a new chunk where the similarity between adjacent
+// sentences drops below the BreakpointPercentile of all adjacent similarities in the text, so that chunk
+// boundaries follow topic shifts. Chunks are kept between MinSize and MaxSize where possible.
+type SemanticSplitter struct {
+	Embed EmbedFunc
+	// LenFunc measures sizes, characters when nil
+	LenFunc func(string) int
+
+	// BreakpointPercentile, 0-100, similarities below this percentile start a new chunk
+	BreakpointPercentile float64
+	MinSize              int
+	MaxSize              int
+	// BufferSize is the number of neighbouring sentences on each side embedded together with a sentence,
+	// which smooths out short sentences
+	BufferSize int
+}
+
+var _ textsplitter.TextSplitter = SemanticSplitter{}
+
+type sentence struct {
+	text string
+	// sep is the separator joining the sentence to the previous one
+	sep string
+}
+
+func (s SemanticSplitter) SplitText(text string) ([]string, error) {
+	if s.Embed == nil {
+		return nil, fmt.Errorf("semantic splitter requires an embed function")
+	}
+	sentences := s.sentences(text)
+	if len(sentences) == 0 {
+		return []string{}, nil
+	}
+	if len(sentences) == 1 {
+		return []string{sentences[0].text}, nil
+	}
+
+	windows := make([]string, len(sentences))
+	for i := range sentences {
+		var sb strings.Builder
+		for j := max(0, i-s.BufferSize); j <= min(len(sentences)-1, i+s.BufferSize); j++ {
+			if sb.Len() > 0 {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(sentences[j].text)
+		}
+		windows[i] = sb.String()
+	}
+	vectors, err := s.Embed(windows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed sentences: %w", err)
+	}
+	if len(vectors) != len(sentences) {
+		return nil, fmt.Errorf("embedded %d sentences but got %d vectors", len(sentences), len(vectors))
+	}
+
+	// similarities[i] is the similarity between sentence i and i+1
+	similarities := make([]float64, len(sentences)-1)
+	for i := range similarities {
+		similarities[i] = cosineSimilarity(vectors[i], vectors[i+1])
+	}
+	threshold := percentile(similarities, s.BreakpointPercentile)
+
+	var chunks []string
+	var current strings.Builder
+	current.WriteString(sentences[0].text)
+	for i := 1; i < len(sentences); i++ {
+		next := sentences[i]
+		topicShift := similarities[i-1] < threshold && s.size(current.String()) >= s.MinSize
+		tooLarge := s.MaxSize > 0 && s.size(current.String()+next.sep+next.text) > s.MaxSize
+		if topicShift || tooLarge {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			current.WriteString(next.text)
+			continue
+		}
+		current.WriteString(next.sep)
+		current.WriteString(next.text)
+	}
+	chunks = append(chunks, current.String())
+
+	return chunks, nil
+}
+
+var sentenceEndRegExp = regexp.MustCompile(`[.!?]["')\]]*\s+`)
+
+// sentences splits the text into paragraphs and the paragraphs into sentences. Markdown structure such as
+// headings, list items and table rows are kept as units, and units longer than MaxSize are split further.
+func (s SemanticSplitter) sentences(text string) []sentence {
+	var result []sentence
+	add := func(t, sep string) {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			return
+		}
+		if len(result) == 0 {
+			sep = ""
+		}
+		for _, part := range s.splitOversized(t) {
+			result = append(result, sentence{text: part, sep: sep})
+			sep = " "
+		}
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		sep := "\n\n"
+		for _, line := range strings.Split(paragraph, "\n") {
+			trimmed := strings.TrimSpace(line)
+			if isStructuralLine(trimmed) {
+				add(trimmed, sep)
+				sep = "\n"
+				continue
+			}
+			start := 0
+			for _, loc := range sentenceEndRegExp.FindAllStringIndex(line, -1) {
+				add(line[start:loc[1]], sep)
+				sep = " "
+				start = loc[1]
+			}
+			add(line[start:], sep)
+			sep = " "
+		}
+	}
+	return result
+}
+
+func isStructuralLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	switch line[0] {
+	case '#', '|', '>', '-', '*', '+':
+		return true
+	}
+	// ordered list items, e.g. "1. item"
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	return i > 0 && i < len(line) && (line[i] == '.' || line[i] == ')')
+}
+
+func (s SemanticSplitter) splitOversized(t string) []string {
+	if s.MaxSize <= 0 || s.size(t) <= s.MaxSize {
+		return []string{t}
+	}
+	parts, err := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(s.MaxSize),
+		textsplitter.WithLenFunc(s.size),
+		textsplitter.WithChunkOverlap(0),
+		textsplitter.WithSeparators([]string{" ", ""}),
+	).SplitText(t)
+	if err != nil || len(parts) == 0 {
+		return []string{t}
+	}
+	return parts
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// percentile returns the p-th percentile, 0-100, of values using linear interpolation
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	p = math.Max(0, math.Min(100, p))
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+func (s SemanticSplitter) size(t string) int {
+	if s.LenFunc == nil {
+		return utf8.RuneCountInString(t)
+	}
+	return s.LenFunc(t)
+}
@@ -0,0 +1,100 @@
+package chunker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/util"
+)
+
+// topicEmbed embeds sentences mentioning cats and dogs in orthogonal directions
+func topicEmbed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, t := range texts {
+		switch {
+		case strings.Contains(t, "cat"):
+			vectors[i] = []float32{1, 0.1}
+		case strings.Contains(t, "dog"):
+			vectors[i] = []float32{0.1, 1}
+		default:
+			vectors[i] = []float32{1, 1}
+		}
+	}
+	return vectors, nil
+}
+
+func TestSemanticSplitter_SplitText(t *testing.T) {
+	tests := []struct {
+		name     string
+		splitter SemanticSplitter
+		text     string
+		want     []string
+	}{
+		{
+			name:     "breaks on topic shift",
+			splitter: SemanticSplitter{Embed: topicEmbed, BreakpointPercentile: 10},
+			text:     "The cat sleeps. The cat eats. A cat purrs. The dog barks. A dog runs.",
+			want: []string{
+				"The cat sleeps. The cat eats. A cat purrs.",
+				"The dog barks. A dog runs.",
+			},
+		},
+		{
+			name:     "keeps paragraphs and headings",
+			splitter: SemanticSplitter{Embed: topicEmbed, BreakpointPercentile: 10},
+			text:     "# About cats\n\nThe cat sleeps.\nThe cat eats.\n\n# About dogs\n\nThe dog barks.",
+			want: []string{
+				"# About cats\n\nThe cat sleeps. The cat eats.",
+				"# About dogs\n\nThe dog barks.",
+			},
+		},
+		{
+			name:     "min size merges short chunks",
+			splitter: SemanticSplitter{Embed: topicEmbed, BreakpointPercentile: 50, MinSize: 1000},
+			text:     "The cat sleeps. The dog barks.",
+			want:     []string{"The cat sleeps. The dog barks."},
+		},
+		{
+			name:     "max size splits within a topic",
+			splitter: SemanticSplitter{Embed: topicEmbed, BreakpointPercentile: 0, MaxSize: 30},
+			text:     "The cat sleeps. The cat eats. A cat purrs.",
+			want:     []string{"The cat sleeps. The cat eats.", "A cat purrs."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.splitter.SplitText(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitText() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTextSplitterFromTubSettings_Semantic(t *testing.T) {
+	settings := pgtype.Hstore{"chunk_splitter": util.Ptr("semantic"), "chunk_size": util.Ptr("2000")}
+
+	if _, ok := GetTextSplitterFromTubSettings(settings, topicEmbed).(SemanticSplitter); !ok {
+		t.Errorf("expected a semantic splitter")
+	}
+	if _, ok := GetTextSplitterFromTubSettings(settings, nil).(SemanticSplitter); ok {
+		t.Errorf("expected a fallback splitter without embed func")
+	}
+
+	// settings stored before they were validated are read leniently, invalid values fall back to their defaults
+	settings["chunk_semantic_percentile"] = util.Ptr("five")
+	settings["legacy_setting"] = util.Ptr("on")
+	splitter, ok := GetTextSplitterFromTubSettings(settings, topicEmbed).(SemanticSplitter)
+	if !ok {
+		t.Fatalf("expected a semantic splitter")
+	}
+	if splitter.MaxSize != 2000 || splitter.BreakpointPercentile != *ragnar.DefaultTubSettings().ChunkSemanticPercentile {
+		t.Errorf("expected chunk_size 2000 and the default percentile, got %d and %v", splitter.MaxSize, splitter.BreakpointPercentile)
+	}
+}
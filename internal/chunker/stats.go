@@ -0,0 +1,36 @@
+package chunker
+
+import (
+	"math"
+	"unicode/utf8"
+
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/tokenizer"
+)
+
+// Stats summarizes the sizes of chunks in characters, and in tokens counted with tok
+func Stats(chunks []ragnar.Chunk, tok tokenizer.Tokenizer) ragnar.ChunkStats {
+	stats := ragnar.ChunkStats{Count: len(chunks)}
+	if len(chunks) == 0 {
+		return stats
+	}
+	sizes := make([]float64, len(chunks))
+	stats.MinChars = math.MaxInt
+	for i, chunk := range chunks {
+		n := utf8.RuneCountInString(chunk.Content)
+		sizes[i] = float64(n)
+		stats.TotalChars += n
+		stats.MinChars = min(stats.MinChars, n)
+		stats.MaxChars = max(stats.MaxChars, n)
+
+		tokens := tok.Count(chunk.Content)
+		stats.Tokens += tokens
+		stats.MaxTokens = max(stats.MaxTokens, tokens)
+	}
+	stats.MeanChars = float64(stats.TotalChars) / float64(len(chunks))
+	stats.P50Chars = math.Round(percentile(sizes, 50)*10) / 10
+	stats.P95Chars = math.Round(percentile(sizes, 95)*10) / 10
+	stats.Tokenizer = tok.Name()
+	stats.TokensExact = tok.Exact()
+	return stats
+}
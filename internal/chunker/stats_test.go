@@ -0,0 +1,41 @@
+package chunker
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/modfin/ragnar"
+)
+
+// quarterTokenizer counts a token per 4 characters, so that the token counts of tests are easy to follow
+type quarterTokenizer struct{}
+
+func (quarterTokenizer) Count(text string) int { return (utf8.RuneCountInString(text) + 3) / 4 }
+func (quarterTokenizer) Exact() bool           { return false }
+func (quarterTokenizer) Name() string          { return "quarter" }
+
+func TestStats(t *testing.T) {
+	var chunks []ragnar.Chunk
+	for _, content := range []string{"aaaa", "bbbbbbbb", "åäö", "cccccccccccccccccccc"} {
+		chunks = append(chunks, ragnar.Chunk{Content: content})
+	}
+	want := ragnar.ChunkStats{
+		Count:      4,
+		TotalChars: 35,
+		MinChars:   3,
+		MaxChars:   20,
+		MeanChars:  8.75,
+		P50Chars:   6,
+		P95Chars:   18.2,
+		Tokens:     9,
+		MaxTokens:  5,
+		Tokenizer:  "quarter",
+	}
+	if got := Stats(chunks, quarterTokenizer{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() got = %+v, want %+v", got, want)
+	}
+	if got := Stats(nil, quarterTokenizer{}); !reflect.DeepEqual(got, ragnar.ChunkStats{}) {
+		t.Errorf("Stats(nil) got = %+v, want zero", got)
+	}
+}
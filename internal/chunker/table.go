@@ -0,0 +1,157 @@
+package chunker
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// TableSplitter splits markdown made up of tables, such as converted CSV files, into chunks of whole table rows that
+// each start with the header of their table. Rows are never split, a row larger than MaxSize is a chunk of its own.
+// Text outside tables is split on blank lines, and every heading starts a new chunk, so that the sections of CSV
+// files converted to records become a chunk each.
+type TableSplitter struct {
+	MaxSize int
+	// LenFunc measures sizes, characters when nil
+	LenFunc func(string) int
+	// JoinRows packs as many rows as fit into each chunk, otherwise every row is a chunk of its own
+	JoinRows bool
+}
+
+var _ textsplitter.TextSplitter = TableSplitter{}
+
+var tableSeparatorRegExp = regexp.MustCompile(`^\|(\s*:?-+:?\s*\|)+$`)
+
+// TabularFile reports whether the file is converted to tables of rows, which are chunked with the TableSplitter, by
+// the content type it is converted as or, when that is not a tabular one, by the extension of its file name
+func TabularFile(contentType, filename string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "text/csv", "application/csv", "text/tab-separated-values":
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".tsv", ".tab":
+		return true
+	}
+	return false
+}
+
+func (s TableSplitter) SplitText(text string) ([]string, error) {
+	var chunks []string
+	var current strings.Builder
+	// header is the table header the current chunk starts with, "" when it holds text
+	header := ""
+	// heading is a heading the next chunk starts with
+	heading := ""
+
+	flush := func() {
+		if chunk := strings.TrimSpace(current.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+		header = ""
+	}
+	start := func() {
+		if heading != "" {
+			current.WriteString(heading + "\n\n")
+			heading = ""
+		}
+	}
+	addText := func(paragraph string) {
+		if current.Len() > 0 && (header != "" || s.size(current.String()+"\n\n"+paragraph) > s.MaxSize) {
+			flush()
+		}
+		if current.Len() == 0 {
+			start()
+		} else {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	addRow := func(tableHeader, row string) {
+		if current.Len() > 0 && (header != tableHeader || !s.JoinRows || s.size(current.String()+"\n"+row) > s.MaxSize) {
+			flush()
+		}
+		if current.Len() == 0 {
+			start()
+			current.WriteString(tableHeader)
+			header = tableHeader
+		}
+		current.WriteString("\n" + row)
+	}
+
+	lines := strings.Split(text, "\n")
+	var paragraph []string
+	endParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		p := strings.Join(paragraph, "\n")
+		paragraph = nil
+		if s.MaxSize > 0 && s.size(p) > s.MaxSize {
+			for _, part := range s.splitOversized(p) {
+				addText(part)
+			}
+			return
+		}
+		addText(p)
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case strings.HasPrefix(line, "|") && i+1 < len(lines) && tableSeparatorRegExp.MatchString(strings.TrimSpace(lines[i+1])):
+			endParagraph()
+			tableHeader := line + "\n" + strings.TrimSpace(lines[i+1])
+			rows := 0
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				addRow(tableHeader, strings.TrimSpace(lines[i]))
+				rows++
+			}
+			i--
+			if rows == 0 {
+				addText(tableHeader)
+			}
+		case line == "":
+			endParagraph()
+		case strings.HasPrefix(line, "#"):
+			endParagraph()
+			flush()
+			if heading != "" {
+				chunks = append(chunks, heading)
+			}
+			heading = line
+		default:
+			paragraph = append(paragraph, lines[i])
+		}
+	}
+	endParagraph()
+	flush()
+	if heading != "" {
+		chunks = append(chunks, heading)
+	}
+	return chunks, nil
+}
+
+func (s TableSplitter) splitOversized(t string) []string {
+	parts, err := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(s.MaxSize),
+		textsplitter.WithLenFunc(s.size),
+		textsplitter.WithChunkOverlap(0),
+	).SplitText(t)
+	if err != nil || len(parts) == 0 {
+		return []string{t}
+	}
+	return parts
+}
+
+func (s TableSplitter) size(t string) int {
+	if s.LenFunc == nil {
+		return utf8.RuneCountInString(t)
+	}
+	return s.LenFunc(t)
+}
@@ -0,0 +1,88 @@
+package chunker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTableSplitter_SplitText(t *testing.T) {
+	table := "| Name | Age |\n| --- | --- |\n| Anna | 34 |\n| Bo | 27 |\n| Carl | 51 |\n\n" +
+		"| Name | Age |\n| --- | --- |\n| Dora | 19 |\n"
+	header := "| Name | Age |\n| --- | --- |"
+
+	tests := []struct {
+		name     string
+		splitter TableSplitter
+		text     string
+		want     []string
+	}{
+		{
+			name:     "joined rows across tables",
+			splitter: TableSplitter{MaxSize: 200, JoinRows: true},
+			text:     table,
+			want:     []string{header + "\n| Anna | 34 |\n| Bo | 27 |\n| Carl | 51 |\n| Dora | 19 |"},
+		},
+		{
+			name:     "rows packed under max size keep the header",
+			splitter: TableSplitter{MaxSize: len(header) + 30, JoinRows: true},
+			text:     table,
+			want: []string{
+				header + "\n| Anna | 34 |\n| Bo | 27 |",
+				header + "\n| Carl | 51 |\n| Dora | 19 |",
+			},
+		},
+		{
+			name:     "row larger than max size is not split",
+			splitter: TableSplitter{MaxSize: 10, JoinRows: true},
+			text:     "| Name | Age |\n| --- | --- |\n| Anna | 34 |\n",
+			want:     []string{header + "\n| Anna | 34 |"},
+		},
+		{
+			name:     "one row per chunk",
+			splitter: TableSplitter{MaxSize: 200},
+			text:     table,
+			want: []string{
+				header + "\n| Anna | 34 |",
+				header + "\n| Bo | 27 |",
+				header + "\n| Carl | 51 |",
+				header + "\n| Dora | 19 |",
+			},
+		},
+		{
+			name:     "records",
+			splitter: TableSplitter{MaxSize: 200, JoinRows: true},
+			text:     "## Row 1\n\nName: Anna\nAge: 34\n\n## Row 2\n\nName: Bo\n",
+			want:     []string{"## Row 1\n\nName: Anna\nAge: 34", "## Row 2\n\nName: Bo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.splitter.SplitText(tt.text)
+			if err != nil {
+				t.Fatalf("SplitText() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitText() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTabularFile(t *testing.T) {
+	tests := []struct {
+		contentType string
+		filename    string
+		want        bool
+	}{
+		{"text/csv; charset=utf-8", "", true},
+		{"text/tab-separated-values", "export", true},
+		{"application/octet-stream", "data.CSV", true},
+		{"text/plain", "data.txt", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := TabularFile(tt.contentType, tt.filename); got != tt.want {
+			t.Errorf("TabularFile(%q, %q) = %v, want %v", tt.contentType, tt.filename, got, tt.want)
+		}
+	}
+}
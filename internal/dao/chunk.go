@@ -26,7 +26,7 @@ func (d *DAO) GetChunks(ctx context.Context, tubname string, documentId string,
 			return fmt.Errorf("error checking permission to read tub: %w", err)
 		}
 
-		q := `SELECT tub_id, tub_name, document_id, chunk_id, content, created_at, updated_at
+		q := `SELECT tub_id, tub_name, document_id, chunk_id, content, context, heading_path, start_offset, end_offset, page_start, page_end, parent_id, symbol, content_hash, created_at, updated_at
 			  FROM "%s".chunk
 			  WHERE tub_name = $1
 			    AND document_id = $2
@@ -70,7 +70,7 @@ func (d *DAO) GetChunk(ctx context.Context, tubname string, documentId string, i
 			return fmt.Errorf("error checking permission to read tub: %w", err)
 		}
 
-		q := `SELECT tub_id, tub_name, document_id, chunk_id, content, created_at, updated_at
+		q := `SELECT tub_id, tub_name, document_id, chunk_id, content, context, heading_path, start_offset, end_offset, page_start, page_end, parent_id, symbol, content_hash, created_at, updated_at
 			  FROM "%s".chunk
 			  WHERE tub_name = $1
 			    AND document_id = $2
@@ -119,11 +119,42 @@ func (d *DAO) DeleteChunks(ctx context.Context, doc ragnar.Document) error {
 			return fmt.Errorf("error getting chunk: %w", err)
 		}
 
+		q = fmt.Sprintf(`DELETE FROM "%s".parent_chunk WHERE document_id = $1 AND tub_id = $2`, schema)
+		_, err = d.db.ExecContext(ctx, q, doc.DocumentId, doc.TubId)
+		if err != nil {
+			return fmt.Errorf("error deleting parent chunks: %w", err)
+		}
+
 		return nil
 	})
 }
 
-func (d *DAO) QueryChunkEmbeds(ctx context.Context, tubname string, model embed.Model, documentFilter ragnar.DocumentFilter, vector []float32, limit, offset int) ([]ragnar.Chunk, error) {
+// ReplaceChunks replaces the chunks of the document with uploaded ones, see InternalReplaceChunks for reuse and
+// resetContext
+func (d *DAO) ReplaceChunks(ctx context.Context, doc ragnar.Document, chunks []ragnar.Chunk, reuse map[int]int, resetContext map[int]bool) error {
+	tubname := doc.TubName
+	if !bucketNameRegExp.MatchString(tubname) {
+		return errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	return d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_DELETE)
+		if err != nil {
+			return fmt.Errorf("error checking permission to replace chunks: %w", err)
+		}
+		schema, err := tubToSchema(tubname)
+		if err != nil {
+			return fmt.Errorf("error getting schema: %w", err)
+		}
+		return replaceChunks(tx, schema, doc, nil, chunks, reuse, resetContext)
+	})
+}
+
+// scoringCandidates is the minimum number of nearest chunks that are re-ranked when scoring modifiers are used.
+// Re-ranking a bounded candidate set keeps the vector index usable for the nearest neighbour search.
+const scoringCandidates = 200
+
+func (d *DAO) QueryChunkEmbeds(ctx context.Context, tubname string, model embed.Model, documentFilter ragnar.DocumentFilter, scoring ragnar.SearchScoring, vector []float32, limit, offset int) ([]ragnar.Chunk, error) {
 	var chunks []ragnar.Chunk
 
 	tubname = strings.ToLower(tubname)
@@ -145,7 +176,6 @@ func (d *DAO) QueryChunkEmbeds(ctx context.Context, tubname string, model embed.
 			return fmt.Errorf("error getting column name from model, %s: %w", model.FQN(), err)
 		}
 		q := `
-SELECT chunk.tub_id, chunk.tub_name, chunk.document_id, chunk.chunk_id, chunk.content, chunk.created_at, chunk.updated_at 
 FROM "%s".chunk
 INNER JOIN "%s".document USING (tub_id, document_id)
 WHERE chunk."%s" IS NOT NULL
@@ -153,110 +183,40 @@ WHERE chunk."%s" IS NOT NULL
 		q = fmt.Sprintf(q, schema, schema, colName)
 		args := []any{vectorToSQLArray(vector)}
 
-		i := len(args) + 1
-
-		// Handle document_id filter separately (it's a column, not a header)
-		if documentIdFilters, hasDocumentId := documentFilter["document_id"]; hasDocumentId {
-			for _, filterValue := range documentIdFilters {
-				if filterValue.Simple != nil {
-					q += fmt.Sprintf(" AND document.document_id = $%d \n", i)
-					args = append(args, *filterValue.Simple)
-					i++
-				} else if filterValue.Array != nil {
-					q += fmt.Sprintf(" AND document.document_id = ANY($%d) \n", i)
-					args = append(args, filterValue.Array)
-					i++
-				} else if filterValue.Condition != nil {
-					leftSide := "document.document_id"
-					rightSide := fmt.Sprintf("$%d", i)
-
-					switch filterValue.Condition.Operator {
-					case ragnar.OpEqual:
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThan:
-						q += fmt.Sprintf(" AND %s > %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThanOrEqual:
-						q += fmt.Sprintf(" AND %s >= %s \n", leftSide, rightSide)
-					case ragnar.OpLessThan:
-						q += fmt.Sprintf(" AND %s < %s \n", leftSide, rightSide)
-					case ragnar.OpLessThanOrEqual:
-						q += fmt.Sprintf(" AND %s <= %s \n", leftSide, rightSide)
-					case ragnar.OpIn:
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					default:
-						return fmt.Errorf("unsupported operator: %s", filterValue.Condition.Operator)
-					}
-					args = append(args, filterValue.Condition.Value)
-					i++
-				}
-			}
+		documentFilter, chunkFilter := splitChunkFilter(documentFilter)
+		filterSQL, args, err := documentFilterSQL(documentFilter, args)
+		if err != nil {
+			return err
 		}
-
-		// Handle header filters
-		for fieldName, filterValues := range documentFilter {
-			// Skip document_id as we already handled it above
-			if fieldName == "document_id" {
-				continue
-			}
-			fieldName = strings.ToLower(fieldName)
-
-			// Process each filter value for this field (multiple conditions are AND-ed together)
-			for _, filterValue := range filterValues {
-				if filterValue.Simple != nil {
-					// Simple equality check
-					q += fmt.Sprintf(" AND document.headers -> $%d = $%d \n", i, i+1)
-					args = append(args, fieldName, *filterValue.Simple)
-					i += 2
-				} else if filterValue.Array != nil {
-					// Array contains check (ANY operator)
-					q += fmt.Sprintf(" AND document.headers -> $%d = ANY($%d) \n", i, i+1)
-					args = append(args, fieldName, filterValue.Array)
-					i += 2
-				} else if filterValue.Condition != nil {
-					// Operator-based condition
-					// Determine the cast expression based on value type
-					leftSide := fmt.Sprintf("document.headers -> $%d", i)
-					rightSide := fmt.Sprintf("$%d", i+1)
-
-					// Apply type casting for numeric comparisons
-					switch filterValue.Condition.ValueType {
-					case ragnar.ValueTypeInteger:
-						leftSide = fmt.Sprintf("CAST(document.headers -> $%d AS INTEGER)", i)
-						rightSide = fmt.Sprintf("CAST($%d AS INTEGER)", i+1)
-					case ragnar.ValueTypeNumeric:
-						leftSide = fmt.Sprintf("CAST(document.headers -> $%d AS NUMERIC)", i)
-						rightSide = fmt.Sprintf("CAST($%d AS NUMERIC)", i+1)
-						// ValueTypeText or default - no casting needed
-					}
-
-					switch filterValue.Condition.Operator {
-					case ragnar.OpEqual:
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThan:
-						q += fmt.Sprintf(" AND %s > %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThanOrEqual:
-						q += fmt.Sprintf(" AND %s >= %s \n", leftSide, rightSide)
-					case ragnar.OpLessThan:
-						q += fmt.Sprintf(" AND %s < %s \n", leftSide, rightSide)
-					case ragnar.OpLessThanOrEqual:
-						q += fmt.Sprintf(" AND %s <= %s \n", leftSide, rightSide)
-					case ragnar.OpIn:
-						// For $in operator with a single value in condition
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					default:
-						return fmt.Errorf("unsupported operator: %s", filterValue.Condition.Operator)
-					}
-					args = append(args, fieldName, filterValue.Condition.Value)
-					i += 2
-				}
-			}
+		q += filterSQL
+		filterSQL, args, err = chunkFilterSQL(chunkFilter, args)
+		if err != nil {
+			return err
 		}
+		q += filterSQL
 
-		q += fmt.Sprintf("\nORDER BY chunk.\"%s\" <=> CAST($1 AS VECTOR(%d))", colName, model.OutputDimensions)
-		q += fmt.Sprintf("\nLIMIT $%d\nOFFSET $%d", i, i+1)
+		distance := fmt.Sprintf(`chunk."%s" <=> CAST($1 AS VECTOR(%d))`, colName, model.OutputDimensions)
+		similarity := fmt.Sprintf("1 - (%s)", distance)
+		score, args, err := scoreExpression(scoring, similarity, args)
+		if err != nil {
+			return err
+		}
+		i := len(args) + 1
 
-		args = append(args, limit, offset)
-		i += 2
+		q = fmt.Sprintf(`
+SELECT chunk.tub_id, chunk.tub_name, chunk.document_id, chunk.chunk_id, chunk.content,
+       chunk.context, chunk.heading_path, chunk.start_offset, chunk.end_offset, chunk.page_start, chunk.page_end, chunk.parent_id, chunk.symbol, chunk.content_hash, chunk.created_at, chunk.updated_at,
+       %s AS similarity, %s AS score`, similarity, score) + q
+		q += fmt.Sprintf("\nORDER BY %s", distance)
+
+		if scoring.IsZero() {
+			q += fmt.Sprintf("\nLIMIT $%d\nOFFSET $%d", i, i+1)
+			args = append(args, limit, offset)
+		} else {
+			q += fmt.Sprintf("\nLIMIT $%d", i)
+			q = fmt.Sprintf("SELECT * FROM (%s) candidates\nORDER BY score DESC, similarity DESC\nLIMIT $%d\nOFFSET $%d", q, i+1, i+2)
+			args = append(args, max(scoringCandidates, 4*(limit+offset)), limit, offset)
+		}
 
 		err = d.db.SelectContext(ctx, &chunks, q, args...)
 		if err != nil {
@@ -271,3 +231,84 @@ WHERE chunk."%s" IS NOT NULL
 	}
 	return chunks, nil
 }
+
+// scoreExpression combines the vector similarity with recency decay and header boosts,
+// appending the needed query arguments to args. The similarity is clamped to 0 or above before it is
+// multiplied, as a boost would otherwise rank a chunk of negative similarity lower.
+func scoreExpression(scoring ragnar.SearchScoring, similarity string, args []any) (string, []any, error) {
+	if scoring.IsZero() {
+		return similarity, args, nil
+	}
+	score := fmt.Sprintf("GREATEST(0, %s)", similarity)
+
+	halfLife, err := scoring.HalfLife()
+	if err != nil {
+		return "", nil, err
+	}
+	if halfLife > 0 {
+		timestamp := "document.updated_at"
+		if field := strings.ToLower(scoring.RecencyField); field != "" && field != "updated_at" {
+			args = append(args, field)
+			// documents with a missing or unparsable date header are not decayed
+			timestamp = fmt.Sprintf("CASE WHEN pg_input_is_valid(document.headers -> $%d, 'timestamptz') THEN CAST(document.headers -> $%d AS TIMESTAMPTZ) END", len(args), len(args))
+		}
+		args = append(args, halfLife.Seconds())
+		score += fmt.Sprintf("\n       * COALESCE(power(0.5, GREATEST(0, EXTRACT(EPOCH FROM now() - (%s)))::DOUBLE PRECISION / CAST($%d AS DOUBLE PRECISION)), 1)", timestamp, len(args))
+	}
+
+	for _, boost := range scoring.Boosts {
+		args = append(args, strings.ToLower(boost.Field), boost.Value, boost.Factor)
+		n := len(args)
+		score += fmt.Sprintf("\n       * CASE WHEN document.headers -> $%d = $%d THEN CAST($%d AS DOUBLE PRECISION) ELSE 1 END", n-2, n-1, n)
+	}
+
+	return score, args, nil
+}
+
+// GetParentChunks returns the parent chunks the given chunks link to, chunks without a parent are skipped
+func (d *DAO) GetParentChunks(ctx context.Context, tubname string, chunks []ragnar.Chunk) ([]ragnar.ParentChunk, error) {
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return nil, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	var documentIds []string
+	var parentIds []int
+	for _, chunk := range chunks {
+		if chunk.ParentId != nil {
+			documentIds = append(documentIds, chunk.DocumentId)
+			parentIds = append(parentIds, *chunk.ParentId)
+		}
+	}
+	if len(parentIds) == 0 {
+		return nil, nil
+	}
+
+	var parents []ragnar.ParentChunk
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		schema, err := tubToSchema(tubname)
+		if err != nil {
+			return fmt.Errorf("error getting schema: %w", err)
+		}
+		q := `SELECT p.tub_id, p.tub_name, p.document_id, p.parent_id, p.content, p.heading_path, p.start_offset, p.end_offset,
+       p.page_start, p.page_end, p.created_at, p.updated_at
+FROM "%s".parent_chunk p
+INNER JOIN unnest(CAST($1 AS TEXT[]), CAST($2 AS INT[])) AS ref(document_id, parent_id)
+        ON p.document_id = ref.document_id AND p.parent_id = ref.parent_id`
+		q = fmt.Sprintf(q, schema)
+		err = tx.SelectContext(ctx, &parents, q, documentIds, parentIds)
+		if err != nil {
+			return fmt.Errorf("error getting parent chunks: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parents, nil
+}
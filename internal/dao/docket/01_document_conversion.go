@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/modfin/pqdocket"
 	"github.com/modfin/ragnar"
 	"github.com/modfin/ragnar/internal/document"
 	"github.com/modfin/ragnar/internal/util"
 	"io"
+	"mime"
+	"strconv"
+	"strings"
 )
 
 func (d *Docket) ScheduleDocumentConversion(doc ragnar.Document) error {
@@ -46,13 +50,40 @@ func documentConversion(d *Docket) func(pqdocket.RunningTask) error {
 			return fmt.Errorf("as documentConversion document missing content-disposition header")
 		}
 
-		md, err := document.ConvertToMarkdown(l, file, *contentType, *contentDisposition)
+		tub, err := d.db.InternalGetTub(doc.TubId)
+		if err != nil {
+			l.Error("failed to get tub", "error", err)
+			return fmt.Errorf("as documentConversion failed to get tub: %w", err)
+		}
+		// settings are validated when the tub is saved, values stored before that are left out
+		settings, _ := tub.GetSettings()
+
+		detectedContentType := ""
+		if detected := doc.Headers[ragnar.HeaderContentTypeDetected]; detected != nil {
+			detectedContentType = *detected
+		}
+		conversionContentType, _ := d.converters.ConversionContentType(*contentType, *contentDisposition, detectedContentType)
+
+		conversion, err := d.converters.Convert(l, file, conversionContentType, *contentDisposition, settings)
 		if err != nil {
 			l.Error("failed to convert to markdown", "error", err)
 			return fmt.Errorf("as documentConversion failed to convert to markdown: %w", err)
 		}
 
-		data, err := io.ReadAll(md)
+		// also without headers, so that the ones derived from an earlier conversion are removed
+		if len(conversion.Headers) > 0 || doc.Headers[ragnar.HeaderDerivedHeaders] != nil {
+			headers := pgtype.Hstore{}
+			for k, v := range conversion.Headers {
+				headers[k] = util.Ptr(v)
+			}
+			doc, err = d.db.InternalAddDocumentHeaders(doc, headers)
+			if err != nil {
+				l.Error("failed to add document headers", "error", err)
+				return fmt.Errorf("as documentConversion failed to add document headers: %w", err)
+			}
+		}
+
+		data, err := io.ReadAll(conversion.Markdown)
 		if err != nil {
 			l.Error("failed to read markdown", "error", err)
 			return fmt.Errorf("as documentConversion failed to read markdown: %w", err)
@@ -78,6 +109,12 @@ func documentConversion(d *Docket) func(pqdocket.RunningTask) error {
 			return fmt.Errorf("as documentConversion failed to put document: %w", err)
 		}
 
+		err = d.upsertAttachments(doc, conversion)
+		if err != nil {
+			l.Error("failed to upsert attachments", "error", err)
+			return fmt.Errorf("as documentConversion failed to upsert attachments: %w", err)
+		}
+
 		err = d.ScheduleDocumentChunking(doc)
 		if err != nil {
 			l.Error("failed to schedule chunking", "error", err)
@@ -87,3 +124,105 @@ func documentConversion(d *Docket) func(pqdocket.RunningTask) error {
 		return nil
 	}
 }
+
+// upsertAttachments ingests the attachments of the document as documents of their own in the same tub, with the
+// document as ragnar.HeaderParentDocumentId. The attachments get the headers of the document, except the ones of the
+// file itself and the ones read from it, such as the subject of an email. Documents of the attachments of an earlier
+// conversion are updated by file name, and the ones no longer attached are deleted.
+func (d *Docket) upsertAttachments(doc ragnar.Document, conversion document.Conversion) error {
+	children, err := d.db.InternalGetChildDocuments(doc)
+	if err != nil {
+		return err
+	}
+	existing := map[string][]ragnar.Document{}
+	for _, child := range children {
+		name := attachmentFilename(child)
+		existing[name] = append(existing[name], child)
+	}
+
+	for _, attachment := range conversion.Attachments {
+		child := ragnar.Document{
+			TubId:   doc.TubId,
+			TubName: doc.TubName,
+			Headers: pgtype.Hstore{},
+		}
+		if docs := existing[attachment.Filename]; len(docs) > 0 {
+			child.DocumentId = docs[0].DocumentId
+			existing[attachment.Filename] = docs[1:]
+		}
+		for k, v := range doc.Headers {
+			if _, ok := conversion.Headers[k]; ok || strings.HasPrefix(k, "content-") || k == ragnar.HeaderDerivedHeaders {
+				continue
+			}
+			child.Headers[k] = v
+		}
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		child.Headers["content-type"] = &contentType
+		child.Headers["content-length"] = util.Ptr(strconv.Itoa(len(attachment.Data)))
+		child.Headers["content-disposition"] = util.Ptr(mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		child.Headers[ragnar.HeaderParentDocumentId] = &doc.DocumentId
+		if detected := document.Sniff(attachment.Data); detected != "" {
+			child.Headers[ragnar.HeaderContentTypeDetected] = &detected
+		}
+
+		child, err = d.db.InternalUpsertDocument(child)
+		if err != nil {
+			return err
+		}
+		hash, err := util.HashReaderSHA256(bytes.NewReader(attachment.Data))
+		if err != nil {
+			return fmt.Errorf("error hashing attachment %s: %w", attachment.Filename, err)
+		}
+		changed, err := d.stor.PutDocument(context.Background(), child.TubName, child.DocumentId, bytes.NewReader(attachment.Data), int64(len(attachment.Data)), child.Headers, hash)
+		if err != nil {
+			return fmt.Errorf("error putting attachment %s: %w", attachment.Filename, err)
+		}
+		if changed {
+			err = d.ScheduleDocumentConversion(child)
+			if err != nil {
+				return fmt.Errorf("error scheduling conversion of attachment %s: %w", attachment.Filename, err)
+			}
+		}
+	}
+
+	for _, docs := range existing {
+		for _, child := range docs {
+			err = d.deleteDocumentTree(child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteDocumentTree deletes the document along with the documents of its attachments
+func (d *Docket) deleteDocumentTree(doc ragnar.Document) error {
+	children, err := d.db.InternalGetChildDocuments(doc)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err = d.deleteDocumentTree(child)
+		if err != nil {
+			return err
+		}
+	}
+	err = d.db.InternalDeleteDocument(doc)
+	if err != nil {
+		return err
+	}
+	return d.stor.DeleteDocument(context.Background(), doc.TubName, doc.DocumentId)
+}
+
+func attachmentFilename(doc ragnar.Document) string {
+	disposition := doc.Headers["content-disposition"]
+	if disposition == nil {
+		return ""
+	}
+	_, params, _ := mime.ParseMediaType(*disposition)
+	return params["filename"]
+}
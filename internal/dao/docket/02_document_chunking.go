@@ -3,10 +3,13 @@ package docket
 import (
 	"context"
 	"fmt"
+	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/pqdocket"
 	"github.com/modfin/ragnar"
 	"github.com/modfin/ragnar/internal/chunker"
+	"github.com/modfin/ragnar/internal/util"
 	"io"
+	"mime"
 )
 
 func (d *Docket) ScheduleDocumentChunking(doc ragnar.Document) error {
@@ -42,12 +45,38 @@ func chunkDocument(d *Docket) func(pqdocket.RunningTask) error {
 			return fmt.Errorf("chunkDocument, could not read md version of document: %w", err)
 		}
 
-		splitter := chunker.GetTextSplitterFromTubSettings(tub.Settings)
+		model, err := d.tubEmbedModel(tub)
+		if err != nil {
+			l.Error("failed to get model", "error", err)
+			return fmt.Errorf("chunkDocument, could not get embed model: %w", err)
+		}
+		embedSentences := func(texts []string) ([][]float32, error) {
+			return d.ai.EmbedStrings(model.WithType(embed.TypeDocument), texts)
+		}
+
+		var filename string
+		if cd := doc.Headers["content-disposition"]; cd != nil {
+			_, params, err := mime.ParseMediaType(*cd)
+			if err == nil {
+				filename = params["filename"]
+			}
+		}
 
-		chunks, err := splitter.SplitText(string(md))
+		if _, err := ragnar.ParseTubSettings(tub.Settings); err != nil {
+			// settings stored before they were validated, the chunker uses the values that parsed
+			l.Warn("tub has invalid settings, chunking with the valid ones", "error", err)
+		}
+		newChunks, newParents, err := chunker.ChunkDocument(string(md), d.converters.DocumentContentType(doc), filename, tub.Settings, embedSentences)
 		if err != nil {
 			l.Error("failed to split document", "error", err)
-			return fmt.Errorf("chunkDocument, could not split document: %w", err)
+			return fmt.Errorf("chunkDocument, %w", err)
+		}
+		for i := range newChunks {
+			newChunks[i].DocumentId, newChunks[i].TubId, newChunks[i].TubName = doc.DocumentId, doc.TubId, doc.TubName
+			newChunks[i].ContentHash = util.HashContent(newChunks[i].Content)
+		}
+		for i := range newParents {
+			newParents[i].DocumentId, newParents[i].TubId, newParents[i].TubName = doc.DocumentId, doc.TubId, doc.TubName
 		}
 
 		currentChunks, err := d.db.InternalGetChunks(doc)
@@ -55,46 +84,70 @@ func chunkDocument(d *Docket) func(pqdocket.RunningTask) error {
 			l.Error("failed to get current chunks", "error", err)
 			return fmt.Errorf("chunkDocument, could not get current chunks: %w", err)
 		}
+		currentParents, err := d.db.InternalGetParentChunks(doc)
+		if err != nil {
+			l.Error("failed to get current parent chunks", "error", err)
+			return fmt.Errorf("chunkDocument, could not get current parent chunks: %w", err)
+		}
 
-		if len(currentChunks) == len(chunks) {
+		if len(currentChunks) == len(newChunks) && len(currentParents) == len(newParents) {
 			identical := true
-			for i, chunk := range chunks {
-				if currentChunks[i].Content != chunk {
+			for i, chunk := range newChunks {
+				if !sameChunk(currentChunks[i], chunk) {
+					identical = false
+					break
+				}
+			}
+			for i, parent := range newParents {
+				if currentParents[i].Content != parent.Content {
 					identical = false
 					break
 				}
 			}
 			if identical {
+				// the chunks might still lack contexts or embeddings, e.g. when contextualization was turned on or
+				// the embed model changed, and the next task only handles the chunks not done with the current model
 				l.Info("chunks are identical to existing ones, skipping update")
+				if tub.ChunkContextualize() {
+					err = d.ScheduleChunkContextualization(doc)
+					if err != nil {
+						l.Error("failed to schedule chunk contextualization", "error", err)
+						return fmt.Errorf("chunkDocument, could not schedule chunk contextualization: %w", err)
+					}
+					return nil
+				}
+				err = d.ScheduleChunkEmbedding(doc)
+				if err != nil {
+					l.Error("failed to schedule chunk embedding", "error", err)
+					return fmt.Errorf("chunkDocument, could not schedule chunk embedding: %w", err)
+				}
 				return nil
 			}
 		}
 
-		// DELETING ALL OLD CHUNKS
-		err = d.db.InternalDeleteChunks(doc)
+		// chunks with unchanged content keep their context and embeddings, also when they have moved, so that
+		// only new and modified chunks are contextualized and embedded. A chunk next to other chunks than before
+		// is contextualized again
+		reuse := util.MatchUnchangedChunks(currentChunks, newChunks)
+		err = d.db.InternalReplaceChunks(doc, newParents, newChunks, reuse, util.ChangedSurroundings(currentChunks, newChunks, reuse))
 		if err != nil {
-			l.Error("failed to delete chunks", "error", err)
-			return fmt.Errorf("chunkDocument, could not delete chunks: %w", err)
+			l.Error("failed to replace chunks", "error", err)
+			return fmt.Errorf("chunkDocument, could not replace chunks: %w", err)
 		}
+		l.Info("replaced chunks", "chunks", len(newChunks), "unchanged", len(reuse))
 
-		if len(chunks) == 0 {
+		if len(newChunks) == 0 {
 			l.Warn("no chunks created from document")
 			return nil
 		}
 
-		for i, chunk := range chunks {
-			// TODO map and batch the inserts?
-			err = d.db.InternalInsertChunk(ragnar.Chunk{
-				ChunkId:    i,
-				DocumentId: doc.DocumentId,
-				TubId:      doc.TubId,
-				TubName:    doc.TubName,
-				Content:    chunk,
-			})
+		if tub.ChunkContextualize() {
+			err = d.ScheduleChunkContextualization(doc)
 			if err != nil {
-				l.Error("failed to insert chunk", "error", err)
-				return fmt.Errorf("chunkDocument, could not insert chunk: %w", err)
+				l.Error("failed to schedule chunk contextualization", "error", err)
+				return fmt.Errorf("chunkDocument, could not schedule chunk contextualization: %w", err)
 			}
+			return nil
 		}
 
 		err = d.ScheduleChunkEmbedding(doc)
@@ -106,3 +159,18 @@ func chunkDocument(d *Docket) func(pqdocket.RunningTask) error {
 		return nil
 	}
 }
+
+// sameChunk compares the content and metadata of two chunks
+func sameChunk(a, b ragnar.Chunk) bool {
+	equal := func(x, y *int) bool {
+		return (x == nil && y == nil) || (x != nil && y != nil && *x == *y)
+	}
+	return a.Content == b.Content &&
+		a.HeadingPath == b.HeadingPath &&
+		equal(a.StartOffset, b.StartOffset) &&
+		equal(a.EndOffset, b.EndOffset) &&
+		equal(a.PageStart, b.PageStart) &&
+		equal(a.PageEnd, b.PageEnd) &&
+		equal(a.ParentId, b.ParentId) &&
+		a.Symbol == b.Symbol
+}
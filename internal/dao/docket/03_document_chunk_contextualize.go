@@ -0,0 +1,129 @@
+package docket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/modfin/pqdocket"
+	"github.com/modfin/ragnar"
+)
+
+// usagePurposeChunkContextualize is the purpose token usage of chunk contextualization is recorded under
+const usagePurposeChunkContextualize = "chunk-contextualize"
+
+func (d *Docket) ScheduleChunkContextualization(doc ragnar.Document) error {
+	return d.scheduleDocumentTask(doc, taskChunkContextualize)
+}
+
+// chunkContextualize has the gen model write a short context for each chunk of the document, situating it in
+// the whole document, before the chunks are embedded
+func chunkContextualize(d *Docket) func(pqdocket.RunningTask) error {
+	return func(task pqdocket.RunningTask) error {
+		l := d.log.With("task", task.TaskId(), "func", task.Func())
+		l.Info("starting contextualizing chunks of document")
+
+		var doc ragnar.Document
+		err := task.BindMetadata(&doc)
+		if err != nil {
+			l.Error("failed to bind metadata", "error", err)
+			return fmt.Errorf("in chunkContextualize pqdocket.BindMetadata: %w", err)
+		}
+		l = l.With("document_id", doc.DocumentId)
+
+		tub, err := d.db.InternalGetTub(doc.TubId)
+		if err != nil {
+			l.Error("failed to get tub", "error", err)
+			return fmt.Errorf("in chunkContextualize pqdocket.InternalGetTub: %w", err)
+		}
+
+		chunks, err := d.db.InternalGetChunks(doc)
+		if err != nil {
+			l.Error("failed to get chunks", "error", err)
+			return fmt.Errorf("in chunkContextualize pqdocket.InternalGetChunks: %w", err)
+		}
+
+		reader, err := d.stor.GetDocumentMarkdown(context.Background(), doc.TubName, doc.DocumentId)
+		if err != nil {
+			l.Error("failed to get document markdown", "error", err)
+			return fmt.Errorf("in chunkContextualize stor.GetDocumentMarkdown: %w", err)
+		}
+		defer reader.Close()
+		md, err := io.ReadAll(reader)
+		if err != nil {
+			l.Error("failed to read document markdown", "error", err)
+			return fmt.Errorf("in chunkContextualize io.ReadAll: %w", err)
+		}
+
+		var modelFQN string
+		if val, ok := tub.Settings["chunk_contextualize_model"]; ok && val != nil {
+			modelFQN = *val
+		}
+		model, err := d.ai.GenModelOf(modelFQN)
+		if err != nil {
+			l.Error("failed to get model", "error", err)
+			return fmt.Errorf("in chunkContextualize ai.GenModelOf: %w", err)
+		}
+
+		usage := ragnar.TokenUsage{
+			TubId:      doc.TubId,
+			TubName:    doc.TubName,
+			DocumentId: doc.DocumentId,
+			Purpose:    usagePurposeChunkContextualize,
+			Model:      model.FQN(),
+		}
+		// record the tokens spent even if a later chunk fails, they are paid for either way
+		recordUsage := func() {
+			if usage.Calls == 0 {
+				return
+			}
+			err := d.db.InternalInsertTokenUsage(usage)
+			if err != nil {
+				l.Error("failed to record token usage", "error", err)
+			}
+		}
+
+		for _, chunk := range chunks {
+			if chunk.Context != "" && chunk.ContextModel == model.FQN() {
+				// contextualized by an earlier attempt of this task, or earlier with the same model
+				continue
+			}
+			text, meta, err := d.ai.ContextualizeChunk(model, string(md), chunk.Content)
+			usage.Calls++
+			usage.InputTokens += meta.InputTokens
+			usage.OutputTokens += meta.OutputTokens
+			usage.TotalTokens += meta.TotalTokens
+			if err != nil {
+				recordUsage()
+				l.Error("failed to contextualize chunk", "error", err, "chunk_id", chunk.ChunkId)
+				return fmt.Errorf("in chunkContextualize ai.ContextualizeChunk: %w", err)
+			}
+
+			chunk.Context, chunk.ContextModel = text, model.FQN()
+			err = d.db.InternalSetChunkContext(chunk)
+			if err != nil {
+				recordUsage()
+				l.Error("failed to set chunk context", "error", err, "chunk_id", chunk.ChunkId)
+				return fmt.Errorf("in chunkContextualize db.InternalSetChunkContext: %w", err)
+			}
+
+			// one model call per chunk, keep the claim for long documents
+			_, err = task.ExtendClaim(5 * time.Minute)
+			if err != nil {
+				recordUsage()
+				l.Error("failed to extend claim", "error", err)
+				return fmt.Errorf("in chunkContextualize task.ExtendClaim: %w", err)
+			}
+		}
+		recordUsage()
+		l.Info("contextualized chunks", "chunks", len(chunks), "calls", usage.Calls, "total_tokens", usage.TotalTokens)
+
+		err = d.ScheduleChunkEmbedding(doc)
+		if err != nil {
+			l.Error("failed to schedule chunk embedding", "error", err)
+			return fmt.Errorf("in chunkContextualize, could not schedule chunk embedding: %w", err)
+		}
+		return nil
+	}
+}
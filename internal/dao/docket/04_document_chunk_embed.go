@@ -0,0 +1,117 @@
+package docket
+
+import (
+	"fmt"
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/pqdocket"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/util"
+	"strings"
+)
+
+func (d *Docket) ScheduleChunkEmbedding(doc ragnar.Document) error {
+	return d.scheduleDocumentTask(doc, taskChunkEmbed)
+}
+
+func chunkEmbed(d *Docket) func(pqdocket.RunningTask) error {
+	return func(task pqdocket.RunningTask) error {
+		l := d.log.With("task", task.TaskId(), "func", task.Func())
+		l.Info("starting embedding of document")
+
+		var doc ragnar.Document
+		err := task.BindMetadata(&doc)
+		if err != nil {
+			l.Error("failed to bind metadata", "error", err)
+			return fmt.Errorf("in chunkEmbed pqdocket.BindMetadata: %w", err)
+		}
+		l = l.With("document_id", doc.DocumentId)
+
+		tub, err := d.db.InternalGetTub(doc.TubId)
+		if err != nil {
+			l.Error("failed to get tub", "error", err)
+			return fmt.Errorf("in chunkEmbed pqdocket.InternalGetTub: %w", err)
+		}
+
+		chunks, err := d.db.InternalGetChunks(doc)
+		if err != nil {
+			l.Error("failed to get chunks", "error", err)
+			return fmt.Errorf("in chunkEmbed pqdocket.InternalGetChunks: %w", err)
+		}
+		if len(chunks) == 0 {
+			l.Warn("no chunks to embed, skipping")
+			return nil
+		}
+
+		model, err := d.tubEmbedModel(tub)
+		if err != nil {
+			l.Error("failed to get model", "error", err)
+			return fmt.Errorf("in chunkEmbed ai.EmbedModelOf: %w", err)
+		}
+
+		err = d.db.InternalEnsureTubEmbeddingSchema(doc, model)
+		if err != nil {
+			l.Error("failed to ensure embedding schema", "error", err)
+			return fmt.Errorf("in chunkEmbed ai.InternalEnsureTubEmbeddingSchema: %w", err)
+		}
+
+		tmpl, err := tub.GetEmbedTemplate()
+		if err != nil {
+			l.Error("failed to get embed template", "error", err)
+			return fmt.Errorf("in chunkEmbed tub.GetEmbedTemplate: %w", err)
+		}
+		embedded, err := d.db.InternalGetEmbeddedChunkIds(doc, model)
+		if err != nil {
+			l.Error("failed to get embedded chunks", "error", err)
+			return fmt.Errorf("in chunkEmbed db.InternalGetEmbeddedChunkIds: %w", err)
+		}
+
+		// the template and the generated context only change the text that is embedded, the stored content is kept
+		embedChunks := make([]ragnar.Chunk, len(chunks))
+		changed := make([]bool, len(chunks))
+		var changedChunks []ragnar.Chunk
+		for i, chunk := range chunks {
+			switch {
+			case tmpl != nil:
+				chunk.Content, err = ragnar.RenderEmbedText(tmpl, doc, chunk)
+				if err != nil {
+					l.Error("failed to render embed template", "error", err, "chunk_id", chunk.ChunkId)
+					return fmt.Errorf("in chunkEmbed ragnar.RenderEmbedText: %w", err)
+				}
+				// the generated context is embedded also with templates that leave out {{.Context}}
+				if tub.ChunkContextualize() && chunk.Context != "" && !strings.Contains(chunk.Content, chunk.Context) {
+					chunk.Content = chunk.Context + "\n\n" + chunk.Content
+				}
+			case tub.ChunkContextualize() && chunk.Context != "":
+				chunk.Content = chunk.Context + "\n\n" + chunk.Content
+			}
+			embedChunks[i] = chunk
+
+			// only chunks whose embedded text, or model, changed since they were last embedded are embedded again
+			hash := util.HashContent(model.FQN() + "\n" + chunk.Content)
+			if chunks[i].EmbedHash != hash || !embedded[chunk.ChunkId] {
+				changed[i] = true
+				chunks[i].EmbedHash = hash
+				changedChunks = append(changedChunks, chunks[i])
+			}
+		}
+		if len(changedChunks) == 0 {
+			l.Info("all chunks are already embedded, skipping")
+			return nil
+		}
+		l.Info("embedding changed chunks", "chunks", len(chunks), "changed", len(changedChunks))
+
+		vectors, err := d.ai.EmbedChangedChunks(model.WithType(embed.TypeDocument), embedChunks, changed)
+		if err != nil {
+			l.Error("failed to embed chunks", "error", err)
+			return fmt.Errorf("in chunkEmbed ai.EmbedChangedChunks: %w", err)
+		}
+
+		err = d.db.InternalSetEmbeds(doc, model, changedChunks, vectors)
+		if err != nil {
+			l.Error("failed to set embeds", "error", err)
+			return fmt.Errorf("in chunkEmbed ai.InternalSetEmbeds: %w", err)
+		}
+
+		return nil
+	}
+}
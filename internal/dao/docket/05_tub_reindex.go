@@ -0,0 +1,226 @@
+package docket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modfin/pqdocket"
+	"github.com/modfin/ragnar"
+)
+
+// reindexPollInterval is how often a running reindex checks on its scheduled documents and schedules more
+const reindexPollInterval = 10 * time.Second
+
+// defaultReindexConcurrency is the number of documents of a reindex in process at the same time
+const defaultReindexConcurrency = 10
+
+// ScheduleTubReindex starts scheduling the documents of the reindex
+func (d *Docket) ScheduleTubReindex(reindex ragnar.Reindex) error {
+	return d.scheduleReindexStep(reindex, time.Now())
+}
+
+// scheduleReindexStep schedules the next step of a reindex. Every step is a task of its own, without ref id,
+// so that the reindex is not holding on to a worker while it waits for its documents
+func (d *Docket) scheduleReindexStep(reindex ragnar.Reindex, at time.Time) error {
+	md, err := pqdocket.CreateMetadata(ragnar.Reindex{ReindexId: reindex.ReindexId, TubId: reindex.TubId, TubName: reindex.TubName})
+	if err != nil {
+		return fmt.Errorf("at %s pqdocket.CreateMetadata: %w", taskTubReindex, err)
+	}
+	creator := d.docket.
+		CreateTaskWithFuncName(taskTubReindex).
+		WithMetadata(md).
+		ScheduleAt(at)
+	_, err = d.docket.InsertTask(nil, creator)
+	if err != nil {
+		return fmt.Errorf("at %s pqdocket.InsertTask: %w", taskTubReindex, err)
+	}
+	return nil
+}
+
+// CancelReindexDocuments removes the tasks of documents scheduled by a cancelled reindex that have not started yet,
+// tasks already running are processed to the end
+func (d *Docket) CancelReindexDocuments(docs []ragnar.ReindexDocument) error {
+	for _, doc := range docs {
+		if doc.ScheduledAt == nil {
+			continue
+		}
+		tasks, err := d.docket.FindTasks(nil, pqdocket.WithRefId(strings.TrimPrefix(doc.DocumentId, "doc_")))
+		if err != nil {
+			return fmt.Errorf("at CancelReindexDocuments pqdocket.FindTasks: %w", err)
+		}
+		for _, t := range tasks {
+			if t.CreatedAt().Before(*doc.ScheduledAt) || t.CompletedAt().Valid {
+				continue
+			}
+			err = t.Delete(nil)
+			if err != nil && !errors.Is(err, pqdocket.ErrClaimedOrNotFound) {
+				return fmt.Errorf("at CancelReindexDocuments pqdocket.DeleteTask: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// tubReindex checks which of the scheduled documents of a reindex are done and schedules pending documents until
+// the configured number of documents are in process, then schedules itself again until all documents are done.
+// The reindex is marked as failed when a step has failed its last attempt, as no further step is scheduled
+func tubReindex(d *Docket) func(pqdocket.RunningTask) error {
+	step := tubReindexStep(d)
+	return func(task pqdocket.RunningTask) error {
+		err := step(task)
+		if err == nil || task.ClaimCount() < maxClaimCount {
+			return err
+		}
+		var reindex ragnar.Reindex
+		if task.BindMetadata(&reindex) != nil {
+			return err
+		}
+		ferr := d.db.InternalFailReindex(reindex.ReindexId)
+		if ferr != nil {
+			d.log.Error("failed to mark reindex as failed", "error", ferr, "reindex_id", reindex.ReindexId)
+		}
+		return err
+	}
+}
+
+func tubReindexStep(d *Docket) func(pqdocket.RunningTask) error {
+	return func(task pqdocket.RunningTask) error {
+		l := d.log.With("task", task.TaskId(), "func", task.Func())
+
+		var reindex ragnar.Reindex
+		err := task.BindMetadata(&reindex)
+		if err != nil {
+			l.Error("failed to bind metadata", "error", err)
+			return fmt.Errorf("in tubReindex pqdocket.BindMetadata: %w", err)
+		}
+		l = l.With("reindex_id", reindex.ReindexId, "tub", reindex.TubName)
+
+		reindex, err = d.db.InternalGetReindex(reindex.ReindexId)
+		if err != nil {
+			l.Error("failed to get reindex", "error", err)
+			return fmt.Errorf("in tubReindex db.InternalGetReindex: %w", err)
+		}
+		if reindex.Status != ragnar.ReindexStatusRunning {
+			l.Info("reindex is no longer running", "status", reindex.Status)
+			return nil
+		}
+
+		scheduled, err := d.db.InternalGetScheduledReindexDocuments(reindex.ReindexId)
+		if err != nil {
+			l.Error("failed to get scheduled documents", "error", err)
+			return fmt.Errorf("in tubReindex db.InternalGetScheduledReindexDocuments: %w", err)
+		}
+		running := 0
+		for _, doc := range scheduled {
+			done, err := d.documentTasksDone(doc.DocumentId, *doc.ScheduledAt)
+			if err != nil {
+				l.Error("failed to get document tasks", "error", err, "document_id", doc.DocumentId)
+				return fmt.Errorf("in tubReindex documentTasksDone: %w", err)
+			}
+			if !done {
+				running++
+				continue
+			}
+			err = d.db.InternalSetReindexDocumentStatus(reindex.ReindexId, doc.DocumentId, "done", "")
+			if err != nil {
+				l.Error("failed to set document done", "error", err, "document_id", doc.DocumentId)
+				return fmt.Errorf("in tubReindex db.InternalSetReindexDocumentStatus: %w", err)
+			}
+		}
+
+		concurrency := d.config.ReindexConcurrency
+		if concurrency <= 0 {
+			concurrency = defaultReindexConcurrency
+		}
+		if free := concurrency - running; free > 0 {
+			docs, err := d.db.InternalScheduleReindexDocuments(reindex, free)
+			if err != nil {
+				l.Error("failed to get pending documents", "error", err)
+				return fmt.Errorf("in tubReindex db.InternalScheduleReindexDocuments: %w", err)
+			}
+			for _, doc := range docs {
+				err = d.scheduleReindexDocument(reindex.Stage, doc)
+				if err != nil {
+					l.Warn("failed to schedule document", "error", err, "document_id", doc.DocumentId)
+					err = d.db.InternalSetReindexDocumentStatus(reindex.ReindexId, doc.DocumentId, "failed", err.Error())
+					if err != nil {
+						l.Error("failed to set document failed", "error", err, "document_id", doc.DocumentId)
+						return fmt.Errorf("in tubReindex db.InternalSetReindexDocumentStatus: %w", err)
+					}
+					continue
+				}
+				running++
+			}
+		}
+
+		if running == 0 {
+			err = d.db.InternalCompleteReindex(reindex.ReindexId)
+			if err != nil {
+				l.Error("failed to complete reindex", "error", err)
+				return fmt.Errorf("in tubReindex db.InternalCompleteReindex: %w", err)
+			}
+			l.Info("reindex completed", "total", reindex.Total)
+			return nil
+		}
+
+		err = d.scheduleReindexStep(reindex, time.Now().Add(reindexPollInterval))
+		if err != nil {
+			l.Error("failed to schedule next reindex step", "error", err)
+			return fmt.Errorf("in tubReindex scheduleReindexStep: %w", err)
+		}
+		return nil
+	}
+}
+
+func (d *Docket) scheduleReindexDocument(stage string, doc ragnar.Document) error {
+	switch stage {
+	case ragnar.ReindexStageConvert:
+		return d.ScheduleDocumentConversion(doc)
+	case ragnar.ReindexStageEmbed:
+		return d.ScheduleChunkEmbedding(doc)
+	case ragnar.ReindexStageContextualize:
+		return d.ScheduleChunkContextualization(doc)
+	default:
+		return d.ScheduleDocumentChunking(doc)
+	}
+}
+
+// documentTasksDone reports whether all tasks of the document created since the given time have completed. A stage
+// schedules the next one before it completes, so a document being processed always has an incomplete task
+func (d *Docket) documentTasksDone(documentId string, since time.Time) (bool, error) {
+	tasks, err := d.docket.FindTasks(nil, pqdocket.WithRefId(strings.TrimPrefix(documentId, "doc_")))
+	if err != nil {
+		return false, fmt.Errorf("at documentTasksDone pqdocket.FindTasks: %w", err)
+	}
+	for _, t := range tasks {
+		if t.CreatedAt().Before(since) {
+			continue
+		}
+		if !t.CompletedAt().Valid {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// reportReindexFailure wraps a document task so that the document is marked as failed in running reindexes when
+// the task has failed its last attempt
+func (d *Docket) reportReindexFailure(f func(pqdocket.RunningTask) error) func(pqdocket.RunningTask) error {
+	return func(task pqdocket.RunningTask) error {
+		err := f(task)
+		if err == nil || task.ClaimCount() < maxClaimCount {
+			return err
+		}
+		var doc ragnar.Document
+		if task.BindMetadata(&doc) != nil {
+			return err
+		}
+		ferr := d.db.InternalFailReindexDocument(doc.DocumentId, fmt.Sprintf("%s: %v", task.Func(), err))
+		if ferr != nil {
+			d.log.Error("failed to mark reindex document as failed", "error", ferr, "document_id", doc.DocumentId)
+		}
+		return err
+	}
+}
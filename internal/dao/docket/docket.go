@@ -8,36 +8,47 @@ import (
 	"strings"
 	"time"
 
+	"github.com/modfin/bellman/models/embed"
+	"github.com/modfin/bellman/services/voyageai"
 	"github.com/modfin/pqdocket"
 	"github.com/modfin/ragnar/internal/ai"
 	"github.com/modfin/ragnar/internal/dao"
+	"github.com/modfin/ragnar/internal/document"
 	"github.com/modfin/ragnar/internal/storage"
 )
 
 type Config struct {
 	URI string `cli:"db-uri"`
+
+	ReindexConcurrency int `cli:"reindex-concurrency"`
 }
 type Docket struct {
-	docket pqdocket.Docket
-	log    *slog.Logger
-	config Config
-	db     *dao.DAO
-	stor   *storage.Storage
-	ai     *ai.AI
+	docket     pqdocket.Docket
+	log        *slog.Logger
+	config     Config
+	db         *dao.DAO
+	stor       *storage.Storage
+	ai         *ai.AI
+	converters *document.Registry
 }
 
 const taskDocumentConversion = "document-conversion"
 const taskChunkDocument = "chunk-document"
+const taskChunkContextualize = "chunks-contextualize"
 const taskChunkEmbed = "chunks-embed"
+const taskTubReindex = "tub-reindex"
+
+// maxClaimCount is the number of attempts of a task before it is given up on
+const maxClaimCount = 2
 
-func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, ai *ai.AI, config Config) (*Docket, error) {
+func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, ai *ai.AI, converters *document.Registry, config Config) (*Docket, error) {
 
-	log.Info("Initializing pqdocket", "funcs", []string{taskDocumentConversion, taskChunkDocument, taskChunkEmbed})
+	log.Info("Initializing pqdocket", "funcs", []string{taskDocumentConversion, taskChunkDocument, taskChunkContextualize, taskChunkEmbed, taskTubReindex})
 
 	pq, err := pqdocket.Init(config.URI,
 		pqdocket.WithLogger(log.With("who", "pqdocket")),
 		pqdocket.Namespace("embedding_queue"),
-		pqdocket.MaxClaimCount(2),
+		pqdocket.MaxClaimCount(maxClaimCount),
 		pqdocket.Parallelism(5),
 		pqdocket.DefaultClaimTime(5*60),
 	)
@@ -46,17 +57,20 @@ func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, ai *ai.AI, config
 	}
 
 	docket := &Docket{
-		config: config,
-		docket: pq,
-		db:     db,
-		stor:   stor,
-		log:    log,
-		ai:     ai,
+		config:     config,
+		docket:     pq,
+		db:         db,
+		stor:       stor,
+		log:        log,
+		ai:         ai,
+		converters: converters,
 	}
 
-	pq.RegisterFunctionWithFuncName(taskDocumentConversion, documentConversion(docket))
-	pq.RegisterFunctionWithFuncName(taskChunkDocument, chunkDocument(docket))
-	pq.RegisterFunctionWithFuncName(taskChunkEmbed, chunkEmbed(docket))
+	pq.RegisterFunctionWithFuncName(taskDocumentConversion, docket.reportReindexFailure(documentConversion(docket)))
+	pq.RegisterFunctionWithFuncName(taskChunkDocument, docket.reportReindexFailure(chunkDocument(docket)))
+	pq.RegisterFunctionWithFuncName(taskChunkContextualize, docket.reportReindexFailure(chunkContextualize(docket)))
+	pq.RegisterFunctionWithFuncName(taskChunkEmbed, docket.reportReindexFailure(chunkEmbed(docket)))
+	pq.RegisterFunctionWithFuncName(taskTubReindex, tubReindex(docket))
 
 	return docket, nil
 }
@@ -128,6 +142,16 @@ func (d *Docket) scheduleDocumentTask(doc ragnar.Document, funcName string) erro
 	return nil
 }
 
+// tubEmbedModel resolves the embedding model configured for the tub
+func (d *Docket) tubEmbedModel(tub ragnar.Tub) (embed.Model, error) {
+	model := voyageai.EmbedModel_voyage_context_3 // default model
+	modelFQN, ok := tub.Settings["embed_model"]
+	if ok && modelFQN != nil {
+		return d.ai.EmbedModelOf(*modelFQN)
+	}
+	return model, nil
+}
+
 func (d *Docket) Close(ctx context.Context) error {
 	var closed = make(chan struct{})
 	go func() {
@@ -112,104 +112,12 @@ func (d *DAO) ListDocuments(ctx context.Context, tubname string, filter ragnar.D
 		var args []interface{}
 		args = append(args, tubname)
 
-		i := 2
-
-		// Handle document_id filter separately (it's a column, not a header)
-		if documentIdFilters, hasDocumentId := filter["document_id"]; hasDocumentId {
-			for _, filterValue := range documentIdFilters {
-				if filterValue.Simple != nil {
-					q += fmt.Sprintf(" AND document.document_id = $%d \n", i)
-					args = append(args, *filterValue.Simple)
-					i++
-				} else if filterValue.Array != nil {
-					q += fmt.Sprintf(" AND document.document_id = ANY($%d) \n", i)
-					args = append(args, filterValue.Array)
-					i++
-				} else if filterValue.Condition != nil {
-					leftSide := "document.document_id"
-					rightSide := fmt.Sprintf("$%d", i)
-
-					switch filterValue.Condition.Operator {
-					case ragnar.OpEqual:
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThan:
-						q += fmt.Sprintf(" AND %s > %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThanOrEqual:
-						q += fmt.Sprintf(" AND %s >= %s \n", leftSide, rightSide)
-					case ragnar.OpLessThan:
-						q += fmt.Sprintf(" AND %s < %s \n", leftSide, rightSide)
-					case ragnar.OpLessThanOrEqual:
-						q += fmt.Sprintf(" AND %s <= %s \n", leftSide, rightSide)
-					case ragnar.OpIn:
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					default:
-						return fmt.Errorf("unsupported operator: %s", filterValue.Condition.Operator)
-					}
-					args = append(args, filterValue.Condition.Value)
-					i++
-				}
-			}
-		}
-
-		// Handle header filters
-		for fieldName, filterValues := range filter {
-			// Skip document_id as we already handled it above
-			if fieldName == "document_id" {
-				continue
-			}
-			fieldName = strings.ToLower(fieldName)
-
-			// Process each filter value for this field (multiple conditions are AND-ed together)
-			for _, filterValue := range filterValues {
-				if filterValue.Simple != nil {
-					// Simple equality check
-					q += fmt.Sprintf(" AND document.headers -> $%d = $%d \n", i, i+1)
-					args = append(args, fieldName, *filterValue.Simple)
-					i += 2
-				} else if filterValue.Array != nil {
-					// Array contains check (ANY operator)
-					q += fmt.Sprintf(" AND document.headers -> $%d = ANY($%d) \n", i, i+1)
-					args = append(args, fieldName, filterValue.Array)
-					i += 2
-				} else if filterValue.Condition != nil {
-					// Operator-based condition
-					// Determine the cast expression based on value type
-					leftSide := fmt.Sprintf("document.headers -> $%d", i)
-					rightSide := fmt.Sprintf("$%d", i+1)
-
-					// Apply type casting for numeric comparisons
-					switch filterValue.Condition.ValueType {
-					case ragnar.ValueTypeInteger:
-						leftSide = fmt.Sprintf("CAST(document.headers -> $%d AS INTEGER)", i)
-						rightSide = fmt.Sprintf("CAST($%d AS INTEGER)", i+1)
-					case ragnar.ValueTypeNumeric:
-						leftSide = fmt.Sprintf("CAST(document.headers -> $%d AS NUMERIC)", i)
-						rightSide = fmt.Sprintf("CAST($%d AS NUMERIC)", i+1)
-						// ValueTypeText or default - no casting needed
-					}
-
-					switch filterValue.Condition.Operator {
-					case ragnar.OpEqual:
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThan:
-						q += fmt.Sprintf(" AND %s > %s \n", leftSide, rightSide)
-					case ragnar.OpGreaterThanOrEqual:
-						q += fmt.Sprintf(" AND %s >= %s \n", leftSide, rightSide)
-					case ragnar.OpLessThan:
-						q += fmt.Sprintf(" AND %s < %s \n", leftSide, rightSide)
-					case ragnar.OpLessThanOrEqual:
-						q += fmt.Sprintf(" AND %s <= %s \n", leftSide, rightSide)
-					case ragnar.OpIn:
-						// For $in operator with a single value in condition
-						q += fmt.Sprintf(" AND %s = %s \n", leftSide, rightSide)
-					default:
-						return fmt.Errorf("unsupported operator: %s", filterValue.Condition.Operator)
-					}
-					args = append(args, fieldName, filterValue.Condition.Value)
-					i += 2
-				}
-			}
+		filterSQL, args, err := documentFilterSQL(filter, args)
+		if err != nil {
+			return err
 		}
+		q += filterSQL
+		i := len(args) + 1
 
 		// Add ORDER BY clause if sort is specified
 		if len(sort) > 0 {
@@ -346,40 +254,55 @@ func (d *DAO) DeleteDocument(ctx context.Context, tubname string, documentId str
 			return fmt.Errorf("error checking permission to delete document: %w", err)
 		}
 
-		schema, err := tubToSchema(tubname)
-		if err != nil {
-			return fmt.Errorf("error getting schema: %w", err)
-		}
-		q := `DELETE FROM "%s"."chunk"
+		return deleteDocument(tx, tubname, documentId)
+	})
+}
+
+// deleteDocument deletes the document with its chunks and parent chunks
+func deleteDocument(tx *sqlx.Tx, tubname string, documentId string) error {
+	schema, err := tubToSchema(tubname)
+	if err != nil {
+		return fmt.Errorf("error getting schema: %w", err)
+	}
+	q := `DELETE FROM "%s"."chunk"
               WHERE tub_name = $1
  				AND document_id = $2
-		  `
-		q = fmt.Sprintf(q, schema)
-		_, err = tx.Exec(q, tubname, documentId)
-		if err != nil {
-			return fmt.Errorf("error deleting chunks: %w", err)
-		}
+	  `
+	q = fmt.Sprintf(q, schema)
+	_, err = tx.Exec(q, tubname, documentId)
+	if err != nil {
+		return fmt.Errorf("error deleting chunks: %w", err)
+	}
 
-		q = `DELETE FROM "%s"."document"
+	q = `DELETE FROM "%s"."parent_chunk"
               WHERE tub_name = $1
  				AND document_id = $2
-		  `
-		q = fmt.Sprintf(q, schema)
+	  `
+	q = fmt.Sprintf(q, schema)
+	_, err = tx.Exec(q, tubname, documentId)
+	if err != nil {
+		return fmt.Errorf("error deleting parent chunks: %w", err)
+	}
 
-		r, err := tx.Exec(q, tubname, documentId)
-		if err != nil {
-			return fmt.Errorf("error deleting document: %w", err)
-		}
-		n, err := r.RowsAffected()
-		if err != nil {
-			return fmt.Errorf("error getting rows affected: %w", err)
-		}
-		if n != 1 {
-			return errors.New("document not found, n " + strconv.FormatInt(n, 10))
-		}
+	q = `DELETE FROM "%s"."document"
+              WHERE tub_name = $1
+ 				AND document_id = $2
+	  `
+	q = fmt.Sprintf(q, schema)
 
-		return nil
-	})
+	r, err := tx.Exec(q, tubname, documentId)
+	if err != nil {
+		return fmt.Errorf("error deleting document: %w", err)
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if n != 1 {
+		return errors.New("document not found, n " + strconv.FormatInt(n, 10))
+	}
+
+	return nil
 }
 
 func (d *DAO) SetDocumentUpdatedAtNow(ctx context.Context, tubname string, documentId string) (ragnar.Document, error) {
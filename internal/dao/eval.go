@@ -0,0 +1,153 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/auth"
+)
+
+func (d *DAO) UpsertEvalSet(ctx context.Context, set ragnar.EvalSet) (ragnar.EvalSet, error) {
+	var retset ragnar.EvalSet
+
+	tubname := strings.ToLower(set.TubName)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return retset, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+	if set.EvalSetName == "" {
+		return retset, errors.New("eval set name is required")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_UPDATE)
+		if err != nil {
+			return fmt.Errorf("error checking permission to update tub: %w", err)
+		}
+
+		q := `INSERT INTO "public"."eval_set" (tub_id, tub_name, eval_set_name, queries)
+			  SELECT tub_id, tub_name, $2, $3 FROM "public"."tub" WHERE tub_name = $1
+			  ON CONFLICT (tub_id, eval_set_name) DO UPDATE
+			  SET queries = EXCLUDED.queries,
+			      updated_at = now()
+			  RETURNING *`
+		err = tx.GetContext(ctx, &retset, q, tubname, set.EvalSetName, set.Queries)
+		if err != nil {
+			return fmt.Errorf("error upserting eval set: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.EvalSet{}, err
+	}
+
+	return retset, nil
+}
+
+func (d *DAO) GetEvalSet(ctx context.Context, tubname string, evalSetName string) (ragnar.EvalSet, error) {
+	var set ragnar.EvalSet
+
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return set, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		q := `SELECT * FROM "public"."eval_set" WHERE tub_name = $1 AND eval_set_name = $2`
+		err = tx.GetContext(ctx, &set, q, tubname, evalSetName)
+		if err != nil {
+			return fmt.Errorf("error getting eval set: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.EvalSet{}, err
+	}
+
+	return set, nil
+}
+
+func (d *DAO) InsertEvalRun(ctx context.Context, run ragnar.EvalRun) (ragnar.EvalRun, error) {
+	var retrun ragnar.EvalRun
+
+	tubname := strings.ToLower(run.TubName)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return retrun, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	if run.Settings == nil {
+		run.Settings = pgtype.Hstore{}
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		q := `INSERT INTO "public"."eval_run"
+    			(eval_set_id, tub_id, tub_name, eval_set_name, settings, embed_model, metrics, results)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			  RETURNING *`
+		err = tx.GetContext(ctx, &retrun, q,
+			run.EvalSetId, run.TubId, tubname, run.EvalSetName,
+			run.Settings, run.EmbedModel, run.Metrics, run.Results,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting eval run: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.EvalRun{}, err
+	}
+
+	return retrun, nil
+}
+
+// ListEvalRuns returns the runs of an eval set, newest first
+func (d *DAO) ListEvalRuns(ctx context.Context, tubname string, evalSetName string, limit int, offset int) ([]ragnar.EvalRun, error) {
+	if limit == 0 {
+		limit = 100
+	}
+
+	var runs []ragnar.EvalRun
+
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return nil, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		q := `SELECT * FROM "public"."eval_run"
+			  WHERE tub_name = $1
+			    AND eval_set_name = $2
+			  ORDER BY created_at DESC
+			  LIMIT $3
+			  OFFSET $4`
+		err = tx.SelectContext(ctx, &runs, q, tubname, evalSetName, limit, offset)
+		if err != nil {
+			return fmt.Errorf("error listing eval runs: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,100 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/auth"
+)
+
+const (
+	defaultFacetBuckets = 10
+	// the most common values returned for a text facet
+	maxFacetValues = 100
+)
+
+// DocumentFacets counts the documents matching the filter per value of each facet field
+func (d *DAO) DocumentFacets(ctx context.Context, tubname string, filter ragnar.DocumentFilter, fields []ragnar.FacetField) ([]ragnar.Facet, error) {
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return nil, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	facets := make([]ragnar.Facet, 0, len(fields))
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+		schema, err := tubToSchema(tubname)
+		if err != nil {
+			return fmt.Errorf("error getting schema: %w", err)
+		}
+
+		for _, field := range fields {
+			filterSQL, args, err := documentFilterSQL(filter, []any{tubname})
+			if err != nil {
+				return err
+			}
+			from := fmt.Sprintf(`FROM "%s"."document" WHERE tub_name = $1 %s`, schema, filterSQL)
+
+			facet := ragnar.Facet{Field: strings.ToLower(field.Field), ValueType: field.ValueType}
+			switch field.ValueType {
+			case ragnar.ValueTypeInteger, ragnar.ValueTypeNumeric:
+				buckets := field.Buckets
+				if buckets <= 0 {
+					buckets = defaultFacetBuckets
+				}
+				castType := "NUMERIC"
+				if field.ValueType == ragnar.ValueTypeInteger {
+					castType = "INTEGER"
+				}
+				args = append(args, facet.Field, buckets)
+				f, k := len(args)-1, len(args)
+				// documents with a value that does not parse as the requested type are left out of the buckets
+				q := fmt.Sprintf(`
+WITH v AS (
+    SELECT CAST(CAST(document.headers -> $%d AS %s) AS NUMERIC) AS value
+    %s AND pg_input_is_valid(document.headers -> $%d, '%s')
+), b AS (
+    SELECT min(value) AS lo, max(value) AS hi FROM v
+), x AS (
+    SELECT CASE WHEN b.hi = b.lo THEN 1 ELSE LEAST(width_bucket(v.value, b.lo, b.hi, CAST($%d AS INTEGER)), CAST($%d AS INTEGER)) END AS bucket
+    FROM v, b
+)
+SELECT CAST(b.lo + (x.bucket - 1) * (b.hi - b.lo) / CAST($%d AS INTEGER) AS DOUBLE PRECISION) AS range_from,
+       CAST(b.lo + x.bucket * (b.hi - b.lo) / CAST($%d AS INTEGER) AS DOUBLE PRECISION) AS range_to,
+       count(*) AS count
+FROM x, b
+GROUP BY x.bucket, b.lo, b.hi
+ORDER BY x.bucket`, f, castType, from, f, strings.ToLower(castType), k, k, k, k)
+				err = tx.SelectContext(ctx, &facet.Ranges, q, args...)
+			case ragnar.ValueTypeText, "":
+				facet.ValueType = ragnar.ValueTypeText
+				args = append(args, facet.Field, maxFacetValues)
+				q := fmt.Sprintf(`
+SELECT document.headers -> $%d AS value, count(*) AS count
+%s AND exist(document.headers, $%d)
+GROUP BY 1
+ORDER BY count DESC, value
+LIMIT $%d`, len(args)-1, from, len(args)-1, len(args))
+				err = tx.SelectContext(ctx, &facet.Values, q, args...)
+			default:
+				return fmt.Errorf("unsupported facet type: %s", field.ValueType)
+			}
+			if err != nil {
+				return fmt.Errorf("error counting facet %s: %w", facet.Field, err)
+			}
+			facets = append(facets, facet)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
@@ -0,0 +1,248 @@
+package dao
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/modfin/ragnar"
+)
+
+// documentFilterSQL translates the filter into AND-ed conditions on the document table,
+// appending the needed query arguments to args
+func documentFilterSQL(filter ragnar.DocumentFilter, args []any) (string, []any, error) {
+	var q string
+	i := len(args) + 1
+
+	// Handle document_id filter separately (it's a column, not a header)
+	if documentIdFilters, hasDocumentId := filter["document_id"]; hasDocumentId {
+		for _, filterValue := range documentIdFilters {
+			if filterValue.Simple != nil {
+				q += fmt.Sprintf(" AND document.document_id = $%d \n", i)
+				args = append(args, *filterValue.Simple)
+				i++
+			} else if filterValue.Array != nil {
+				q += fmt.Sprintf(" AND document.document_id = ANY($%d) \n", i)
+				args = append(args, filterValue.Array)
+				i++
+			} else if filterValue.Condition != nil {
+				leftSide := "document.document_id"
+				rightSide := fmt.Sprintf("$%d", i)
+
+				var cond string
+				switch filterValue.Condition.Operator {
+				case ragnar.OpEqual:
+					cond = fmt.Sprintf("%s = %s", leftSide, rightSide)
+				case ragnar.OpNotEqual:
+					cond = fmt.Sprintf("%s IS DISTINCT FROM %s", leftSide, rightSide)
+				case ragnar.OpGreaterThan:
+					cond = fmt.Sprintf("%s > %s", leftSide, rightSide)
+				case ragnar.OpGreaterThanOrEqual:
+					cond = fmt.Sprintf("%s >= %s", leftSide, rightSide)
+				case ragnar.OpLessThan:
+					cond = fmt.Sprintf("%s < %s", leftSide, rightSide)
+				case ragnar.OpLessThanOrEqual:
+					cond = fmt.Sprintf("%s <= %s", leftSide, rightSide)
+				case ragnar.OpIn:
+					cond = fmt.Sprintf("%s = %s", leftSide, rightSide)
+				default:
+					return "", nil, fmt.Errorf("unsupported operator: %s", filterValue.Condition.Operator)
+				}
+				q += andFilterCondition(cond, filterValue.Condition.Not)
+				args = append(args, filterValue.Condition.Value)
+				i++
+			}
+		}
+	}
+
+	// Handle header filters
+	for fieldName, filterValues := range filter {
+		// Skip document_id as we already handled it above
+		if fieldName == "document_id" {
+			continue
+		}
+		if strings.HasPrefix(fieldName, chunkFilterPrefix) {
+			return "", nil, fmt.Errorf("chunk filter %s is only supported when searching chunks", fieldName)
+		}
+		fieldName = strings.ToLower(fieldName)
+
+		// Process each filter value for this field (multiple conditions are AND-ed together)
+		for _, filterValue := range filterValues {
+			if filterValue.Simple != nil {
+				// Simple equality check
+				q += fmt.Sprintf(" AND document.headers -> $%d = $%d \n", i, i+1)
+				args = append(args, fieldName, *filterValue.Simple)
+				i += 2
+			} else if filterValue.Array != nil {
+				// Array contains check (ANY operator)
+				q += fmt.Sprintf(" AND document.headers -> $%d = ANY($%d) \n", i, i+1)
+				args = append(args, fieldName, filterValue.Array)
+				i += 2
+			} else if filterValue.Condition != nil {
+				// Operator-based condition
+				// Determine the cast expression based on value type
+				leftSide := fmt.Sprintf("document.headers -> $%d", i)
+				rightSide := fmt.Sprintf("$%d", i+1)
+
+				// Apply type casting for numeric comparisons
+				switch filterValue.Condition.ValueType {
+				case ragnar.ValueTypeInteger:
+					leftSide = fmt.Sprintf("CAST(document.headers -> $%d AS INTEGER)", i)
+					rightSide = fmt.Sprintf("CAST($%d AS INTEGER)", i+1)
+				case ragnar.ValueTypeNumeric:
+					leftSide = fmt.Sprintf("CAST(document.headers -> $%d AS NUMERIC)", i)
+					rightSide = fmt.Sprintf("CAST($%d AS NUMERIC)", i+1)
+					// ValueTypeText or default - no casting needed
+				}
+
+				var cond string
+				switch filterValue.Condition.Operator {
+				case ragnar.OpEqual:
+					cond = fmt.Sprintf("%s = %s", leftSide, rightSide)
+				case ragnar.OpNotEqual:
+					cond = fmt.Sprintf("%s IS DISTINCT FROM %s", leftSide, rightSide)
+				case ragnar.OpGreaterThan:
+					cond = fmt.Sprintf("%s > %s", leftSide, rightSide)
+				case ragnar.OpGreaterThanOrEqual:
+					cond = fmt.Sprintf("%s >= %s", leftSide, rightSide)
+				case ragnar.OpLessThan:
+					cond = fmt.Sprintf("%s < %s", leftSide, rightSide)
+				case ragnar.OpLessThanOrEqual:
+					cond = fmt.Sprintf("%s <= %s", leftSide, rightSide)
+				case ragnar.OpIn:
+					// For $in operator with a single value in condition
+					cond = fmt.Sprintf("%s = %s", leftSide, rightSide)
+				default:
+					return "", nil, fmt.Errorf("unsupported operator: %s", filterValue.Condition.Operator)
+				}
+				q += andFilterCondition(cond, filterValue.Condition.Not)
+				args = append(args, fieldName, filterValue.Condition.Value)
+				i += 2
+			}
+		}
+	}
+
+	return q, args, nil
+}
+
+// chunkFilterPrefix marks filter fields on chunk metadata rather than document headers, e.g. chunk.page
+const chunkFilterPrefix = "chunk."
+
+// chunkFilterColumns are the chunk metadata columns that can be filtered on, and their types
+var chunkFilterColumns = map[string]ragnar.ValueType{
+	"heading_path": ragnar.ValueTypeText,
+	"symbol":       ragnar.ValueTypeText,
+	"start_offset": ragnar.ValueTypeInteger,
+	"end_offset":   ragnar.ValueTypeInteger,
+	"page_start":   ragnar.ValueTypeInteger,
+	"page_end":     ragnar.ValueTypeInteger,
+}
+
+// splitChunkFilter separates the chunk metadata fields of the filter from the document fields
+func splitChunkFilter(filter ragnar.DocumentFilter) (document ragnar.DocumentFilter, chunk ragnar.DocumentFilter) {
+	document = ragnar.NewDocumentFilter()
+	chunk = ragnar.NewDocumentFilter()
+	for field, values := range filter {
+		if name, ok := strings.CutPrefix(strings.ToLower(field), chunkFilterPrefix); ok {
+			chunk[name] = values
+			continue
+		}
+		document[field] = values
+	}
+	return document, chunk
+}
+
+// chunkFilterSQL translates filters on chunk metadata into AND-ed conditions on the chunk table.
+// The field "page" matches chunks whose page range contains the page, comparisons on it compare the range.
+func chunkFilterSQL(filter ragnar.DocumentFilter, args []any) (string, []any, error) {
+	var q string
+	for field, filterValues := range filter {
+		valueType, ok := chunkFilterColumns[field]
+		if !ok && field != "page" {
+			return "", nil, fmt.Errorf("unknown chunk filter field: %s%s", chunkFilterPrefix, field)
+		}
+		cast := "TEXT"
+		if valueType == ragnar.ValueTypeInteger || field == "page" {
+			cast = "INTEGER"
+		}
+
+		for _, filterValue := range filterValues {
+			if filterValue.Array != nil {
+				args = append(args, filterValue.Array)
+				if field == "page" {
+					q += fmt.Sprintf(" AND EXISTS (SELECT 1 FROM unnest(CAST($%d AS INTEGER[])) p WHERE p BETWEEN chunk.page_start AND chunk.page_end) \n", len(args))
+				} else {
+					q += fmt.Sprintf(" AND CAST(chunk.%s AS TEXT) = ANY($%d) \n", field, len(args))
+				}
+				continue
+			}
+
+			operator := ragnar.OpEqual
+			var value string
+			var not bool
+			switch {
+			case filterValue.Simple != nil:
+				value = *filterValue.Simple
+			case filterValue.Condition != nil:
+				operator = filterValue.Condition.Operator
+				value = filterValue.Condition.Value
+				not = filterValue.Condition.Not
+			default:
+				continue
+			}
+			args = append(args, value)
+			right := fmt.Sprintf("CAST($%d AS %s)", len(args), cast)
+
+			var cond string
+			if field == "page" {
+				switch operator {
+				case ragnar.OpEqual, ragnar.OpIn:
+					cond = fmt.Sprintf("%s BETWEEN chunk.page_start AND chunk.page_end", right)
+				case ragnar.OpNotEqual:
+					// like $ne elsewhere, chunks without pages, such as of documents that are not PDFs, match too
+					cond = fmt.Sprintf("(%s NOT BETWEEN chunk.page_start AND chunk.page_end OR chunk.page_start IS NULL)", right)
+				case ragnar.OpGreaterThan:
+					cond = fmt.Sprintf("chunk.page_end > %s", right)
+				case ragnar.OpGreaterThanOrEqual:
+					cond = fmt.Sprintf("chunk.page_end >= %s", right)
+				case ragnar.OpLessThan:
+					cond = fmt.Sprintf("chunk.page_start < %s", right)
+				case ragnar.OpLessThanOrEqual:
+					cond = fmt.Sprintf("chunk.page_start <= %s", right)
+				default:
+					return "", nil, fmt.Errorf("unsupported operator: %s", operator)
+				}
+				q += andFilterCondition(cond, not)
+				continue
+			}
+
+			left := fmt.Sprintf("chunk.%s", field)
+			switch operator {
+			case ragnar.OpEqual, ragnar.OpIn:
+				cond = fmt.Sprintf("%s = %s", left, right)
+			case ragnar.OpNotEqual:
+				cond = fmt.Sprintf("%s IS DISTINCT FROM %s", left, right)
+			case ragnar.OpGreaterThan:
+				cond = fmt.Sprintf("%s > %s", left, right)
+			case ragnar.OpGreaterThanOrEqual:
+				cond = fmt.Sprintf("%s >= %s", left, right)
+			case ragnar.OpLessThan:
+				cond = fmt.Sprintf("%s < %s", left, right)
+			case ragnar.OpLessThanOrEqual:
+				cond = fmt.Sprintf("%s <= %s", left, right)
+			default:
+				return "", nil, fmt.Errorf("unsupported operator: %s", operator)
+			}
+			q += andFilterCondition(cond, not)
+		}
+	}
+	return q, args, nil
+}
+
+// andFilterCondition ANDs the condition to a query. A negated condition is the complement of the condition, so it
+// also matches where the condition is NULL, e.g. for documents without the header compared.
+func andFilterCondition(cond string, not bool) string {
+	if not {
+		return fmt.Sprintf(" AND NOT COALESCE(%s, false) \n", cond)
+	}
+	return fmt.Sprintf(" AND %s \n", cond)
+}
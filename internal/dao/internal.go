@@ -1,12 +1,17 @@
 package dao
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/modfin/bellman/models/embed"
+	"slices"
 	"strings"
 
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
 	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/util"
 )
 
 func embedModelToColName(model embed.Model) (string, error) {
@@ -31,25 +36,6 @@ func embedModelToColName(model embed.Model) (string, error) {
 	return fmt.Sprintf("embedding_%s", name), nil
 }
 
-func (d *DAO) InternalDeleteChunks(doc ragnar.Document) error {
-
-	tubname := doc.TubName
-	schema, err := tubToSchema(tubname)
-	if err != nil {
-		return fmt.Errorf("error getting schema from tubname, %s: %w", tubname, err)
-	}
-
-	q := `DELETE FROM "%s".chunk WHERE document_id = $1 AND tub_id = $2`
-	q = fmt.Sprintf(q, schema)
-
-	_, err = d.db.Exec(q, doc.DocumentId, doc.TubId)
-	if err != nil {
-		return fmt.Errorf("error deleting chunks: %w", err)
-	}
-
-	return nil
-}
-
 func (d *DAO) InternalGetTub(tubId string) (ragnar.Tub, error) {
 
 	q := `SELECT * FROM "public"."tub" WHERE tub_id = $1`
@@ -62,22 +48,93 @@ func (d *DAO) InternalGetTub(tubId string) (ragnar.Tub, error) {
 	return tub, nil
 }
 
-func (d *DAO) InternalInsertChunk(chunk ragnar.Chunk) error {
+// InternalReplaceChunks replaces the chunks and parent chunks of the document in one transaction. reuse maps the
+// chunk id of a new chunk to the id of a current chunk with the same content, which is kept with its context and
+// embeddings and only gets the id and metadata of the new chunk. Other current chunks are deleted. The context of
+// the reused chunks in resetContext is cleared, as it was written for surroundings the chunk no longer has
+func (d *DAO) InternalReplaceChunks(doc ragnar.Document, parents []ragnar.ParentChunk, chunks []ragnar.Chunk, reuse map[int]int, resetContext map[int]bool) error {
+	schema, err := tubToSchema(doc.TubName)
+	if err != nil {
+		return fmt.Errorf("at InternalReplaceChunks, error getting schema from tubname, %s: %w", doc.TubName, err)
+	}
 
-	schema, err := tubToSchema(chunk.TubName)
+	err = d.txx(context.Background(), func(tx *sqlx.Tx) error {
+		return replaceChunks(tx, schema, doc, parents, chunks, reuse, resetContext)
+	})
 	if err != nil {
-		return fmt.Errorf("at InternalInsertChunk, error getting schema from tubname, %s: %w", chunk.TubName, err)
+		return fmt.Errorf("at InternalReplaceChunks, %w", err)
 	}
+	return nil
+}
 
-	q := `INSERT INTO "%s"."chunk" (chunk_id, document_id, tub_id, tub_name, content) VALUES ($1, $2, $3, $4, $5)`
+func replaceChunks(tx *sqlx.Tx, schema string, doc ragnar.Document, parents []ragnar.ParentChunk, chunks []ragnar.Chunk, reuse map[int]int, resetContext map[int]bool) error {
+	reusedIds := make([]int, 0, len(reuse))
+	for _, oldId := range reuse {
+		reusedIds = append(reusedIds, oldId)
+	}
 
-	q = fmt.Sprintf(q, schema)
+	q := fmt.Sprintf(`DELETE FROM "%s".parent_chunk WHERE document_id = $1 AND tub_id = $2`, schema)
+	_, err := tx.Exec(q, doc.DocumentId, doc.TubId)
+	if err != nil {
+		return fmt.Errorf("error deleting parent chunks: %w", err)
+	}
 
-	_, err = d.db.Exec(q, chunk.ChunkId, chunk.DocumentId, chunk.TubId, chunk.TubName, chunk.Content)
+	// move the reused chunks out of the way of the new chunk ids, to negative ids, and delete the rest
+	q = fmt.Sprintf(`UPDATE "%s".chunk SET chunk_id = -1 - chunk_id
+		WHERE document_id = $1 AND tub_id = $2 AND chunk_id = ANY($3)`, schema)
+	_, err = tx.Exec(q, doc.DocumentId, doc.TubId, reusedIds)
 	if err != nil {
-		return fmt.Errorf("at InternalInsertChunk, error inserting chunk: %w", err)
+		return fmt.Errorf("error moving reused chunks: %w", err)
+	}
+	q = fmt.Sprintf(`DELETE FROM "%s".chunk WHERE document_id = $1 AND tub_id = $2 AND chunk_id >= 0`, schema)
+	_, err = tx.Exec(q, doc.DocumentId, doc.TubId)
+	if err != nil {
+		return fmt.Errorf("error deleting chunks: %w", err)
+	}
+
+	for _, parent := range parents {
+		q = fmt.Sprintf(`INSERT INTO "%s"."parent_chunk" (parent_id, document_id, tub_id, tub_name, content, heading_path, start_offset, end_offset, page_start, page_end)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, schema)
+		_, err = tx.Exec(q, parent.ParentId, doc.DocumentId, doc.TubId, doc.TubName, parent.Content,
+			parent.HeadingPath, parent.StartOffset, parent.EndOffset, parent.PageStart, parent.PageEnd)
+		if err != nil {
+			return fmt.Errorf("error inserting parent chunk: %w", err)
+		}
 	}
 
+	for _, chunk := range chunks {
+		if chunk.ContentHash == "" {
+			chunk.ContentHash = util.HashContent(chunk.Content)
+		}
+		oldId, ok := reuse[chunk.ChunkId]
+		if ok {
+			q = fmt.Sprintf(`UPDATE "%s"."chunk"
+				SET chunk_id = $4, heading_path = $5, start_offset = $6, end_offset = $7, page_start = $8, page_end = $9,
+				    parent_id = $10, symbol = $11, context = CASE WHEN $12 THEN '' ELSE context END,
+				    context_model = CASE WHEN $12 THEN '' ELSE context_model END, updated_at = now()
+				WHERE document_id = $1 AND tub_id = $2 AND chunk_id = -1 - $3`, schema)
+			_, err = tx.Exec(q, doc.DocumentId, doc.TubId, oldId, chunk.ChunkId, chunk.HeadingPath,
+				chunk.StartOffset, chunk.EndOffset, chunk.PageStart, chunk.PageEnd, chunk.ParentId, chunk.Symbol, resetContext[chunk.ChunkId])
+			if err != nil {
+				return fmt.Errorf("error updating reused chunk: %w", err)
+			}
+			continue
+		}
+		q = fmt.Sprintf(`INSERT INTO "%s"."chunk" (chunk_id, document_id, tub_id, tub_name, content, heading_path, start_offset, end_offset, page_start, page_end, parent_id, symbol, content_hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`, schema)
+		_, err = tx.Exec(q, chunk.ChunkId, doc.DocumentId, doc.TubId, doc.TubName, chunk.Content,
+			chunk.HeadingPath, chunk.StartOffset, chunk.EndOffset, chunk.PageStart, chunk.PageEnd, chunk.ParentId, chunk.Symbol, chunk.ContentHash)
+		if err != nil {
+			return fmt.Errorf("error inserting chunk: %w", err)
+		}
+	}
+
+	// reused chunks that no new chunk claimed
+	q = fmt.Sprintf(`DELETE FROM "%s".chunk WHERE document_id = $1 AND tub_id = $2 AND chunk_id < 0`, schema)
+	_, err = tx.Exec(q, doc.DocumentId, doc.TubId)
+	if err != nil {
+		return fmt.Errorf("error deleting unclaimed chunks: %w", err)
+	}
 	return nil
 }
 
@@ -89,7 +146,7 @@ func (d *DAO) InternalGetChunks(doc ragnar.Document) ([]ragnar.Chunk, error) {
 	}
 
 	q := `
-SELECT tub_id, tub_name, document_id, chunk_id, content, created_at, updated_at 
+SELECT tub_id, tub_name, document_id, chunk_id, content, context, context_model, heading_path, start_offset, end_offset, page_start, page_end, parent_id, symbol, content_hash, embed_hash, created_at, updated_at 
 FROM "%s".chunk 
 WHERE document_id = $1 
   AND tub_id = $2 
@@ -160,10 +217,10 @@ func (d *DAO) InternalSetEmbeds(doc ragnar.Document, model embed.Model, chunks [
 	if err != nil {
 		return fmt.Errorf("error getting column name from model, %s: %w", model.FQN(), err)
 	}
-	q := `UPDATE "%s".chunk SET "%s" = CAST($1 AS VECTOR(%d)) WHERE document_id = $2 AND tub_id = $3 AND chunk_id = $4`
+	q := `UPDATE "%s".chunk SET "%s" = CAST($1 AS VECTOR(%d)), embed_hash = $5 WHERE document_id = $2 AND tub_id = $3 AND chunk_id = $4`
 	q = fmt.Sprintf(q, schema, colName, model.OutputDimensions)
 	for i, chunk := range chunks {
-		_, err = d.db.Exec(q, vectorToSQLArray(vectors[i]), chunk.DocumentId, chunk.TubId, chunk.ChunkId)
+		_, err = d.db.Exec(q, vectorToSQLArray(vectors[i]), chunk.DocumentId, chunk.TubId, chunk.ChunkId, chunk.EmbedHash)
 		if err != nil {
 			return fmt.Errorf("error updating chunk embedding: %w", err)
 		}
@@ -172,6 +229,31 @@ func (d *DAO) InternalSetEmbeds(doc ragnar.Document, model embed.Model, chunks [
 	return nil
 }
 
+// InternalGetEmbeddedChunkIds returns the ids of the chunks of the document that have an embedding of the model
+func (d *DAO) InternalGetEmbeddedChunkIds(doc ragnar.Document, model embed.Model) (map[int]bool, error) {
+	schema, err := tubToSchema(doc.TubName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting schema from tubname, %s: %w", doc.TubName, err)
+	}
+	colName, err := embedModelToColName(model)
+	if err != nil {
+		return nil, fmt.Errorf("error getting column name from model, %s: %w", model.FQN(), err)
+	}
+	q := `SELECT chunk_id FROM "%s".chunk WHERE document_id = $1 AND tub_id = $2 AND "%s" IS NOT NULL`
+	q = fmt.Sprintf(q, schema, colName)
+
+	var ids []int
+	err = d.db.Select(&ids, q, doc.DocumentId, doc.TubId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting embedded chunks: %w", err)
+	}
+	embedded := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		embedded[id] = true
+	}
+	return embedded, nil
+}
+
 func vectorToSQLArray(vec []float32) string {
 	strs := make([]string, len(vec))
 	for i, v := range vec {
@@ -179,3 +261,153 @@ func vectorToSQLArray(vec []float32) string {
 	}
 	return fmt.Sprintf("[%s]", strings.Join(strs, ","))
 }
+
+func (d *DAO) InternalSetChunkContext(chunk ragnar.Chunk) error {
+	schema, err := tubToSchema(chunk.TubName)
+	if err != nil {
+		return fmt.Errorf("at InternalSetChunkContext, error getting schema from tubname, %s: %w", chunk.TubName, err)
+	}
+
+	q := `UPDATE "%s".chunk SET context = $1, context_model = $5, updated_at = now() WHERE document_id = $2 AND tub_id = $3 AND chunk_id = $4`
+	q = fmt.Sprintf(q, schema)
+
+	_, err = d.db.Exec(q, chunk.Context, chunk.DocumentId, chunk.TubId, chunk.ChunkId, chunk.ContextModel)
+	if err != nil {
+		return fmt.Errorf("at InternalSetChunkContext, error updating chunk context: %w", err)
+	}
+	return nil
+}
+
+func (d *DAO) InternalGetParentChunks(doc ragnar.Document) ([]ragnar.ParentChunk, error) {
+	schema, err := tubToSchema(doc.TubName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting schema from tubname, %s: %w", doc.TubName, err)
+	}
+
+	q := `
+SELECT tub_id, tub_name, document_id, parent_id, content, heading_path, start_offset, end_offset, page_start, page_end, created_at, updated_at
+FROM "%s".parent_chunk
+WHERE document_id = $1
+  AND tub_id = $2
+ORDER BY parent_id`
+	q = fmt.Sprintf(q, schema)
+
+	var parents []ragnar.ParentChunk
+	err = d.db.Select(&parents, q, doc.DocumentId, doc.TubId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting parent chunks: %w", err)
+	}
+	return parents, nil
+}
+
+// InternalUpsertDocument creates the document, or replaces the headers of it when it has a document id
+func (d *DAO) InternalUpsertDocument(doc ragnar.Document) (ragnar.Document, error) {
+	schema, err := tubToSchema(doc.TubName)
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("at InternalUpsertDocument, error getting schema from tubname, %s: %w", doc.TubName, err)
+	}
+
+	q := `INSERT INTO "%s"."document" (tub_id, tub_name, headers) VALUES ($1, $2, $3) RETURNING *`
+	args := []any{doc.TubId, doc.TubName, doc.Headers}
+	if doc.DocumentId != "" {
+		q = `UPDATE "%s"."document"
+			SET headers = $3,
+			    updated_at = CASE WHEN headers IS DISTINCT FROM $3 THEN now() ELSE updated_at END
+			WHERE tub_id = $1 AND tub_name = $2 AND document_id = $4
+			RETURNING *`
+		args = append(args, doc.DocumentId)
+	}
+	q = fmt.Sprintf(q, schema)
+
+	var retdoc ragnar.Document
+	err = d.db.Get(&retdoc, q, args...)
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("at InternalUpsertDocument, error inserting / updating document: %w", err)
+	}
+	return retdoc, nil
+}
+
+// InternalAddDocumentHeaders sets the headers derived from the file of the document, such as the title of a web page.
+// Headers given at upload are kept, and the derived headers of an earlier conversion are replaced, see
+// ragnar.HeaderDerivedHeaders
+func (d *DAO) InternalAddDocumentHeaders(doc ragnar.Document, headers pgtype.Hstore) (ragnar.Document, error) {
+	schema, err := tubToSchema(doc.TubName)
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("at InternalAddDocumentHeaders, error getting schema from tubname, %s: %w", doc.TubName, err)
+	}
+
+	var retdoc ragnar.Document
+	err = d.txx(context.Background(), func(tx *sqlx.Tx) error {
+		var current pgtype.Hstore
+		q := fmt.Sprintf(`SELECT headers FROM "%s"."document" WHERE tub_id = $1 AND document_id = $2 FOR UPDATE`, schema)
+		err := tx.Get(&current, q, doc.TubId, doc.DocumentId)
+		if err != nil {
+			return fmt.Errorf("error getting document headers: %w", err)
+		}
+
+		merged := pgtype.Hstore{}
+		for k, v := range current {
+			merged[k] = v
+		}
+		if previous := merged[ragnar.HeaderDerivedHeaders]; previous != nil {
+			for _, k := range strings.Split(*previous, ",") {
+				delete(merged, k)
+			}
+		}
+		delete(merged, ragnar.HeaderDerivedHeaders)
+
+		var derived []string
+		for k, v := range headers {
+			if _, given := merged[k]; given {
+				continue
+			}
+			merged[k] = v
+			derived = append(derived, k)
+		}
+		if len(derived) > 0 {
+			slices.Sort(derived)
+			merged[ragnar.HeaderDerivedHeaders] = util.Ptr(strings.Join(derived, ","))
+		}
+
+		q = fmt.Sprintf(`UPDATE "%s"."document" SET headers = $3 WHERE tub_id = $1 AND document_id = $2 RETURNING *`, schema)
+		err = tx.Get(&retdoc, q, doc.TubId, doc.DocumentId, merged)
+		if err != nil {
+			return fmt.Errorf("error updating document headers: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("at InternalAddDocumentHeaders, %w", err)
+	}
+	return retdoc, nil
+}
+
+// InternalGetChildDocuments returns the documents ingested from files embedded in the document, see
+// ragnar.HeaderParentDocumentId
+func (d *DAO) InternalGetChildDocuments(doc ragnar.Document) ([]ragnar.Document, error) {
+	schema, err := tubToSchema(doc.TubName)
+	if err != nil {
+		return nil, fmt.Errorf("at InternalGetChildDocuments, error getting schema from tubname, %s: %w", doc.TubName, err)
+	}
+
+	q := `SELECT * FROM "%s"."document" WHERE tub_id = $1 AND headers -> $2 = $3 ORDER BY created_at`
+	q = fmt.Sprintf(q, schema)
+
+	var docs []ragnar.Document
+	err = d.db.Select(&docs, q, doc.TubId, ragnar.HeaderParentDocumentId, doc.DocumentId)
+	if err != nil {
+		return nil, fmt.Errorf("at InternalGetChildDocuments, error getting documents: %w", err)
+	}
+	return docs, nil
+}
+
+// InternalDeleteDocument deletes the document with its chunks and parent chunks
+func (d *DAO) InternalDeleteDocument(doc ragnar.Document) error {
+	err := d.txx(context.Background(), func(tx *sqlx.Tx) error {
+		return deleteDocument(tx, doc.TubName, doc.DocumentId)
+	})
+	if err != nil {
+		return fmt.Errorf("at InternalDeleteDocument, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS public.eval_set
+(
+    eval_set_id   text                     DEFAULT ('eval_' || gen_random_uuid()) PRIMARY KEY,
+    tub_id        text                                   NOT NULL references public.tub (tub_id) on delete cascade,
+    tub_name      text                                   NOT NULL references public.tub (tub_name) on delete cascade,
+    eval_set_name text                                   NOT NULL,
+
+    queries       jsonb                    default '[]'  NOT NULL,
+
+    created_at    timestamp with time zone default now() NOT NULL,
+    updated_at    timestamp with time zone default now() NOT NULL,
+
+    UNIQUE (tub_id, eval_set_name)
+);
+
+
+CREATE TABLE IF NOT EXISTS public.eval_run
+(
+    eval_run_id   text                     DEFAULT ('run_' || gen_random_uuid()) PRIMARY KEY,
+    eval_set_id   text                                   NOT NULL references public.eval_set (eval_set_id) on delete cascade,
+    tub_id        text                                   NOT NULL references public.tub (tub_id) on delete cascade,
+    tub_name      text                                   NOT NULL references public.tub (tub_name) on delete cascade,
+    eval_set_name text                                   NOT NULL,
+
+    -- snapshot of the tub settings and model used, so runs can be compared over time
+    settings      hstore                   default ''    NOT NULL,
+    embed_model   text                                   NOT NULL,
+
+    metrics       jsonb                                  NOT NULL,
+    results       jsonb                    default '[]'  NOT NULL,
+
+    created_at    timestamp with time zone default now() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS eval_run_eval_set_id_created_at_idx ON public.eval_run (eval_set_id, created_at);
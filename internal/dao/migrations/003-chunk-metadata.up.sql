@@ -0,0 +1,26 @@
+-- Adds structural metadata to the chunk table of every existing tub, new tubs get them in CreateTub
+DO
+$$
+    DECLARE
+        tub_schema text;
+    BEGIN
+        FOR tub_schema IN
+            SELECT t.table_schema
+            FROM information_schema.tables t
+            WHERE t.table_name = 'chunk'
+              AND t.table_schema LIKE '\_tub[%'
+              AND NOT EXISTS (SELECT 1
+                              FROM information_schema.columns c
+                              WHERE c.table_schema = t.table_schema
+                                AND c.table_name = 'chunk'
+                                AND c.column_name = 'heading_path')
+            LOOP
+                EXECUTE format('ALTER TABLE %I.chunk
+                    ADD COLUMN IF NOT EXISTS heading_path TEXT NOT NULL DEFAULT '''',
+                    ADD COLUMN IF NOT EXISTS start_offset INT,
+                    ADD COLUMN IF NOT EXISTS end_offset   INT,
+                    ADD COLUMN IF NOT EXISTS page_start   INT,
+                    ADD COLUMN IF NOT EXISTS page_end     INT', tub_schema);
+            END LOOP;
+    END
+$$;
@@ -0,0 +1,43 @@
+-- Adds the LLM generated context to the chunk table of every existing tub, new tubs get it in CreateTub
+DO
+$$
+    DECLARE
+        tub_schema text;
+    BEGIN
+        FOR tub_schema IN
+            SELECT t.table_schema
+            FROM information_schema.tables t
+            WHERE t.table_name = 'chunk'
+              AND t.table_schema LIKE '\_tub[%'
+              AND NOT EXISTS (SELECT 1
+                              FROM information_schema.columns c
+                              WHERE c.table_schema = t.table_schema
+                                AND c.table_name = 'chunk'
+                                AND c.column_name = 'context')
+            LOOP
+                EXECUTE format('ALTER TABLE %I.chunk
+                    ADD COLUMN IF NOT EXISTS context TEXT NOT NULL DEFAULT ''''', tub_schema);
+            END LOOP;
+    END
+$$;
+
+
+CREATE TABLE IF NOT EXISTS public.token_usage
+(
+    token_usage_id bigserial PRIMARY KEY,
+    tub_id         text                                   NOT NULL references public.tub (tub_id) on delete cascade,
+    tub_name       text                                   NOT NULL references public.tub (tub_name) on delete cascade,
+    document_id    text                                   NOT NULL,
+
+    -- what the tokens were spent on, e.g. chunk-contextualize
+    purpose        text                                   NOT NULL,
+    model          text                                   NOT NULL,
+    calls          int                      default 0     NOT NULL,
+    input_tokens   int                      default 0     NOT NULL,
+    output_tokens  int                      default 0     NOT NULL,
+    total_tokens   int                      default 0     NOT NULL,
+
+    created_at     timestamp with time zone default now() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS token_usage_tub_id_created_at_idx ON public.token_usage (tub_id, created_at);
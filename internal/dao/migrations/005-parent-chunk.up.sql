@@ -0,0 +1,34 @@
+-- Adds parent chunks for small-to-big retrieval to every existing tub, new tubs get them in CreateTub
+DO
+$$
+    DECLARE
+        tub_schema text;
+    BEGIN
+        FOR tub_schema IN
+            SELECT t.table_schema
+            FROM information_schema.tables t
+            WHERE t.table_name = 'chunk'
+              AND t.table_schema LIKE '\_tub[%'
+              AND NOT EXISTS (SELECT 1
+                              FROM information_schema.tables p
+                              WHERE p.table_schema = t.table_schema
+                                AND p.table_name = 'parent_chunk')
+            LOOP
+                EXECUTE format('CREATE TABLE IF NOT EXISTS %I.parent_chunk (
+                    parent_id    INT,
+                    document_id  TEXT NOT NULL REFERENCES %I.document (document_id),
+                    tub_id       TEXT NOT NULL REFERENCES public.tub (tub_id),
+                    tub_name     TEXT NOT NULL REFERENCES public.tub (tub_name),
+                    content      TEXT NOT NULL,
+                    heading_path TEXT NOT NULL DEFAULT '''',
+                    start_offset INT,
+                    end_offset   INT,
+                    page_start   INT,
+                    page_end     INT,
+                    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+                    updated_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+                    PRIMARY KEY (document_id, parent_id))', tub_schema, tub_schema);
+                EXECUTE format('ALTER TABLE %I.chunk ADD COLUMN IF NOT EXISTS parent_id INT', tub_schema);
+            END LOOP;
+    END
+$$;
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS public.reindex
+(
+    reindex_id  text                     DEFAULT ('reindex_' || gen_random_uuid()) PRIMARY KEY,
+    tub_id      text                                   NOT NULL references public.tub (tub_id) on delete cascade,
+    tub_name    text                                   NOT NULL references public.tub (tub_name) on delete cascade,
+
+    -- the stage the documents are re-processed from, chunk, contextualize or embed
+    stage       text                                   NOT NULL,
+    -- running, completed, cancelled or failed
+    status      text                     default 'running' NOT NULL,
+
+    created_at  timestamp with time zone default now() NOT NULL,
+    updated_at  timestamp with time zone default now() NOT NULL,
+    finished_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS reindex_tub_id_created_at_idx ON public.reindex (tub_id, created_at);
+
+
+CREATE TABLE IF NOT EXISTS public.reindex_document
+(
+    reindex_id   text                                   NOT NULL references public.reindex (reindex_id) on delete cascade,
+    document_id  text                                   NOT NULL,
+
+    -- pending, scheduled, done or failed
+    status       text                     default 'pending' NOT NULL,
+    error        text                     default ''    NOT NULL,
+
+    scheduled_at timestamp with time zone,
+    updated_at   timestamp with time zone default now() NOT NULL,
+
+    PRIMARY KEY (reindex_id, document_id)
+);
+
+CREATE INDEX IF NOT EXISTS reindex_document_document_id_idx ON public.reindex_document (document_id) WHERE status = 'scheduled';
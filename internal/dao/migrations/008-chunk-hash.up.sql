@@ -0,0 +1,26 @@
+-- Adds the content hash and the hash of the last embedded text to the chunk table of every existing tub, new tubs
+-- get them in CreateTub. Content hashes of existing chunks are backfilled, embed hashes are set on the next embedding
+DO
+$$
+    DECLARE
+        tub_schema text;
+    BEGIN
+        FOR tub_schema IN
+            SELECT t.table_schema
+            FROM information_schema.tables t
+            WHERE t.table_name = 'chunk'
+              AND t.table_schema LIKE '\_tub[%'
+              AND NOT EXISTS (SELECT 1
+                              FROM information_schema.columns c
+                              WHERE c.table_schema = t.table_schema
+                                AND c.table_name = 'chunk'
+                                AND c.column_name = 'content_hash')
+            LOOP
+                EXECUTE format('ALTER TABLE %I.chunk
+                    ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '''',
+                    ADD COLUMN IF NOT EXISTS embed_hash TEXT NOT NULL DEFAULT ''''', tub_schema);
+                EXECUTE format('UPDATE %I.chunk
+                    SET content_hash = encode(sha256(convert_to(content, ''UTF8'')), ''hex'')', tub_schema);
+            END LOOP;
+    END
+$$;
@@ -0,0 +1,23 @@
+-- Adds the gen model the context of a chunk was written by to the chunk table of every existing tub, new tubs get it
+-- in CreateTub. Contexts written before are of an unknown model and are written again when next contextualized
+DO
+$$
+    DECLARE
+        tub_schema text;
+    BEGIN
+        FOR tub_schema IN
+            SELECT t.table_schema
+            FROM information_schema.tables t
+            WHERE t.table_name = 'chunk'
+              AND t.table_schema LIKE '\_tub[%'
+              AND NOT EXISTS (SELECT 1
+                              FROM information_schema.columns c
+                              WHERE c.table_schema = t.table_schema
+                                AND c.table_name = 'chunk'
+                                AND c.column_name = 'context_model')
+            LOOP
+                EXECUTE format('ALTER TABLE %I.chunk
+                    ADD COLUMN IF NOT EXISTS context_model TEXT NOT NULL DEFAULT ''''', tub_schema);
+            END LOOP;
+    END
+$$;
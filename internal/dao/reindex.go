@@ -0,0 +1,318 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/auth"
+)
+
+// reindexProgressQuery selects reindexes with their document counts, the caller adds the WHERE clause and GROUP BY
+const reindexProgressQuery = `SELECT r.reindex_id, r.tub_id, r.tub_name, r.stage, r.status, r.created_at, r.updated_at, r.finished_at,
+       count(rd.document_id) AS total,
+       count(rd.document_id) FILTER (WHERE rd.status = 'pending') AS pending,
+       count(rd.document_id) FILTER (WHERE rd.status = 'scheduled') AS running,
+       count(rd.document_id) FILTER (WHERE rd.status = 'done') AS done,
+       count(rd.document_id) FILTER (WHERE rd.status = 'failed') AS failed
+FROM "public"."reindex" r
+LEFT JOIN "public"."reindex_document" rd USING (reindex_id)
+`
+
+// CreateReindex starts a reindex of every document in the tub from the given stage. A running reindex of the tub
+// is cancelled, as the new one covers all documents again, and the documents it had scheduled are returned so that
+// their tasks can be removed. The new reindex starts from the stage of the cancelled one when that is earlier, since
+// its documents might not have been re-processed from that stage yet
+func (d *DAO) CreateReindex(ctx context.Context, tubname string, stage string) (ragnar.Reindex, []ragnar.ReindexDocument, error) {
+	var reindex ragnar.Reindex
+	var superseded []ragnar.ReindexDocument
+
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return reindex, nil, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_UPDATE)
+		if err != nil {
+			return fmt.Errorf("error checking permission to update tub: %w", err)
+		}
+		schema, err := tubToSchema(tubname)
+		if err != nil {
+			return fmt.Errorf("error getting schema: %w", err)
+		}
+
+		var cancelled []ragnar.Reindex
+		q := `UPDATE "public"."reindex"
+			  SET status = 'cancelled', updated_at = now(), finished_at = now()
+			  WHERE tub_name = $1
+			    AND status = 'running'
+			  RETURNING reindex_id, stage`
+		err = tx.SelectContext(ctx, &cancelled, q, tubname)
+		if err != nil {
+			return fmt.Errorf("error cancelling running reindex: %w", err)
+		}
+		for _, c := range cancelled {
+			stage = ragnar.EarlierReindexStage(stage, c.Stage)
+
+			q = `SELECT * FROM "public"."reindex_document" WHERE reindex_id = $1 AND status = 'scheduled'`
+			var docs []ragnar.ReindexDocument
+			err = tx.SelectContext(ctx, &docs, q, c.ReindexId)
+			if err != nil {
+				return fmt.Errorf("error getting scheduled documents of cancelled reindex: %w", err)
+			}
+			superseded = append(superseded, docs...)
+		}
+
+		var reindexId string
+		q = `INSERT INTO "public"."reindex" (tub_id, tub_name, stage)
+			 SELECT tub_id, tub_name, $2 FROM "public"."tub" WHERE tub_name = $1
+			 RETURNING reindex_id`
+		err = tx.GetContext(ctx, &reindexId, q, tubname, stage)
+		if err != nil {
+			return fmt.Errorf("error inserting reindex: %w", err)
+		}
+
+		q = fmt.Sprintf(`INSERT INTO "public"."reindex_document" (reindex_id, document_id)
+			 SELECT $1, document_id FROM "%s"."document"`, schema)
+		_, err = tx.ExecContext(ctx, q, reindexId)
+		if err != nil {
+			return fmt.Errorf("error inserting reindex documents: %w", err)
+		}
+
+		q = reindexProgressQuery + `WHERE r.reindex_id = $1 GROUP BY r.reindex_id`
+		err = tx.GetContext(ctx, &reindex, q, reindexId)
+		if err != nil {
+			return fmt.Errorf("error getting reindex: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.Reindex{}, nil, err
+	}
+	return reindex, superseded, nil
+}
+
+func (d *DAO) GetReindex(ctx context.Context, tubname string, reindexId string) (ragnar.Reindex, error) {
+	var reindex ragnar.Reindex
+
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return reindex, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		q := reindexProgressQuery + `WHERE r.tub_name = $1 AND r.reindex_id = $2 GROUP BY r.reindex_id`
+		err = tx.GetContext(ctx, &reindex, q, tubname, reindexId)
+		if err != nil {
+			return fmt.Errorf("error getting reindex: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.Reindex{}, err
+	}
+	return reindex, nil
+}
+
+func (d *DAO) ListReindexes(ctx context.Context, tubname string, limit, offset int) ([]ragnar.Reindex, error) {
+	if limit == 0 {
+		limit = 100
+	}
+
+	var reindexes []ragnar.Reindex
+
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return nil, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		q := reindexProgressQuery + `WHERE r.tub_name = $1
+GROUP BY r.reindex_id
+ORDER BY r.created_at DESC
+LIMIT $2 OFFSET $3`
+		err = tx.SelectContext(ctx, &reindexes, q, tubname, limit, offset)
+		if err != nil {
+			return fmt.Errorf("error listing reindexes: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reindexes, nil
+}
+
+// CancelReindex stops a running reindex from scheduling more documents, documents already scheduled are
+// processed to the end
+func (d *DAO) CancelReindex(ctx context.Context, tubname string, reindexId string) (ragnar.Reindex, error) {
+	var reindex ragnar.Reindex
+
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return reindex, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_UPDATE)
+		if err != nil {
+			return fmt.Errorf("error checking permission to update tub: %w", err)
+		}
+
+		q := `UPDATE "public"."reindex"
+			  SET status = 'cancelled', updated_at = now(), finished_at = now()
+			  WHERE tub_name = $1
+			    AND reindex_id = $2
+			    AND status = 'running'`
+		_, err = tx.ExecContext(ctx, q, tubname, reindexId)
+		if err != nil {
+			return fmt.Errorf("error cancelling reindex: %w", err)
+		}
+
+		q = reindexProgressQuery + `WHERE r.tub_name = $1 AND r.reindex_id = $2 GROUP BY r.reindex_id`
+		err = tx.GetContext(ctx, &reindex, q, tubname, reindexId)
+		if err != nil {
+			return fmt.Errorf("error getting reindex: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ragnar.Reindex{}, err
+	}
+	return reindex, nil
+}
+
+func (d *DAO) InternalGetReindex(reindexId string) (ragnar.Reindex, error) {
+	var reindex ragnar.Reindex
+	q := reindexProgressQuery + `WHERE r.reindex_id = $1 GROUP BY r.reindex_id`
+	err := d.db.Get(&reindex, q, reindexId)
+	if err != nil {
+		return reindex, fmt.Errorf("at InternalGetReindex, error getting reindex: %w", err)
+	}
+	return reindex, nil
+}
+
+// InternalGetScheduledReindexDocuments returns the documents of the reindex that are scheduled and not yet done
+func (d *DAO) InternalGetScheduledReindexDocuments(reindexId string) ([]ragnar.ReindexDocument, error) {
+	var docs []ragnar.ReindexDocument
+	q := `SELECT * FROM "public"."reindex_document" WHERE reindex_id = $1 AND status = 'scheduled'`
+	err := d.db.Select(&docs, q, reindexId)
+	if err != nil {
+		return nil, fmt.Errorf("at InternalGetScheduledReindexDocuments, error getting documents: %w", err)
+	}
+	return docs, nil
+}
+
+// InternalScheduleReindexDocuments marks up to limit pending documents of the reindex as scheduled and returns
+// them. Pending documents that have been deleted since the reindex started are marked as failed
+func (d *DAO) InternalScheduleReindexDocuments(reindex ragnar.Reindex, limit int) ([]ragnar.Document, error) {
+	schema, err := tubToSchema(reindex.TubName)
+	if err != nil {
+		return nil, fmt.Errorf("at InternalScheduleReindexDocuments, error getting schema: %w", err)
+	}
+
+	var docs []ragnar.Document
+	err = d.txx(context.Background(), func(tx *sqlx.Tx) error {
+		q := fmt.Sprintf(`UPDATE "public"."reindex_document" rd
+			  SET status = 'failed', error = 'document deleted', updated_at = now()
+			  WHERE rd.reindex_id = $1
+			    AND rd.status = 'pending'
+			    AND NOT EXISTS (SELECT 1 FROM "%s"."document" d WHERE d.document_id = rd.document_id)`, schema)
+		_, err := tx.Exec(q, reindex.ReindexId)
+		if err != nil {
+			return fmt.Errorf("error failing deleted documents: %w", err)
+		}
+
+		q = fmt.Sprintf(`WITH next AS (
+				SELECT document_id FROM "public"."reindex_document"
+				WHERE reindex_id = $1 AND status = 'pending'
+				ORDER BY document_id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			), scheduled AS (
+				UPDATE "public"."reindex_document" rd
+				SET status = 'scheduled', scheduled_at = now(), updated_at = now()
+				FROM next
+				WHERE rd.reindex_id = $1 AND rd.document_id = next.document_id
+				RETURNING rd.document_id
+			)
+			SELECT d.* FROM "%s"."document" d INNER JOIN scheduled USING (document_id)`, schema)
+		err = tx.Select(&docs, q, reindex.ReindexId, limit)
+		if err != nil {
+			return fmt.Errorf("error scheduling documents: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("at InternalScheduleReindexDocuments: %w", err)
+	}
+	return docs, nil
+}
+
+// InternalSetReindexDocumentStatus sets the status of a document of the reindex, errMsg is kept for failed documents
+func (d *DAO) InternalSetReindexDocumentStatus(reindexId string, documentId string, status string, errMsg string) error {
+	q := `UPDATE "public"."reindex_document"
+		  SET status = $3, error = $4, updated_at = now()
+		  WHERE reindex_id = $1 AND document_id = $2`
+	_, err := d.db.Exec(q, reindexId, documentId, status, errMsg)
+	if err != nil {
+		return fmt.Errorf("at InternalSetReindexDocumentStatus, error updating document: %w", err)
+	}
+	return nil
+}
+
+// InternalFailReindexDocument marks the document as failed in the running reindexes that have scheduled it
+func (d *DAO) InternalFailReindexDocument(documentId string, errMsg string) error {
+	q := `UPDATE "public"."reindex_document" rd
+		  SET status = 'failed', error = $2, updated_at = now()
+		  FROM "public"."reindex" r
+		  WHERE r.reindex_id = rd.reindex_id
+		    AND r.status = 'running'
+		    AND rd.document_id = $1
+		    AND rd.status = 'scheduled'`
+	_, err := d.db.Exec(q, documentId, errMsg)
+	if err != nil {
+		return fmt.Errorf("at InternalFailReindexDocument, error updating document: %w", err)
+	}
+	return nil
+}
+
+// InternalFailReindex marks a running reindex as failed, the documents it has scheduled are processed to the end
+func (d *DAO) InternalFailReindex(reindexId string) error {
+	q := `UPDATE "public"."reindex"
+		  SET status = 'failed', updated_at = now(), finished_at = now()
+		  WHERE reindex_id = $1
+		    AND status = 'running'`
+	_, err := d.db.Exec(q, reindexId)
+	if err != nil {
+		return fmt.Errorf("at InternalFailReindex, error updating reindex: %w", err)
+	}
+	return nil
+}
+
+// InternalCompleteReindex marks a running reindex as completed
+func (d *DAO) InternalCompleteReindex(reindexId string) error {
+	q := `UPDATE "public"."reindex"
+		  SET status = 'completed', updated_at = now(), finished_at = now()
+		  WHERE reindex_id = $1
+		    AND status = 'running'`
+	_, err := d.db.Exec(q, reindexId)
+	if err != nil {
+		return fmt.Errorf("at InternalCompleteReindex, error updating reindex: %w", err)
+	}
+	return nil
+}
@@ -136,6 +136,19 @@ func (d *DAO) CreateTub(ctx context.Context, tub ragnar.Tub) (ragnar.Tub, error)
 			  tub_name    TEXT        NOT NULL REFERENCES "public"."tub" (tub_name),
 			  
 			  content     TEXT        NOT NULL,
+
+			  heading_path TEXT       NOT NULL DEFAULT '',
+			  start_offset INT,
+			  end_offset   INT,
+			  page_start   INT,
+			  page_end     INT,
+
+			  context      TEXT       NOT NULL DEFAULT '',
+			  context_model TEXT      NOT NULL DEFAULT '',
+			  parent_id    INT,
+			  symbol       TEXT       NOT NULL DEFAULT '',
+			  content_hash TEXT       NOT NULL DEFAULT '',
+			  embed_hash   TEXT       NOT NULL DEFAULT '',
 		
 			  created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
 			  updated_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
@@ -146,6 +159,29 @@ func (d *DAO) CreateTub(ctx context.Context, tub ragnar.Tub) (ragnar.Tub, error)
 		if err != nil {
 			return fmt.Errorf("error create chunk table: %w", err)
 		}
+		q = `CREATE TABLE "%s"."parent_chunk" (
+			  parent_id    INT,
+			  document_id  TEXT       NOT NULL REFERENCES "%s"."document" (document_id),
+			  tub_id       TEXT       NOT NULL REFERENCES "public"."tub" (tub_id),
+			  tub_name     TEXT       NOT NULL REFERENCES "public"."tub" (tub_name),
+
+			  content      TEXT       NOT NULL,
+
+			  heading_path TEXT       NOT NULL DEFAULT '',
+			  start_offset INT,
+			  end_offset   INT,
+			  page_start   INT,
+			  page_end     INT,
+
+			  created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+			  updated_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+			  primary key (document_id, parent_id)
+			)`
+		_, err = tx.Exec(fmt.Sprintf(q, schemaname, schemaname))
+		if err != nil {
+			return fmt.Errorf("error create parent chunk table: %w", err)
+		}
 
 		return nil
 	})
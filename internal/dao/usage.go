@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/auth"
+)
+
+func (d *DAO) InternalInsertTokenUsage(usage ragnar.TokenUsage) error {
+	q := `INSERT INTO "public"."token_usage" (tub_id, tub_name, document_id, purpose, model, calls, input_tokens, output_tokens, total_tokens)
+		  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := d.db.Exec(q, usage.TubId, usage.TubName, usage.DocumentId, usage.Purpose, usage.Model,
+		usage.Calls, usage.InputTokens, usage.OutputTokens, usage.TotalTokens)
+	if err != nil {
+		return fmt.Errorf("at InternalInsertTokenUsage, error inserting token usage: %w", err)
+	}
+	return nil
+}
+
+// GetTokenUsage sums the tokens spent in the tub per purpose and model, optionally for a single document and
+// since a point in time. CreatedAt of each row is the time of the latest usage
+func (d *DAO) GetTokenUsage(ctx context.Context, tubname string, documentId string, since time.Time) ([]ragnar.TokenUsage, error) {
+	tubname = strings.ToLower(tubname)
+	if !bucketNameRegExp.MatchString(tubname) {
+		return nil, errors.New("tub name must only contain a-z0-9_-, and be at least 3 character long")
+	}
+
+	var usage []ragnar.TokenUsage
+	err := d.txx(ctx, func(tx *sqlx.Tx) error {
+		err := allowedTubOperation(tx, ctx, tubname, auth.ALLOW_READ)
+		if err != nil {
+			return fmt.Errorf("error checking permission to read tub: %w", err)
+		}
+
+		q := `SELECT tub_id, tub_name, CAST($2 AS TEXT) AS document_id, purpose, model,
+				     sum(calls) AS calls, sum(input_tokens) AS input_tokens,
+				     sum(output_tokens) AS output_tokens, sum(total_tokens) AS total_tokens,
+				     max(created_at) AS created_at
+			  FROM "public"."token_usage"
+			  WHERE tub_name = $1
+			    AND (CAST($2 AS TEXT) = '' OR document_id = $2)
+			    AND created_at >= $3
+			  GROUP BY tub_id, tub_name, purpose, model
+			  ORDER BY purpose, model`
+		err = tx.SelectContext(ctx, &usage, q, tubname, documentId, since)
+		if err != nil {
+			return fmt.Errorf("error getting token usage: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,211 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveFormat returns the archive format of the content type, or "" when it is not an archive
+func ArchiveFormat(contentType string) string {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	switch strings.TrimSpace(contentType) {
+	case "application/zip", "application/x-zip-compressed":
+		return ArchiveZip
+	case "application/x-tar":
+		return ArchiveTar
+	case "application/gzip", "application/x-gzip", "application/x-gtar", "application/x-compressed-tar":
+		return ArchiveTarGz
+	}
+	return ""
+}
+
+// ArchiveLimits guards against archives that expand to far more than they take, such as zip bombs
+type ArchiveLimits struct {
+	// MaxEntries is the maximum number of entries, directories included
+	MaxEntries int
+	// MaxSize is the maximum total uncompressed size of the files
+	MaxSize int64
+	// MaxFileSize is the maximum uncompressed size of a file, larger files are reported as entry errors
+	MaxFileSize int64
+}
+
+// ErrArchiveLimit is returned when an archive exceeds its ArchiveLimits
+var ErrArchiveLimit = errors.New("archive exceeds limits")
+
+// ArchiveEntry is a regular file of an archive, extracted to a temporary file. Err is set, and File nil, when the
+// file could not be extracted.
+type ArchiveEntry struct {
+	Path string
+	Size int64
+	File *os.File
+	Err  error
+}
+
+// ExtractArchive extracts the regular files of the archive, of size bytes, to temporary files, which are removed
+// with RemoveArchiveEntries. Directories and files left by operating systems, such as __MACOSX and dot files, are
+// skipped. The archive as a whole is rejected when it is corrupt or exceeds the limits.
+func ExtractArchive(archive *os.File, size int64, format string, limits ArchiveLimits) (entries []ArchiveEntry, err error) {
+	x := extractor{limits: limits}
+	defer func() {
+		if err != nil {
+			RemoveArchiveEntries(x.entries)
+		}
+	}()
+
+	switch format {
+	case ArchiveZip:
+		zr, err := zip.NewReader(archive, size)
+		if err != nil {
+			return nil, fmt.Errorf("error reading zip archive: %w", err)
+		}
+		if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, limits.MaxEntries)
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				x.fail(f.Name, err)
+				continue
+			}
+			err = x.extract(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	case ArchiveTar, ArchiveTarGz:
+		var r io.Reader = archive
+		if format == ArchiveTarGz {
+			gz, err := gzip.NewReader(archive)
+			if err != nil {
+				return nil, fmt.Errorf("error reading gzip archive: %w", err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+		tr := tar.NewReader(r)
+		for n := 1; ; n++ {
+			h, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error reading tar archive: %w", err)
+			}
+			if limits.MaxEntries > 0 && n > limits.MaxEntries {
+				return nil, fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, limits.MaxEntries)
+			}
+			if h.Typeflag != tar.TypeReg {
+				continue
+			}
+			err = x.extract(h.Name, tr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+	return x.entries, nil
+}
+
+// RemoveArchiveEntries closes and removes the temporary files of the entries
+func RemoveArchiveEntries(entries []ArchiveEntry) {
+	for _, e := range entries {
+		if e.File != nil {
+			e.File.Close()
+			os.Remove(e.File.Name())
+		}
+	}
+}
+
+type extractor struct {
+	limits  ArchiveLimits
+	total   int64
+	entries []ArchiveEntry
+}
+
+func (x *extractor) fail(name string, err error) {
+	if p, ok := entryPath(name); ok {
+		x.entries = append(x.entries, ArchiveEntry{Path: p, Err: err})
+	}
+}
+
+// extract copies the file to a temporary file, the error returned is ErrArchiveLimit or a failure to write the
+// temporary file, errors of the entry itself are kept in the entry
+func (x *extractor) extract(name string, r io.Reader) error {
+	p, ok := entryPath(name)
+	if !ok {
+		return nil
+	}
+	// the sizes in the archive are not trusted, the total is counted while reading
+	limit := int64(-1)
+	if x.limits.MaxSize > 0 {
+		limit = x.limits.MaxSize - x.total
+	}
+	fileLimit := limit
+	if x.limits.MaxFileSize > 0 && (fileLimit < 0 || x.limits.MaxFileSize < fileLimit) {
+		fileLimit = x.limits.MaxFileSize
+	}
+	if fileLimit >= 0 {
+		r = io.LimitReader(r, fileLimit+1)
+	}
+
+	tmp, err := os.CreateTemp("", "ragnar-archive-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	n, err := io.Copy(tmp, r)
+	x.total += n
+	entry := ArchiveEntry{Path: p, Size: n, File: tmp}
+	switch {
+	case err != nil:
+		entry.Err = fmt.Errorf("error extracting file: %w", err)
+	case limit >= 0 && n > limit:
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("%w: more than %d bytes uncompressed", ErrArchiveLimit, x.limits.MaxSize)
+	case fileLimit >= 0 && n > fileLimit:
+		entry.Err = fmt.Errorf("file is larger than %d bytes", x.limits.MaxFileSize)
+	}
+	if entry.Err == nil {
+		_, entry.Err = tmp.Seek(0, io.SeekStart)
+	}
+	if entry.Err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		entry.File = nil
+	}
+	x.entries = append(x.entries, entry)
+	return nil
+}
+
+// entryPath cleans the path of an archive entry, and reports whether it is a file worth ingesting
+func entryPath(name string) (string, bool) {
+	p := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if p == "" || p == "." {
+		return "", false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+	return p, true
+}
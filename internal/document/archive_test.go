@@ -0,0 +1,147 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempArchive(t *testing.T, data []byte) (*os.File, int64) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	_, err = f.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, int64(len(data))
+}
+
+// extracted returns the content of the entries, or their error, by path
+func extracted(t *testing.T, entries []ArchiveEntry) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	for _, e := range entries {
+		if e.Err != nil {
+			got[e.Path] = "error: " + e.Err.Error()
+			continue
+		}
+		b, err := io.ReadAll(e.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[e.Path] = string(b)
+	}
+	return got
+}
+
+func TestExtractArchive_Zip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct{ name, content string }{
+		{"docs/", ""},
+		{"docs/a.md", "# A"},
+		{"docs/../b.txt", "b"},
+		{"__MACOSX/docs/._a.md", "junk"},
+		{"docs/.DS_Store", "junk"},
+		{"big.txt", strings.Repeat("x", 20)},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(f.content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, size := tempArchive(t, buf.Bytes())
+	entries, err := ExtractArchive(f, size, ArchiveZip, ArchiveLimits{MaxEntries: 10, MaxSize: 100, MaxFileSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveArchiveEntries(entries)
+
+	expected := map[string]string{
+		"docs/a.md": "# A",
+		"b.txt":     "b",
+		"big.txt":   "error: file is larger than 10 bytes",
+	}
+	if got := extracted(t, entries); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestExtractArchive_TarGz(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct{ name, content string }{
+		{"report.csv", "a,b\n1,2\n"},
+		{"notes/readme.md", "hello"},
+	} {
+		err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write([]byte(f.content))
+	}
+	_ = tw.WriteHeader(&tar.Header{Name: "link", Linkname: "report.csv", Typeflag: tar.TypeSymlink})
+	_ = tw.Close()
+	_ = gz.Close()
+
+	f, size := tempArchive(t, buf.Bytes())
+	entries, err := ExtractArchive(f, size, ArchiveTarGz, ArchiveLimits{MaxEntries: 10, MaxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveArchiveEntries(entries)
+
+	expected := map[string]string{"report.csv": "a,b\n1,2\n", "notes/readme.md": "hello"}
+	if got := extracted(t, entries); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// too many entries
+	f, size = tempArchive(t, buf.Bytes())
+	_, err = ExtractArchive(f, size, ArchiveTarGz, ArchiveLimits{MaxEntries: 2})
+	if !errors.Is(err, ErrArchiveLimit) {
+		t.Errorf("Expected ErrArchiveLimit for entries, got %v", err)
+	}
+
+	// too large uncompressed
+	f, size = tempArchive(t, buf.Bytes())
+	_, err = ExtractArchive(f, size, ArchiveTarGz, ArchiveLimits{MaxSize: 10})
+	if !errors.Is(err, ErrArchiveLimit) {
+		t.Errorf("Expected ErrArchiveLimit for size, got %v", err)
+	}
+}
+
+func TestArchiveFormat(t *testing.T) {
+	for contentType, expected := range map[string]string{
+		"application/zip":          ArchiveZip,
+		"application/x-tar":        ArchiveTar,
+		"application/gzip":         ArchiveTarGz,
+		"application/pdf":          "",
+		"multipart/form-data; b=1": "",
+	} {
+		if got := ArchiveFormat(contentType); got != expected {
+			t.Errorf("ArchiveFormat(%q) = %q, want %q", contentType, got, expected)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package document
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// compoundFile is a read only Compound File Binary file, the container format of Outlook .msg files. It holds a
+// tree of storages, like directories, and streams, like files.
+type compoundFile struct {
+	data             []byte
+	sectorSize       int
+	miniSectorSize   int
+	miniStreamCutoff uint64
+	fat              []uint32
+	miniFat          []uint32
+	miniStream       []byte
+	entries          []cfbEntry
+}
+
+type cfbEntry struct {
+	name  string
+	kind  byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+const (
+	cfbStorage   = 1
+	cfbStream    = 2
+	cfbRoot      = 5
+	cfbNoStream  = 0xFFFFFFFF
+	cfbEndChain  = 0xFFFFFFFE
+	cfbMaxSector = 0xFFFFFFFA
+)
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+func openCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < 512 || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, errors.New("not a compound file")
+	}
+	le := binary.LittleEndian
+	sectorShift := le.Uint16(data[0x1E:])
+	miniSectorShift := le.Uint16(data[0x20:])
+	if sectorShift != 9 && sectorShift != 12 || miniSectorShift != 6 {
+		return nil, fmt.Errorf("unsupported compound file sector sizes %d and %d", sectorShift, miniSectorShift)
+	}
+	cf := &compoundFile{
+		data:             data,
+		sectorSize:       1 << sectorShift,
+		miniSectorSize:   1 << miniSectorShift,
+		miniStreamCutoff: uint64(le.Uint32(data[0x38:])),
+	}
+
+	// the sectors of the FAT are listed by the DIFAT, which starts in the header and continues in a chain of sectors
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		if s := le.Uint32(data[0x4C+4*i:]); s <= cfbMaxSector {
+			fatSectors = append(fatSectors, s)
+		}
+	}
+	difat := le.Uint32(data[0x44:])
+	for n := 0; difat <= cfbMaxSector; n++ {
+		sector, err := cf.sector(difat)
+		if err != nil || len(sector) < cf.sectorSize || n > len(data)/cf.sectorSize {
+			return nil, errors.New("invalid compound file DIFAT")
+		}
+		for i := 0; i < cf.sectorSize/4-1; i++ {
+			if s := le.Uint32(sector[4*i:]); s <= cfbMaxSector {
+				fatSectors = append(fatSectors, s)
+			}
+		}
+		difat = le.Uint32(sector[cf.sectorSize-4:])
+	}
+	for _, s := range fatSectors {
+		sector, err := cf.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		cf.fat = append(cf.fat, uint32s(sector)...)
+	}
+
+	dir, err := cf.chain(le.Uint32(data[0x30:]), cf.fat, cf.sector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading compound file directory: %w", err)
+	}
+	for i := 0; i+128 <= len(dir); i += 128 {
+		e := dir[i : i+128]
+		nameLen := int(le.Uint16(e[0x40:]))
+		if nameLen > 64 {
+			nameLen = 64
+		}
+		cf.entries = append(cf.entries, cfbEntry{
+			name:  strings.TrimRight(utf16String(e[:nameLen]), "\x00"),
+			kind:  e[0x42],
+			left:  le.Uint32(e[0x44:]),
+			right: le.Uint32(e[0x48:]),
+			child: le.Uint32(e[0x4C:]),
+			start: le.Uint32(e[0x74:]),
+			size:  le.Uint64(e[0x78:]),
+		})
+	}
+	if len(cf.entries) == 0 || cf.entries[0].kind != cfbRoot {
+		return nil, errors.New("compound file has no root entry")
+	}
+	if cf.sectorSize == 512 {
+		// the high part of the size is undefined in version 3 files
+		for i := range cf.entries {
+			cf.entries[i].size &= 0xFFFFFFFF
+		}
+	}
+
+	miniFat, err := cf.chain(le.Uint32(data[0x3C:]), cf.fat, cf.sector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading compound file mini FAT: %w", err)
+	}
+	cf.miniFat = uint32s(miniFat)
+	root := cf.entries[0]
+	cf.miniStream, err = cf.chain(root.start, cf.fat, cf.sector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading compound file mini stream: %w", err)
+	}
+	if uint64(len(cf.miniStream)) > root.size {
+		cf.miniStream = cf.miniStream[:root.size]
+	}
+	return cf, nil
+}
+
+func (cf *compoundFile) sector(n uint32) ([]byte, error) {
+	start := (int(n) + 1) * cf.sectorSize
+	if n > cfbMaxSector || start >= len(cf.data) {
+		return nil, fmt.Errorf("sector %d out of range", n)
+	}
+	// the last sector may be cut short
+	return cf.data[start:min(start+cf.sectorSize, len(cf.data))], nil
+}
+
+func (cf *compoundFile) miniSector(n uint32) ([]byte, error) {
+	start := int(n) * cf.miniSectorSize
+	if start >= len(cf.miniStream) {
+		return nil, fmt.Errorf("mini sector %d out of range", n)
+	}
+	return cf.miniStream[start:min(start+cf.miniSectorSize, len(cf.miniStream))], nil
+}
+
+// chain reads the sectors of a chain, starting at start and following the allocation table
+func (cf *compoundFile) chain(start uint32, table []uint32, sector func(uint32) ([]byte, error)) ([]byte, error) {
+	var out []byte
+	for n, s := 0, start; s != cfbEndChain && s != cfbNoStream; n++ {
+		if n > len(table) || int(s) >= len(table) {
+			return nil, errors.New("invalid sector chain")
+		}
+		b, err := sector(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+		s = table[s]
+	}
+	return out, nil
+}
+
+// stream returns the content of the stream entry
+func (cf *compoundFile) stream(entry int) ([]byte, error) {
+	e := cf.entries[entry]
+	var b []byte
+	var err error
+	if e.size < cf.miniStreamCutoff {
+		b, err = cf.chain(e.start, cf.miniFat, cf.miniSector)
+	} else {
+		b, err = cf.chain(e.start, cf.fat, cf.sector)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading stream %s: %w", e.name, err)
+	}
+	if uint64(len(b)) < e.size {
+		return nil, fmt.Errorf("stream %s is cut short", e.name)
+	}
+	return b[:e.size], nil
+}
+
+// children returns the entries of a storage by name
+func (cf *compoundFile) children(storage int) map[string]int {
+	children := map[string]int{}
+	var walk func(i uint32, depth int)
+	walk = func(i uint32, depth int) {
+		if i == cfbNoStream || int(i) >= len(cf.entries) || depth > len(cf.entries) {
+			return
+		}
+		e := cf.entries[i]
+		children[e.name] = int(i)
+		walk(e.left, depth+1)
+		walk(e.right, depth+1)
+	}
+	walk(cf.entries[storage].child, 0)
+	return children
+}
+
+func uint32s(b []byte) []uint32 {
+	out := make([]uint32, len(b)/4)
+	for i := range out {
+		out[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return out
+}
+
+func utf16String(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,168 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultMaxOutputSize is the size of the markdown a command converter may output, unless configured
+const defaultMaxOutputSize = 64 << 20
+
+// CommandConfig is an external command converter of the commands file, a JSON array of them, e.g.
+//
+//	[{"name": "libreoffice", "command": "soffice", "args": ["--headless", "--cat", "{file}"],
+//	  "content_types": ["application/msword"], "extensions": [".doc"], "timeout": "2m"}]
+type CommandConfig struct {
+	// Name identifies the converter in the converters setting of tubs
+	Name string `json:"name"`
+	// Command is the executable, looked up in PATH unless it is a path
+	Command string `json:"command"`
+	// Args are the arguments of the command. {file} is replaced by the path of a temporary file holding the file to
+	// convert, without it the file is written to the standard input of the command.
+	Args []string `json:"args"`
+	// ContentTypes and Extensions, such as application/msword and .doc, are what the converter converts
+	ContentTypes []string `json:"content_types"`
+	Extensions   []string `json:"extensions"`
+	// Timeout of the command as a duration, e.g. 90s, defaulting to the converter timeout
+	Timeout string `json:"timeout"`
+	// MaxOutputSize is the number of bytes of markdown the command may write to its standard output, default 64 MiB
+	MaxOutputSize int64 `json:"max_output_size"`
+	// Default makes the converter win over the converters registered before it, the built in ones included, in
+	// tubs that do not prefer another converter
+	Default bool `json:"default"`
+}
+
+// commandConverter converts files by running an external command, which writes markdown to its standard output
+type commandConverter struct {
+	name          string
+	command       string
+	args          []string
+	timeout       time.Duration
+	maxOutputSize int64
+}
+
+var errOutputTooLarge = errors.New("output too large")
+
+func readCommandsFile(path string) ([]CommandConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading converters file: %w", err)
+	}
+	var commands []CommandConfig
+	err = json.Unmarshal(b, &commands)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing converters file %s: %w", path, err)
+	}
+	return commands, nil
+}
+
+func newCommandConverter(c CommandConfig, timeout time.Duration) (*commandConverter, error) {
+	if c.Command == "" {
+		return nil, fmt.Errorf("converter %q has no command", c.Name)
+	}
+	if timeout <= 0 {
+		timeout = defaultConverterTimeout
+	}
+	if c.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("converter %q has invalid timeout %q", c.Name, c.Timeout)
+		}
+	}
+	maxOutputSize := c.MaxOutputSize
+	if maxOutputSize <= 0 {
+		maxOutputSize = defaultMaxOutputSize
+	}
+	return &commandConverter{name: c.Name, command: c.Command, args: c.Args, timeout: timeout, maxOutputSize: maxOutputSize}, nil
+}
+
+func (c *commandConverter) Name() string {
+	return c.name
+}
+
+func (c *commandConverter) Convert(in io.Reader, opts ConvertOptions) (Conversion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	args := c.args
+	var stdin io.Reader = in
+	if c.usesFile() {
+		dir, err := os.MkdirTemp("", "ragnar-convert-")
+		if err != nil {
+			return Conversion{}, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		// the extension is kept, as commands may tell formats apart by it
+		file := filepath.Join(dir, "input"+filepath.Ext(opts.Filename))
+		f, err := os.Create(file)
+		if err != nil {
+			return Conversion{}, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		_, err = io.Copy(f, in)
+		f.Close()
+		if err != nil {
+			return Conversion{}, fmt.Errorf("failed to write temporary file: %w", err)
+		}
+		args = make([]string, len(c.args))
+		for i, a := range c.args {
+			args[i] = strings.ReplaceAll(a, "{file}", file)
+		}
+		stdin = nil
+	}
+
+	stdout := &limitedBuffer{max: c.maxOutputSize}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.command, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	// children of the command, such as those of a shell script, may keep its output open after it is killed
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if stdout.exceeded {
+		return Conversion{}, fmt.Errorf("converter %s: %w, max %d bytes", c.name, errOutputTooLarge, c.maxOutputSize)
+	}
+	if err != nil {
+		return Conversion{}, fmt.Errorf("converter %s: %s execution error: %w, stderr: %s", c.name, c.command, err, stderr.String())
+	}
+	return Conversion{Markdown: bytes.NewReader(stdout.Bytes())}, nil
+}
+
+func (c *commandConverter) usesFile() bool {
+	for _, a := range c.args {
+		if strings.Contains(a, "{file}") {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedBuffer is a buffer that fails writes beyond max bytes. The buffer is not embedded, as its ReadFrom would
+// let io.Copy bypass Write.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buf.Len()+len(p)) > b.max {
+		b.exceeded = true
+		return 0, errOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
@@ -0,0 +1,251 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/chunker"
+)
+
+// Converter converts files to markdown
+type Converter interface {
+	// Name identifies the converter, such as in the converters setting of a tub
+	Name() string
+	Convert(in io.Reader, opts ConvertOptions) (Conversion, error)
+}
+
+// ConvertOptions are what a converter is told about the file it converts
+type ConvertOptions struct {
+	Log      *slog.Logger
+	Filename string
+	Settings ragnar.TubSettings
+}
+
+// ConverterFunc returns a converter of the name converting with fn
+func ConverterFunc(name string, fn func(in io.Reader, opts ConvertOptions) (Conversion, error)) Converter {
+	return converterFunc{name: name, fn: fn}
+}
+
+type converterFunc struct {
+	name string
+	fn   func(in io.Reader, opts ConvertOptions) (Conversion, error)
+}
+
+func (c converterFunc) Name() string {
+	return c.name
+}
+
+func (c converterFunc) Convert(in io.Reader, opts ConvertOptions) (Conversion, error) {
+	return c.fn(in, opts)
+}
+
+// Config configures the converters of a Registry
+type Config struct {
+	// Timeout bounds the external tools run by the built in converters, pdftotext, pandoc, and pdftoppm and tesseract
+	// for each page read with OCR
+	Timeout time.Duration `cli:"converter-timeout"`
+	// OcrTimeout bounds the OCR of all the pages of a document, pages not read in time are left out
+	OcrTimeout time.Duration `cli:"ocr-timeout"`
+	// CommandsFile is a JSON file of external command converters, see CommandConfig
+	CommandsFile string `cli:"converters-file"`
+}
+
+// Registry holds converters by the content types and file extensions they convert. The converter registered first
+// for a content type or extension converts it, unless the tub prefers another one with its converters setting.
+type Registry struct {
+	converters   map[string]Converter
+	contentTypes map[string][]string
+	extensions   map[string][]string
+}
+
+// NewRegistry returns a registry of the built in converters and the command converters of the commands file
+func NewRegistry(cfg Config) (*Registry, error) {
+	r := newBuiltinRegistry(cfg)
+	if cfg.CommandsFile == "" {
+		return r, nil
+	}
+	commands, err := readCommandsFile(cfg.CommandsFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range commands {
+		converter, err := newCommandConverter(c, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		err = r.register(converter, c.ContentTypes, c.Extensions, c.Default)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds the converter for the content types and extensions, such as application/pdf and .pdf. Converters
+// registered before it keep converting them, unless a tub prefers the converter.
+func (r *Registry) Register(c Converter, contentTypes []string, extensions []string) error {
+	return r.register(c, contentTypes, extensions, false)
+}
+
+// register adds the converter, ahead of the converters registered before it when first is set
+func (r *Registry) register(c Converter, contentTypes []string, extensions []string, first bool) error {
+	name := c.Name()
+	if name == "" || strings.ContainsAny(name, "=,") {
+		return fmt.Errorf("invalid converter name %q", name)
+	}
+	if _, ok := r.converters[name]; ok {
+		return fmt.Errorf("converter %q is already registered", name)
+	}
+	if len(contentTypes) == 0 && len(extensions) == 0 {
+		return fmt.Errorf("converter %q has no content types or extensions", name)
+	}
+	add := func(m map[string][]string, key string) {
+		if first {
+			m[key] = append([]string{name}, m[key]...)
+		} else {
+			m[key] = append(m[key], name)
+		}
+	}
+	for _, t := range contentTypes {
+		add(r.contentTypes, contentTypeKey(t))
+	}
+	for _, e := range extensions {
+		key, err := normalizeExtension(e)
+		if err != nil {
+			return fmt.Errorf("converter %q: %w", name, err)
+		}
+		add(r.extensions, key)
+	}
+	r.converters[name] = c
+	return nil
+}
+
+// Lookup returns the converter of the content type, or of the extension of the file name, or nil when there is none.
+// preferred are the converters setting of a tub, converters by content type or extension, which win over the
+// converters registered first.
+func (r *Registry) Lookup(contentType, filename string, preferred map[string]string) Converter {
+	ct, ext := contentTypeKey(contentType), extensionKey(filepath.Ext(filename))
+	for _, key := range []struct {
+		key   string
+		names []string
+	}{{ct, r.contentTypes[ct]}, {ext, r.extensions[ext]}} {
+		if name, ok := preferred[key.key]; ok && slices.Contains(key.names, name) {
+			return r.converters[name]
+		}
+	}
+	if names := r.contentTypes[ct]; len(names) > 0 {
+		return r.converters[names[0]]
+	}
+	if names := r.extensions[ext]; len(names) > 0 {
+		return r.converters[names[0]]
+	}
+	return nil
+}
+
+// ValidatePreferences checks that the converters preferred by a tub are registered for the content types and
+// extensions they are preferred for
+func (r *Registry) ValidatePreferences(preferred map[string]string) error {
+	for key, name := range preferred {
+		names := r.contentTypes[key]
+		if strings.HasPrefix(key, ".") {
+			names = r.extensions[key]
+		}
+		if !slices.Contains(names, name) {
+			return fmt.Errorf("invalid converters setting, %s is not converted by %q", key, name)
+		}
+	}
+	return nil
+}
+
+// Supported reports whether files with the name are converted, by their extension
+func (r *Registry) Supported(filename string) bool {
+	return len(r.extensions[extensionKey(filepath.Ext(filename))]) > 0 || chunker.LanguageOf(filename) != ""
+}
+
+// SupportedContentType reports whether files of the content type can be converted to markdown
+func (r *Registry) SupportedContentType(contentType string) bool {
+	return len(r.contentTypes[contentTypeKey(contentType)]) > 0
+}
+
+// ConversionContentType returns the content type a file is converted as: the declared content type when it, or the
+// extension of the file name in the content disposition, can be converted, otherwise the content type detected from
+// the content with Sniff. ok is false when the file can not be converted by either.
+func (r *Registry) ConversionContentType(declared, contentDisposition, detected string) (contentType string, ok bool) {
+	if r.SupportedContentType(declared) {
+		return declared, true
+	}
+	if _, params, err := mime.ParseMediaType(contentDisposition); err == nil && r.Supported(params["filename"]) {
+		return declared, true
+	}
+	if r.SupportedContentType(detected) {
+		return detected, true
+	}
+	return declared, false
+}
+
+// DocumentContentType returns the content type the uploaded file of the document is converted as, see
+// ConversionContentType, or "" when the document has no content-type header
+func (r *Registry) DocumentContentType(doc ragnar.Document) string {
+	header := func(key string) string {
+		if v := doc.Headers[key]; v != nil {
+			return *v
+		}
+		return ""
+	}
+	if header("content-type") == "" {
+		return ""
+	}
+	contentType, _ := r.ConversionContentType(header("content-type"), header("content-disposition"), header(ragnar.HeaderContentTypeDetected))
+	return contentType
+}
+
+// Convert converts the uploaded file to markdown by its content type, or by the extension of the file name in the
+// content disposition, with the converter preferred by the tub settings. Source code is converted to a code block.
+func (r *Registry) Convert(log *slog.Logger, reader io.Reader, contentType, contentDisposition string, settings ragnar.TubSettings) (Conversion, error) {
+	var filename string
+	if _, params, err := mime.ParseMediaType(contentDisposition); err == nil {
+		filename = params["filename"]
+	}
+
+	c := r.Lookup(contentType, filename, settings.ConverterPreferences())
+	if c != nil {
+		log.Debug("converting document", "converter", c.Name(), "content_type", contentType, "filename", filename)
+		return c.Convert(reader, ConvertOptions{Log: log, Filename: filename, Settings: settings})
+	}
+
+	if language := chunker.LanguageOf(filename); language != "" {
+		log.Debug("content detected as source code from extension.", "language", language)
+		return Conversion{Markdown: io.MultiReader(bytes.NewBufferString("```"+language+"\n"), reader, bytes.NewBufferString("\n```"))}, nil
+	}
+	return Conversion{}, fmt.Errorf("unsupported file, no converter for content type %q or file name %q", contentType, filename)
+}
+
+func contentTypeKey(contentType string) string {
+	contentType, _, _ = strings.Cut(contentType, ";") // Remove charset if present
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+func extensionKey(extension string) string {
+	return strings.ToLower(extension)
+}
+
+// normalizeExtension returns the key of a file extension a converter is registered for, with the leading dot added
+// when it is left out, e.g. doc for .doc
+func normalizeExtension(extension string) (string, error) {
+	extension = strings.TrimSpace(extension)
+	if !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	if extension == "." || strings.ContainsAny(extension[1:], "./\\ ") {
+		return "", fmt.Errorf("invalid extension %q", extension)
+	}
+	return extensionKey(extension), nil
+}
@@ -0,0 +1,159 @@
+package document
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticConverter(name, md string) Converter {
+	return ConverterFunc(name, func(in io.Reader, opts ConvertOptions) (Conversion, error) {
+		return Conversion{Markdown: strings.NewReader(md)}, nil
+	})
+}
+
+func TestRegistry_Lookup(t *testing.T) {
+	r := newBuiltinRegistry(Config{Timeout: time.Second})
+	require.NoError(t, r.Register(staticConverter("marker", "marker"), []string{"application/pdf"}, []string{".pdf"}))
+	require.NoError(t, r.register(staticConverter("libreoffice", "libreoffice"), []string{"application/msword"}, []string{".doc", ".docx"}, true))
+
+	// the converter registered first wins
+	assert.Equal(t, "pdf", r.Lookup("application/pdf; charset=binary", "report.pdf", nil).Name())
+	// unless registered as default
+	assert.Equal(t, "libreoffice", r.Lookup("", "report.DOCX", nil).Name())
+	// the content type wins over the extension
+	assert.Equal(t, "docx", r.Lookup("application/vnd.openxmlformats-officedocument.wordprocessingml.document", "report.doc", nil).Name())
+
+	// preferences win, when the converter converts the content type or extension
+	assert.Equal(t, "marker", r.Lookup("application/pdf", "", map[string]string{"application/pdf": "marker"}).Name())
+	assert.Equal(t, "marker", r.Lookup("application/octet-stream", "report.pdf", map[string]string{".pdf": "marker"}).Name())
+	assert.Equal(t, "pdf", r.Lookup("application/pdf", "", map[string]string{"application/pdf": "libreoffice"}).Name())
+
+	assert.Nil(t, r.Lookup("application/octet-stream", "main.go", nil))
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := newBuiltinRegistry(Config{Timeout: time.Second})
+	assert.ErrorContains(t, r.Register(staticConverter("pdf", ""), []string{"application/pdf"}, nil), "already registered")
+	assert.ErrorContains(t, r.Register(staticConverter("a=b", ""), []string{"application/pdf"}, nil), "invalid converter name")
+	assert.ErrorContains(t, r.Register(staticConverter("nothing", ""), nil, nil), "no content types or extensions")
+	assert.ErrorContains(t, r.Register(staticConverter("dotted", ""), nil, []string{"tar.gz"}), "invalid extension")
+	assert.ErrorContains(t, r.Register(staticConverter("empty", ""), nil, []string{""}), "invalid extension")
+
+	// the leading dot may be left out
+	require.NoError(t, r.Register(staticConverter("wordperfect", ""), nil, []string{"WPD"}))
+	assert.Equal(t, "wordperfect", r.Lookup("application/octet-stream", "letter.wpd", nil).Name())
+}
+
+func TestRegistry_ValidatePreferences(t *testing.T) {
+	r := newBuiltinRegistry(Config{Timeout: time.Second})
+	require.NoError(t, r.Register(staticConverter("marker", ""), []string{"application/pdf"}, []string{".pdf"}))
+
+	assert.NoError(t, r.ValidatePreferences(map[string]string{"application/pdf": "marker", ".pdf": "pdf"}))
+	assert.ErrorContains(t, r.ValidatePreferences(map[string]string{".docx": "marker"}), `.docx is not converted by "marker"`)
+	assert.Error(t, r.ValidatePreferences(map[string]string{"application/pdf": "unknown"}))
+}
+
+func TestRegistry_Convert(t *testing.T) {
+	r := newBuiltinRegistry(Config{Timeout: time.Second})
+	require.NoError(t, r.Register(staticConverter("marker", "# From marker"), []string{"application/pdf"}, nil))
+	settings := ragnar.TubSettings{}.WithConverter("application/pdf", "marker")
+
+	c, err := r.Convert(slog.Default(), strings.NewReader("%PDF-"), "application/pdf", "", settings)
+	require.NoError(t, err)
+	b, _ := io.ReadAll(c.Markdown)
+	assert.Equal(t, "# From marker", string(b))
+
+	c, err = r.Convert(slog.Default(), strings.NewReader("package main"), "application/octet-stream", `attachment; filename="main.go"`, ragnar.TubSettings{})
+	require.NoError(t, err)
+	b, _ = io.ReadAll(c.Markdown)
+	assert.Equal(t, "```go\npackage main\n```", string(b))
+
+	_, err = r.Convert(slog.Default(), strings.NewReader(""), "application/octet-stream", `attachment; filename="data.bin"`, ragnar.TubSettings{})
+	assert.ErrorContains(t, err, "unsupported file")
+}
+
+func TestCommandConverter(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no shell")
+	}
+	convert := func(c CommandConfig, in string) (string, error) {
+		converter, err := newCommandConverter(c, time.Second)
+		require.NoError(t, err)
+		conv, err := converter.Convert(strings.NewReader(in), ConvertOptions{Filename: "notes.txt"})
+		if err != nil {
+			return "", err
+		}
+		b, err := io.ReadAll(conv.Markdown)
+		return string(b), err
+	}
+
+	out, err := convert(CommandConfig{Name: "stdin", Command: "sh", Args: []string{"-c", "echo '# Title'; cat"}}, "body")
+	require.NoError(t, err)
+	assert.Equal(t, "# Title\nbody", out)
+
+	out, err = convert(CommandConfig{Name: "file", Command: "sh", Args: []string{"-c", `basename "$1"; cat "$1"`, "sh", "{file}"}}, "body")
+	require.NoError(t, err)
+	assert.Equal(t, "input.txt\nbody", out)
+
+	_, err = convert(CommandConfig{Name: "large", Command: "sh", Args: []string{"-c", "cat"}, MaxOutputSize: 3}, "too large")
+	assert.True(t, errors.Is(err, errOutputTooLarge), err)
+
+	_, err = convert(CommandConfig{Name: "slow", Command: "sh", Args: []string{"-c", "sleep 5"}, Timeout: "50ms"}, "")
+	assert.Error(t, err)
+
+	_, err = convert(CommandConfig{Name: "failing", Command: "sh", Args: []string{"-c", "echo broken >&2; exit 1"}}, "")
+	assert.ErrorContains(t, err, "stderr: broken")
+}
+
+func TestNewRegistry_CommandsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "converters.json")
+	require.NoError(t, os.WriteFile(file, []byte(`[
+		{"name": "upper", "command": "tr", "args": ["a-z", "A-Z"], "content_types": ["text/x-shout"], "extensions": [".shout"]},
+		{"name": "pdf-tool", "command": "cat", "content_types": ["application/pdf"], "default": true}
+	]`), 0o600))
+
+	r, err := NewRegistry(Config{Timeout: time.Second, CommandsFile: file})
+	require.NoError(t, err)
+	assert.True(t, r.Supported("loud.shout"))
+	assert.True(t, r.SupportedContentType("text/x-shout"))
+	assert.Equal(t, "pdf-tool", r.Lookup("application/pdf", "", nil).Name())
+
+	_, err = NewRegistry(Config{CommandsFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.ErrorContains(t, err, "error reading converters file")
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"name": "pdf", "command": "cat", "content_types": ["application/pdf"]}]`), 0o600))
+	_, err = NewRegistry(Config{CommandsFile: file})
+	assert.ErrorContains(t, err, "already registered")
+
+	require.NoError(t, os.WriteFile(file, []byte(`[{"name": "bad", "command": "cat", "extensions": [".x"], "timeout": "soon"}]`), 0o600))
+	_, err = NewRegistry(Config{CommandsFile: file})
+	assert.ErrorContains(t, err, "invalid timeout")
+}
+
+func TestRegistry_DocumentContentType(t *testing.T) {
+	r := newBuiltinRegistry(Config{Timeout: time.Second})
+	doc := func(headers map[string]string) ragnar.Document {
+		doc := ragnar.Document{Headers: pgtype.Hstore{}}
+		for k, v := range headers {
+			doc.Headers[k] = &v
+		}
+		return doc
+	}
+
+	assert.Equal(t, "text/csv", r.DocumentContentType(doc(map[string]string{
+		"content-type": "text/csv", "content-disposition": `attachment; filename="export"`})))
+	assert.Equal(t, "text/csv", r.DocumentContentType(doc(map[string]string{
+		"content-type": "application/octet-stream", "content-disposition": `attachment; filename="export"`, ragnar.HeaderContentTypeDetected: "text/csv"})))
+	assert.Equal(t, "", r.DocumentContentType(doc(nil)))
+}
@@ -0,0 +1,104 @@
+package document
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvRowsPerTable is the number of rows of each markdown table a CSV file is converted to, every table repeats the
+// header row so that the columns of a row are known wherever the document is cut
+const csvRowsPerTable = 100
+
+// extractFromCSV converts comma or tab separated values with a header row to markdown, as tables of
+// csvRowsPerTable rows or, with records, as one section per row of column: value lines
+func extractFromCSV(in io.Reader, comma rune, records bool) (io.Reader, error) {
+	br := bufio.NewReader(in)
+	// skip the byte order mark spreadsheet programs write
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		_, _ = br.Discard(3)
+	}
+	r := csv.NewReader(br)
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var header []string
+	var rows [][]string
+	var sb strings.Builder
+	n := 0
+
+	flush := func() {
+		if len(rows) == 0 {
+			return
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(markdownTable(append([][]string{header}, rows...)))
+		rows = rows[:0]
+	}
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv: %w", err)
+		}
+		if isEmptyRecord(record) {
+			continue
+		}
+		if header == nil {
+			header = make([]string, len(record))
+			for i, h := range record {
+				header[i] = strings.Join(strings.Fields(h), " ")
+				if header[i] == "" {
+					header[i] = columnName(i)
+				}
+			}
+			continue
+		}
+		n++
+		for len(header) < len(record) {
+			header = append(header, columnName(len(header)))
+		}
+
+		if records {
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(fmt.Sprintf("## Row %d\n\n", n))
+			for i, value := range record {
+				value = strings.Join(strings.Fields(value), " ")
+				if value != "" {
+					sb.WriteString(header[i] + ": " + value + "\n")
+				}
+			}
+			continue
+		}
+
+		rows = append(rows, record)
+		if len(rows) == csvRowsPerTable {
+			flush()
+		}
+	}
+	flush()
+	if header != nil && n == 0 {
+		sb.WriteString(markdownTable([][]string{header}))
+	}
+	return strings.NewReader(sb.String()), nil
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
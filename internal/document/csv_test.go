@@ -0,0 +1,58 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtractFromCSV(t *testing.T) {
+	in := "\xef\xbb\xbfName,,City\n\nAnna,34,\"Stockholm, SE\"\nBo,27,Oslo,extra\n"
+
+	r, err := extractFromCSV(strings.NewReader(in), ',', false)
+	got := readAll(t, r, err)
+	expected := `| Name | B | City | D |
+| --- | --- | --- | --- |
+| Anna | 34 | Stockholm, SE |  |
+| Bo | 27 | Oslo | extra |
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+
+	r, err = extractFromCSV(strings.NewReader(in), ',', true)
+	got = readAll(t, r, err)
+	expected = `## Row 1
+
+Name: Anna
+B: 34
+City: Stockholm, SE
+
+## Row 2
+
+Name: Bo
+B: 27
+City: Oslo
+D: extra
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestExtractFromCSV_TablesRepeatHeader(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("id\tvalue\n")
+	for i := 1; i <= csvRowsPerTable+1; i++ {
+		sb.WriteString(fmt.Sprintf("%d\tv%d\n", i, i))
+	}
+
+	r, err := extractFromCSV(strings.NewReader(sb.String()), '\t', false)
+	got := readAll(t, r, err)
+	if n := strings.Count(got, "| id | value |\n| --- | --- |\n"); n != 2 {
+		t.Errorf("Expected the header in 2 tables, got %d:\n%s", n, got)
+	}
+	if !strings.HasSuffix(got, fmt.Sprintf("\n| id | value |\n| --- | --- |\n| %d | v%d |\n", csvRowsPerTable+1, csvRowsPerTable+1)) {
+		t.Errorf("Expected the last row in a table of its own, got:\n%s", got)
+	}
+}
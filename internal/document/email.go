@@ -0,0 +1,435 @@
+package document
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// email is an email message read from an .eml or .msg file
+type email struct {
+	subject     string
+	from        string
+	to          []string
+	cc          []string
+	date        time.Time
+	text        string
+	html        string
+	attachments []Attachment
+}
+
+// headers returns the headers of the email that become document headers
+func (m email) headers() map[string]string {
+	headers := map[string]string{}
+	set := func(k, v string) {
+		if v != "" {
+			headers[k] = v
+		}
+	}
+	set("subject", m.subject)
+	set("from", m.from)
+	set("to", strings.Join(m.to, ", "))
+	set("cc", strings.Join(m.cc, ", "))
+	if !m.date.IsZero() {
+		headers["date"] = m.date.Format(time.RFC3339)
+	}
+	return headers
+}
+
+// markdown renders the email as a heading of the subject, the sender, recipients, date and attachments, followed by
+// the body, converted from HTML with pandoc, bounded by timeout, when there is an HTML body
+func (m email) markdown(timeout time.Duration) (io.Reader, error) {
+	var sb strings.Builder
+	subject := m.subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	sb.WriteString("# " + subject + "\n\n")
+	field := func(name, value string) {
+		if value != "" {
+			sb.WriteString("- **" + name + ":** " + value + "\n")
+		}
+	}
+	field("From", m.from)
+	field("To", strings.Join(m.to, ", "))
+	field("Cc", strings.Join(m.cc, ", "))
+	if !m.date.IsZero() {
+		field("Date", m.date.Format(time.RFC1123Z))
+	}
+	var names []string
+	for _, a := range m.attachments {
+		names = append(names, a.Filename)
+	}
+	field("Attachments", strings.Join(names, ", "))
+
+	var body io.Reader = strings.NewReader(strings.TrimSpace(strings.ReplaceAll(m.text, "\r\n", "\n")) + "\n")
+	if strings.TrimSpace(m.html) != "" {
+		var err error
+		body, err = extractFromHTML(strings.NewReader(m.html), timeout)
+		if err != nil {
+			return nil, fmt.Errorf("error converting html body: %w", err)
+		}
+	}
+	sb.WriteString("\n")
+	return io.MultiReader(strings.NewReader(sb.String()), body), nil
+}
+
+// parseEml reads a MIME email message, the body is the first plain text and HTML part that is not an attachment
+func parseEml(in io.Reader) (email, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(in))
+	if err != nil {
+		return email{}, fmt.Errorf("error reading email: %w", err)
+	}
+	m := email{
+		subject: decodeHeader(msg.Header.Get("Subject")),
+		from:    strings.Join(addressList(msg.Header, "From"), ", "),
+		to:      addressList(msg.Header, "To"),
+		cc:      addressList(msg.Header, "Cc"),
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		m.date = date
+	}
+	err = m.readPart(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return email{}, err
+	}
+	return m, nil
+}
+
+func (m *email) readPart(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &base64Cleaner{r: body})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading email part: %w", err)
+			}
+			err = m.readPart(part.Header, part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeHeader(dispositionParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("error reading email part %s: %w", mediaType, err)
+	}
+
+	switch {
+	case mediaType == "message/rfc822":
+		if filename == "" {
+			filename = fmt.Sprintf("message-%d.eml", len(m.attachments)+1)
+		}
+		m.attachments = append(m.attachments, Attachment{Filename: filename, ContentType: mediaType, Data: data})
+	case disposition == "attachment" || filename != "" && header.Get("Content-Id") == "":
+		if filename == "" {
+			filename = fmt.Sprintf("attachment-%d", len(m.attachments)+1)
+		}
+		m.attachments = append(m.attachments, Attachment{Filename: filename, ContentType: mediaType, Data: data})
+	case mediaType == "text/plain" && m.text == "":
+		m.text = decodeCharset(params["charset"], data)
+	case mediaType == "text/html" && m.html == "":
+		m.html = decodeCharset(params["charset"], data)
+	}
+	// inline parts referenced from the HTML body, such as images in signatures, are left out
+	return nil
+}
+
+// base64Cleaner drops the characters, other than line breaks, that mail programs leave in base64 bodies and the
+// base64 decoder does not skip
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != ' ' && b != '\t' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
+
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header value
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(decoded)
+}
+
+// addressList returns the addresses of the header as "Name <address>"
+func addressList(header mail.Header, key string) []string {
+	value := header.Get(key)
+	if value == "" {
+		return nil
+	}
+	addresses, err := (&mail.AddressParser{WordDecoder: wordDecoder}).ParseList(value)
+	if err != nil {
+		return []string{decodeHeader(value)}
+	}
+	var list []string
+	for _, a := range addresses {
+		list = append(list, formatAddress(a.Name, a.Address))
+	}
+	return list
+}
+
+func formatAddress(name, address string) string {
+	switch {
+	case name == "" || name == address:
+		return address
+	case address == "":
+		return name
+	}
+	return name + " <" + address + ">"
+}
+
+func decodeCharset(charset string, data []byte) string {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return string(data)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// Outlook .msg files are compound files that hold each property of the message in a stream named by the property id
+// and type, and the recipients and attachments in storages of their own
+const (
+	msgPropertyPrefix   = "__substg1.0_"
+	msgRecipientPrefix  = "__recip_version1.0_#"
+	msgAttachmentPrefix = "__attach_version1.0_#"
+	msgPropertiesStream = "__properties_version1.0"
+
+	msgTypeString8 = "001E"
+	msgTypeUnicode = "001F"
+	msgTypeBinary  = "0102"
+
+	msgSubject           = "0037"
+	msgClientSubmitTime  = 0x0039
+	msgSenderName        = "0C1A"
+	msgSenderAddress     = "0C1F"
+	msgSenderSmtpAddress = "5D01"
+	msgDeliveryTime      = 0x0E06
+	msgBody              = "1000"
+	msgBodyHtml          = "1013"
+
+	msgRecipientType        = 0x0C15
+	msgRecipientName        = "3001"
+	msgRecipientAddress     = "3003"
+	msgRecipientSmtpAddress = "39FE"
+
+	msgAttachFilename     = "3704"
+	msgAttachLongFilename = "3707"
+	msgAttachData         = "3701"
+	msgAttachMimeTag      = "370E"
+	msgAttachHidden       = 0x7FFE
+
+	msgRecipientTo = 1
+	msgRecipientCc = 2
+)
+
+// parseMsg reads an Outlook .msg message, attached messages stored as storages of their own are left out
+func parseMsg(in io.Reader) (email, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return email{}, fmt.Errorf("error reading msg: %w", err)
+	}
+	cf, err := openCompoundFile(data)
+	if err != nil {
+		return email{}, fmt.Errorf("error reading msg: %w", err)
+	}
+	root := cf.children(0)
+	props := msgFixedProperties(cf, root, 32)
+
+	m := email{
+		subject: msgString(cf, root, msgSubject),
+		text:    msgString(cf, root, msgBody),
+		html:    msgString(cf, root, msgBodyHtml),
+	}
+	address := msgString(cf, root, msgSenderSmtpAddress)
+	if address == "" {
+		address = msgString(cf, root, msgSenderAddress)
+	}
+	if !strings.Contains(address, "@") {
+		address = "" // exchange addresses mean nothing outside the organization
+	}
+	m.from = formatAddress(msgString(cf, root, msgSenderName), address)
+	for _, id := range []uint16{msgClientSubmitTime, msgDeliveryTime} {
+		if ft, ok := props[id]; ok && ft != 0 {
+			m.date = filetime(ft)
+			break
+		}
+	}
+
+	for _, name := range sortedWithPrefix(root, msgRecipientPrefix) {
+		recipient := cf.children(root[name])
+		address := msgString(cf, recipient, msgRecipientSmtpAddress)
+		if address == "" {
+			address = msgString(cf, recipient, msgRecipientAddress)
+		}
+		if !strings.Contains(address, "@") {
+			address = ""
+		}
+		formatted := formatAddress(msgString(cf, recipient, msgRecipientName), address)
+		if formatted == "" {
+			continue
+		}
+		switch msgFixedProperties(cf, recipient, 8)[msgRecipientType] {
+		case msgRecipientTo:
+			m.to = append(m.to, formatted)
+		case msgRecipientCc:
+			m.cc = append(m.cc, formatted)
+		}
+	}
+
+	for _, name := range sortedWithPrefix(root, msgAttachmentPrefix) {
+		attachment := cf.children(root[name])
+		if msgFixedProperties(cf, attachment, 8)[msgAttachHidden] != 0 {
+			continue
+		}
+		i, ok := attachment[msgPropertyPrefix+msgAttachData+msgTypeBinary]
+		if !ok {
+			continue
+		}
+		content, err := cf.stream(i)
+		if err != nil {
+			return email{}, fmt.Errorf("error reading msg attachment: %w", err)
+		}
+		filename := msgString(cf, attachment, msgAttachLongFilename)
+		if filename == "" {
+			filename = msgString(cf, attachment, msgAttachFilename)
+		}
+		if filename == "" {
+			filename = fmt.Sprintf("attachment-%d", len(m.attachments)+1)
+		}
+		contentType := msgString(cf, attachment, msgAttachMimeTag)
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		m.attachments = append(m.attachments, Attachment{Filename: filename, ContentType: contentType, Data: content})
+	}
+	return m, nil
+}
+
+// msgString reads a string or binary property of a storage, by the hex id of the property
+func msgString(cf *compoundFile, storage map[string]int, id string) string {
+	if i, ok := storage[msgPropertyPrefix+id+msgTypeUnicode]; ok {
+		b, err := cf.stream(i)
+		if err == nil {
+			return strings.TrimSpace(strings.TrimRight(utf16String(b), "\x00"))
+		}
+	}
+	for _, kind := range []string{msgTypeString8, msgTypeBinary} {
+		if i, ok := storage[msgPropertyPrefix+id+kind]; ok {
+			b, err := cf.stream(i)
+			if err != nil {
+				continue
+			}
+			b = bytes.TrimRight(b, "\x00")
+			if kind == msgTypeString8 && !utf8.Valid(b) {
+				b, _ = charmap.Windows1252.NewDecoder().Bytes(b)
+			}
+			return strings.TrimSpace(string(b))
+		}
+	}
+	return ""
+}
+
+// msgFixedProperties reads the fixed size values, such as times and integers, from the properties stream of a
+// storage, which starts with a header of headerSize bytes followed by 16 bytes per property
+func msgFixedProperties(cf *compoundFile, storage map[string]int, headerSize int) map[uint16]uint64 {
+	props := map[uint16]uint64{}
+	i, ok := storage[msgPropertiesStream]
+	if !ok {
+		return props
+	}
+	b, err := cf.stream(i)
+	if err != nil || len(b) < headerSize {
+		return props
+	}
+	for b = b[headerSize:]; len(b) >= 16; b = b[16:] {
+		props[binary.LittleEndian.Uint16(b[2:])] = binary.LittleEndian.Uint64(b[8:])
+	}
+	return props
+}
+
+func sortedWithPrefix(storage map[string]int, prefix string) []string {
+	var names []string
+	for name := range storage {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filetime converts a Windows FILETIME, 100 nanosecond intervals since 1601, to a time
+func filetime(ft uint64) time.Time {
+	const epochDiff = 116444736000000000 // from 1601 to 1970
+	if ft < epochDiff {
+		return time.Time{}
+	}
+	ft -= epochDiff
+	return time.Unix(int64(ft/1e7), int64(ft%1e7)*100).UTC()
+}
@@ -0,0 +1,324 @@
+package document
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/modfin/ragnar"
+)
+
+const testEml = "From: =?utf-8?q?Anna_Str=C3=B6m?= <anna@example.com>\r\n" +
+	"To: \"Doe, Bo\" <bo@example.com>, carl@example.com\r\n" +
+	"Cc: Dora <dora@example.com>\r\n" +
+	"Subject: =?utf-8?b?UXVhcnRlcmx5IHJlcG9ydCDwn5OI?=\r\n" +
+	"Date: Fri, 01 Mar 2024 11:00:00 +0100\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Hej,\r\n\r\nse bifogad rapport f=F6r Q1.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Hej,</p><p>se bifogad rapport f\xc3\xb6r Q1.</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Disposition: inline; filename=\"logo.png\"\r\n" +
+	"Content-Id: <logo@example.com>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"q1.csv\"\r\n" +
+	"Content-Disposition: attachment; filename*=utf-8''r%C3%A4kning.csv\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YSxi\r\nCjEs\r\nMgo=\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: bo@example.com\r\nSubject: Earlier\r\n\r\nEarlier message\r\n" +
+	"--outer--\r\n"
+
+func TestParseEml(t *testing.T) {
+	m, err := parseEml(strings.NewReader(testEml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.subject != "Quarterly report 📈" {
+		t.Errorf("subject = %q", m.subject)
+	}
+	if m.from != "Anna Ström <anna@example.com>" {
+		t.Errorf("from = %q", m.from)
+	}
+	if !reflect.DeepEqual(m.to, []string{"Doe, Bo <bo@example.com>", "carl@example.com"}) {
+		t.Errorf("to = %q", m.to)
+	}
+	if !reflect.DeepEqual(m.cc, []string{"Dora <dora@example.com>"}) {
+		t.Errorf("cc = %q", m.cc)
+	}
+	if !m.date.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("date = %v", m.date)
+	}
+	if m.text != "Hej,\r\n\r\nse bifogad rapport för Q1." {
+		t.Errorf("text = %q", m.text)
+	}
+	if m.html != "<p>Hej,</p><p>se bifogad rapport för Q1.</p>" {
+		t.Errorf("html = %q", m.html)
+	}
+
+	expected := []Attachment{
+		{Filename: "räkning.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")},
+		{Filename: "message-2.eml", ContentType: "message/rfc822", Data: []byte("From: bo@example.com\r\nSubject: Earlier\r\n\r\nEarlier message")},
+	}
+	if !reflect.DeepEqual(m.attachments, expected) {
+		t.Errorf("attachments = %+v", m.attachments)
+	}
+
+	headers := map[string]string{
+		"subject": "Quarterly report 📈",
+		"from":    "Anna Ström <anna@example.com>",
+		"to":      "Doe, Bo <bo@example.com>, carl@example.com",
+		"cc":      "Dora <dora@example.com>",
+		"date":    "2024-03-01T11:00:00+01:00",
+	}
+	if !reflect.DeepEqual(m.headers(), headers) {
+		t.Errorf("headers = %v", m.headers())
+	}
+}
+
+func TestConvert_Email(t *testing.T) {
+	c, err := Convert(slog.Default(), strings.NewReader("From: bo@example.com\r\nSubject: Earlier\r\n\r\nEarlier message\r\n"),
+		"message/rfc822", "", ragnar.TubSettings{})
+	got := readAll(t, c.Markdown, err)
+
+	expected := "# Earlier\n\n- **From:** bo@example.com\n\nEarlier message\n"
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+	if !reflect.DeepEqual(c.Headers, map[string]string{"subject": "Earlier", "from": "bo@example.com"}) {
+		t.Errorf("headers = %v", c.Headers)
+	}
+}
+
+func TestParseMsg(t *testing.T) {
+	submitted := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	large := bytes.Repeat([]byte("0123456789"), 500) // stored in regular sectors rather than the mini stream
+
+	data := compoundFileBytes(t, map[string][]byte{
+		"__substg1.0_0037001F":    utf16Bytes("Quarterly report"),
+		"__substg1.0_0C1A001F":    utf16Bytes("Anna Ström"),
+		"__substg1.0_5D01001F":    utf16Bytes("anna@example.com"),
+		"__substg1.0_1000001E":    []byte("Hej,\r\n\r\nse bifogad rapport f\xf6r Q1.\x00"),
+		"__properties_version1.0": msgProperties(32, map[uint16]uint64{msgClientSubmitTime: uint64(submitted.UnixNano()/100) + 116444736000000000}),
+
+		"__recip_version1.0_#00000000/__substg1.0_3001001F":    utf16Bytes("Bo"),
+		"__recip_version1.0_#00000000/__substg1.0_39FE001F":    utf16Bytes("bo@example.com"),
+		"__recip_version1.0_#00000000/__properties_version1.0": msgProperties(8, map[uint16]uint64{msgRecipientType: msgRecipientTo}),
+		"__recip_version1.0_#00000001/__substg1.0_3001001F":    utf16Bytes("Carl"),
+		"__recip_version1.0_#00000001/__substg1.0_3003001F":    utf16Bytes("/O=EXAMPLE/OU=EXCHANGE/CN=RECIPIENTS/CN=CARL"),
+		"__recip_version1.0_#00000001/__properties_version1.0": msgProperties(8, map[uint16]uint64{msgRecipientType: msgRecipientCc}),
+
+		"__attach_version1.0_#00000000/__substg1.0_3707001F":    utf16Bytes("data.txt"),
+		"__attach_version1.0_#00000000/__substg1.0_37010102":    large,
+		"__attach_version1.0_#00000000/__substg1.0_370E001F":    utf16Bytes("text/plain"),
+		"__attach_version1.0_#00000001/__substg1.0_3704001F":    utf16Bytes("logo.png"),
+		"__attach_version1.0_#00000001/__substg1.0_37010102":    []byte("png"),
+		"__attach_version1.0_#00000001/__properties_version1.0": msgProperties(8, map[uint16]uint64{msgAttachHidden: 1}),
+	})
+
+	m, err := parseMsg(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := email{
+		subject:     "Quarterly report",
+		from:        "Anna Ström <anna@example.com>",
+		to:          []string{"Bo <bo@example.com>"},
+		cc:          []string{"Carl"},
+		date:        submitted,
+		text:        "Hej,\r\n\r\nse bifogad rapport för Q1.",
+		attachments: []Attachment{{Filename: "data.txt", ContentType: "text/plain", Data: large}},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected:\n%+v\nGot:\n%+v", expected, m)
+	}
+}
+
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// msgProperties builds a properties stream of 8 byte values, after a header of headerSize bytes
+func msgProperties(headerSize int, values map[uint16]uint64) []byte {
+	b := make([]byte, headerSize)
+	for id, v := range values {
+		entry := make([]byte, 16)
+		binary.LittleEndian.PutUint16(entry[0:], 0x0040)
+		binary.LittleEndian.PutUint16(entry[2:], id)
+		binary.LittleEndian.PutUint64(entry[8:], v)
+		b = append(b, entry...)
+	}
+	return b
+}
+
+// compoundFileBytes builds a version 3 compound file of the streams, by slash separated paths
+func compoundFileBytes(t *testing.T, streams map[string][]byte) []byte {
+	t.Helper()
+	type entry struct {
+		name     string
+		kind     byte
+		data     []byte
+		children []int
+		start    uint32
+		size     uint64
+	}
+	entries := []entry{{name: "Root Entry", kind: cfbRoot}}
+	storages := map[string]int{"": 0}
+	var paths []string
+	for path := range streams {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		parent := 0
+		parts := strings.Split(path, "/")
+		for i, name := range parts[:len(parts)-1] {
+			key := strings.Join(parts[:i+1], "/")
+			idx, ok := storages[key]
+			if !ok {
+				idx = len(entries)
+				entries = append(entries, entry{name: name, kind: cfbStorage})
+				entries[parent].children = append(entries[parent].children, idx)
+				storages[key] = idx
+			}
+			parent = idx
+		}
+		entries = append(entries, entry{name: parts[len(parts)-1], kind: cfbStream, data: streams[path]})
+		entries[parent].children = append(entries[parent].children, len(entries)-1)
+	}
+
+	var sectors [][]byte
+	var fat []uint32
+	allocate := func(data []byte, size int, table *[]uint32, out *[][]byte) uint32 {
+		if len(data) == 0 {
+			return cfbEndChain
+		}
+		start := uint32(len(*table))
+		for i := 0; i < len(data); i += size {
+			sector := make([]byte, size)
+			copy(sector, data[i:])
+			*out = append(*out, sector)
+			*table = append(*table, uint32(len(*table)+1))
+		}
+		(*table)[len(*table)-1] = cfbEndChain
+		return start
+	}
+
+	var miniSectors [][]byte
+	var miniFat []uint32
+	for i := range entries {
+		e := &entries[i]
+		e.start, e.size = cfbEndChain, uint64(len(e.data))
+		if e.kind != cfbStream {
+			continue
+		}
+		if len(e.data) < 4096 {
+			e.start = allocate(e.data, 64, &miniFat, &miniSectors)
+		} else {
+			e.start = allocate(e.data, 512, &fat, &sectors)
+		}
+	}
+	entries[0].start = allocate(bytes.Join(miniSectors, nil), 512, &fat, &sectors)
+	entries[0].size = uint64(64 * len(miniSectors))
+	var miniFatBytes []byte
+	for _, s := range miniFat {
+		miniFatBytes = binary.LittleEndian.AppendUint32(miniFatBytes, s)
+	}
+	miniFatStart := allocate(miniFatBytes, 512, &fat, &sectors)
+
+	var dir []byte
+	for _, e := range entries {
+		b := make([]byte, 128)
+		name := utf16Bytes(e.name + "\x00")
+		copy(b, name)
+		binary.LittleEndian.PutUint16(b[0x40:], uint16(len(name)))
+		b[0x42] = e.kind
+		for _, off := range []int{0x44, 0x48, 0x4C} {
+			binary.LittleEndian.PutUint32(b[off:], cfbNoStream)
+		}
+		if len(e.children) > 0 {
+			binary.LittleEndian.PutUint32(b[0x4C:], uint32(e.children[0]))
+		}
+		binary.LittleEndian.PutUint32(b[0x74:], e.start)
+		binary.LittleEndian.PutUint64(b[0x78:], e.size)
+		dir = append(dir, b...)
+	}
+	// siblings are linked after all entries are written
+	for _, e := range entries {
+		for i := 0; i+1 < len(e.children); i++ {
+			binary.LittleEndian.PutUint32(dir[128*e.children[i]+0x48:], uint32(e.children[i+1]))
+		}
+	}
+	dirStart := allocate(dir, 512, &fat, &sectors)
+
+	fatSectors := (len(fat) + 127) / 128
+	for (len(fat)+fatSectors+127)/128 > fatSectors {
+		fatSectors++
+	}
+	firstFat := len(fat)
+	for i := 0; i < fatSectors; i++ {
+		fat = append(fat, 0xFFFFFFFD)
+	}
+	var fatBytes []byte
+	for _, s := range fat {
+		fatBytes = binary.LittleEndian.AppendUint32(fatBytes, s)
+	}
+	for len(fatBytes) < 512*fatSectors {
+		fatBytes = binary.LittleEndian.AppendUint32(fatBytes, cfbNoStream)
+	}
+	for i := 0; i < fatSectors; i++ {
+		sectors = append(sectors, fatBytes[512*i:512*(i+1)])
+	}
+
+	header := make([]byte, 512)
+	copy(header, cfbSignature)
+	le := binary.LittleEndian
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], uint32(fatSectors))
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], miniFatStart)
+	le.PutUint32(header[0x40:], uint32((len(miniFatBytes)+511)/512))
+	le.PutUint32(header[0x44:], cfbEndChain)
+	for i := 0; i < 109; i++ {
+		s := uint32(cfbNoStream)
+		if i < fatSectors {
+			s = uint32(firstFat + i)
+		}
+		le.PutUint32(header[0x4C+4*i:], s)
+	}
+	return append(header, bytes.Join(sectors, nil)...)
+}
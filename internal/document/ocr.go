@@ -0,0 +1,203 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// ocrMinPageChars is the number of non-space characters below which a page of the pdftotext output is taken to be
+	// a scan without a text layer
+	ocrMinPageChars = 20
+	// ocrDPI is the resolution pages are rasterized at for tesseract, which reads best at 300 dpi
+	ocrDPI = 300
+	// defaultOcrTimeout bounds the OCR of all the pages of a document, unless configured, keeping it within the claim
+	// time of the conversion task
+	defaultOcrTimeout = 4 * time.Minute
+)
+
+// extractFromPDF extracts the text layer of the PDF with pdftotext, pages are separated by form feeds. Pages with
+// next to no text, such as scans, are rasterized with pdftoppm and read with tesseract in the given languages.
+// timeout bounds pdftotext and the OCR of each page, ocrTimeout the OCR of all pages.
+func extractFromPDF(log *slog.Logger, in io.Reader, languages string, timeout, ocrTimeout time.Duration) (io.Reader, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pdf: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// The command is `pdftotext [options] [PDF-file] [text-file]`: Using `-` tells it to read from stdin/write to stdout
+	text, err := runCommand(ctx, bytes.NewReader(data), "pdftotext", "-", "-")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := strings.Split(string(text), "\f")
+	scanned := scannedPages(pages)
+	if len(scanned) == 0 {
+		return bytes.NewReader(text), nil
+	}
+
+	log.Debug("pdf pages without text, running ocr", "pages", len(scanned))
+	err = ocrPDFPages(data, pages, scanned, languages, timeout, ocrTimeout)
+	result := strings.Join(pages, "\f")
+	if err != nil {
+		if !hasText(result) {
+			return nil, fmt.Errorf("pdf has no text layer and ocr failed: %w", err)
+		}
+		log.Warn("ocr of some pdf pages without text failed, using the pages that were read", "err", err)
+	}
+	return strings.NewReader(result), nil
+}
+
+// scannedPages returns the indexes of the pages of the pdftotext output, split on form feeds, with too little text
+// to be anything but scans. pdftotext ends every page with a form feed, so the last element is not a page.
+func scannedPages(pages []string) []int {
+	n := max(len(pages)-1, 1)
+	var scanned []int
+	for i := 0; i < n && i < len(pages); i++ {
+		chars := 0
+		for _, r := range pages[i] {
+			if !unicode.IsSpace(r) {
+				chars++
+			}
+		}
+		if chars < ocrMinPageChars {
+			scanned = append(scanned, i)
+		}
+	}
+	return scanned
+}
+
+// ocrPDFPages replaces the scanned pages with the text tesseract reads from them. Every page has pageTimeout, a page
+// that fails is left as it is and the following pages are still read until timeout, the errors of the failed pages
+// are returned joined
+func ocrPDFPages(data []byte, pages []string, scanned []int, languages string, pageTimeout, timeout time.Duration) error {
+	dir, err := os.MkdirTemp("", "ragnar-ocr-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pdf := filepath.Join(dir, "document.pdf")
+	err = os.WriteFile(pdf, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write pdf: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	var errs []error
+	for n, i := range scanned {
+		if time.Now().After(deadline) {
+			errs = append(errs, fmt.Errorf("ocr timed out, %d of %d pages not read", len(scanned)-n, len(scanned)))
+			break
+		}
+		text, err := ocrPDFPage(dir, pdf, i+1, languages, pageTimeout)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if hasText(text) {
+			pages[i] = strings.TrimRight(text, "\f\n") + "\n"
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ocrPDFPage rasterizes the page, numbered from 1, and reads it with tesseract
+func ocrPDFPage(dir, pdf string, number int, languages string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	page := strconv.Itoa(number)
+	image := filepath.Join(dir, "page")
+	_, err := runCommand(ctx, nil, "pdftoppm", "-f", page, "-l", page, "-r", strconv.Itoa(ocrDPI), "-gray", "-png", "-singlefile", pdf, image)
+	if err != nil {
+		return "", fmt.Errorf("error rasterizing page %s: %w", page, err)
+	}
+	text, err := runCommand(ctx, nil, "tesseract", image+".png", "stdout", "-l", languages)
+	if err != nil {
+		return "", fmt.Errorf("error reading page %s: %w", page, err)
+	}
+	return string(text), nil
+}
+
+// extractFromImage reads the text of a PNG or JPEG image with tesseract in the given languages, timeout bounds
+// tesseract
+func extractFromImage(in io.Reader, languages string, timeout time.Duration) (io.Reader, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	text, err := runCommand(ctx, in, "tesseract", "stdin", "stdout", "-l", languages)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(bytes.TrimRight(text, "\f\n")), nil
+}
+
+// tesseractLanguages returns the languages tesseract has data installed for
+var tesseractLanguages = sync.OnceValues(func() (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := runCommand(ctx, nil, "tesseract", "--list-langs")
+	if err != nil {
+		return nil, err
+	}
+	languages := map[string]bool{}
+	// the first line is a heading, "List of available languages in ...", followed by a language per line
+	lines := strings.Split(string(out), "\n")
+	for _, line := range lines[min(1, len(lines)):] {
+		if line = strings.TrimSpace(line); line != "" {
+			languages[line] = true
+		}
+	}
+	return languages, nil
+})
+
+// ValidateOcrLanguages checks that tesseract has data installed for the languages, joined by +. Languages are not
+// checked when tesseract is not installed, as there is no OCR at all then
+func ValidateOcrLanguages(languages string) error {
+	installed, err := tesseractLanguages()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error listing tesseract languages: %w", err)
+	}
+	for _, language := range strings.Split(languages, "+") {
+		if !installed[language] {
+			return fmt.Errorf("invalid ocr_languages setting, tesseract has no data for %q", language)
+		}
+	}
+	return nil
+}
+
+// runCommand runs the command with stdin as input and returns its output
+func runCommand(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s execution error: %w, stderr: %s", name, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func hasText(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return !unicode.IsSpace(r) }) >= 0
+}
@@ -0,0 +1,37 @@
+package document
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScannedPages(t *testing.T) {
+	text := "A page with plenty of text from the text layer.\n\f\n\f  12 \n\fAnother page with plenty of text on it.\n\f"
+	assert.Equal(t, []int{1, 2}, scannedPages(strings.Split(text, "\f")))
+
+	// pdftotext outputs only the form feeds of a fully scanned document
+	assert.Equal(t, []int{0, 1, 2}, scannedPages(strings.Split("\f\f\f", "\f")))
+
+	// output without form feeds is a single page
+	assert.Equal(t, []int{0}, scannedPages(strings.Split("", "\f")))
+	assert.Empty(t, scannedPages(strings.Split("A page with plenty of text but no form feed", "\f")))
+}
+
+func TestSupported_Images(t *testing.T) {
+	for _, name := range []string{"scan.png", "photo.JPG", "receipt.jpeg"} {
+		assert.True(t, builtin.Supported(name), name)
+	}
+	assert.False(t, builtin.Supported("drawing.svg"))
+}
+
+func TestValidateOcrLanguages(t *testing.T) {
+	if _, err := exec.LookPath("tesseract"); err != nil {
+		assert.NoError(t, ValidateOcrLanguages("not_a_language"), "languages are not checked without tesseract")
+		return
+	}
+	assert.NoError(t, ValidateOcrLanguages("eng"))
+	assert.ErrorContains(t, ValidateOcrLanguages("eng+not_a_language"), `no data for "not_a_language"`)
+}
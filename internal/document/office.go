@@ -0,0 +1,118 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Office documents, OOXML and OpenDocument, are zip archives of xml parts
+
+func openZip(in io.Reader) (*zip.Reader, error) {
+	b, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading body: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening zip archive: %w", err)
+	}
+	return zr, nil
+}
+
+// decodeZipXML unmarshals the xml part at name into v
+func decodeZipXML(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer f.Close()
+	err = xml.NewDecoder(f).Decode(v)
+	if err != nil {
+		return fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	return nil
+}
+
+type ooxmlRelationship struct {
+	Id     string `xml:"Id,attr"`
+	Type   string `xml:"Type,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+// readRelationships returns the relationships of the OOXML part at name, stored in _rels/<part>.rels next to it, with
+// the targets resolved to part names. A part without a rels file has no relationships.
+func readRelationships(zr *zip.Reader, name string) ([]ooxmlRelationship, error) {
+	dir, file := path.Split(name)
+	relsName := path.Join(dir, "_rels", file+".rels")
+	if _, err := fs.Stat(zr, relsName); err != nil {
+		return nil, nil
+	}
+	var rels struct {
+		Relationships []ooxmlRelationship `xml:"Relationship"`
+	}
+	err := decodeZipXML(zr, relsName, &rels)
+	if err != nil {
+		return nil, err
+	}
+	for i, rel := range rels.Relationships {
+		rels.Relationships[i].Target = resolvePart(dir, rel.Target)
+	}
+	return rels.Relationships, nil
+}
+
+func resolvePart(dir, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(dir, target)
+}
+
+func xmlAttr(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// markdownTable renders rows as a markdown table with the first row as header
+func markdownTable(rows [][]string) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	var sb strings.Builder
+	writeRow := func(row []string) {
+		sb.WriteString("|")
+		for i := 0; i < width; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = tableCell(row[i])
+			}
+			sb.WriteString(" " + cell + " |")
+		}
+		sb.WriteString("\n")
+	}
+	writeRow(rows[0])
+	sb.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+	return sb.String()
+}
+
+var tableCellReplacer = strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+
+func tableCell(s string) string {
+	return strings.TrimSpace(tableCellReplacer.Replace(s))
+}
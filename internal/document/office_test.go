@@ -0,0 +1,229 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func zipFiles(t *testing.T, files map[string]string) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func readAll(t *testing.T, r io.Reader, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read result: %v", err)
+	}
+	return string(b)
+}
+
+const (
+	pptxNamespaces = `xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"`
+	relsNamespace  = `xmlns="http://schemas.openxmlformats.org/package/2006/relationships"`
+)
+
+func TestExtractFromPptx(t *testing.T) {
+	files := map[string]string{
+		"ppt/presentation.xml": `<p:presentation ` + pptxNamespaces + `><p:sldIdLst>
+			<p:sldId id="257" r:id="rId3"/><p:sldId id="256" r:id="rId2"/>
+		</p:sldIdLst></p:presentation>`,
+		"ppt/_rels/presentation.xml.rels": `<Relationships ` + relsNamespace + `>
+			<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/slide" Target="slides/slide1.xml"/>
+			<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/slide" Target="slides/slide2.xml"/>
+		</Relationships>`,
+		"ppt/slides/slide1.xml": `<p:sld ` + pptxNamespaces + `><p:cSld><p:spTree>
+			<p:sp><p:nvSpPr><p:nvPr><p:ph type="title"/></p:nvPr></p:nvSpPr><p:txBody><a:p><a:r><a:t>Quarterly Results</a:t></a:r></a:p></p:txBody></p:sp>
+			<p:sp><p:nvSpPr><p:nvPr><p:ph idx="1"/></p:nvPr></p:nvSpPr><p:txBody>
+				<a:p><a:r><a:t>Revenue </a:t></a:r><a:r><a:t>up 10%</a:t></a:r></a:p>
+				<a:p><a:pPr lvl="1"/><a:r><a:t>Mostly Nordics</a:t></a:r></a:p>
+			</p:txBody></p:sp>
+			<p:graphicFrame><a:graphic><a:graphicData><a:tbl>
+				<a:tr><a:tc><a:txBody><a:p><a:r><a:t>Region</a:t></a:r></a:p></a:txBody></a:tc><a:tc><a:txBody><a:p><a:r><a:t>Growth</a:t></a:r></a:p></a:txBody></a:tc></a:tr>
+				<a:tr><a:tc><a:txBody><a:p><a:r><a:t>Sweden</a:t></a:r></a:p></a:txBody></a:tc><a:tc><a:txBody><a:p><a:r><a:t>12|%</a:t></a:r></a:p></a:txBody></a:tc></a:tr>
+			</a:tbl></a:graphicData></a:graphic></p:graphicFrame>
+		</p:spTree></p:cSld></p:sld>`,
+		"ppt/slides/_rels/slide1.xml.rels": `<Relationships ` + relsNamespace + `>
+			<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/notesSlide" Target="../notesSlides/notesSlide1.xml"/>
+		</Relationships>`,
+		"ppt/notesSlides/notesSlide1.xml": `<p:notes ` + pptxNamespaces + `><p:cSld><p:spTree>
+			<p:sp><p:nvSpPr><p:nvPr><p:ph type="sldImg"/></p:nvPr></p:nvSpPr></p:sp>
+			<p:sp><p:nvSpPr><p:nvPr><p:ph type="body" idx="1"/></p:nvPr></p:nvSpPr><p:txBody><a:p><a:r><a:t>Mention the new office.</a:t></a:r></a:p></p:txBody></p:sp>
+			<p:sp><p:nvSpPr><p:nvPr><p:ph type="sldNum" idx="5"/></p:nvPr></p:nvSpPr><p:txBody><a:p><a:r><a:t>1</a:t></a:r></a:p></p:txBody></p:sp>
+		</p:spTree></p:cSld></p:notes>`,
+		"ppt/slides/slide2.xml": `<p:sld ` + pptxNamespaces + `><p:cSld><p:spTree>
+			<p:sp><p:nvSpPr><p:nvPr/></p:nvSpPr><p:txBody><a:p><a:r><a:t>Thank you</a:t></a:r></a:p></p:txBody></p:sp>
+		</p:spTree></p:cSld></p:sld>`,
+	}
+
+	r, err := extractFromPptx(zipFiles(t, files))
+	got := readAll(t, r, err)
+
+	expected := `## Slide 1
+
+Thank you
+
+## Slide 2: Quarterly Results
+
+- Revenue up 10%
+  - Mostly Nordics
+
+| Region | Growth |
+| --- | --- |
+| Sweden | 12\|% |
+
+### Speaker Notes
+
+Mention the new office.
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestExtractFromOdp(t *testing.T) {
+	files := map[string]string{
+		"content.xml": `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:presentation="urn:oasis:names:tc:opendocument:xmlns:presentation:1.0">
+		<office:body><office:presentation>
+			<draw:page draw:name="page1">
+				<draw:frame presentation:class="title"><draw:text-box><text:p>Roadmap</text:p></draw:text-box></draw:frame>
+				<draw:frame presentation:class="outline"><draw:text-box><text:list>
+					<text:list-item><text:p>Search</text:p><text:list><text:list-item><text:p>Hybrid<text:s/>ranking</text:p></text:list-item></text:list></text:list-item>
+				</text:list></draw:text-box></draw:frame>
+				<presentation:notes><draw:page-thumbnail/><draw:frame presentation:class="notes"><draw:text-box><text:p>Ship in Q3.</text:p></draw:text-box></draw:frame></presentation:notes>
+			</draw:page>
+			<draw:page draw:name="page2">
+				<draw:frame><draw:text-box><text:p>Questions?</text:p></draw:text-box></draw:frame>
+			</draw:page>
+		</office:presentation></office:body></office:document-content>`,
+	}
+
+	r, err := extractFromOdp(zipFiles(t, files))
+	got := readAll(t, r, err)
+
+	expected := `## Slide 1: Roadmap
+
+- Search
+  - Hybrid ranking
+
+### Speaker Notes
+
+Ship in Q3.
+
+## Slide 2
+
+Questions?
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestExtractFromXlsx(t *testing.T) {
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Sales" sheetId="1" r:id="rId1"/><sheet name="Empty" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships ` + relsNamespace + `>
+			<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+			<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+			<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/sharedStrings" Target="sharedStrings.xml"/>
+			<Relationship Id="rId4" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+		</Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>Date</t></si><si><t>Amount</t></si><si><r><t>Nordic </t></r><r><t>region</t></r></si>
+		</sst>`,
+		"xl/styles.xml": `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<numFmts><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd"/><numFmt numFmtId="165" formatCode="#,##0 &quot;kr&quot;"/></numFmts>
+			<cellXfs><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="165"/></cellXfs>
+		</styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+			<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c><c r="D1"><v>1</v></c></row>
+			<row r="2"/>
+			<row r="3"><c r="A3" s="1"><v>45292</v></c><c r="B3" s="1"/><c r="C3" s="2"><v>1200</v></c><c r="D3" t="b"><v>1</v></c></row>
+			<row r="4"><c r="A4" t="inlineStr"><is><t>Total</t></is></c><c r="C4" t="s"><v>2</v></c></row>
+		</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`,
+	}
+
+	r, err := extractFromXlsx(zipFiles(t, files))
+	got := readAll(t, r, err)
+
+	expected := `## Sheet: Sales
+
+| Date | Amount | 1 |
+| --- | --- | --- |
+| 2024-01-01 | 1200 | TRUE |
+| Total | Nordic region |  |
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestExtractFromOds(t *testing.T) {
+	files := map[string]string{
+		"content.xml": `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">
+		<office:body><office:spreadsheet>
+			<table:table table:name="People">
+				<table:table-row><table:table-cell/><table:table-cell><text:p>Name</text:p></table:table-cell><table:table-cell table:number-columns-repeated="1020"/></table:table-row>
+				<table:table-row table:number-rows-repeated="2"><table:table-cell><text:p>x</text:p></table:table-cell><table:table-cell office:value-type="string"><text:p>Anna</text:p><office:annotation><text:p>comment</text:p></office:annotation></table:table-cell></table:table-row>
+				<table:table-row table:number-rows-repeated="1048573"><table:table-cell table:number-columns-repeated="1024"/></table:table-row>
+			</table:table>
+		</office:spreadsheet></office:body></office:document-content>`,
+	}
+
+	r, err := extractFromOds(zipFiles(t, files))
+	got := readAll(t, r, err)
+
+	expected := `## Sheet: People
+
+| A | Name |
+| --- | --- |
+| x | Anna |
+| x | Anna |
+`
+	if got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestRenderSheets_Truncated(t *testing.T) {
+	var s sheet
+	s.name = "Big"
+	wide := make([]string, maxSheetColumns)
+	for i := range wide {
+		wide[i] = "v"
+	}
+	s.addRow(wide, maxSheetColumns+6, maxSheetRows+100)
+
+	got := readAll(t, renderSheets([]sheet{s}), nil)
+	if strings.Count(got, "\n| v") != maxSheetRows+1 {
+		t.Errorf("Expected %d rows, got %d", maxSheetRows+1, strings.Count(got, "\n| v"))
+	}
+	if !strings.Contains(got, "_Showing the first 5000 of 5099 rows and 64 of 70 columns._") {
+		t.Errorf("Expected truncation note, got: %s", got[len(got)-200:])
+	}
+}
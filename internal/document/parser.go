@@ -7,122 +7,155 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"mime"
 	"os/exec"
-	"path/filepath"
-	"strings"
 	"time"
-)
-
-func ConvertToMarkdown(log *slog.Logger, reader io.Reader, contentType, contentDisposition string) (io.Reader, error) {
-	var err error
-	// Dispatch based on the detected content type
-	contentType, _, _ = strings.Cut(contentType, ";") // Remove charset if present
-
-	switch contentType {
-	case "application/json": // This is probably not something we want to do in general
-		log.Debug("content detected as JSON.")
-		return extractFromJson(reader)
-	case "text/plain":
-		log.Debug("content detected as plain text.")
-		return reader, nil
-	case "text/html":
-		log.Debug("content detected as html.")
-		return extractFromHTML(reader)
-	case "application/pdf":
-		log.Debug("content detected as PDF.")
-		return extractFromPDF(reader)
-	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
-		log.Debug("content detected as docx.")
-		return extractFromDocx(reader)
-	case "application/vnd.oasis.opendocument.text":
-		log.Debug("content detected as odt.")
-		return extractFromOdt(reader)
-	}
 
-	_, params, err := mime.ParseMediaType(contentDisposition)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing content disposition: %v", err)
-	}
-	filename, ok := params["filename"]
-	if !ok {
-		return nil, fmt.Errorf("could not determine file extension from content disposition")
-	}
-	extension := filepath.Ext(filename)
-
-	switch extension {
-	case ".md":
-		log.Debug("content detected as markdown from extension.")
-		return reader, nil
-	case ".txt", ".text":
-		log.Debug("content detected as plain text from extension.")
-		return io.MultiReader(bytes.NewBufferString("```text\n"), reader, bytes.NewBufferString("\n```")), nil
-
-	case ".json":
-		log.Debug("content detected as JSON from extension.")
-		return extractFromJson(reader)
-	case ".odt":
-		log.Debug("content detected as odt from extension.")
-		return extractFromOdt(reader)
-	case ".docx":
-		log.Debug("content detected as docx from extension.")
-		return extractFromDocx(reader)
-	case ".pdf":
-		log.Debug("content detected as pdf from extension.")
-		return extractFromPDF(reader)
-	}
+	"github.com/modfin/ragnar"
+)
 
-	return nil, fmt.Errorf("unsupported file: %w", err) // return err
+// Attachment is a file embedded in a converted file, such as an email attachment, which is ingested as a document of
+// its own
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
 }
 
-func extractFromPDF(in io.Reader) (io.Reader, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) // 30 sec time limit
+// Conversion is the markdown of a converted file, along with the headers read from the file and the files embedded
+// in it
+type Conversion struct {
+	Markdown    io.Reader
+	Headers     map[string]string
+	Attachments []Attachment
+}
 
-	// The command is `pdftotext [options] [PDF-file] [text-file]`: Using `-` tells it to read from stdin/write to stdout
-	cmd := exec.CommandContext(ctx, "pdftotext", "-", "-")
+// defaultConverterTimeout is the timeout of the external tools run by the built in converters, unless configured
+const defaultConverterTimeout = 30 * time.Second
 
-	// Set up buffers to capture the command's output and errors
-	var stderr bytes.Buffer
+// builtin is the registry of the built in converters, which ConvertToMarkdown and Convert convert with
+var builtin = newBuiltinRegistry(Config{})
 
-	out, stdout := io.Pipe()
+// ConvertToMarkdown converts the uploaded file to markdown by its content type, or by the extension of the file name in
+// the content disposition. settings are the settings of the tub the file is uploaded to. The headers and attachments
+// of the file are left out, see Convert.
+func ConvertToMarkdown(log *slog.Logger, reader io.Reader, contentType, contentDisposition string, settings ragnar.TubSettings) (io.Reader, error) {
+	c, err := Convert(log, reader, contentType, contentDisposition, settings)
+	return c.Markdown, err
+}
 
-	cmd.Stdout = stdout
-	cmd.Stderr = &stderr
+// Convert converts the uploaded file like ConvertToMarkdown, and returns the headers read from emails, such as
+// subject and from, and their attachments. Only the built in converters are used, see Registry.Convert.
+func Convert(log *slog.Logger, reader io.Reader, contentType, contentDisposition string, settings ragnar.TubSettings) (Conversion, error) {
+	return builtin.Convert(log, reader, contentType, contentDisposition, settings)
+}
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		defer cancel()
-		return nil, fmt.Errorf("error creating stdin pipe: %v", err)
+// newBuiltinRegistry returns a registry of the converters of ragnar, see Config for the timeouts
+func newBuiltinRegistry(cfg Config) *Registry {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultConverterTimeout
 	}
-
-	if err := cmd.Start(); err != nil {
-		defer cancel()
-		defer stdout.Close()
-		return nil, fmt.Errorf("failed to start pdftotext command: %w", err)
+	ocrTimeout := cfg.OcrTimeout
+	if ocrTimeout <= 0 {
+		ocrTimeout = defaultOcrTimeout
 	}
-
-	go func() {
-		defer stdin.Close()
-		_, err := io.Copy(stdin, in)
-		if err != nil {
-			stdout.CloseWithError(fmt.Errorf("error copying to stdin: %v", err))
-			return
+	csvRecords := func(o ConvertOptions) bool {
+		return o.Settings.CsvFormat != nil && *o.Settings.CsvFormat == "records"
+	}
+	readability := func(o ConvertOptions) bool {
+		return o.Settings.HtmlReadability == nil || *o.Settings.HtmlReadability
+	}
+	ocrLanguages := func(o ConvertOptions) string {
+		if o.Settings.OcrLanguages != nil {
+			return *o.Settings.OcrLanguages
 		}
-	}()
+		return "eng"
+	}
+	asIs := func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+		return Conversion{Markdown: in}, nil
+	}
 
-	go func() {
-		defer cancel()
-		defer stdout.Close()
+	builtins := []struct {
+		name         string
+		contentTypes []string
+		extensions   []string
+		convert      func(in io.Reader, o ConvertOptions) (Conversion, error)
+	}{
+		{"json", []string{"application/json"}, []string{".json"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			// This is probably not something we want to do in general
+			return markdown(extractFromJson(in))
+		}},
+		{"text", []string{"text/plain"}, nil, asIs},
+		{"text-file", nil, []string{".txt", ".text"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return Conversion{Markdown: io.MultiReader(bytes.NewBufferString("```text\n"), in, bytes.NewBufferString("\n```"))}, nil
+		}},
+		{"markdown", []string{"text/markdown"}, []string{".md"}, asIs},
+		{"html", []string{"text/html"}, []string{".html", ".htm"}, func(in io.Reader, o ConvertOptions) (Conversion, error) {
+			return extractFromWebPage(in, readability(o), timeout)
+		}},
+		{"pdf", []string{"application/pdf"}, []string{".pdf"}, func(in io.Reader, o ConvertOptions) (Conversion, error) {
+			return markdown(extractFromPDF(o.Log, in, ocrLanguages(o), timeout, ocrTimeout))
+		}},
+		{"image", []string{"image/png", "image/jpeg"}, []string{".png", ".jpg", ".jpeg"}, func(in io.Reader, o ConvertOptions) (Conversion, error) {
+			return markdown(extractFromImage(in, ocrLanguages(o), timeout))
+		}},
+		{"docx", []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"}, []string{".docx"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return markdown(extractWithPandoc(in, "docx", timeout))
+		}},
+		{"odt", []string{"application/vnd.oasis.opendocument.text"}, []string{".odt"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return markdown(extractWithPandoc(in, "odt", timeout))
+		}},
+		{"eml", []string{"message/rfc822"}, []string{".eml"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return extractFromEmail(in, parseEml, timeout)
+		}},
+		{"msg", []string{"application/vnd.ms-outlook"}, []string{".msg"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return extractFromEmail(in, parseMsg, timeout)
+		}},
+		{"csv", []string{"text/csv", "application/csv"}, []string{".csv"}, func(in io.Reader, o ConvertOptions) (Conversion, error) {
+			return markdown(extractFromCSV(in, ',', csvRecords(o)))
+		}},
+		{"tsv", []string{"text/tab-separated-values"}, []string{".tsv", ".tab"}, func(in io.Reader, o ConvertOptions) (Conversion, error) {
+			return markdown(extractFromCSV(in, '\t', csvRecords(o)))
+		}},
+		{"pptx", []string{"application/vnd.openxmlformats-officedocument.presentationml.presentation"}, []string{".pptx"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return markdown(extractFromPptx(in))
+		}},
+		{"odp", []string{"application/vnd.oasis.opendocument.presentation"}, []string{".odp"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return markdown(extractFromOdp(in))
+		}},
+		{"xlsx", []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}, []string{".xlsx"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return markdown(extractFromXlsx(in))
+		}},
+		{"ods", []string{"application/vnd.oasis.opendocument.spreadsheet"}, []string{".ods"}, func(in io.Reader, _ ConvertOptions) (Conversion, error) {
+			return markdown(extractFromOds(in))
+		}},
+	}
 
-		err := cmd.Wait()
+	r := &Registry{converters: map[string]Converter{}, contentTypes: map[string][]string{}, extensions: map[string][]string{}}
+	for _, b := range builtins {
+		err := r.Register(ConverterFunc(b.name, b.convert), b.contentTypes, b.extensions)
 		if err != nil {
-			stdout.CloseWithError(fmt.Errorf("pdftotext execution error: %w, stderr: %s", err, stderr.String()))
-			return
+			panic(err) // the built in converters are registered once each
 		}
+	}
+	return r
+}
 
-	}()
+func markdown(md io.Reader, err error) (Conversion, error) {
+	return Conversion{Markdown: md}, err
+}
 
-	return out, nil
+// extractFromEmail converts an email to markdown, with its subject, from, to, cc and date as headers
+func extractFromEmail(in io.Reader, parse func(io.Reader) (email, error), timeout time.Duration) (Conversion, error) {
+	m, err := parse(in)
+	if err != nil {
+		return Conversion{}, err
+	}
+	md, err := m.markdown(timeout)
+	if err != nil {
+		return Conversion{}, err
+	}
+	return Conversion{Markdown: md, Headers: m.headers(), Attachments: m.attachments}, nil
 }
 
 func extractFromJson(reader io.Reader) (io.Reader, error) {
@@ -139,21 +172,12 @@ func extractFromJson(reader io.Reader) (io.Reader, error) {
 }
 
 // Separate function to allow easy implementation swapping later
-func extractFromDocx(data io.Reader) (io.Reader, error) {
-	return extractWithPandoc(data, "docx")
-}
-
-// Separate function to allow easy implementation swapping later
-func extractFromHTML(data io.Reader) (io.Reader, error) {
-	return extractWithPandoc(data, "html")
-}
-
-func extractFromOdt(data io.Reader) (io.Reader, error) {
-	return extractWithPandoc(data, "odt")
+func extractFromHTML(data io.Reader, timeout time.Duration) (io.Reader, error) {
+	return extractWithPandoc(data, "html", timeout)
 }
 
-func extractWithPandoc(in io.Reader, fromFormat string) (io.Reader, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func extractWithPandoc(in io.Reader, fromFormat string, timeout time.Duration) (io.Reader, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 
 	args := []string{"--to", "markdown"}
 	if fromFormat != "" {
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/modfin/ragnar"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,7 +19,7 @@ func TestConvertToMarkdown_JSON(t *testing.T) {
 	jsonInput := `{"name": "test", "value": 123}`
 	reader := strings.NewReader(jsonInput)
 
-	result, err := ConvertToMarkdown(logger, reader, "application/json", "")
+	result, err := ConvertToMarkdown(logger, reader, "application/json", "", ragnar.TubSettings{})
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -40,7 +41,7 @@ func TestConvertToMarkdown_PlainText(t *testing.T) {
 	textInput := "This is plain text content"
 	reader := strings.NewReader(textInput)
 
-	result, err := ConvertToMarkdown(logger, reader, "text/plain", "")
+	result, err := ConvertToMarkdown(logger, reader, "text/plain", "", ragnar.TubSettings{})
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -71,7 +72,7 @@ func TestConvertToMarkdown_HTML(t *testing.T) {
 
 	reader := strings.NewReader(htmlInput)
 
-	result, err := ConvertToMarkdown(logger, reader, "text/html", "")
+	result, err := ConvertToMarkdown(logger, reader, "text/html", "", ragnar.TubSettings{})
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -128,7 +129,7 @@ func TestConvertToMarkdown_HTMLSimple(t *testing.T) {
 	htmlInput := `<h1>Hello World</h1><p>This is a test.</p>`
 	reader := strings.NewReader(htmlInput)
 
-	result, err := ConvertToMarkdown(logger, reader, "text/html", "")
+	result, err := ConvertToMarkdown(logger, reader, "text/html", "", ragnar.TubSettings{})
 	if err != nil {
 		t.Fatalf("Expected no error, got: %v", err)
 	}
@@ -201,7 +202,7 @@ func TestConvertToMarkdown_FileExtensions(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			reader := strings.NewReader(tt.input)
 
-			result, err := ConvertToMarkdown(logger, reader, tt.contentType, tt.contentDisposition)
+			result, err := ConvertToMarkdown(logger, reader, tt.contentType, tt.contentDisposition, ragnar.TubSettings{})
 			if err != nil {
 				t.Fatalf("Expected no error, got: %v", err)
 			}
@@ -226,7 +227,7 @@ func TestConvertToMarkdown_UnsupportedType(t *testing.T) {
 
 	reader := strings.NewReader("some content")
 
-	_, err := ConvertToMarkdown(logger, reader, "application/unknown", "")
+	_, err := ConvertToMarkdown(logger, reader, "application/unknown", "", ragnar.TubSettings{})
 	if err == nil {
 		t.Error("Expected error for unsupported content type")
 	}
@@ -308,7 +309,7 @@ func TestExtractWithPandoc_HTMLFormats(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			reader := strings.NewReader(tt.input)
-			result, err := extractWithPandoc(reader, tt.format)
+			result, err := extractWithPandoc(reader, tt.format, defaultConverterTimeout)
 
 			if err != nil {
 				t.Fatalf("Expected no error, got: %v", err)
@@ -352,7 +353,7 @@ func TestExtractWithPandoc_Simple(t *testing.T) {
 	htmlInput := "<h1>Simple Test</h1>"
 	reader := strings.NewReader(htmlInput)
 
-	result, err := extractWithPandoc(reader, "html")
+	result, err := extractWithPandoc(reader, "html", defaultConverterTimeout)
 	if err != nil {
 		t.Fatalf("extractWithPandoc failed: %v", err)
 	}
@@ -401,7 +402,7 @@ func BenchmarkConvertToMarkdown_HTML(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		reader := strings.NewReader(htmlInput)
-		result, err := ConvertToMarkdown(logger, reader, "text/html", "")
+		result, err := ConvertToMarkdown(logger, reader, "text/html", "", ragnar.TubSettings{})
 		if err != nil {
 			b.Fatalf("Error in benchmark: %v", err)
 		}
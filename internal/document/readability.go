@@ -0,0 +1,332 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// minContentChars is the number of non-space characters the main content must have to be taken over the whole page
+const minContentChars = 140
+
+var (
+	// boilerplateTags are dropped from pages along with their content. Forms are kept, only their controls are
+	// dropped, as some sites, e.g. ASP.NET ones, wrap the whole page in a form
+	boilerplateTags = map[atom.Atom]bool{
+		atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Iframe: true,
+		atom.Svg: true, atom.Canvas: true, atom.Object: true, atom.Embed: true, atom.Link: true, atom.Meta: true,
+		atom.Nav: true, atom.Aside: true, atom.Footer: true, atom.Dialog: true,
+		atom.Button: true, atom.Input: true, atom.Select: true, atom.Textarea: true,
+	}
+	// boilerplateRoles are the ARIA roles of navigation, banners and the like
+	boilerplateRoles = map[string]bool{
+		"navigation": true, "banner": true, "contentinfo": true, "complementary": true, "search": true,
+		"dialog": true, "alertdialog": true, "menu": true, "menubar": true,
+	}
+	// boilerplateNames match the classes and ids of elements that are unlikely to be content, unless they match
+	// contentNames as well
+	boilerplateNames = regexp.MustCompile(`(?i)banner|breadcrumb|comment|consent|cookie|disqus|footer|gdpr|header|menu|modal|navbar|newsletter|pagination|pager|popup|promo|related|share|sidebar|social|sponsor|subscribe|toolbar`)
+	contentNames     = regexp.MustCompile(`(?i)article|body|content|entry|main|post|story|text`)
+	spaces           = regexp.MustCompile(`\s+`)
+)
+
+// extractFromWebPage converts an HTML page to markdown. With readability the main content of the page is extracted
+// first, dropping scripts, styles, navigation, banners and footers, and the title and description of the page are
+// returned as headers. timeout bounds pandoc.
+func extractFromWebPage(in io.Reader, readability bool, timeout time.Duration) (Conversion, error) {
+	if !readability {
+		return markdown(extractFromHTML(in, timeout))
+	}
+	content, headers, err := readableHTML(in)
+	if err != nil {
+		return Conversion{}, err
+	}
+	md, err := extractFromHTML(bytes.NewReader(content), timeout)
+	if err != nil {
+		return Conversion{}, err
+	}
+	return Conversion{Markdown: md, Headers: headers}, nil
+}
+
+// readableHTML returns the main content of an HTML page as HTML, along with the title and description of the page
+func readableHTML(in io.Reader) ([]byte, map[string]string, error) {
+	doc, err := html.Parse(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing html: %w", err)
+	}
+	headers := pageMeta(doc)
+
+	body := findElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body })
+	if body == nil {
+		body = doc
+	}
+	removeBoilerplate(body, false)
+
+	content := mainContent(body)
+	var buf bytes.Buffer
+	buf.WriteString("<html><body>")
+	if title := headers["title"]; title != "" && findElement(content, func(n *html.Node) bool { return n.DataAtom == atom.H1 }) == nil {
+		buf.WriteString("<h1>" + html.EscapeString(title) + "</h1>")
+	}
+	for c := content.FirstChild; c != nil; c = c.NextSibling {
+		err = html.Render(&buf, c)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error rendering html: %w", err)
+		}
+	}
+	buf.WriteString("</body></html>")
+	return buf.Bytes(), headers, nil
+}
+
+// pageMeta returns the title and description of the page, from its title and meta elements
+func pageMeta(doc *html.Node) map[string]string {
+	headers := map[string]string{}
+	set := func(key, val string) {
+		val = strings.TrimSpace(spaces.ReplaceAllString(val, " "))
+		if _, ok := headers[key]; !ok && val != "" {
+			headers[key] = val
+		}
+	}
+	walk(doc, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Title:
+			set("title", textContent(n))
+		case atom.Meta:
+			name := strings.ToLower(attr(n, "name") + attr(n, "property"))
+			switch name {
+			case "description", "og:description":
+				set("description", attr(n, "content"))
+			case "og:title":
+				set("title", attr(n, "content"))
+			}
+		case atom.Body:
+			return false
+		}
+		return true
+	})
+	return headers
+}
+
+// removeBoilerplate removes the elements of the node that are not content. Elements are not removed by their class
+// or id when they hold an article or the main content, which they may be a layout wrapper of.
+func removeBoilerplate(n *html.Node, inArticle bool) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c)
+		case c.Type != html.ElementNode:
+		case isBoilerplate(c, inArticle):
+			n.RemoveChild(c)
+		default:
+			removeBoilerplate(c, inArticle || isMainContent(c))
+		}
+		c = next
+	}
+}
+
+func isBoilerplate(n *html.Node, inArticle bool) bool {
+	if boilerplateTags[n.DataAtom] || boilerplateRoles[strings.ToLower(attr(n, "role"))] {
+		return true
+	}
+	// the header of an article holds its title, the header of the page its navigation
+	if n.DataAtom == atom.Header && !inArticle {
+		return true
+	}
+	if hasAttr(n, "hidden") || attr(n, "aria-hidden") == "true" || strings.Contains(strings.ReplaceAll(attr(n, "style"), " ", ""), "display:none") {
+		return true
+	}
+	names := attr(n, "class") + " " + attr(n, "id")
+	if boilerplateNames.MatchString(names) && !contentNames.MatchString(names) {
+		return findElement(n, isMainContent) == nil
+	}
+	return false
+}
+
+func isMainContent(n *html.Node) bool {
+	return n.DataAtom == atom.Article || n.DataAtom == atom.Main || attr(n, "role") == "main"
+}
+
+// mainContent returns the element holding the main content of the page: the largest article or main element, or
+// otherwise the element scored highest by the paragraphs it holds, along with its siblings that are content as well,
+// as in Readability. The body is returned when no element holds enough text.
+func mainContent(body *html.Node) *html.Node {
+	var best *html.Node
+	bestLen := 0
+	walk(body, func(n *html.Node) bool {
+		if n.Type == html.ElementNode && isMainContent(n) {
+			if l := textLen(n); l > bestLen {
+				best, bestLen = n, l
+			}
+		}
+		return true
+	})
+	if bestLen >= minContentChars {
+		return best
+	}
+
+	// paragraphs score their parents by their length and commas, and their grandparents by half of that
+	scores := map[*html.Node]float64{}
+	walk(body, func(n *html.Node) bool {
+		if n.DataAtom != atom.P && n.DataAtom != atom.Pre && n.DataAtom != atom.Blockquote {
+			return true
+		}
+		text := textContent(n)
+		l := textLen(n)
+		if l < 25 {
+			return false
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(l)/100, 3)
+		if p := n.Parent; p != nil && p != body.Parent {
+			scores[p] += score
+			if gp := p.Parent; gp != nil && gp != body.Parent {
+				scores[gp] += score / 2
+			}
+		}
+		return false
+	})
+
+	best = nil
+	bestScore := 0.0
+	walk(body, func(n *html.Node) bool {
+		if s, ok := scores[n]; ok {
+			// lists of links, such as menus, are not content
+			if s *= 1 - linkDensity(n); s > bestScore {
+				best, bestScore = n, s
+			}
+		}
+		return true
+	})
+	if best == nil || textLen(best) < minContentChars {
+		return body
+	}
+	return withContentSiblings(best, bestScore, scores)
+}
+
+// withContentSiblings returns the best scored element together with its siblings that score at least a fifth of it,
+// or are paragraphs of their own, in a div of their own. The element itself is returned when no sibling qualifies.
+func withContentSiblings(best *html.Node, bestScore float64, scores map[*html.Node]float64) *html.Node {
+	if best.Parent == nil || best.DataAtom == atom.Body {
+		return best
+	}
+	threshold := max(10, bestScore*0.2)
+	var content []*html.Node
+	for s := best.Parent.FirstChild; s != nil; s = s.NextSibling {
+		switch {
+		case s == best:
+			content = append(content, s)
+		case s.Type != html.ElementNode:
+		case scores[s]*(1-linkDensity(s)) >= threshold || isContentParagraph(s):
+			content = append(content, s)
+		}
+	}
+	if len(content) == 1 {
+		return best
+	}
+
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range content {
+		n.Parent.RemoveChild(n)
+		div.AppendChild(n)
+	}
+	return div
+}
+
+// isContentParagraph reports whether the node is a paragraph of text, either a long one with few links or a sentence
+// without links
+func isContentParagraph(n *html.Node) bool {
+	if n.DataAtom != atom.P {
+		return false
+	}
+	l, density := textLen(n), linkDensity(n)
+	if l > 80 {
+		return density < 0.25
+	}
+	return l > 0 && density == 0 && strings.HasSuffix(strings.TrimSpace(textContent(n)), ".")
+}
+
+// linkDensity is the share of the text of the node that is the text of links
+func linkDensity(n *html.Node) float64 {
+	total := textLen(n)
+	if total == 0 {
+		return 0
+	}
+	links := 0
+	walk(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.A {
+			links += textLen(c)
+			return false
+		}
+		return true
+	})
+	return float64(links) / float64(total)
+}
+
+// walk calls fn for the node and its descendants in document order, the children of a node are skipped when fn
+// returns false for it
+func walk(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) bool {
+		if found == nil && c != n && c.Type == html.ElementNode && match(c) {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			sb.WriteString(c.Data)
+		}
+		return true
+	})
+	return sb.String()
+}
+
+// textLen counts the non-space characters of the text of the node
+func textLen(n *html.Node) int {
+	count := 0
+	for _, r := range textContent(n) {
+		if !unicode.IsSpace(r) {
+			count++
+		}
+	}
+	return count
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package document
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Quarterly   results</title>
+  <meta name="description" content="Revenue grew in the first quarter.">
+  <style>body { color: red }</style>
+  <script>var tracking = true;</script>
+</head>
+<body>
+  <header><a href="/">Home</a> <a href="/news">News</a></header>
+  <nav><ul><li><a href="/a">Menu item</a></li></ul></nav>
+  <div id="cookie-banner">We use cookies. <button>Accept</button></div>
+  <div class="layout sidebar-left">
+    <aside>Popular posts</aside>
+    <article>
+      <header><h1>Results for Q1</h1></header>
+      <p>Revenue grew by twelve percent in the first quarter, driven by subscriptions, services and a strong launch.</p>
+      <p>Costs fell, as the move to the new offices was completed ahead of plan, and hiring slowed.</p>
+      <div class="share-buttons">Share on social media</div>
+    </article>
+  </div>
+  <footer>Copyright 2024</footer>
+</body>
+</html>`
+
+func TestReadableHTML_Article(t *testing.T) {
+	content, headers, err := readableHTML(strings.NewReader(articlePage))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"title": "Quarterly results", "description": "Revenue grew in the first quarter."}, headers)
+
+	out := string(content)
+	for _, kept := range []string{"Results for Q1", "Revenue grew by twelve percent", "Costs fell"} {
+		assert.Contains(t, out, kept)
+	}
+	for _, dropped := range []string{"Home", "Menu item", "cookies", "Popular posts", "Share on social", "Copyright", "tracking", "color: red"} {
+		assert.NotContains(t, out, dropped)
+	}
+	// the article has a heading of its own, the title is not added
+	assert.NotContains(t, out, "Quarterly")
+}
+
+func TestReadableHTML_ScoredContent(t *testing.T) {
+	page := `<html><head><meta property="og:title" content="Release notes"></head><body>
+<div class="menu"><a href="/1">One</a> <a href="/2">Two</a> <a href="/3">Three</a></div>
+<div id="links"><p><a href="/x">A long list of links that is not content at all, no, not at all</a></p></div>
+<div id="story">
+  <p>The release adds support for scanned documents, which are read with OCR, page by page.</p>
+  <p>Web pages are cleaned up before they are converted, leaving navigation, banners and footers out.</p>
+</div>
+<div class="newsletter">Subscribe to our newsletter</div>
+</body></html>`
+	content, headers, err := readableHTML(strings.NewReader(page))
+	assert.NoError(t, err)
+	assert.Equal(t, "Release notes", headers["title"])
+
+	out := string(content)
+	assert.True(t, strings.HasPrefix(out, "<html><body><h1>Release notes</h1>"), out)
+	assert.Contains(t, out, "scanned documents")
+	assert.Contains(t, out, "navigation, banners and footers")
+	assert.NotContains(t, out, "A long list of links")
+	assert.NotContains(t, out, "Subscribe")
+	assert.NotContains(t, out, "Three")
+}
+
+func TestReadableHTML_ShortPageKept(t *testing.T) {
+	content, headers, err := readableHTML(strings.NewReader(`<h1>Hello World</h1><p>This is a test.</p>`))
+	assert.NoError(t, err)
+	assert.Empty(t, headers)
+	assert.Equal(t, "<html><body><h1>Hello World</h1><p>This is a test.</p></body></html>", string(content))
+}
+
+func TestReadableHTML_PageInForm(t *testing.T) {
+	page := `<html><body><form id="aspnetForm" method="post"><input type="hidden" name="__VIEWSTATE" value="x">
+<div class="menu"><a href="/1">One</a> <a href="/2">Two</a></div>
+<div id="content">
+  <p>The annual report covers the results of the year, the outlook and the proposed dividend, in that order.</p>
+  <p>Revenue grew in all regions, most of all in the Nordics, where two new offices were opened.</p>
+</div>
+<input type="submit" value="Search"></form></body></html>`
+	content, _, err := readableHTML(strings.NewReader(page))
+	assert.NoError(t, err)
+
+	out := string(content)
+	assert.Contains(t, out, "The annual report covers")
+	assert.Contains(t, out, "Revenue grew in all regions")
+	assert.NotContains(t, out, "VIEWSTATE")
+	assert.NotContains(t, out, "Search")
+}
+
+func TestReadableHTML_ContentSiblings(t *testing.T) {
+	page := `<html><body>
+<div class="menu"><a href="/1">One</a> <a href="/2">Two</a> <a href="/3">Three</a></div>
+<div id="intro">
+  <p>The release adds support for scanned documents, which are read with OCR, page by page, in many languages.</p>
+  <p>Web pages are cleaned up before they are converted, leaving navigation, banners and footers out of it.</p>
+</div>
+<p>Archives are expanded into one document per file, and the files of an archive are typed by their content too.</p>
+<div id="links"><p><a href="/x">A long list of links that is not content at all, no, not at all, really</a></p></div>
+</body></html>`
+	content, _, err := readableHTML(strings.NewReader(page))
+	assert.NoError(t, err)
+
+	out := string(content)
+	assert.Contains(t, out, "scanned documents")
+	assert.Contains(t, out, "Archives are expanded")
+	assert.NotContains(t, out, "A long list of links")
+	assert.NotContains(t, out, "Three")
+}
@@ -0,0 +1,526 @@
+package document
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sheets are cut to this many rows below the header row and this many columns, a note below the table tells
+	// how much was left out
+	maxSheetRows    = 5000
+	maxSheetColumns = 64
+)
+
+// sheet is a spreadsheet sheet, rendered as one markdown table with the first non-empty row as header
+type sheet struct {
+	name string
+	// rows are the non-empty rows within the limits
+	rows [][]string
+	// totalRows and totalColumns count the whole sheet
+	totalRows    int
+	totalColumns int
+}
+
+// addRow adds the row times, as spreadsheets store runs of equal rows once. width is the number of columns the row
+// spans, which may exceed the cells kept in row.
+func (s *sheet) addRow(row []string, width int, times int) {
+	for len(row) > 0 && strings.TrimSpace(row[len(row)-1]) == "" {
+		row = row[:len(row)-1]
+	}
+	if len(row) == 0 {
+		return
+	}
+	s.totalRows += times
+	s.totalColumns = max(s.totalColumns, width, len(row))
+	for i := 0; i < times && len(s.rows) <= maxSheetRows; i++ {
+		s.rows = append(s.rows, row)
+	}
+}
+
+func renderSheets(sheets []sheet) io.Reader {
+	var sb strings.Builder
+	for _, s := range sheets {
+		if len(s.rows) == 0 {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("## Sheet: " + s.name + "\n\n")
+
+		// leave out columns that are empty in every row, e.g. spacing columns
+		var columns []int
+		for col := 0; col < maxSheetColumns; col++ {
+			for _, row := range s.rows {
+				if col < len(row) && strings.TrimSpace(row[col]) != "" {
+					columns = append(columns, col)
+					break
+				}
+			}
+		}
+		table := make([][]string, len(s.rows))
+		for i, row := range s.rows {
+			table[i] = make([]string, len(columns))
+			for j, col := range columns {
+				if col < len(row) {
+					table[i][j] = row[col]
+				}
+				if i == 0 && strings.TrimSpace(table[i][j]) == "" {
+					table[i][j] = columnName(col)
+				}
+			}
+		}
+		sb.WriteString(markdownTable(table))
+
+		if s.totalRows > len(s.rows) || s.totalColumns > maxSheetColumns {
+			sb.WriteString(fmt.Sprintf("\n_Showing the first %d of %d rows and %d of %d columns._\n",
+				len(s.rows)-1, s.totalRows-1, min(s.totalColumns, maxSheetColumns), s.totalColumns))
+		}
+	}
+	return strings.NewReader(sb.String())
+}
+
+// columnName returns the spreadsheet name of the zero based column index, e.g. A, Z and AA
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// columnIndex returns the zero based column index of a cell reference such as B12, or -1
+func columnIndex(ref string) int {
+	index := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		index = index*26 + int(r-'A') + 1
+		n++
+	}
+	if n == 0 {
+		return -1
+	}
+	return index - 1
+}
+
+const (
+	relTypeSharedStrings = "/sharedStrings"
+	relTypeStyles        = "/styles"
+)
+
+// extractFromXlsx converts an Excel workbook to one markdown table per sheet
+func extractFromXlsx(in io.Reader) (io.Reader, error) {
+	zr, err := openZip(in)
+	if err != nil {
+		return nil, err
+	}
+	var workbook struct {
+		Properties struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name  string `xml:"name,attr"`
+			RelId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	err = decodeZipXML(zr, "xl/workbook.xml", &workbook)
+	if err != nil {
+		return nil, err
+	}
+	date1904, _ := strconv.ParseBool(workbook.Properties.Date1904)
+
+	rels, err := readRelationships(zr, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	var sharedStrings []string
+	var dateStyles []bool
+	for _, rel := range rels {
+		targets[rel.Id] = rel.Target
+		switch {
+		case strings.HasSuffix(rel.Type, relTypeSharedStrings):
+			sharedStrings, err = readSharedStrings(zr, rel.Target)
+		case strings.HasSuffix(rel.Type, relTypeStyles):
+			dateStyles, err = readDateStyles(zr, rel.Target)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sheets []sheet
+	for _, ws := range workbook.Sheets {
+		name, ok := targets[ws.RelId]
+		if !ok {
+			return nil, fmt.Errorf("sheet relationship %s not found", ws.RelId)
+		}
+		s, err := readXlsxSheet(zr, name, sharedStrings, dateStyles, date1904)
+		if err != nil {
+			return nil, err
+		}
+		s.name = ws.Name
+		sheets = append(sheets, s)
+	}
+	return renderSheets(sheets), nil
+}
+
+func readSharedStrings(zr *zip.Reader, name string) ([]string, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer f.Close()
+
+	var result []string
+	var sb strings.Builder
+	var inText bool
+	var skipDepth int
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", name, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			switch t.Name.Local {
+			case "si":
+				sb.Reset()
+			case "t":
+				inText = true
+			case "rPh":
+				// phonetic guides of east asian text
+				skipDepth = 1
+			}
+		case xml.CharData:
+			if inText && skipDepth == 0 {
+				sb.Write(t)
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "si":
+				result = append(result, sb.String())
+			}
+		}
+	}
+	return result, nil
+}
+
+// readDateStyles reports for each cell style index whether its number format is a date or time
+func readDateStyles(zr *zip.Reader, name string) ([]bool, error) {
+	var styles struct {
+		NumFmts []struct {
+			Id   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtId int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	err := decodeZipXML(zr, name, &styles)
+	if err != nil {
+		return nil, err
+	}
+	custom := map[int]string{}
+	for _, f := range styles.NumFmts {
+		custom[f.Id] = f.Code
+	}
+	result := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		if code, ok := custom[xf.NumFmtId]; ok {
+			result[i] = isDateFormatCode(code)
+			continue
+		}
+		// built in date and time formats
+		result[i] = (xf.NumFmtId >= 14 && xf.NumFmtId <= 22) || (xf.NumFmtId >= 45 && xf.NumFmtId <= 47)
+	}
+	return result, nil
+}
+
+var formatLiteralRegExp = regexp.MustCompile(`"[^"]*"|\[[^\]]*\]|\\.`)
+
+// isDateFormatCode reports whether a custom number format such as yyyy-mm-dd formats dates or times
+func isDateFormatCode(code string) bool {
+	code = strings.ToLower(formatLiteralRegExp.ReplaceAllString(code, ""))
+	return strings.ContainsAny(code, "ydhs")
+}
+
+func readXlsxSheet(zr *zip.Reader, name string, sharedStrings []string, dateStyles []bool, date1904 bool) (sheet, error) {
+	var s sheet
+	f, err := zr.Open(name)
+	if err != nil {
+		return s, fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer f.Close()
+
+	var row []string
+	// next is the column after the last cell read, cells without a reference follow it
+	var next, width int
+	var col, style int
+	var typ string
+	var value strings.Builder
+	var inValue, inInline bool
+	var skipDepth int
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s, fmt.Errorf("error decoding %s: %w", name, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			switch t.Name.Local {
+			case "row":
+				row = nil
+				next, width = 0, 0
+			case "c":
+				col = columnIndex(xmlAttr(t, "r"))
+				if col < 0 {
+					col = next
+				}
+				typ = xmlAttr(t, "t")
+				style, _ = strconv.Atoi(xmlAttr(t, "s"))
+				value.Reset()
+			case "v":
+				inValue = true
+			case "is":
+				inInline = true
+			case "rPh":
+				skipDepth = 1
+			}
+		case xml.CharData:
+			if (inValue || inInline) && skipDepth == 0 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			switch t.Name.Local {
+			case "v":
+				inValue = false
+			case "is":
+				inInline = false
+			case "c":
+				text := xlsxCellText(value.String(), typ, style, sharedStrings, dateStyles, date1904)
+				next = col + 1
+				if text == "" {
+					continue
+				}
+				width = max(width, col+1)
+				if col >= maxSheetColumns {
+					continue
+				}
+				for len(row) <= col {
+					row = append(row, "")
+				}
+				row[col] = text
+			case "row":
+				s.addRow(row, width, 1)
+			}
+		}
+	}
+	return s, nil
+}
+
+func xlsxCellText(value, typ string, style int, sharedStrings []string, dateStyles []bool, date1904 bool) string {
+	switch typ {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || i < 0 || i >= len(sharedStrings) {
+			return ""
+		}
+		return sharedStrings[i]
+	case "b":
+		if strings.TrimSpace(value) == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "inlineStr", "str", "e":
+		return value
+	}
+	if style >= 0 && style < len(dateStyles) && dateStyles[style] {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil {
+			return excelDate(f, date1904)
+		}
+	}
+	return value
+}
+
+// excelDate formats a spreadsheet date serial, days since the epoch of the workbook with the time of day as fraction
+func excelDate(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days, fraction := math.Modf(serial)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(math.Round(fraction*86400)) * time.Second)
+	switch {
+	case fraction == 0:
+		return t.Format(time.DateOnly)
+	case days == 0:
+		return t.Format(time.TimeOnly)
+	}
+	return t.Format(time.DateTime)
+}
+
+// extractFromOds converts an OpenDocument spreadsheet to one markdown table per sheet, cells hold their displayed text
+func extractFromOds(in io.Reader) (io.Reader, error) {
+	zr, err := openZip(in)
+	if err != nil {
+		return nil, err
+	}
+	f, err := zr.Open("content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error opening content.xml: %w", err)
+	}
+	defer f.Close()
+
+	var sheets []sheet
+	var row []string
+	// pos is the column after the last cell read, counting repeated cells, width the column after the last
+	// non-empty one
+	var pos, width, rowRepeat, cellRepeat int
+	var cell strings.Builder
+	var inCell, inPara bool
+	var tableDepth, skipDepth int
+
+	repeated := func(t xml.StartElement, attr string) int {
+		n, err := strconv.Atoi(xmlAttr(t, attr))
+		if err != nil || n < 1 {
+			return 1
+		}
+		return n
+	}
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding content.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			switch t.Name.Local {
+			case "table":
+				tableDepth++
+				if tableDepth == 1 {
+					sheets = append(sheets, sheet{name: xmlAttr(t, "name")})
+				} else {
+					// sub tables in cells are read as the text of the cell
+					continue
+				}
+			case "table-row":
+				if tableDepth == 1 {
+					row = nil
+					pos, width = 0, 0
+					rowRepeat = repeated(t, "number-rows-repeated")
+				}
+			case "table-cell", "covered-table-cell":
+				if tableDepth == 1 {
+					cell.Reset()
+					inCell = true
+					cellRepeat = repeated(t, "number-columns-repeated")
+				}
+			case "p", "h":
+				if inCell && cell.Len() > 0 {
+					cell.WriteString(" ")
+				}
+				inPara = true
+			case "s":
+				if inPara {
+					cell.WriteString(strings.Repeat(" ", repeated(t, "c")))
+				}
+			case "tab", "line-break":
+				if inPara {
+					cell.WriteString(" ")
+				}
+			case "annotation":
+				skipDepth = 1
+			}
+		case xml.CharData:
+			if inCell && inPara && skipDepth == 0 {
+				cell.Write(t)
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			switch t.Name.Local {
+			case "table":
+				tableDepth--
+			case "p", "h":
+				inPara = false
+			case "table-cell", "covered-table-cell":
+				if tableDepth != 1 {
+					continue
+				}
+				inCell = false
+				pos += cellRepeat
+				text := strings.TrimSpace(cell.String())
+				if text == "" {
+					// empty runs are often repeated to the last column of the sheet
+					if len(row) < maxSheetColumns {
+						row = append(row, make([]string, min(cellRepeat, maxSheetColumns-len(row)))...)
+					}
+					continue
+				}
+				for i := 0; i < cellRepeat; i++ {
+					if len(row) < maxSheetColumns {
+						row = append(row, text)
+					}
+				}
+				width = pos
+			case "table-row":
+				if tableDepth == 1 && len(sheets) > 0 {
+					sheets[len(sheets)-1].addRow(row, width, rowRepeat)
+				}
+			}
+		}
+	}
+	return renderSheets(sheets), nil
+}
@@ -0,0 +1,376 @@
+package document
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// slide is a presentation slide, rendered as one markdown section with its speaker notes
+type slide struct {
+	title  string
+	blocks []slideBlock
+	notes  []string
+}
+
+type slideBlockKind int
+
+const (
+	slideParagraph slideBlockKind = iota
+	slideBullet
+	slideTable
+)
+
+type slideBlock struct {
+	kind  slideBlockKind
+	text  string
+	level int
+}
+
+func renderSlides(slides []slide) io.Reader {
+	var sb strings.Builder
+	for i, s := range slides {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fmt.Sprintf("## Slide %d", i+1))
+		if s.title != "" {
+			sb.WriteString(": " + s.title)
+		}
+		sb.WriteString("\n\n")
+
+		for j, block := range s.blocks {
+			// consecutive bullets form one list
+			if j > 0 && !(block.kind == slideBullet && s.blocks[j-1].kind == slideBullet) {
+				sb.WriteString("\n")
+			}
+			switch block.kind {
+			case slideBullet:
+				sb.WriteString(strings.Repeat("  ", block.level) + "- " + block.text + "\n")
+			case slideTable:
+				sb.WriteString(block.text)
+			default:
+				sb.WriteString(block.text + "\n")
+			}
+		}
+
+		if len(s.notes) > 0 {
+			if len(s.blocks) > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString("### Speaker Notes\n\n")
+			sb.WriteString(strings.Join(s.notes, "\n\n") + "\n")
+		}
+	}
+	return strings.NewReader(sb.String())
+}
+
+const relTypeNotesSlide = "/notesSlide"
+
+// extractFromPptx converts a PowerPoint presentation to one section per slide, in presentation order, with the slide
+// title as heading, the text of the slide and its speaker notes
+func extractFromPptx(in io.Reader) (io.Reader, error) {
+	zr, err := openZip(in)
+	if err != nil {
+		return nil, err
+	}
+	var presentation struct {
+		SlideIds []struct {
+			RelId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	err = decodeZipXML(zr, "ppt/presentation.xml", &presentation)
+	if err != nil {
+		return nil, err
+	}
+	rels, err := readRelationships(zr, "ppt/presentation.xml")
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, rel := range rels {
+		targets[rel.Id] = rel.Target
+	}
+
+	var slides []slide
+	for _, id := range presentation.SlideIds {
+		name, ok := targets[id.RelId]
+		if !ok {
+			return nil, fmt.Errorf("slide relationship %s not found", id.RelId)
+		}
+		shapes, err := readPptxShapes(zr, name)
+		if err != nil {
+			return nil, err
+		}
+		var s slide
+		for _, shape := range shapes {
+			switch {
+			case shape.placeholder == "title" || shape.placeholder == "ctrTitle":
+				var title []string
+				for _, block := range shape.blocks {
+					title = append(title, block.text)
+				}
+				s.title = strings.Join(title, " ")
+			case shape.isPlaceholder && (shape.placeholder == "" || shape.placeholder == "body"):
+				for _, block := range shape.blocks {
+					if block.kind == slideParagraph {
+						block.kind = slideBullet
+					}
+					s.blocks = append(s.blocks, block)
+				}
+			default:
+				s.blocks = append(s.blocks, shape.blocks...)
+			}
+		}
+
+		slideRels, err := readRelationships(zr, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range slideRels {
+			if !strings.HasSuffix(rel.Type, relTypeNotesSlide) {
+				continue
+			}
+			noteShapes, err := readPptxShapes(zr, rel.Target)
+			if err != nil {
+				return nil, err
+			}
+			for _, shape := range noteShapes {
+				if shape.placeholder != "body" {
+					continue // slide image, slide number and header placeholders
+				}
+				for _, block := range shape.blocks {
+					s.notes = append(s.notes, block.text)
+				}
+			}
+		}
+		slides = append(slides, s)
+	}
+	return renderSlides(slides), nil
+}
+
+// pptxShape is a shape of a slide holding text, placeholder is the type of placeholder the shape fills
+type pptxShape struct {
+	isPlaceholder bool
+	placeholder   string
+	blocks        []slideBlock
+}
+
+// readPptxShapes reads the paragraphs and tables of the shapes of a slide or notes slide part
+func readPptxShapes(zr *zip.Reader, name string) ([]pptxShape, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", name, err)
+	}
+	defer f.Close()
+
+	var shapes []pptxShape
+	var para strings.Builder
+	var level int
+	var inText bool
+	var table [][]string
+	var inTable bool
+
+	addBlock := func(block slideBlock) {
+		if len(shapes) == 0 {
+			shapes = append(shapes, pptxShape{})
+		}
+		shapes[len(shapes)-1].blocks = append(shapes[len(shapes)-1].blocks, block)
+	}
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", name, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "sp", "graphicFrame":
+				shapes = append(shapes, pptxShape{})
+			case "ph":
+				if len(shapes) > 0 {
+					shapes[len(shapes)-1].isPlaceholder = true
+					shapes[len(shapes)-1].placeholder = xmlAttr(t, "type")
+				}
+			case "tbl":
+				inTable = true
+				table = nil
+			case "tr":
+				table = append(table, nil)
+			case "tc":
+				if len(table) > 0 {
+					table[len(table)-1] = append(table[len(table)-1], "")
+				}
+			case "p":
+				para.Reset()
+				level = 0
+			case "pPr":
+				level, _ = strconv.Atoi(xmlAttr(t, "lvl"))
+			case "t":
+				inText = true
+			case "br":
+				para.WriteString(" ")
+			}
+		case xml.CharData:
+			if inText {
+				para.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(para.String())
+				if text == "" {
+					continue
+				}
+				if inTable {
+					if row := len(table) - 1; row >= 0 && len(table[row]) > 0 {
+						cell := len(table[row]) - 1
+						table[row][cell] = strings.TrimSpace(table[row][cell] + " " + text)
+					}
+					continue
+				}
+				addBlock(slideBlock{kind: slideParagraph, text: text, level: level})
+			case "tbl":
+				inTable = false
+				if len(table) > 0 {
+					addBlock(slideBlock{kind: slideTable, text: markdownTable(table)})
+				}
+			}
+		}
+	}
+	return shapes, nil
+}
+
+// extractFromOdp converts an OpenDocument presentation to one section per slide, with the slide title as heading,
+// the text of the slide and its speaker notes
+func extractFromOdp(in io.Reader) (io.Reader, error) {
+	zr, err := openZip(in)
+	if err != nil {
+		return nil, err
+	}
+	f, err := zr.Open("content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error opening content.xml: %w", err)
+	}
+	defer f.Close()
+
+	var slides []slide
+	var para strings.Builder
+	var inPara, inNotes bool
+	var frameClasses []string
+	var listDepth, skipDepth int
+	var table [][]string
+	var inTable bool
+
+	dec := xml.NewDecoder(f)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding content.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			switch t.Name.Local {
+			case "page":
+				slides = append(slides, slide{})
+			case "notes":
+				inNotes = true
+			case "frame":
+				frameClasses = append(frameClasses, xmlAttr(t, "class"))
+			case "list":
+				listDepth++
+			case "table":
+				inTable = true
+				table = nil
+			case "table-row":
+				table = append(table, nil)
+			case "table-cell", "covered-table-cell":
+				if len(table) > 0 {
+					table[len(table)-1] = append(table[len(table)-1], "")
+				}
+			case "p", "h":
+				para.Reset()
+				inPara = true
+			case "s":
+				n, err := strconv.Atoi(xmlAttr(t, "c"))
+				if err != nil || n < 1 {
+					n = 1
+				}
+				para.WriteString(strings.Repeat(" ", n))
+			case "tab", "line-break":
+				para.WriteString(" ")
+			case "annotation":
+				skipDepth = 1
+			}
+		case xml.CharData:
+			if inPara && skipDepth == 0 {
+				para.Write(t)
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			switch t.Name.Local {
+			case "notes":
+				inNotes = false
+			case "frame":
+				if len(frameClasses) > 0 {
+					frameClasses = frameClasses[:len(frameClasses)-1]
+				}
+			case "list":
+				listDepth--
+			case "table":
+				inTable = false
+				if len(table) > 0 && len(slides) > 0 {
+					s := &slides[len(slides)-1]
+					s.blocks = append(s.blocks, slideBlock{kind: slideTable, text: markdownTable(table)})
+				}
+			case "p", "h":
+				inPara = false
+				text := strings.TrimSpace(para.String())
+				if text == "" || len(slides) == 0 {
+					continue
+				}
+				s := &slides[len(slides)-1]
+				class := ""
+				if len(frameClasses) > 0 {
+					class = frameClasses[len(frameClasses)-1]
+				}
+				switch {
+				case inTable:
+					if row := len(table) - 1; row >= 0 && len(table[row]) > 0 {
+						cell := len(table[row]) - 1
+						table[row][cell] = strings.TrimSpace(table[row][cell] + " " + text)
+					}
+				case inNotes:
+					s.notes = append(s.notes, text)
+				case class == "title":
+					s.title = strings.TrimSpace(s.title + " " + text)
+				case listDepth > 0:
+					s.blocks = append(s.blocks, slideBlock{kind: slideBullet, text: text, level: listDepth - 1})
+				default:
+					s.blocks = append(s.blocks, slideBlock{kind: slideParagraph, text: text})
+				}
+			}
+		}
+	}
+	return renderSlides(slides), nil
+}
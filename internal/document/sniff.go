@@ -0,0 +1,91 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sniff detects the content type of a file from its content: PDF, the ZIP based Office and OpenDocument formats,
+// Outlook messages, PNG and JPEG images, HTML, JSON and UTF-8 text. Other ZIP files are application/zip, and ""
+// is returned when the content is not recognized.
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return sniffZip(data)
+	case bytes.HasPrefix(data, cfbSignature):
+		return sniffCompoundFile(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	}
+
+	text := bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(text) {
+		return ""
+	}
+	trimmed := bytes.TrimSpace(text)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json"
+	}
+	// the html signatures of DetectContentType, such as <!DOCTYPE HTML and <body, are looked for in the first 512 bytes
+	if strings.HasPrefix(http.DetectContentType(trimmed), "text/html") {
+		return "text/html"
+	}
+	if bytes.ContainsFunc(text, func(r rune) bool { return r < ' ' && r != '\n' && r != '\r' && r != '\t' && r != '\f' }) {
+		return ""
+	}
+	return "text/plain"
+}
+
+// sniffZip tells the Office and OpenDocument formats from other ZIP files by the files they hold
+func sniffZip(data []byte) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "application/zip"
+	}
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "mimetype":
+			// OpenDocument files start with a mimetype file holding their content type
+			rc, err := f.Open()
+			if err != nil {
+				continue
+			}
+			b, _ := io.ReadAll(io.LimitReader(rc, 128))
+			rc.Close()
+			if contentType := strings.TrimSpace(string(b)); strings.HasPrefix(contentType, "application/vnd.oasis.opendocument.") {
+				return contentType
+			}
+		case strings.HasPrefix(f.Name, "word/"):
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		case strings.HasPrefix(f.Name, "xl/"):
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		}
+	}
+	return "application/zip"
+}
+
+// sniffCompoundFile tells Outlook messages from other Compound File Binary files, such as .doc and .xls, by their
+// property streams
+func sniffCompoundFile(data []byte) string {
+	cf, err := openCompoundFile(data)
+	if err != nil {
+		return ""
+	}
+	for name := range cf.children(0) {
+		if strings.HasPrefix(name, "__substg1.0_") {
+			return "application/vnd.ms-outlook"
+		}
+	}
+	return ""
+}
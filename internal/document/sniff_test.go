@@ -0,0 +1,62 @@
+package document
+
+import (
+	"io"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	zipped := func(files map[string]string) []byte {
+		b, err := io.ReadAll(zipFiles(t, files))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	msg := compoundFileBytes(t, map[string][]byte{"__substg1.0_0037001F": utf16Bytes("Subject")})
+	other := compoundFileBytes(t, map[string][]byte{"WordDocument": []byte("not a message")})
+
+	for name, tt := range map[string]struct {
+		data     []byte
+		expected string
+	}{
+		"pdf":         {[]byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), "application/pdf"},
+		"docx":        {zipped(map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": "<w:document/>"}), "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		"xlsx":        {zipped(map[string]string{"xl/workbook.xml": "<workbook/>"}), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		"odp":         {zipped(map[string]string{"mimetype": "application/vnd.oasis.opendocument.presentation"}), "application/vnd.oasis.opendocument.presentation"},
+		"zip":         {zipped(map[string]string{"notes.txt": "hello"}), "application/zip"},
+		"msg":         {msg, "application/vnd.ms-outlook"},
+		"doc":         {other, ""},
+		"png":         {[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		"html":        {[]byte("\n  <!DOCTYPE html><html><body>Hi</body></html>"), "text/html"},
+		"json":        {[]byte(` {"a": [1, 2]}`), "application/json"},
+		"not json":    {[]byte(`{"a": 1`), "text/plain"},
+		"utf-8 text":  {[]byte("\xef\xbb\xbfRäksmörgås\r\n\fpage two"), "text/plain"},
+		"binary":      {[]byte{0x00, 0x01, 0x02, 0x7f, 0xfe}, ""},
+		"latin-1":     {[]byte("R\xe4ksm\xf6rg\xe5s"), ""},
+		"control chr": {[]byte("text\x00with nul"), ""},
+	} {
+		if got := Sniff(tt.data); got != tt.expected {
+			t.Errorf("%s: Sniff() = %q, want %q", name, got, tt.expected)
+		}
+	}
+}
+
+func TestConversionContentType(t *testing.T) {
+	for name, tt := range map[string]struct {
+		declared, disposition, detected string
+		expected                        string
+		ok                              bool
+	}{
+		"declared":         {"application/pdf", "", "text/plain", "application/pdf", true},
+		"by file name":     {"application/octet-stream", `attachment; filename="report.docx"`, "application/zip", "application/octet-stream", true},
+		"detected":         {"application/octet-stream", `attachment; filename="file"`, "application/pdf", "application/pdf", true},
+		"no content type":  {"", "", "text/html", "text/html", true},
+		"nothing converts": {"application/octet-stream", `attachment; filename="file"`, "application/zip", "application/octet-stream", false},
+	} {
+		got, ok := builtin.ConversionContentType(tt.declared, tt.disposition, tt.detected)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("%s: ConversionContentType() = %q, %v, want %q, %v", name, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
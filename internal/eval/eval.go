@@ -0,0 +1,107 @@
+package eval
+
+import (
+	"math"
+	"sort"
+
+	"github.com/modfin/ragnar"
+)
+
+// ScoreQuery computes recall@k, reciprocal rank and nDCG@k for the ranked hits of a single query.
+// A relevance without a chunk id matches any chunk of its document, each relevance is credited at most once
+func ScoreQuery(query ragnar.EvalQuery, hits []ragnar.Chunk, k int) ragnar.EvalQueryResult {
+	result := ragnar.EvalQueryResult{Query: query.Query}
+	if len(query.Relevant) == 0 {
+		return result
+	}
+	if k > 0 && len(hits) > k {
+		hits = hits[:k]
+	}
+
+	credited := make([]bool, len(query.Relevant))
+	found := 0
+	dcg := 0.0
+	for rank, hit := range hits {
+		idx := matchRelevance(query.Relevant, credited, hit)
+		if idx < 0 {
+			continue
+		}
+		credited[idx] = true
+		found++
+		if result.ReciprocalRank == 0 {
+			result.ReciprocalRank = 1 / float64(rank+1)
+		}
+		dcg += gain(query.Relevant[idx]) / math.Log2(float64(rank+2))
+	}
+
+	result.Recall = float64(found) / float64(len(query.Relevant))
+	if idcg := idealDCG(query.Relevant, k); idcg > 0 {
+		result.NDCG = dcg / idcg
+	}
+	return result
+}
+
+// Aggregate averages the per query results into run metrics
+func Aggregate(k int, results []ragnar.EvalQueryResult) ragnar.EvalMetrics {
+	metrics := ragnar.EvalMetrics{K: k, Queries: len(results)}
+	if len(results) == 0 {
+		return metrics
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			metrics.Failed++
+		}
+		metrics.Recall += r.Recall
+		metrics.MRR += r.ReciprocalRank
+		metrics.NDCG += r.NDCG
+	}
+	n := float64(len(results))
+	metrics.Recall /= n
+	metrics.MRR /= n
+	metrics.NDCG /= n
+	return metrics
+}
+
+// matchRelevance returns the index of the first not yet credited relevance matching the hit, preferring
+// exact chunk matches over document matches, or -1 if there is none
+func matchRelevance(relevant []ragnar.EvalRelevance, credited []bool, hit ragnar.Chunk) int {
+	docMatch := -1
+	for i, rel := range relevant {
+		if credited[i] || rel.DocumentId != hit.DocumentId {
+			continue
+		}
+		if rel.ChunkId != nil {
+			if *rel.ChunkId == hit.ChunkId {
+				return i
+			}
+			continue
+		}
+		if docMatch < 0 {
+			docMatch = i
+		}
+	}
+	return docMatch
+}
+
+func gain(rel ragnar.EvalRelevance) float64 {
+	if rel.Grade <= 0 {
+		return 1
+	}
+	return float64(rel.Grade)
+}
+
+func idealDCG(relevant []ragnar.EvalRelevance, k int) float64 {
+	gains := make([]float64, len(relevant))
+	for i, rel := range relevant {
+		gains[i] = gain(rel)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(gains)))
+	if k > 0 && len(gains) > k {
+		gains = gains[:k]
+	}
+	idcg := 0.0
+	for rank, g := range gains {
+		idcg += g / math.Log2(float64(rank+2))
+	}
+	return idcg
+}
@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/util"
+)
+
+func hit(documentId string, chunkId int) ragnar.Chunk {
+	return ragnar.Chunk{DocumentId: documentId, ChunkId: chunkId}
+}
+
+func TestScoreQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      ragnar.EvalQuery
+		hits       []ragnar.Chunk
+		k          int
+		wantRecall float64
+		wantRR     float64
+		wantNDCG   float64
+	}{
+		{
+			name: "no relevant hits",
+			query: ragnar.EvalQuery{Relevant: []ragnar.EvalRelevance{
+				{DocumentId: "doc_a"},
+			}},
+			hits:       []ragnar.Chunk{hit("doc_b", 0), hit("doc_c", 0)},
+			k:          10,
+			wantRecall: 0,
+			wantRR:     0,
+			wantNDCG:   0,
+		},
+		{
+			name: "document relevance on first rank",
+			query: ragnar.EvalQuery{Relevant: []ragnar.EvalRelevance{
+				{DocumentId: "doc_a"},
+			}},
+			hits:       []ragnar.Chunk{hit("doc_a", 3), hit("doc_a", 4)},
+			k:          10,
+			wantRecall: 1,
+			wantRR:     1,
+			wantNDCG:   1,
+		},
+		{
+			name: "chunk relevance on second rank",
+			query: ragnar.EvalQuery{Relevant: []ragnar.EvalRelevance{
+				{DocumentId: "doc_a", ChunkId: util.Ptr(4)},
+			}},
+			hits:       []ragnar.Chunk{hit("doc_a", 3), hit("doc_a", 4)},
+			k:          10,
+			wantRecall: 1,
+			wantRR:     0.5,
+			wantNDCG:   1 / math.Log2(3),
+		},
+		{
+			name: "relevant hit outside k",
+			query: ragnar.EvalQuery{Relevant: []ragnar.EvalRelevance{
+				{DocumentId: "doc_a"},
+				{DocumentId: "doc_b"},
+			}},
+			hits:       []ragnar.Chunk{hit("doc_a", 0), hit("doc_c", 0), hit("doc_b", 0)},
+			k:          2,
+			wantRecall: 0.5,
+			wantRR:     1,
+			wantNDCG:   1 / (1 + 1/math.Log2(3)),
+		},
+		{
+			name: "graded relevance in wrong order",
+			query: ragnar.EvalQuery{Relevant: []ragnar.EvalRelevance{
+				{DocumentId: "doc_a", Grade: 3},
+				{DocumentId: "doc_b", Grade: 1},
+			}},
+			hits:       []ragnar.Chunk{hit("doc_b", 0), hit("doc_a", 0)},
+			k:          10,
+			wantRecall: 1,
+			wantRR:     1,
+			wantNDCG:   (1 + 3/math.Log2(3)) / (3 + 1/math.Log2(3)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ScoreQuery(tt.query, tt.hits, tt.k)
+			if math.Abs(got.Recall-tt.wantRecall) > 1e-9 {
+				t.Errorf("recall = %v, want %v", got.Recall, tt.wantRecall)
+			}
+			if math.Abs(got.ReciprocalRank-tt.wantRR) > 1e-9 {
+				t.Errorf("reciprocal rank = %v, want %v", got.ReciprocalRank, tt.wantRR)
+			}
+			if math.Abs(got.NDCG-tt.wantNDCG) > 1e-9 {
+				t.Errorf("ndcg = %v, want %v", got.NDCG, tt.wantNDCG)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	metrics := Aggregate(5, []ragnar.EvalQueryResult{
+		{Recall: 1, ReciprocalRank: 1, NDCG: 1},
+		{Recall: 0, ReciprocalRank: 0, NDCG: 0, Error: "search failed"},
+	})
+	if metrics.K != 5 || metrics.Queries != 2 || metrics.Failed != 1 {
+		t.Fatalf("unexpected counts: %+v", metrics)
+	}
+	if metrics.Recall != 0.5 || metrics.MRR != 0.5 || metrics.NDCG != 0.5 {
+		t.Fatalf("unexpected averages: %+v", metrics)
+	}
+}
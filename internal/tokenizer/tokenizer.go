@@ -0,0 +1,67 @@
+// Package tokenizer counts tokens of texts the way embedding models do, so that chunks can be sized and embedding
+// requests packed by tokens rather than characters.
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/modfin/bellman/models/embed"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Tokenizer counts the tokens of a text
+type Tokenizer interface {
+	Count(text string) int
+	// Exact reports whether the counts are the model's own, or approximated with a related tokenizer
+	Exact() bool
+	Name() string
+}
+
+type bpe struct {
+	encoding *tiktoken.Tiktoken
+	name     string
+	exact    bool
+}
+
+func (t bpe) Count(text string) int {
+	return len(t.encoding.EncodeOrdinary(text))
+}
+func (t bpe) Exact() bool  { return t.exact }
+func (t bpe) Name() string { return t.name }
+
+func init() {
+	// the BPE ranks are bundled with the binary, so that token counts do not depend on network access
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// cl100k loads the cl100k_base encoding once
+var cl100k = sync.OnceValues(func() (*tiktoken.Tiktoken, error) {
+	return tiktoken.GetEncoding("cl100k_base")
+})
+
+// ForModel returns the tokenizer of the embedding model family. OpenAI embedding models use cl100k_base. VoyageAI,
+// VertexAI and other providers do not publish tokenizers usable from Go, their counts are approximated with
+// cl100k_base and reported as not exact, callers leave room for the difference.
+func ForModel(model embed.Model) Tokenizer {
+	exact := strings.EqualFold(model.Provider, "OpenAI") && strings.HasPrefix(model.Name, "text-embedding-")
+
+	enc, err := cl100k()
+	if err != nil {
+		// the encoding is bundled, it only fails to load if the bundle is broken
+		panic(fmt.Errorf("could not load bundled cl100k_base encoding: %w", err))
+	}
+	return bpe{encoding: enc, name: "cl100k_base", exact: exact}
+}
+
+// ForModelFQN is ForModel for a model given by its fully qualified name, as in the embed_model tub setting.
+// Empty or unknown names get the approximating tokenizer of the non-OpenAI providers.
+func ForModelFQN(fqn string) Tokenizer {
+	model, err := embed.ToModel(fqn)
+	if err != nil {
+		model = embed.Model{Name: fqn}
+	}
+	return ForModel(model)
+}
@@ -0,0 +1,31 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/modfin/bellman/models/embed"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForModel(t *testing.T) {
+	openai := ForModel(embed.Model{Provider: "OpenAI", Name: "text-embedding-3-small"})
+	assert.Equal(t, "cl100k_base", openai.Name())
+	assert.True(t, openai.Exact())
+
+	voyage := ForModel(embed.Model{Provider: "VoyageAI", Name: "voyage-context-3"})
+	assert.Equal(t, "cl100k_base", voyage.Name())
+	assert.False(t, voyage.Exact())
+
+	assert.False(t, ForModelFQN("").Exact())
+	assert.False(t, ForModelFQN("not a model").Exact())
+}
+
+func TestCount(t *testing.T) {
+	tok := ForModelFQN("OpenAI/text-embedding-3-small")
+	assert.Equal(t, 0, tok.Count(""))
+	assert.Equal(t, 2, tok.Count("hello world"))
+	assert.Equal(t, 6, tok.Count("tiktoken is great!"))
+	// special tokens are counted as ordinary text
+	assert.Equal(t, tok.Count("<|endoftext|>"), ForModelFQN("VoyageAI/voyage-3").Count("<|endoftext|>"))
+	assert.Greater(t, tok.Count("<|endoftext|>"), 1)
+}
@@ -16,3 +16,52 @@ func ChunkSlicesContentEqual(a, b []ragnar.Chunk) bool {
 	}
 	return true
 }
+
+// MatchUnchangedChunks pairs the chunks of next with chunks of current that have the same content hash, also
+// when they have moved, e.g. after text was inserted earlier in the document. It returns the chunk id in current
+// of each reused chunk keyed by its chunk id in next. Repeated contents are paired in document order
+func MatchUnchangedChunks(current, next []ragnar.Chunk) map[int]int {
+	byHash := map[string][]int{}
+	for _, chunk := range current {
+		byHash[chunk.ContentHash] = append(byHash[chunk.ContentHash], chunk.ChunkId)
+	}
+
+	reuse := map[int]int{}
+	for _, chunk := range next {
+		ids := byHash[chunk.ContentHash]
+		if chunk.ContentHash == "" || len(ids) == 0 {
+			continue
+		}
+		reuse[chunk.ChunkId] = ids[0]
+		byHash[chunk.ContentHash] = ids[1:]
+	}
+	return reuse
+}
+
+// ChangedSurroundings returns the chunk ids in next of the reused chunks, see MatchUnchangedChunks, whose preceding
+// or following chunk differs from the one they had in current
+func ChangedSurroundings(current, next []ragnar.Chunk, reuse map[int]int) map[int]bool {
+	position := map[int]int{}
+	for i, chunk := range current {
+		position[chunk.ChunkId] = i
+	}
+	hashAt := func(chunks []ragnar.Chunk, i int) string {
+		if i < 0 || i >= len(chunks) {
+			return ""
+		}
+		return chunks[i].ContentHash
+	}
+
+	changed := map[int]bool{}
+	for i, chunk := range next {
+		oldId, ok := reuse[chunk.ChunkId]
+		if !ok {
+			continue
+		}
+		j := position[oldId]
+		if hashAt(next, i-1) != hashAt(current, j-1) || hashAt(next, i+1) != hashAt(current, j+1) {
+			changed[chunk.ChunkId] = true
+		}
+	}
+	return changed
+}
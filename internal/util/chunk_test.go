@@ -0,0 +1,87 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/modfin/ragnar"
+)
+
+func TestMatchUnchangedChunks(t *testing.T) {
+	chunks := func(contents ...string) []ragnar.Chunk {
+		var result []ragnar.Chunk
+		for i, content := range contents {
+			result = append(result, ragnar.Chunk{ChunkId: i, Content: content, ContentHash: HashContent(content)})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name    string
+		current []ragnar.Chunk
+		next    []ragnar.Chunk
+		want    map[int]int
+	}{
+		{
+			name:    "unchanged",
+			current: chunks("a", "b", "c"),
+			next:    chunks("a", "b", "c"),
+			want:    map[int]int{0: 0, 1: 1, 2: 2},
+		},
+		{
+			name:    "inserted chunk shifts the following ones",
+			current: chunks("a", "b", "c"),
+			next:    chunks("a", "new", "b", "c"),
+			want:    map[int]int{0: 0, 2: 1, 3: 2},
+		},
+		{
+			name:    "modified and removed chunks",
+			current: chunks("a", "b", "c", "d"),
+			next:    chunks("a", "b2", "d"),
+			want:    map[int]int{0: 0, 2: 3},
+		},
+		{
+			name:    "repeated contents are paired in order",
+			current: chunks("x", "a", "x"),
+			next:    chunks("x", "x", "x"),
+			want:    map[int]int{0: 0, 1: 2},
+		},
+		{
+			name:    "chunks without hash are not reused",
+			current: []ragnar.Chunk{{ChunkId: 0, Content: "a"}},
+			next:    []ragnar.Chunk{{ChunkId: 0, Content: "a"}},
+			want:    map[int]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchUnchangedChunks(tt.current, tt.next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchUnchangedChunks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangedSurroundings(t *testing.T) {
+	chunks := func(contents ...string) []ragnar.Chunk {
+		var result []ragnar.Chunk
+		for i, content := range contents {
+			result = append(result, ragnar.Chunk{ChunkId: i, Content: content, ContentHash: HashContent(content)})
+		}
+		return result
+	}
+
+	current := chunks("a", "b", "c", "d")
+	next := chunks("a", "b", "new", "c", "d")
+	got := ChangedSurroundings(current, next, MatchUnchangedChunks(current, next))
+	want := map[int]bool{1: true, 3: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedSurroundings() = %v, want %v", got, want)
+	}
+
+	current = chunks("a", "b")
+	next = chunks("a", "b")
+	if got := ChangedSurroundings(current, next, MatchUnchangedChunks(current, next)); len(got) != 0 {
+		t.Errorf("ChangedSurroundings() = %v, want none", got)
+	}
+}
@@ -14,3 +14,9 @@ func HashReaderSHA256(r io.Reader) (string, error) {
 	hashInBytes := hasher.Sum(nil)
 	return hex.EncodeToString(hashInBytes), nil
 }
+
+// HashContent returns the hex encoded SHA-256 of a text, e.g. the content of a chunk
+func HashContent(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,193 @@
+package web
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/document"
+	"github.com/modfin/ragnar/internal/util"
+)
+
+// handleArchiveUpload expands an uploaded archive into one document per supported file, each with the x-ragnar-*
+// headers of the upload and the path of the file as archive_path. Files that fail are listed in the response, along
+// with the created documents.
+func (web *Web) handleArchiveUpload(w http.ResponseWriter, r *http.Request, ctx context.Context, tub ragnar.Tub, format string, requestId string) {
+	var err error
+
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err = gzip.NewReader(r.Body)
+		if err != nil {
+			web.log.Error("error creating gzip reader", "err", err, "request_id", requestId)
+			http.Error(w, "error creating gzip reader", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// zip archives are read from the end, so the archive is spooled to a file
+	tmp, err := os.CreateTemp("", "ragnar-upload-archive-")
+	if err != nil {
+		web.log.Error("failed to create temporary file", "err", err, "request_id", requestId)
+		http.Error(w, "failed to create temporary file, request_id: "+requestId, http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	length, err := io.Copy(tmp, io.LimitReader(reader, web.cfg.HttpUploadLimit))
+	if err != nil {
+		web.log.Error("failed to copy request body to temporary file", "err", err, "request_id", requestId)
+		http.Error(w, "failed to copy request body to temporary file, request_id: "+requestId, http.StatusInternalServerError)
+		return
+	}
+	// Throws error if exactly at limit to avoid truncation
+	if length >= web.cfg.HttpUploadLimit {
+		web.log.Error("Request body is too large", "limit", web.cfg.HttpUploadLimit-1, "request_id", requestId)
+		http.Error(w, fmt.Sprintf("Request body is too large, max %d bytes", web.cfg.HttpUploadLimit-1), http.StatusBadRequest)
+		return
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		web.log.Error("failed to seek to beginning of temporary file", "err", err, "request_id", requestId)
+		http.Error(w, "failed to seek to beginning of temporary file, request_id: "+requestId, http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := document.ExtractArchive(tmp, length, format, document.ArchiveLimits{
+		MaxEntries:  web.cfg.ArchiveMaxEntries,
+		MaxSize:     web.cfg.ArchiveMaxSize,
+		MaxFileSize: web.cfg.HttpUploadLimit - 1,
+	})
+	if err != nil {
+		web.log.Error("error extracting archive", "err", err, "request_id", requestId)
+		http.Error(w, fmt.Sprintf("error extracting archive: %v, request_id: %s", err, requestId), http.StatusBadRequest)
+		return
+	}
+	defer document.RemoveArchiveEntries(entries)
+
+	headers := pgtype.Hstore{}
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, headerPrefix) {
+			headers[strings.TrimPrefix(k, headerPrefix)] = util.Ptr(v[0])
+		}
+	}
+
+	result := ragnar.ArchiveUpload{Documents: []ragnar.Document{}, Errors: []ragnar.ArchiveError{}}
+	for _, entry := range entries {
+		var detectedContentType string
+		if entry.Err == nil {
+			detectedContentType, entry.Err = web.sniffArchiveEntry(entry)
+		}
+		if entry.Err != nil {
+			result.Errors = append(result.Errors, ragnar.ArchiveError{Path: entry.Path, Error: entry.Err.Error()})
+			continue
+		}
+		doc, err := web.createArchiveDocument(ctx, tub, entry, detectedContentType, headers)
+		if err != nil {
+			web.log.Error("error creating document from archive", "err", err, "path", entry.Path, "request_id", requestId)
+			result.Errors = append(result.Errors, ragnar.ArchiveError{Path: entry.Path, Error: err.Error()})
+			continue
+		}
+		result.Documents = append(result.Documents, doc)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		web.log.Error("error encoding archive upload", "err", err, "request_id", requestId)
+		return
+	}
+}
+
+// sniffArchiveEntry returns the content type detected from the content of a file of an archive, see document.Sniff,
+// and an error when the file can be converted neither by its extension nor by the detected content type
+func (web *Web) sniffArchiveEntry(entry document.ArchiveEntry) (string, error) {
+	data, err := io.ReadAll(entry.File)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	_, err = entry.File.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("failed to seek to beginning of file after reading: %w", err)
+	}
+	detectedContentType := document.Sniff(data)
+	if _, ok := web.converters.ConversionContentType(archiveContentType(entry), archiveContentDisposition(entry), detectedContentType); !ok {
+		return "", errors.New("unsupported file type")
+	}
+	return detectedContentType, nil
+}
+
+// archiveContentType is the content type of a file of an archive by its extension
+func archiveContentType(entry document.ArchiveEntry) string {
+	contentType := mime.TypeByExtension(path.Ext(entry.Path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return contentType
+}
+
+func archiveContentDisposition(entry document.ArchiveEntry) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(entry.Path)})
+}
+
+// createArchiveDocument creates the document of a file of an archive and schedules its conversion, the document is
+// removed again when that fails
+func (web *Web) createArchiveDocument(ctx context.Context, tub ragnar.Tub, entry document.ArchiveEntry, detectedContentType string, headers pgtype.Hstore) (ragnar.Document, error) {
+	contentType := archiveContentType(entry)
+	doc := ragnar.Document{
+		TubId:   tub.TubId,
+		TubName: tub.TubName,
+		Headers: pgtype.Hstore{},
+	}
+	for k, v := range headers {
+		doc.Headers[k] = v
+	}
+	doc.Headers["content-type"] = &contentType
+	doc.Headers["content-length"] = util.Ptr(fmt.Sprintf("%d", entry.Size))
+	doc.Headers["content-disposition"] = util.Ptr(archiveContentDisposition(entry))
+	doc.Headers[ragnar.HeaderArchivePath] = &entry.Path
+	if detectedContentType != "" {
+		doc.Headers[ragnar.HeaderContentTypeDetected] = &detectedContentType
+	} else {
+		delete(doc.Headers, ragnar.HeaderContentTypeDetected)
+	}
+
+	documentHash, err := util.HashReaderSHA256(entry.File)
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("failed to hash document: %w", err)
+	}
+	_, err = entry.File.Seek(0, io.SeekStart)
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("failed to seek to beginning of file after hashing: %w", err)
+	}
+
+	doc, err = web.db.UpsertDocument(ctx, doc)
+	if err != nil {
+		return ragnar.Document{}, fmt.Errorf("error creating document: %w", err)
+	}
+	_, err = web.stor.PutDocument(ctx, doc.TubName, doc.DocumentId, entry.File, entry.Size, doc.Headers, documentHash)
+	if err != nil {
+		web.db.DeleteDocument(ctx, doc.TubName, doc.DocumentId) // try to rollback
+		return ragnar.Document{}, fmt.Errorf("error putting document: %w", err)
+	}
+	err = web.docket.ScheduleDocumentConversion(doc)
+	if err != nil {
+		web.stor.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
+		web.db.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
+		return ragnar.Document{}, fmt.Errorf("error scheduling document conversion: %w", err)
+	}
+	return doc, nil
+}
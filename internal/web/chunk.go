@@ -3,10 +3,16 @@ package web
 import (
 	"context"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
 
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/chunker"
+	"github.com/modfin/ragnar/internal/tokenizer"
 	"github.com/modfin/strut"
 )
 
@@ -58,3 +64,97 @@ func (web *Web) GetChunk(ctx context.Context) strut.Response[ragnar.Chunk] {
 	return strut.RespondOk(chunks)
 
 }
+
+// PreviewChunking chunks markdown, or the markdown of an existing document, with the tub settings overridden by
+// candidate settings and returns the chunks with size statistics. Nothing is stored.
+func (web *Web) PreviewChunking(ctx context.Context, req ragnar.ChunkPreviewRequest) strut.Response[ragnar.ChunkPreview] {
+	requestId := GetRequestID(ctx)
+
+	tubName := strut.PathParam(ctx, "tub")
+	tub, err := web.db.GetTub(ctx, tubName)
+	if err != nil {
+		return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest, "Tub not found")
+	}
+
+	md := req.Markdown
+	filename := req.Filename
+	var contentType string
+	switch {
+	case md != "" && req.DocumentId != "":
+		return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest, "Provide either markdown or document_id, not both")
+	case req.DocumentId != "":
+		doc, err := web.db.GetDocument(ctx, tubName, req.DocumentId)
+		if err != nil {
+			web.log.Error("error fetching document", "err", err, "request_id", requestId)
+			return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest,
+				fmt.Sprintf("Error fetching document, request_id: %s", requestId))
+		}
+		reader, err := web.stor.GetDocumentMarkdown(ctx, doc.TubName, doc.DocumentId)
+		if err != nil {
+			web.log.Error("error getting markdown document", "err", err, "request_id", requestId)
+			return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest,
+				fmt.Sprintf("Error getting markdown document, request_id: %s", requestId))
+		}
+		defer reader.Close()
+		b, err := io.ReadAll(reader)
+		if err != nil {
+			web.log.Error("error reading markdown document", "err", err, "request_id", requestId)
+			return strut.RespondError[ragnar.ChunkPreview](http.StatusInternalServerError,
+				fmt.Sprintf("Error reading markdown document, request_id: %s", requestId))
+		}
+		md = string(b)
+		contentType = web.converters.DocumentContentType(doc)
+		if cd := doc.Headers["content-disposition"]; cd != nil && filename == "" {
+			_, params, err := mime.ParseMediaType(*cd)
+			if err == nil {
+				filename = params["filename"]
+			}
+		}
+	case md == "":
+		return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest, "Provide markdown or a document_id to chunk")
+	}
+
+	settings := pgtype.Hstore{}
+	for k, v := range tub.Settings {
+		settings[k] = v
+	}
+	for k, v := range req.Settings {
+		settings[k] = v
+	}
+
+	// the settings of the request are checked, the stored ones of the tub are read leniently like when chunking
+	_, err = ragnar.ParseTubSettings(req.Settings)
+	if err != nil {
+		return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest, fmt.Sprintf("Invalid settings: %v", err))
+	}
+
+	embedModel, err := web.tubEmbedModel(ragnar.Tub{Settings: settings})
+	if err != nil {
+		return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest, fmt.Sprintf("Could not find embedding model: %v", err))
+	}
+	embedSentences := func(texts []string) ([][]float32, error) {
+		return web.ai.EmbedStrings(embedModel.WithType(embed.TypeDocument), texts)
+	}
+
+	chunks, parents, err := chunker.ChunkDocument(md, contentType, filename, settings, embedSentences)
+	if err != nil {
+		web.log.Error("error chunking markdown", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.ChunkPreview](http.StatusBadRequest,
+			fmt.Sprintf("Error chunking markdown: %v, request_id: %s", err, requestId))
+	}
+	if chunks == nil {
+		chunks = []ragnar.Chunk{}
+	}
+	for i := range chunks {
+		chunks[i].DocumentId, chunks[i].TubId, chunks[i].TubName = req.DocumentId, tub.TubId, tub.TubName
+	}
+	for i := range parents {
+		parents[i].DocumentId, parents[i].TubId, parents[i].TubName = req.DocumentId, tub.TubId, tub.TubName
+	}
+
+	return strut.RespondOk(ragnar.ChunkPreview{
+		Chunks:  chunks,
+		Parents: parents,
+		Stats:   chunker.Stats(chunks, tokenizer.ForModel(embedModel)),
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar/internal/chunker"
+	"github.com/modfin/ragnar/internal/document"
 	"github.com/modfin/ragnar/internal/util"
 	"io"
 	"mime"
@@ -74,6 +76,11 @@ func (web *Web) UpsertDocument(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if format := document.ArchiveFormat(mediaType); format != "" && documentId == "" {
+		web.handleArchiveUpload(w, r, ctx, tub, format, requestId)
+		return
+	}
+
 	if mediaType == "multipart/form-data" {
 		web.handleMultipartUpsert(w, r, ctx, tub, documentId, params, requestId)
 	} else {
@@ -144,6 +151,13 @@ func (web *Web) handleSingleFileUpsert(w http.ResponseWriter, r *http.Request, c
 	}
 	seekableReader := bytes.NewReader(data)
 
+	detectedContentType := document.Sniff(data)
+	if _, ok := web.converters.ConversionContentType(contentType, contentDisposition, detectedContentType); !ok {
+		web.log.Error("unsupported document type", "content_type", contentType, "content_disposition", contentDisposition, "detected_content_type", detectedContentType, "request_id", requestId)
+		http.Error(w, fmt.Sprintf("unsupported document type, neither the Content-Type %q, the file name of the Content-Disposition nor the detected content type %q can be converted", contentType, detectedContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	isNewDocument := documentId == ""
 	doc := ragnar.Document{
 		DocumentId: documentId,
@@ -177,6 +191,11 @@ func (web *Web) handleSingleFileUpsert(w http.ResponseWriter, r *http.Request, c
 			doc.Headers[k] = util.Ptr(v[0])
 		}
 	}
+	if detectedContentType != "" {
+		doc.Headers[ragnar.HeaderContentTypeDetected] = &detectedContentType
+	} else {
+		delete(doc.Headers, ragnar.HeaderContentTypeDetected)
+	}
 
 	doc, err = web.db.UpsertDocument(ctx, doc)
 	if err != nil {
@@ -393,6 +412,22 @@ func (web *Web) handleMultipartUpsert(w http.ResponseWriter, r *http.Request, ct
 		return
 	}
 
+	fileData, err := io.ReadAll(fileReader)
+	if err != nil {
+		web.log.Error("failed to read file part", "err", err, "request_id", requestId)
+		http.Error(w, "failed to read file part, request_id: "+requestId, http.StatusInternalServerError)
+		return
+	}
+	fileReader = bytes.NewReader(fileData)
+
+	// the file only has to be convertible when no markdown is uploaded along with it
+	detectedContentType := document.Sniff(fileData)
+	if _, ok := web.converters.ConversionContentType(fileContentType, fileContentDisposition, detectedContentType); !ok && markdownReader == nil {
+		web.log.Error("unsupported document type", "content_type", fileContentType, "content_disposition", fileContentDisposition, "detected_content_type", detectedContentType, "request_id", requestId)
+		http.Error(w, fmt.Sprintf("unsupported document type, neither the Content-Type %q, the file name of the Content-Disposition nor the detected content type %q can be converted", fileContentType, detectedContentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	isNewDocument := documentId == ""
 	doc := ragnar.Document{
 		DocumentId: documentId,
@@ -414,6 +449,11 @@ func (web *Web) handleMultipartUpsert(w http.ResponseWriter, r *http.Request, ct
 			doc.Headers[k] = util.Ptr(v[0])
 		}
 	}
+	if detectedContentType != "" {
+		doc.Headers[ragnar.HeaderContentTypeDetected] = &detectedContentType
+	} else {
+		delete(doc.Headers, ragnar.HeaderContentTypeDetected)
+	}
 
 	documentHash, err := util.HashReaderSHA256(fileReader)
 	if err != nil {
@@ -478,8 +518,9 @@ func (web *Web) handleMultipartUpsert(w http.ResponseWriter, r *http.Request, ct
 
 	// Store chunks if provided
 	var chunksChanged bool
+	var currentChunks []ragnar.Chunk
 	if len(chunks) > 0 {
-		currentChunks, err := web.db.InternalGetChunks(doc)
+		currentChunks, err = web.db.InternalGetChunks(doc)
 		if err != nil {
 			web.log.Error("error fetching current chunks", "err", err, "request_id", requestId)
 			http.Error(w, "error fetching current chunks, request_id: "+requestId, http.StatusInternalServerError)
@@ -496,25 +537,23 @@ func (web *Web) handleMultipartUpsert(w http.ResponseWriter, r *http.Request, ct
 	}
 
 	if len(chunks) > 0 && chunksChanged {
-		// Delete old chunks if any
-		err = web.db.DeleteChunks(ctx, doc)
-		if err != nil {
-			web.log.Error("error deleting old chunks", "err", err, "request_id", requestId)
+		for i := range chunks {
+			chunks[i].TubId = doc.TubId
+			chunks[i].TubName = doc.TubName
+			chunks[i].DocumentId = doc.DocumentId
+			chunks[i].ContentHash = util.HashContent(chunks[i].Content)
 		}
-		for _, chunk := range chunks {
-			chunk.TubId = doc.TubId
-			chunk.TubName = doc.TubName
-			chunk.DocumentId = doc.DocumentId
-			err = web.db.InternalInsertChunk(chunk)
-			if err != nil {
-				web.log.Error("error inserting chunk", "err", err, "request_id", requestId)
-				http.Error(w, "error inserting chunk, request_id: "+requestId, http.StatusInternalServerError)
-				if isNewDocument {
-					web.stor.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
-					web.db.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
-				}
-				return
+		// chunks with unchanged content keep their embeddings
+		reuse := util.MatchUnchangedChunks(currentChunks, chunks)
+		err = web.db.ReplaceChunks(ctx, doc, chunks, reuse, util.ChangedSurroundings(currentChunks, chunks, reuse))
+		if err != nil {
+			web.log.Error("error replacing chunks", "err", err, "request_id", requestId)
+			http.Error(w, "error inserting chunks, request_id: "+requestId, http.StatusInternalServerError)
+			if isNewDocument {
+				web.stor.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
+				web.db.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
 			}
+			return
 		}
 	}
 
@@ -573,10 +612,65 @@ func (web *Web) GetDocuments(ctx context.Context) strut.Response[[]ragnar.Docume
 	requestId := GetRequestID(ctx)
 
 	tub := strut.PathParam(ctx, "tub")
-	filterstr := strut.QueryParam(ctx, "filter")
-	if filterstr == "" {
-		filterstr = "{}"
+	list, err := documentListParams(ctx)
+	if err != nil {
+		web.log.Error("Error parsing document list parameters", "err", err, "request_id", requestId)
+		return strut.RespondError[[]ragnar.Document](http.StatusBadRequest,
+			fmt.Sprintf("%v, request_id: %s", err, requestId))
 	}
+
+	docs, err := web.db.ListDocuments(ctx, tub, list.filter, list.sort, list.limit, list.offset)
+	if err != nil {
+		web.log.Error("Error listing documents", "err", err, "request_id", requestId)
+		return strut.RespondError[[]ragnar.Document](http.StatusInternalServerError,
+			fmt.Sprintf("Error listing documents, request_id: %s", requestId))
+	}
+	return strut.RespondOk(docs)
+}
+
+// GetFacetedDocuments lists documents like GetDocuments, and counts the facets over all documents matching the filter
+func (web *Web) GetFacetedDocuments(ctx context.Context) strut.Response[ragnar.FacetedDocuments] {
+	requestId := GetRequestID(ctx)
+
+	tub := strut.PathParam(ctx, "tub")
+	list, err := documentListParams(ctx)
+	if err != nil {
+		web.log.Error("Error parsing document list parameters", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.FacetedDocuments](http.StatusBadRequest,
+			fmt.Sprintf("%v, request_id: %s", err, requestId))
+	}
+	fields, err := ragnar.ParseFacetFields(strut.QueryParam(ctx, "facets"))
+	if err != nil {
+		return strut.RespondError[ragnar.FacetedDocuments](http.StatusBadRequest, fmt.Sprintf("Invalid 'facets' query parameter: %v", err))
+	}
+	if len(fields) == 0 {
+		return strut.RespondError[ragnar.FacetedDocuments](http.StatusBadRequest, "No facets provided")
+	}
+
+	docs, err := web.db.ListDocuments(ctx, tub, list.filter, list.sort, list.limit, list.offset)
+	if err != nil {
+		web.log.Error("Error listing documents", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.FacetedDocuments](http.StatusInternalServerError,
+			fmt.Sprintf("Error listing documents, request_id: %s", requestId))
+	}
+	facets, err := web.db.DocumentFacets(ctx, tub, list.filter, fields)
+	if err != nil {
+		web.log.Error("Error counting document facets", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.FacetedDocuments](http.StatusInternalServerError,
+			fmt.Sprintf("Error counting document facets, request_id: %s", requestId))
+	}
+	return strut.RespondOk(ragnar.FacetedDocuments{Documents: docs, Facets: facets})
+}
+
+// documentList is a page of documents, as given by the query parameters of the document list endpoints
+type documentList struct {
+	filter ragnar.DocumentFilter
+	sort   ragnar.DocumentSort
+	limit  int
+	offset int
+}
+
+func documentListParams(ctx context.Context) (documentList, error) {
 	sortstr := strut.QueryParam(ctx, "sort")
 	if sortstr == "" {
 		sortstr = "[]"
@@ -590,30 +684,17 @@ func (web *Web) GetDocuments(ctx context.Context) strut.Response[[]ragnar.Docume
 		offset = 0
 	}
 
-	var filter ragnar.DocumentFilter
-	err = json.Unmarshal([]byte(filterstr), &filter)
+	filter, err := documentFilterParams(ctx)
 	if err != nil {
-		web.log.Error("Error unmarshalling filter json", "err", err, "request_id", requestId)
-		return strut.RespondError[[]ragnar.Document](http.StatusBadRequest,
-			fmt.Sprintf("Invalid JSON format in 'filter' query parameter, request_id: %s", requestId))
+		return documentList{}, err
 	}
 
 	var sort ragnar.DocumentSort
 	err = json.Unmarshal([]byte(sortstr), &sort)
 	if err != nil {
-		web.log.Error("Error unmarshalling sort json", "err", err, "request_id", requestId)
-		return strut.RespondError[[]ragnar.Document](http.StatusBadRequest,
-			fmt.Sprintf("Invalid JSON format in 'sort' query parameter, request_id: %s", requestId))
-	}
-
-	docs, err := web.db.ListDocuments(ctx, tub, filter, sort, limit, offset)
-	if err != nil {
-		web.log.Error("Error listing documents", "err", err, "request_id", requestId)
-		return strut.RespondError[[]ragnar.Document](http.StatusInternalServerError,
-			fmt.Sprintf("Error listing documents, request_id: %s", requestId))
+		return documentList{}, fmt.Errorf("invalid JSON format in 'sort' query parameter: %w", err)
 	}
-
-	return strut.RespondOk(docs)
+	return documentList{filter: filter, sort: sort, limit: limit, offset: offset}, nil
 }
 
 func (web *Web) GetDocument(ctx context.Context) strut.Response[ragnar.Document] {
@@ -714,36 +795,103 @@ func (web *Web) DownloadDocumentMarkdown(ctx context.Context) strut.Response[[]b
 	})
 }
 
-func (web *Web) DeleteDocument(ctx context.Context) strut.Response[ragnar.Document] {
+// DownloadDocumentPage returns the text of a page of a paged document, such as a PDF, from its markdown
+func (web *Web) DownloadDocumentPage(ctx context.Context) strut.Response[[]byte] {
 	requestId := GetRequestID(ctx)
 
 	tub := strut.PathParam(ctx, "tub")
 	documentId := strut.PathParam(ctx, "document_id")
+	page, err := strconv.Atoi(strut.PathParam(ctx, "page"))
+	if err != nil || page < 1 {
+		return strut.RespondError[[]byte](http.StatusBadRequest,
+			fmt.Sprintf("invalid page %q, must be a page number starting at 1", strut.PathParam(ctx, "page")))
+	}
 
 	doc, err := web.db.GetDocument(ctx, tub, documentId)
 	if err != nil {
 		web.log.Error("Error fetching document", "err", err, "request_id", requestId)
-		return strut.RespondError[ragnar.Document](http.StatusBadRequest,
+		return strut.RespondError[[]byte](http.StatusBadRequest,
 			fmt.Sprintf("Error fetching document, request_id: %s", requestId))
 	}
 
-	err = web.db.DeleteDocument(ctx, tub, documentId)
+	reader, err := web.stor.GetDocumentMarkdown(ctx, doc.TubName, doc.DocumentId)
+	if err != nil {
+		web.log.Error("Error getting markdown document", "err", err, "request_id", requestId)
+		return strut.RespondError[[]byte](http.StatusBadRequest,
+			fmt.Sprintf("Error getting markdown document, request_id: %s", requestId))
+	}
+	defer reader.Close()
+	md, err := io.ReadAll(reader)
 	if err != nil {
-		web.log.Error("Error deleting document obj", "err", err, "request_id", requestId)
+		web.log.Error("Error reading markdown document", "err", err, "request_id", requestId)
+		return strut.RespondError[[]byte](http.StatusInternalServerError,
+			fmt.Sprintf("Error reading markdown document, request_id: %s", requestId))
+	}
+
+	pages := chunker.Pages(string(md))
+	if page > len(pages) {
+		return strut.RespondError[[]byte](http.StatusNotFound,
+			fmt.Sprintf("page %d not found, the document has %d pages", page, len(pages)))
+	}
+
+	return strut.RespondFunc[[]byte](func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("x-ragnar-page-count", strconv.Itoa(len(pages)))
+		w.WriteHeader(200)
+		_, err := io.WriteString(w, strings.TrimSpace(pages[page-1]))
+		return err
+	})
+}
+
+func (web *Web) DeleteDocument(ctx context.Context) strut.Response[ragnar.Document] {
+	requestId := GetRequestID(ctx)
+
+	tub := strut.PathParam(ctx, "tub")
+	documentId := strut.PathParam(ctx, "document_id")
+
+	doc, err := web.db.GetDocument(ctx, tub, documentId)
+	if err != nil {
+		web.log.Error("Error fetching document", "err", err, "request_id", requestId)
 		return strut.RespondError[ragnar.Document](http.StatusBadRequest,
-			fmt.Sprintf("Error deleting document obj, request_id: %s", requestId))
+			fmt.Sprintf("Error fetching document, request_id: %s", requestId))
 	}
 
-	err = web.stor.DeleteDocument(ctx, tub, documentId)
+	err = web.deleteDocumentTree(ctx, doc)
 	if err != nil {
-		web.log.Error("Error deleting document from storage", "err", err, "request_id", requestId)
+		web.log.Error("Error deleting document", "err", err, "request_id", requestId)
 		return strut.RespondError[ragnar.Document](http.StatusBadRequest,
-			fmt.Sprintf("Error deleting document from storage, request_id: %s", requestId))
+			fmt.Sprintf("Error deleting document, request_id: %s", requestId))
 	}
 
 	return strut.RespondOk(doc)
 }
 
+// deleteDocumentTree deletes the document along with the documents of its attachments, see
+// ragnar.HeaderParentDocumentId. The attachments are deleted first, so that none are left behind when deleting
+// is not allowed
+func (web *Web) deleteDocumentTree(ctx context.Context, doc ragnar.Document) error {
+	children, err := web.db.InternalGetChildDocuments(doc)
+	if err != nil {
+		return fmt.Errorf("error getting attachment documents: %w", err)
+	}
+	for _, child := range children {
+		err = web.deleteDocumentTree(ctx, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = web.db.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
+	if err != nil {
+		return fmt.Errorf("error deleting document obj %s: %w", doc.DocumentId, err)
+	}
+	err = web.stor.DeleteDocument(ctx, doc.TubName, doc.DocumentId)
+	if err != nil {
+		return fmt.Errorf("error deleting document %s from storage: %w", doc.DocumentId, err)
+	}
+	return nil
+}
+
 func (web *Web) GetDocumentStatus(ctx context.Context) strut.Response[ragnar.DocumentStatus] {
 	requestId := GetRequestID(ctx)
 
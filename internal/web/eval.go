@@ -0,0 +1,164 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/eval"
+	"github.com/modfin/strut"
+)
+
+const defaultEvalK = 10
+
+func (web *Web) UpsertEvalSet(ctx context.Context, set ragnar.EvalSet) strut.Response[ragnar.EvalSet] {
+	requestId := GetRequestID(ctx)
+
+	set.TubName = strut.PathParam(ctx, "tub")
+	set.EvalSetName = strut.PathParam(ctx, "eval_set")
+
+	for i, q := range set.Queries {
+		if q.Query == "" {
+			return strut.RespondError[ragnar.EvalSet](http.StatusBadRequest,
+				fmt.Sprintf("eval query %d has no query text, request_id: %s", i, requestId))
+		}
+		if len(q.Relevant) == 0 {
+			return strut.RespondError[ragnar.EvalSet](http.StatusBadRequest,
+				fmt.Sprintf("eval query %d has no relevant documents, request_id: %s", i, requestId))
+		}
+	}
+
+	set, err := web.db.UpsertEvalSet(ctx, set)
+	if err != nil {
+		web.log.Error("error upserting eval set", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalSet](http.StatusBadRequest,
+			fmt.Sprintf("error upserting eval set, request_id: %s", requestId))
+	}
+	return strut.RespondOk(set)
+}
+
+func (web *Web) GetEvalSet(ctx context.Context) strut.Response[ragnar.EvalSet] {
+	requestId := GetRequestID(ctx)
+
+	tub := strut.PathParam(ctx, "tub")
+	evalSetName := strut.PathParam(ctx, "eval_set")
+
+	set, err := web.db.GetEvalSet(ctx, tub, evalSetName)
+	if err != nil {
+		web.log.Error("error fetching eval set", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalSet](evalErrorStatus(err),
+			fmt.Sprintf("error fetching eval set, request_id: %s", requestId))
+	}
+	return strut.RespondOk(set)
+}
+
+func (web *Web) ListEvalRuns(ctx context.Context) strut.Response[[]ragnar.EvalRun] {
+	requestId := GetRequestID(ctx)
+
+	tub := strut.PathParam(ctx, "tub")
+	evalSetName := strut.PathParam(ctx, "eval_set")
+	limit, err := strconv.Atoi(strut.QueryParam(ctx, "limit"))
+	if err != nil {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(strut.QueryParam(ctx, "offset"))
+	if err != nil {
+		offset = 0
+	}
+	if limit < 0 || offset < 0 {
+		return strut.RespondError[[]ragnar.EvalRun](http.StatusBadRequest, "limit and offset must not be negative")
+	}
+
+	runs, err := web.db.ListEvalRuns(ctx, tub, evalSetName, limit, offset)
+	if err != nil {
+		web.log.Error("error listing eval runs", "err", err, "request_id", requestId)
+		return strut.RespondError[[]ragnar.EvalRun](http.StatusInternalServerError,
+			fmt.Sprintf("error listing eval runs, request_id: %s", requestId))
+	}
+	return strut.RespondOk(runs)
+}
+
+// RunEvalSet runs every query of the eval set through the regular search path and stores the resulting metrics
+func (web *Web) RunEvalSet(ctx context.Context, req ragnar.EvalRunRequest) strut.Response[ragnar.EvalRun] {
+	requestId := GetRequestID(ctx)
+
+	tubName := strut.PathParam(ctx, "tub")
+	evalSetName := strut.PathParam(ctx, "eval_set")
+	k := req.K
+	if k <= 0 {
+		k = defaultEvalK
+	}
+	if k > maxSearchLimit {
+		return strut.RespondError[ragnar.EvalRun](http.StatusBadRequest,
+			fmt.Sprintf("k must not be more than %d", maxSearchLimit))
+	}
+
+	tub, err := web.db.GetTub(ctx, tubName)
+	if err != nil {
+		web.log.Error("error fetching tub", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalRun](evalErrorStatus(err),
+			fmt.Sprintf("error fetching tub, request_id: %s", requestId))
+	}
+	set, err := web.db.GetEvalSet(ctx, tubName, evalSetName)
+	if err != nil {
+		web.log.Error("error fetching eval set", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalRun](evalErrorStatus(err),
+			fmt.Sprintf("error fetching eval set, request_id: %s", requestId))
+	}
+	embedModel, err := web.tubEmbedModel(tub)
+	if err != nil {
+		web.log.Error("failed to get model", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalRun](http.StatusBadRequest,
+			fmt.Sprintf("could not find embedding model: %v", err))
+	}
+
+	queries := make([]ragnar.SearchQuery, len(set.Queries))
+	for i, q := range set.Queries {
+		queries[i] = ragnar.SearchQuery{Query: q.Query, Filter: q.Filter, Limit: k}
+	}
+	hits, err := web.batchSearchChunks(ctx, tub, embedModel, queries)
+	if err != nil {
+		web.log.Error("eval search failed", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalRun](http.StatusInternalServerError,
+			fmt.Sprintf("error searching eval queries, request_id: %s", requestId))
+	}
+
+	results := make(ragnar.EvalQueryResults, 0, len(set.Queries))
+	for i, q := range set.Queries {
+		if hits[i].Error != "" {
+			web.log.Warn("eval query search failed", "err", hits[i].Error, "query", q.Query, "request_id", requestId)
+			results = append(results, ragnar.EvalQueryResult{Query: q.Query, Error: hits[i].Error})
+			continue
+		}
+		results = append(results, eval.ScoreQuery(q, hits[i].Chunks, k))
+	}
+
+	run, err := web.db.InsertEvalRun(ctx, ragnar.EvalRun{
+		EvalSetId:   set.EvalSetId,
+		TubId:       tub.TubId,
+		TubName:     tub.TubName,
+		EvalSetName: set.EvalSetName,
+		Settings:    tub.Settings,
+		EmbedModel:  embedModel.FQN(),
+		Metrics:     eval.Aggregate(k, results),
+		Results:     results,
+	})
+	if err != nil {
+		web.log.Error("error storing eval run", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.EvalRun](http.StatusInternalServerError,
+			fmt.Sprintf("error storing eval run, request_id: %s", requestId))
+	}
+	return strut.RespondOk(run)
+}
+
+// evalErrorStatus is 404 for tubs and eval sets that do not exist, or are not readable, and 400 otherwise
+func evalErrorStatus(err error) int {
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"context"
+	"strings"
+
+	"github.com/modfin/ragnar"
+)
+
+// defaultFacetHits is the number of top search hits whose documents are counted for search facets
+const defaultFacetHits = 100
+
+// hitFacets counts the facets over the documents of the search hits, rather than over the whole tub
+func (web *Web) hitFacets(ctx context.Context, tubName string, filter ragnar.DocumentFilter, fields []ragnar.FacetField, hits []ragnar.Chunk) ([]ragnar.Facet, error) {
+	seen := map[string]bool{}
+	documentIds := []string{}
+	for _, c := range hits {
+		if !seen[c.DocumentId] {
+			seen[c.DocumentId] = true
+			documentIds = append(documentIds, c.DocumentId)
+		}
+	}
+	documentFilter := ragnar.DocumentFilter{}
+	for field, values := range filter {
+		// chunk metadata filters are already applied by the search, and do not apply to documents
+		if !strings.HasPrefix(strings.ToLower(field), "chunk.") {
+			documentFilter[field] = values
+		}
+	}
+	documentFilter["document_id"] = append(documentFilter["document_id"], ragnar.FilterValue{Array: documentIds})
+
+	return web.db.DocumentFacets(ctx, tubName, documentFilter, fields)
+}
@@ -2,6 +2,8 @@ package web
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"regexp"
@@ -10,8 +12,10 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/modfin/ragnar"
 	"github.com/modfin/ragnar/internal/auth"
 	"github.com/modfin/ragnar/internal/dao"
+	"github.com/modfin/strut"
 )
 
 var GetRequestID = middleware.GetReqID
@@ -175,3 +179,25 @@ func AuthenticateAccess(log *slog.Logger, db *dao.DAO, operation ...auth.ACLOper
 		})
 	}
 }
+
+// documentFilterParams reads the JSON 'filter' and the query string 'q_filter' parameters, both are optional
+// and when both are given the resulting filters are AND-ed together
+func documentFilterParams(ctx context.Context) (ragnar.DocumentFilter, error) {
+	filter := ragnar.NewDocumentFilter()
+	if filterstr := strut.QueryParam(ctx, "filter"); filterstr != "" {
+		err := json.Unmarshal([]byte(filterstr), &filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON format in 'filter' query parameter: %w", err)
+		}
+	}
+	if qfilter := strut.QueryParam(ctx, "q_filter"); qfilter != "" {
+		parsed, err := ragnar.ParseDocumentFilterQuery(qfilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'q_filter' query parameter: %w", err)
+		}
+		for field, values := range parsed {
+			filter[field] = append(filter[field], values...)
+		}
+	}
+	return filter, nil
+}
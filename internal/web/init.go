@@ -14,6 +14,7 @@ import (
 	"github.com/modfin/ragnar/internal/auth"
 	"github.com/modfin/ragnar/internal/dao"
 	"github.com/modfin/ragnar/internal/dao/docket"
+	"github.com/modfin/ragnar/internal/document"
 	"github.com/modfin/ragnar/internal/storage"
 	"github.com/modfin/strut"
 	"github.com/modfin/strut/with"
@@ -24,17 +25,24 @@ type Config struct {
 	HttpPort        int    `cli:"http-port"`
 	HttpURI         string `cli:"http-uri"`
 	HttpUploadLimit int64  `cli:"http-upload-limit"`
+
+	ArchiveMaxEntries int   `cli:"archive-max-entries"`
+	ArchiveMaxSize    int64 `cli:"archive-max-size"`
+
+	SearchBatchMaxQueries  int `cli:"search-batch-max-queries"`
+	SearchBatchParallelism int `cli:"search-batch-parallelism"`
 }
 
 // Web holds application-wide dependencies, like the database connection pool.
 type Web struct {
-	cfg    Config
-	db     *dao.DAO
-	srv    *http.Server
-	stor   *storage.Storage
-	log    *slog.Logger
-	docket *docket.Docket
-	ai     *ai.AI
+	cfg        Config
+	db         *dao.DAO
+	srv        *http.Server
+	stor       *storage.Storage
+	log        *slog.Logger
+	docket     *docket.Docket
+	ai         *ai.AI
+	converters *document.Registry
 }
 
 func (web *Web) Name() string {
@@ -45,16 +53,17 @@ func (web *Web) Close(ctx context.Context) error {
 	return web.srv.Shutdown(ctx)
 }
 
-func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, docket *docket.Docket, ai *ai.AI, cfg Config) *Web {
+func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, docket *docket.Docket, ai *ai.AI, converters *document.Registry, cfg Config) *Web {
 	// Create a new chi router
 	r := chi.NewRouter()
 	web := &Web{
-		cfg:    cfg,
-		db:     db,
-		stor:   stor,
-		docket: docket,
-		ai:     ai,
-		log:    log,
+		cfg:        cfg,
+		db:         db,
+		stor:       stor,
+		docket:     docket,
+		ai:         ai,
+		converters: converters,
+		log:        log,
 	}
 
 	// Create strut instance with logger and router
@@ -85,6 +94,14 @@ func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, docket *docket.Do
 		with.Description("Create a tub"),
 		with.ResponseDescription(201, "returns information about the newly created tub"),
 	)
+	strut.Get(
+		s.With(AuthenticateAccess(log, db, auth.ALLOW_READ)),
+		"/tub-settings",
+		web.GetTubSettings,
+		with.OperationId("get-tub-settings"),
+		with.Description("Describe the settings of a tub. Tub settings are stored and sent as strings, unknown keys and invalid values are rejected with 400 when a tub is created or updated"),
+		with.ResponseDescription(200, "the settings a tub can have, holding the defaults used for settings that are not set"),
+	)
 
 	strut.Get(
 		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
@@ -115,6 +132,62 @@ func New(log *slog.Logger, db *dao.DAO, stor *storage.Storage, docket *docket.Do
 		with.ResponseDescription(200, "???"),
 	)
 
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/usage",
+		web.GetTubTokenUsage,
+		with.OperationId("get-tub-token-usage"),
+		with.Description("Get the model tokens spent on the tub, e.g. by chunk contextualization, summed per purpose and model"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.QueryParam[string]("document_id", "Optional document to sum the usage of"),
+		with.QueryParam[string]("since", "Optional RFC 3339 timestamp to sum the usage from"),
+		with.ResponseDescription(200, "The token usage per purpose and model"),
+	)
+
+	strut.Post(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_UPDATE)),
+		"/tubs/{tub}/reindex",
+		web.ReindexTub,
+		with.OperationId("reindex-tub"),
+		with.Description("Re-process all documents of the tub from a stage, chunk, contextualize or embed. Documents are scheduled a few at a time and a running reindex of the tub is cancelled. Updating the chunking or embedding settings of a tub starts a reindex as well"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.ResponseDescription(200, "The started reindex"),
+	)
+
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/reindex",
+		web.ListReindexes,
+		with.OperationId("list-tub-reindexes"),
+		with.Description("List the reindexes of the tub with their progress, newest first"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.QueryParam[int]("limit", "Optional limit query"),
+		with.QueryParam[int]("offset", "Optional offset query"),
+		with.ResponseDescription(200, "A list of reindexes"),
+	)
+
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/reindex/{reindex_id}",
+		web.GetReindex,
+		with.OperationId("get-tub-reindex"),
+		with.Description("Get the progress of a reindex, the number of documents pending, running, done and failed"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("reindex_id", "the reindex id"),
+		with.ResponseDescription(200, "The reindex"),
+	)
+
+	strut.Delete(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_UPDATE)),
+		"/tubs/{tub}/reindex/{reindex_id}",
+		web.CancelReindex,
+		with.OperationId("cancel-tub-reindex"),
+		with.Description("Cancel a running reindex, documents already scheduled are processed to the end. The reindex and its progress are kept"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("reindex_id", "the reindex id"),
+		with.ResponseDescription(200, "The cancelled reindex"),
+	)
+
 	strut.Get(
 		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
 		"/tubs/{tub}/documents",
@@ -130,6 +203,8 @@ Filter format supports:
 - Less than: {"field": {"$lt": "value"}}
 - Less than or equal: {"field": {"$lte": "value"}}
 - Explicit equality: {"field": {"$eq": "value"}}
+- Not equal: {"field": {"$ne": "value"}}
+- Negation: {"field": {"$not": {"$gte": "10", "type": "integer"}}}, also matching documents without the field
 
 Type hints for numeric comparisons (optional):
 - Integer: {"field": {"$gt": "10", "type": "integer"}}
@@ -139,15 +214,40 @@ Type hints for numeric comparisons (optional):
 Without type hints, all comparisons are performed as text/string comparisons.
 Use "integer" or "numeric" type hints for proper numeric comparisons.
 
-Example: {"status": "active", "priority": {"$gte": "10", "type": "integer"}}`),
+Example: {"status": "active", "priority": {"$gte": "10", "type": "integer"}}
+
+The q_filter parameter accepts the same filters in a query string syntax, AND-ed with filter:
+- Terms: field:value, field>value, field>=value, field<value, field<=value, values with spaces are "quoted"
+- Combine terms with AND (or just a space), OR between values of the same field, and parentheses
+- Negate with NOT or a leading -, e.g. -status:draft
+- Comparisons against integer or decimal values are numeric
+
+Example: department:legal AND priority>=10 AND (author:anna OR author:erik) -status:draft`),
 		with.PathParam[string]("tub", "the document tub"),
-		with.QueryParam[string]("filter", "Optional filter query in JSON format with support for comparison operators ($eq, $gt, $gte, $lt, $lte) and array contains"),
+		with.QueryParam[string]("filter", "Optional filter query in JSON format with support for comparison operators ($eq, $ne, $gt, $gte, $lt, $lte) and array contains"),
+		with.QueryParam[string]("q_filter", "Optional filter in query string syntax, e.g. department:legal AND priority>=10 -status:draft"),
 		with.QueryParam[string]("sort", "Optional sorting of documents"),
 		with.QueryParam[int]("limit", "Optional limit query"),
 		with.QueryParam[int]("offset", "Optional offset query"),
 		with.ResponseDescription(200, "List of found documents matching filter"),
 	)
 
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/documents/faceted",
+		web.GetFacetedDocuments,
+		with.OperationId("list-documents-faceted"),
+		with.Description("Get documents in a tub like list-documents, together with facet counts over all documents matching the filter"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.QueryParam[string]("facets", `Comma separated facet fields on the form field[:type[:buckets]], e.g. department,priority:integer,price:numeric:5. Text facets count each distinct value, integer and numeric facets count equal width range buckets`),
+		with.QueryParam[string]("filter", "Optional filter query in JSON format, as for list-documents"),
+		with.QueryParam[string]("q_filter", "Optional filter in query string syntax, e.g. department:legal AND priority>=10 -status:draft"),
+		with.QueryParam[string]("sort", "Optional sorting of documents"),
+		with.QueryParam[int]("limit", "Optional limit query"),
+		with.QueryParam[int]("offset", "Optional offset query"),
+		with.ResponseDescription(200, "The page of documents matching filter and the facets counted over all of them"),
+	)
+
 	strut.Get(
 		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
 		"/tubs/{tub}/documents/{document_id}",
@@ -164,7 +264,7 @@ Example: {"status": "active", "priority": {"$gte": "10", "type": "integer"}}`),
 		"/tubs/{tub}/documents",
 		web.UpsertDocument,
 		with.OperationId("upload-document"),
-		with.Description("Upload a document"),
+		with.Description("Upload a document. A ZIP or tar(.gz) archive, by its Content-Type, is expanded into one document per supported file, with the path of the file as the archive_path header and the x-ragnar-* headers of the upload, and the response is then an ArchiveUpload listing the created documents and the files that failed"),
 		with.PathParam[string]("tub", "the document tub"),
 		with.ResponseDescription(200, "Successfully uploaded document"),
 	)
@@ -202,6 +302,18 @@ Example: {"status": "active", "priority": {"$gte": "10", "type": "integer"}}`),
 		with.ResponseDescription(200, "byte steam of document"),
 	)
 
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/documents/{document_id}/pages/{page}",
+		web.DownloadDocumentPage,
+		with.OperationId("download-document-page"),
+		with.Description("Download the text of a page of a paged document, such as a PDF, as numbered by the page_start and page_end of its chunks"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("document_id", "The document id of which page to be download"),
+		with.PathParam[int]("page", "The page number, starting at 1"),
+		with.ResponseDescription(200, "text of the page"),
+	)
+
 	strut.Get(
 		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
 		"/tubs/{tub}/documents/{document_id}/status",
@@ -237,6 +349,16 @@ Example: {"status": "active", "priority": {"$gte": "10", "type": "integer"}}`),
 		with.ResponseDescription(200, "A list of chunks from the requested document"),
 	)
 
+	strut.Post(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/chunking/preview",
+		web.PreviewChunking,
+		with.OperationId("preview-chunking"),
+		with.Description("Chunk markdown, or the markdown of an existing document, with candidate chunk settings overriding the tub settings. Nothing is stored, the chunks are returned with size statistics"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.ResponseDescription(200, "The chunks and their size statistics"),
+	)
+
 	strut.Get(
 		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
 		"/tubs/{tub}/document/{document_id}/chunks/{index}",
@@ -259,9 +381,87 @@ Example: {"status": "active", "priority": {"$gte": "10", "type": "integer"}}`),
 		with.QueryParam[string]("q", "free text search query"),
 		with.QueryParam[int]("limit", "Optional limit query"),
 		with.QueryParam[string]("filter", "Optional filter chunk documents query in flat JSON format"),
+		with.QueryParam[string]("q_filter", "Optional filter in query string syntax, e.g. department:legal AND priority>=10 -status:draft"),
 		with.QueryParam[int]("offset", "Optional offset query"),
+		with.QueryParam[string]("recency_half_life", "Optional half-life of the recency decay, e.g. 30d or 12h, overriding the tub setting"),
+		with.QueryParam[string]("recency_field", "Optional date header used for the recency decay, defaults to updated_at"),
+		with.QueryParam[string]("boosts", "Optional header boosts on the form field=value:factor,..., overriding the tub setting"),
 		with.ResponseDescription(200, "The chunks best matching the search"),
 	)
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/search/xnn/{tub}/faceted",
+		web.SearchXNNFaceted,
+		with.OperationId("vector-search-faceted"),
+		with.Description("Search for chunks like vector-search, together with facet counts over the documents of the top hits"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.QueryParam[string]("q", "free text search query"),
+		with.QueryParam[string]("facets", "Comma separated facet fields on the form field[:type[:buckets]], counted over the documents of the top hits"),
+		with.QueryParam[int]("facet_hits", "Optional number of top hits the facets are counted over, defaults to 100"),
+		with.QueryParam[int]("limit", "Optional limit query"),
+		with.QueryParam[string]("filter", "Optional filter chunk documents query in flat JSON format"),
+		with.QueryParam[string]("q_filter", "Optional filter in query string syntax, e.g. department:legal AND priority>=10 -status:draft"),
+		with.QueryParam[int]("offset", "Optional offset query"),
+		with.QueryParam[string]("recency_half_life", "Optional half-life of the recency decay, e.g. 30d or 12h, overriding the tub setting"),
+		with.QueryParam[string]("recency_field", "Optional date header used for the recency decay, defaults to updated_at"),
+		with.QueryParam[string]("boosts", "Optional header boosts on the form field=value:factor,..., overriding the tub setting"),
+		with.ResponseDescription(200, "The chunks best matching the search and the facets counted over the documents of the top hits"),
+	)
+	strut.Post(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/search/xnn/{tub}/batch",
+		web.BatchSearchXNN,
+		with.OperationId("vector-search-batch"),
+		with.Description("Search for chunks matching many text prompts in one request, each with its own filter and limit"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.ResponseDescription(200, "The chunks best matching each query, in the order of the queries"),
+	)
+
+	strut.Put(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_UPDATE)),
+		"/tubs/{tub}/evals/{eval_set}",
+		web.UpsertEvalSet,
+		with.OperationId("upsert-eval-set"),
+		with.Description("Create or replace an evaluation set of queries with their relevant documents or chunks"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("eval_set", "the eval set name"),
+		with.ResponseDescription(200, "The stored eval set"),
+	)
+
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/evals/{eval_set}",
+		web.GetEvalSet,
+		with.OperationId("get-eval-set"),
+		with.Description("Get an evaluation set"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("eval_set", "the eval set name"),
+		with.ResponseDescription(200, "The eval set"),
+	)
+
+	strut.Post(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/evals/{eval_set}/runs",
+		web.RunEvalSet,
+		with.OperationId("run-eval-set"),
+		with.Description("Run an evaluation set through vector search and compute recall@k, MRR and nDCG@k. The run is stored together with a snapshot of the tub settings"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("eval_set", "the eval set name"),
+		with.ResponseDescription(200, "The eval run with aggregated and per query metrics"),
+	)
+
+	strut.Get(
+		s.With(AuthenticateTubAccess(log, db, PathParam("tub"), auth.ALLOW_READ)),
+		"/tubs/{tub}/evals/{eval_set}/runs",
+		web.ListEvalRuns,
+		with.OperationId("list-eval-runs"),
+		with.Description("List previous runs of an evaluation set, newest first"),
+		with.PathParam[string]("tub", "the document tub"),
+		with.PathParam[string]("eval_set", "the eval set name"),
+		with.QueryParam[int]("limit", "Optional limit query"),
+		with.QueryParam[int]("offset", "Optional offset query"),
+		with.ResponseDescription(200, "A list of eval runs"),
+	)
 
 	//strut.Get(s, "/search/agent", web.SearchAgent,
 	//	with.OperationId("agent-vector-search"),
@@ -0,0 +1,147 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/modfin/ragnar"
+	"github.com/modfin/strut"
+)
+
+func (web *Web) ReindexTub(ctx context.Context, req ragnar.ReindexRequest) strut.Response[ragnar.Reindex] {
+	requestId := GetRequestID(ctx)
+
+	tubname := strut.PathParam(ctx, "tub")
+	stage := req.Stage
+	if stage == "" {
+		stage = ragnar.ReindexStageChunk
+	}
+	switch stage {
+	case ragnar.ReindexStageConvert, ragnar.ReindexStageChunk, ragnar.ReindexStageContextualize, ragnar.ReindexStageEmbed:
+	default:
+		return strut.RespondError[ragnar.Reindex](http.StatusBadRequest,
+			fmt.Sprintf("invalid stage %q, must be convert, chunk, contextualize or embed", stage))
+	}
+
+	reindex, err := web.startReindex(ctx, tubname, stage)
+	if err != nil {
+		web.log.Error("error starting reindex", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.Reindex](http.StatusBadRequest,
+			fmt.Sprintf("error starting reindex, request_id: %s", requestId))
+	}
+	return strut.RespondOk(reindex)
+}
+
+func (web *Web) ListReindexes(ctx context.Context) strut.Response[[]ragnar.Reindex] {
+	requestId := GetRequestID(ctx)
+
+	tubname := strut.PathParam(ctx, "tub")
+	limit, _ := strconv.Atoi(strut.QueryParam(ctx, "limit"))
+	offset, _ := strconv.Atoi(strut.QueryParam(ctx, "offset"))
+
+	reindexes, err := web.db.ListReindexes(ctx, tubname, limit, offset)
+	if err != nil {
+		web.log.Error("error listing reindexes", "err", err, "request_id", requestId)
+		return strut.RespondError[[]ragnar.Reindex](http.StatusBadRequest,
+			fmt.Sprintf("error listing reindexes, request_id: %s", requestId))
+	}
+	if reindexes == nil {
+		reindexes = []ragnar.Reindex{}
+	}
+	return strut.RespondOk(reindexes)
+}
+
+func (web *Web) GetReindex(ctx context.Context) strut.Response[ragnar.Reindex] {
+	requestId := GetRequestID(ctx)
+
+	tubname := strut.PathParam(ctx, "tub")
+	reindexId := strut.PathParam(ctx, "reindex_id")
+
+	reindex, err := web.db.GetReindex(ctx, tubname, reindexId)
+	if err != nil {
+		web.log.Error("error getting reindex", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.Reindex](http.StatusBadRequest,
+			fmt.Sprintf("error getting reindex, request_id: %s", requestId))
+	}
+	return strut.RespondOk(reindex)
+}
+
+func (web *Web) CancelReindex(ctx context.Context) strut.Response[ragnar.Reindex] {
+	requestId := GetRequestID(ctx)
+
+	tubname := strut.PathParam(ctx, "tub")
+	reindexId := strut.PathParam(ctx, "reindex_id")
+
+	reindex, err := web.db.CancelReindex(ctx, tubname, reindexId)
+	if err != nil {
+		web.log.Error("error cancelling reindex", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.Reindex](http.StatusBadRequest,
+			fmt.Sprintf("error cancelling reindex, request_id: %s", requestId))
+	}
+	return strut.RespondOk(reindex)
+}
+
+func (web *Web) startReindex(ctx context.Context, tubname string, stage string) (ragnar.Reindex, error) {
+	reindex, superseded, err := web.db.CreateReindex(ctx, tubname, stage)
+	if err != nil {
+		return reindex, fmt.Errorf("error creating reindex: %w", err)
+	}
+	err = web.docket.CancelReindexDocuments(superseded)
+	if err != nil {
+		return reindex, fmt.Errorf("error cancelling documents of previous reindex: %w", err)
+	}
+	err = web.docket.ScheduleTubReindex(reindex)
+	if err != nil {
+		return reindex, fmt.Errorf("error scheduling reindex: %w", err)
+	}
+	return reindex, nil
+}
+
+// reindexStage returns the earliest processing stage affected by the settings that changed between before and after,
+// or "" when the existing documents are not affected
+func reindexStage(before, after pgtype.Hstore) string {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	stage := ""
+	earlier := func(s string) {
+		stage = ragnar.EarlierReindexStage(stage, s)
+	}
+	for k := range keys {
+		if settingValue(before, k) == settingValue(after, k) {
+			continue
+		}
+		switch {
+		case k == "csv_format" || k == "converters" || k == "html_readability" || k == "ocr_languages":
+			earlier(ragnar.ReindexStageConvert)
+		case k == "chunk_contextualize" || k == "chunk_contextualize_model":
+			if (ragnar.Tub{Settings: after}).ChunkContextualize() {
+				earlier(ragnar.ReindexStageContextualize)
+			} else {
+				// stored contexts are left out of the embedded text when contextualization is off
+				earlier(ragnar.ReindexStageEmbed)
+			}
+		case strings.HasPrefix(k, "chunk_") || k == "join_table_rows":
+			earlier(ragnar.ReindexStageChunk)
+		case k == "embed_model":
+			// the semantic splitter and token sized chunks depend on the embedding model as well
+			if settingValue(after, "chunk_splitter") == "semantic" || settingValue(after, "chunk_size_unit") == "tokens" {
+				earlier(ragnar.ReindexStageChunk)
+			} else {
+				earlier(ragnar.ReindexStageEmbed)
+			}
+		case k == "embed_template":
+			earlier(ragnar.ReindexStageEmbed)
+		}
+	}
+	return stage
+}
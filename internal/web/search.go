@@ -2,35 +2,97 @@ package web
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/modfin/bellman/models/embed"
 	"github.com/modfin/bellman/services/voyageai"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/modfin/ragnar"
 	"github.com/modfin/strut"
 )
 
 func (web *Web) SearchXNN(ctx context.Context) strut.Response[[]ragnar.Chunk] {
+	search, errResponse := web.xnnSearchParams(ctx)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	web.log.Debug("SearchXNN", "tub", search.tub, "query", search.query, "limit", search.limit, "offset", search.offset, "scoring", search.scoring)
+
+	chunks, err := web.searchChunks(ctx, search.tub, search.embedModel, search.query, search.filter, search.scoring, search.limit, search.offset)
+	if err != nil {
+		web.log.Error("failed to search chunks", "error", err)
+		return strut.RespondError[[]ragnar.Chunk](http.StatusInternalServerError, "Failed to search chunks")
+	}
+	return strut.RespondOk(chunks)
+}
+
+// SearchXNNFaceted searches like SearchXNN, and counts the facets over the documents of the top hits
+func (web *Web) SearchXNNFaceted(ctx context.Context) strut.Response[ragnar.FacetedChunks] {
+	requestId := GetRequestID(ctx)
+
+	search, errResponse := web.xnnSearchParams(ctx)
+	if errResponse != nil {
+		return errResponse
+	}
+	fields, err := ragnar.ParseFacetFields(strut.QueryParam(ctx, "facets"))
+	if err != nil {
+		return strut.RespondError[ragnar.FacetedChunks](http.StatusBadRequest, fmt.Sprintf("Invalid 'facets' query parameter: %v", err))
+	}
+	if len(fields) == 0 {
+		return strut.RespondError[ragnar.FacetedChunks](http.StatusBadRequest, "No facets provided")
+	}
+	facetHits, err := strconv.Atoi(strut.QueryParam(ctx, "facet_hits"))
+	if err != nil || facetHits <= 0 {
+		facetHits = defaultFacetHits
+	}
+	facetHits = min(facetHits, maxSearchLimit)
+
+	web.log.Debug("SearchXNNFaceted", "tub", search.tub, "query", search.query, "limit", search.limit, "offset", search.offset, "scoring", search.scoring, "facets", fields)
+
+	// the page and the facets are taken from the same hits, so the query is embedded and searched once
+	hits, err := web.searchChunks(ctx, search.tub, search.embedModel, search.query, search.filter, search.scoring, max(search.limit+search.offset, facetHits), 0)
+	if err != nil {
+		web.log.Error("failed to search chunks", "error", err)
+		return strut.RespondError[ragnar.FacetedChunks](http.StatusInternalServerError, "Failed to search chunks")
+	}
+	facets, err := web.hitFacets(ctx, search.tub.TubName, search.filter, fields, hits[:min(facetHits, len(hits))])
+	if err != nil {
+		web.log.Error("Error counting search facets", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.FacetedChunks](http.StatusInternalServerError,
+			fmt.Sprintf("Error counting search facets, request_id: %s", requestId))
+	}
+	chunks := hits[min(search.offset, len(hits)):min(search.offset+search.limit, len(hits))]
+	return strut.RespondOk(ragnar.FacetedChunks{Chunks: chunks, Facets: facets})
+}
+
+// xnnSearch is a search of a tub, as given by the query parameters of the search endpoints
+type xnnSearch struct {
+	tub        ragnar.Tub
+	embedModel embed.Model
+	query      string
+	filter     ragnar.DocumentFilter
+	scoring    ragnar.SearchScoring
+	limit      int
+	offset     int
+}
+
+// xnnSearchParams reads the search from the query parameters, or returns the error response of the search endpoints
+func (web *Web) xnnSearchParams(ctx context.Context) (xnnSearch, strut.Response[[]ragnar.Chunk]) {
 	requestId := GetRequestID(ctx)
 
 	tubName := strut.PathParam(ctx, "tub")
 	tub, err := web.db.GetTub(ctx, tubName)
 	if err != nil {
-		return strut.RespondError[string](http.StatusBadRequest, "Tub not found")
+		return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusBadRequest, "Tub not found")
 	}
 
 	query := strut.QueryParam(ctx, "q")
 	if query == "" {
-		return strut.RespondError[string](http.StatusBadRequest, "No query provided")
-	}
-	filterStr := strut.QueryParam(ctx, "filter")
-	if filterStr == "" {
-		filterStr = "{}"
+		return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusBadRequest, "No query provided")
 	}
-
 	limit, err := strconv.Atoi(strut.QueryParam(ctx, "limit"))
 	if err != nil {
 		limit = 10
@@ -39,37 +101,276 @@ func (web *Web) SearchXNN(ctx context.Context) strut.Response[[]ragnar.Chunk] {
 	if err != nil {
 		offset = 0
 	}
+	limit, offset = searchLimits(limit, offset)
 
-	var filter ragnar.DocumentFilter
-	err = json.Unmarshal([]byte(filterStr), &filter)
+	filter, err := documentFilterParams(ctx)
 	if err != nil {
-		web.log.Error("Error unmarshalling filter json", "err", err, "request_id", requestId)
-		return strut.RespondError[[]ragnar.Document](http.StatusBadRequest,
-			fmt.Sprintf("Invalid JSON format in 'filter' query parameter, request_id: %s", requestId))
+		web.log.Error("Error parsing filter", "err", err, "request_id", requestId)
+		return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusBadRequest,
+			fmt.Sprintf("%v, request_id: %s", err, requestId))
 	}
 
-	web.log.Debug("SearchXNN", "tub", tub, "query", query, "limit", limit, "offset", offset)
+	scoring, err := tub.GetSearchScoring()
+	if err != nil {
+		web.log.Error("invalid tub search scoring", "err", err, "request_id", requestId)
+		return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusInternalServerError,
+			fmt.Sprintf("Invalid search scoring settings on tub: %v, request_id: %s", err, requestId))
+	}
+	override := ragnar.SearchScoring{
+		RecencyHalfLife: strut.QueryParam(ctx, "recency_half_life"),
+		RecencyField:    strut.QueryParam(ctx, "recency_field"),
+	}
+	if boosts := strut.QueryParam(ctx, "boosts"); boosts != "" {
+		override.Boosts, err = ragnar.ParseScoreBoosts(boosts)
+		if err != nil {
+			return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusBadRequest, fmt.Sprintf("Invalid 'boosts' query parameter: %v", err))
+		}
+	}
+	scoring = scoring.Override(override)
+	err = scoring.Validate()
+	if err != nil {
+		return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusBadRequest, fmt.Sprintf("Invalid scoring: %v", err))
+	}
+
+	embedModel, err := web.tubEmbedModel(tub)
+	if err != nil {
+		web.log.Error("failed to get model", "error", err)
+		return xnnSearch{}, strut.RespondError[[]ragnar.Chunk](http.StatusBadRequest, fmt.Sprintf("Could not find embedding model: %v", err))
+	}
+
+	return xnnSearch{
+		tub:        tub,
+		embedModel: embedModel,
+		query:      query,
+		filter:     filter,
+		scoring:    scoring,
+		limit:      limit,
+		offset:     offset,
+	}, nil
+}
 
+// tubEmbedModel resolves the embedding model configured for the tub
+func (web *Web) tubEmbedModel(tub ragnar.Tub) (embed.Model, error) {
 	embedModel := voyageai.EmbedModel_voyage_context_3 // default model
 	modelFQN, ok := tub.Settings["embed_model"]
 	if ok && modelFQN != nil {
-		embedModel, err = web.ai.EmbedModelOf(*modelFQN)
+		model, err := web.ai.EmbedModelOf(*modelFQN)
 		if err != nil {
-			web.log.Error("failed to get model", "error", err)
-			return strut.RespondError[string](http.StatusBadRequest, fmt.Sprintf("Could not find embedding model: %v", *modelFQN))
+			return embed.Model{}, fmt.Errorf("%s: %w", *modelFQN, err)
 		}
+		embedModel = model
 	}
+	return embedModel, nil
+}
+
+const (
+	defaultSearchLimit = 10
+	// maxSearchLimit and maxSearchOffset bound the hits a search may ask for, as the nearest limit+offset chunks
+	// are ranked for each search
+	maxSearchLimit  = 1000
+	maxSearchOffset = 10_000
+)
+
+// searchLimits returns the limit and offset of a search, defaulted and capped
+func searchLimits(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	return min(limit, maxSearchLimit), max(0, min(offset, maxSearchOffset))
+}
+
+// searchChunks embeds the query and returns the chunks of the tub closest to it, ranked by scoring
+func (web *Web) searchChunks(ctx context.Context, tub ragnar.Tub, embedModel embed.Model, query string, filter ragnar.DocumentFilter, scoring ragnar.SearchScoring, limit, offset int) ([]ragnar.Chunk, error) {
 	queryVector, err := web.ai.EmbedString(embedModel.WithType(embed.TypeQuery), query)
 	if err != nil {
-		web.log.Error("failed to embed query", "error", err)
-		return strut.RespondError[string](http.StatusInternalServerError, fmt.Sprintf("Failed to embed query"))
+		return nil, fmt.Errorf("failed to embed query: %w", err)
 	}
 
-	chunks, err := web.db.QueryChunkEmbeds(ctx, tub.TubName, embedModel, filter, queryVector, limit, offset)
+	return web.queryChunks(ctx, tub, embedModel, filter, scoring, queryVector, limit, offset)
+}
+
+// parentHitsPerResult is how many child hits are fetched per parent returned, as several children of the same
+// parent are often among the nearest hits
+const parentHitsPerResult = 4
+
+// queryChunks returns the chunks nearest to the vector. For tubs with parent/child chunking the children are
+// matched and their parents returned, one per parent, carrying the content and position of the parent.
+func (web *Web) queryChunks(ctx context.Context, tub ragnar.Tub, embedModel embed.Model, filter ragnar.DocumentFilter, scoring ragnar.SearchScoring, vector []float32, limit, offset int) ([]ragnar.Chunk, error) {
+	if tub.GetParentChunkSize() <= 0 {
+		chunks, err := web.db.QueryChunkEmbeds(ctx, tub.TubName, embedModel, filter, scoring, vector, limit, offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query chunk embeds: %w", err)
+		}
+		return chunks, nil
+	}
+
+	hits, err := web.db.QueryChunkEmbeds(ctx, tub.TubName, embedModel, filter, scoring, vector, (limit+offset)*parentHitsPerResult, 0)
 	if err != nil {
-		web.log.Error("failed to query chunk embeds", "error", err)
-		return strut.RespondError[string](http.StatusInternalServerError, fmt.Sprintf("Failed to query chunk embeds"))
+		return nil, fmt.Errorf("failed to query chunk embeds: %w", err)
 	}
 
-	return strut.RespondOk(chunks)
+	type parentKey struct {
+		documentId string
+		parentId   int
+	}
+	var chunks []ragnar.Chunk
+	seen := map[parentKey]bool{}
+	for _, hit := range hits {
+		if hit.ParentId != nil {
+			key := parentKey{hit.DocumentId, *hit.ParentId}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		chunks = append(chunks, hit)
+	}
+	if offset >= len(chunks) {
+		return []ragnar.Chunk{}, nil
+	}
+	chunks = chunks[offset:min(offset+limit, len(chunks))]
+
+	parents, err := web.db.GetParentChunks(ctx, tub.TubName, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parent chunks: %w", err)
+	}
+	byKey := map[parentKey]ragnar.ParentChunk{}
+	for _, p := range parents {
+		byKey[parentKey{p.DocumentId, p.ParentId}] = p
+	}
+	for i, chunk := range chunks {
+		if chunk.ParentId == nil {
+			// chunked before the tub used parents
+			continue
+		}
+		p, ok := byKey[parentKey{chunk.DocumentId, *chunk.ParentId}]
+		if !ok {
+			continue
+		}
+		chunks[i].Content = p.Content
+		chunks[i].HeadingPath = p.HeadingPath
+		chunks[i].StartOffset, chunks[i].EndOffset = p.StartOffset, p.EndOffset
+		chunks[i].PageStart, chunks[i].PageEnd = p.PageStart, p.PageEnd
+	}
+	return chunks, nil
+}
+
+// BatchSearchXNN searches the tub for many queries at once. All queries are embedded in a single request to the
+// embedding provider and the vector searches run concurrently, a failing query does not fail the batch.
+func (web *Web) BatchSearchXNN(ctx context.Context, req ragnar.BatchSearchRequest) strut.Response[[]ragnar.BatchSearchResult] {
+	requestId := GetRequestID(ctx)
+
+	tubName := strut.PathParam(ctx, "tub")
+	tub, err := web.db.GetTub(ctx, tubName)
+	if err != nil {
+		return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest, "Tub not found")
+	}
+
+	if len(req.Queries) == 0 {
+		return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest, "No queries provided")
+	}
+	if web.cfg.SearchBatchMaxQueries > 0 && len(req.Queries) > web.cfg.SearchBatchMaxQueries {
+		return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest,
+			fmt.Sprintf("Too many queries, at most %d queries are allowed per batch", web.cfg.SearchBatchMaxQueries))
+	}
+	for i, q := range req.Queries {
+		if q.Query == "" {
+			return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest,
+				fmt.Sprintf("No query provided for query %d, request_id: %s", i, requestId))
+		}
+		if q.QueryFilter != "" {
+			parsed, err := ragnar.ParseDocumentFilterQuery(q.QueryFilter)
+			if err != nil {
+				return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest,
+					fmt.Sprintf("Invalid q_filter for query %d: %v", i, err))
+			}
+			if req.Queries[i].Filter == nil {
+				req.Queries[i].Filter = ragnar.NewDocumentFilter()
+			}
+			for field, values := range parsed {
+				req.Queries[i].Filter[field] = append(req.Queries[i].Filter[field], values...)
+			}
+		}
+		if q.Scoring != nil {
+			err = q.Scoring.Validate()
+			if err != nil {
+				return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest,
+					fmt.Sprintf("Invalid scoring for query %d: %v", i, err))
+			}
+		}
+	}
+
+	web.log.Debug("BatchSearchXNN", "tub", tub, "queries", len(req.Queries))
+
+	embedModel, err := web.tubEmbedModel(tub)
+	if err != nil {
+		web.log.Error("failed to get model", "error", err)
+		return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusBadRequest, fmt.Sprintf("Could not find embedding model: %v", err))
+	}
+
+	results, err := web.batchSearchChunks(ctx, tub, embedModel, req.Queries)
+	if err != nil {
+		web.log.Error("failed to batch search chunks", "error", err, "request_id", requestId)
+		return strut.RespondError[[]ragnar.BatchSearchResult](http.StatusInternalServerError,
+			fmt.Sprintf("Failed to search chunks, request_id: %s", requestId))
+	}
+
+	return strut.RespondOk(results)
+}
+
+// batchSearchChunks embeds all queries in one go and runs the vector searches on a bounded number of goroutines.
+// Results are returned in the order of the queries, with per query errors set on the result.
+func (web *Web) batchSearchChunks(ctx context.Context, tub ragnar.Tub, embedModel embed.Model, queries []ragnar.SearchQuery) ([]ragnar.BatchSearchResult, error) {
+	texts := make([]string, len(queries))
+	for i, q := range queries {
+		texts[i] = q.Query
+	}
+	queryVectors, err := web.ai.EmbedStrings(embedModel.WithType(embed.TypeQuery), texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed queries: %w", err)
+	}
+
+	scoring, err := tub.GetSearchScoring()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tub search scoring: %w", err)
+	}
+
+	parallelism := web.cfg.SearchBatchParallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+
+	results := make([]ragnar.BatchSearchResult, len(queries))
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		limit, offset := searchLimits(q.Limit, q.Offset)
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			queryScoring := scoring
+			if q.Scoring != nil {
+				queryScoring = scoring.Override(*q.Scoring)
+			}
+
+			results[i].Query = q.Query
+			chunks, err := web.queryChunks(ctx, tub, embedModel, q.Filter, queryScoring, queryVectors[i], limit, offset)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Chunks = chunks
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
 }
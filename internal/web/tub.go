@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/modfin/ragnar"
+	"github.com/modfin/ragnar/internal/document"
+	"github.com/modfin/ragnar/internal/util"
 	"github.com/modfin/strut"
 )
 
@@ -14,6 +17,15 @@ func (web *Web) CreateTub(ctx context.Context, tub ragnar.Tub) strut.Response[ra
 
 	web.log.Info("Create tub request received")
 
+	err := tub.ValidateSettings()
+	if err != nil {
+		return strut.RespondError[ragnar.Tub](http.StatusBadRequest, fmt.Sprintf("error creating tub: %v", err))
+	}
+	err = web.validateConverters(tub)
+	if err != nil {
+		return strut.RespondError[ragnar.Tub](http.StatusBadRequest, fmt.Sprintf("error creating tub: %v", err))
+	}
+
 	restub, err := web.db.CreateTub(ctx, tub)
 	if err != nil {
 		web.log.Error("error creating tub", "err", err, "request_id", requestId)
@@ -49,15 +61,82 @@ func (web *Web) GetTub(ctx context.Context) strut.Response[ragnar.Tub] {
 	return strut.RespondOk(tub)
 }
 
+// GetTubSettings returns the settings a tub can have with the defaults used for settings that are not set,
+// documenting the keys and values of Tub.Settings
+func (web *Web) GetTubSettings(ctx context.Context) strut.Response[ragnar.TubSettings] {
+	requestId := GetRequestID(ctx)
+
+	settings := ragnar.DefaultTubSettings()
+	embedModel, err := web.tubEmbedModel(ragnar.Tub{})
+	if err != nil {
+		web.log.Error("error getting default embed model", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.TubSettings](http.StatusInternalServerError, "err getting default settings, request_id: "+requestId)
+	}
+	settings.EmbedModel = util.Ptr(embedModel.FQN())
+	genModel, err := web.ai.GenModelOf("")
+	if err == nil {
+		settings.ChunkContextualizeModel = util.Ptr(genModel.FQN())
+	}
+	return strut.RespondOk(settings)
+}
+
+func (web *Web) GetTubTokenUsage(ctx context.Context) strut.Response[[]ragnar.TokenUsage] {
+	requestId := GetRequestID(ctx)
+
+	tubname := strut.PathParam(ctx, "tub")
+	documentId := strut.QueryParam(ctx, "document_id")
+	var since time.Time
+	if s := strut.QueryParam(ctx, "since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return strut.RespondError[[]ragnar.TokenUsage](http.StatusBadRequest, fmt.Sprintf("invalid since, %v", err))
+		}
+	}
+
+	usage, err := web.db.GetTokenUsage(ctx, tubname, documentId, since)
+	if err != nil {
+		web.log.Error("error getting token usage", "err", err, "request_id", requestId)
+		return strut.RespondError[[]ragnar.TokenUsage](http.StatusInternalServerError,
+			fmt.Sprintf("error getting token usage, request_id: %s", requestId))
+	}
+	return strut.RespondOk(usage)
+}
+
 func (web *Web) UpdateTub(ctx context.Context, tub ragnar.Tub) strut.Response[ragnar.Tub] {
 	requestId := GetRequestID(ctx)
 
-	err := web.db.UpdateTub(ctx, tub)
+	err := tub.ValidateSettings()
+	if err != nil {
+		return strut.RespondError[ragnar.Tub](http.StatusBadRequest, fmt.Sprintf("error updating settings: %v", err))
+	}
+	err = web.validateConverters(tub)
+	if err != nil {
+		return strut.RespondError[ragnar.Tub](http.StatusBadRequest, fmt.Sprintf("error updating settings: %v", err))
+	}
+
+	oldTub, err := web.db.GetTub(ctx, tub.TubName)
+	if err != nil {
+		web.log.Error("error getting tub", "err", err, "request_id", requestId)
+		return strut.RespondError[ragnar.Tub](http.StatusBadRequest, fmt.Sprintf("error updating settings: %v", err))
+	}
+
+	err = web.db.UpdateTub(ctx, tub)
 	if err != nil {
 		web.log.Error("error updating tub", "err", err, "request_id", requestId)
 		return strut.RespondError[ragnar.Tub](http.StatusBadRequest, fmt.Sprintf("error updating settings: %v", err))
 	}
 
+	if stage := reindexStage(oldTub.Settings, tub.Settings); stage != "" {
+		reindex, err := web.startReindex(ctx, tub.TubName, stage)
+		if err != nil {
+			web.log.Error("error starting reindex", "err", err, "request_id", requestId)
+			return strut.RespondError[ragnar.Tub](http.StatusInternalServerError,
+				fmt.Sprintf("settings updated but documents could not be scheduled for reindexing, request_id: %s", requestId))
+		}
+		web.log.Info("settings changed, started reindex", "tub", tub.TubName, "stage", stage, "reindex_id", reindex.ReindexId, "documents", reindex.Total, "request_id", requestId)
+	}
+
 	tub, err = web.db.GetTub(ctx, tub.TubName)
 	if err != nil {
 		web.log.Error("error getting tub list", "err", err, "request_id", requestId)
@@ -92,3 +171,27 @@ func (web *Web) DeleteTub(ctx context.Context) strut.Response[ragnar.Tub] {
 
 	return strut.RespondOk(tub)
 }
+
+func settingValue(settings map[string]*string, key string) string {
+	val, ok := settings[key]
+	if !ok || val == nil {
+		return ""
+	}
+	return *val
+}
+
+// validateConverters checks that the converters preferred by the tub settings are registered and that the OCR
+// languages are installed
+func (web *Web) validateConverters(tub ragnar.Tub) error {
+	settings, err := ragnar.ParseTubSettings(tub.Settings)
+	if err != nil {
+		return err
+	}
+	if settings.OcrLanguages != nil {
+		err = document.ValidateOcrLanguages(*settings.OcrLanguages)
+		if err != nil {
+			return err
+		}
+	}
+	return web.converters.ValidatePreferences(settings.ConverterPreferences())
+}
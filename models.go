@@ -3,9 +3,13 @@ package ragnar
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgtype"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -23,7 +27,7 @@ type AccessToken struct {
 type Tub struct {
 	TubId     string        `db:"tub_id" json:"tub_id"`
 	TubName   string        `db:"tub_name" json:"tub_name"`
-	Settings  pgtype.Hstore `db:"settings" json:"settings"`
+	Settings  pgtype.Hstore `db:"settings" json:"settings" json-description:"Tub settings as strings, the keys and values are described by TubSettings, see GET /tub-settings"`
 	CreatedAt time.Time     `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time     `db:"updated_at" json:"updated_at"`
 	DeletedAt *time.Time    `db:"deleted_at" json:"-"`
@@ -49,6 +53,557 @@ func (t Tub) GetRequiredDocumentHeaders() []string {
 	return strings.Split(*val, ",")
 }
 
+// ValidateSettings checks that every setting of the tub is known and holds a valid value
+func (t Tub) ValidateSettings() error {
+	_, err := ParseTubSettings(t.Settings)
+	return err
+}
+
+// TubSettings are the typed settings of a tub. They are stored and sent as the string values of Tub.Settings,
+// ParseTubSettings and Hstore convert between the two. Unset settings are nil and fall back to their defaults.
+type TubSettings struct {
+	Description *string `json:"description,omitempty" json-description:"Free text description of the tub"`
+
+	EmbedModel    *string `json:"embed_model,omitempty" json-description:"Embedding model as provider/name, e.g. VoyageAI/voyage-context-3" json-pattern:"^[^/]+/.+$"`
+	EmbedTemplate *string `json:"embed_template,omitempty" json-description:"Go template building the text embedded for each chunk, e.g. {{.Headers.title}} > {{.HeadingPath}}\n\n{{.Content}}"`
+
+	ChunkSplitter           *string  `json:"chunk_splitter,omitempty" json-description:"Splitter of the chunks, default markdown" json-enum:"markdown,recursive,token,semantic,code"`
+	ChunkSize               *int     `json:"chunk_size,omitempty" json-description:"Maximum chunk size, default 512" json-minimum:"1"`
+	ChunkSizeUnit           *string  `json:"chunk_size_unit,omitempty" json-description:"Unit of the chunk sizes, characters (default) or tokens of the embedding model" json-enum:"characters,tokens"`
+	ChunkOverlap            *int     `json:"chunk_overlap,omitempty" json-description:"Overlap between adjacent chunks, default 0" json-minimum:"0"`
+	ChunkSeparators         []string `json:"chunk_separators,omitempty" json-description:"Separators the text is split on, comma separated in the stored settings, default a blank line"`
+	ChunkHeadingHierarchy   *bool    `json:"chunk_heading_hierarchy,omitempty" json-description:"Prefix markdown chunks with the headings they are under, default true"`
+	JoinTableRows           *bool    `json:"join_table_rows,omitempty" json-description:"Keep markdown table rows together in chunks, default true"`
+	ChunkParentSize         *int     `json:"chunk_parent_size,omitempty" json-description:"Size of the parent chunks the chunks are split from, 0 (default) disables parent chunks" json-minimum:"0"`
+	ChunkSemanticPercentile *float64 `json:"chunk_semantic_percentile,omitempty" json-description:"Semantic splitter, adjacent similarities below this percentile start a new chunk, default 5" json-minimum:"0" json-maximum:"100"`
+	ChunkSemanticMinSize    *int     `json:"chunk_semantic_min_size,omitempty" json-description:"Semantic splitter, chunks are not cut on topic shifts before this size, default chunk_size / 4" json-minimum:"0"`
+	ChunkSemanticBuffer     *int     `json:"chunk_semantic_buffer,omitempty" json-description:"Semantic splitter, neighbouring sentences embedded together with each sentence, default 1" json-minimum:"0"`
+	ChunkContextualize      *bool    `json:"chunk_contextualize,omitempty" json-description:"Have the gen model write a short context for every chunk, default false"`
+	ChunkContextualizeModel *string  `json:"chunk_contextualize_model,omitempty" json-description:"Gen model writing the chunk contexts as provider/name" json-pattern:"^[^/]+/.+$"`
+
+	CsvFormat       *string `json:"csv_format,omitempty" json-description:"How CSV and TSV files are converted, table (default) as markdown tables repeating the header row, or records with every row as a record of column: value lines" json-enum:"table,records"`
+	Converters      *string `json:"converters,omitempty" json-description:"Converters preferred for content types and file extensions that more than one converter converts, e.g. application/pdf=marker,.doc=libreoffice"`
+	HtmlReadability *bool   `json:"html_readability,omitempty" json-description:"Convert only the main content of HTML pages, dropping scripts, navigation, banners and footers, and add their title and description as headers, default true"`
+	OcrLanguages    *string `json:"ocr_languages,omitempty" json-description:"Tesseract languages scanned PDFs and images are read in, joined by +, default eng, e.g. eng+swe" json-pattern:"^[A-Za-z_]+(\\+[A-Za-z_]+)*$"`
+
+	RequiredDocumentHeaders []string `json:"required_document_headers,omitempty" json-description:"Headers every document must have, comma separated in the stored settings"`
+
+	SearchRecencyField    *string `json:"search_recency_field,omitempty" json-description:"Document header holding the timestamp recency decay is computed from, default updated_at"`
+	SearchRecencyHalfLife *string `json:"search_recency_half_life,omitempty" json-description:"Half-life of the search recency decay, e.g. 30d or 12h"`
+	SearchBoosts          *string `json:"search_boosts,omitempty" json-description:"Search score boosts, e.g. source=official:2,tier=gold:1.5"`
+}
+
+// DefaultTubSettings returns the chunking defaults used for the settings a tub does not set
+func DefaultTubSettings() TubSettings {
+	return TubSettings{
+		ChunkSplitter:           ptr("markdown"),
+		ChunkSize:               ptr(512),
+		ChunkSizeUnit:           ptr("characters"),
+		ChunkOverlap:            ptr(0),
+		ChunkSeparators:         []string{"\n\n"},
+		ChunkHeadingHierarchy:   ptr(true),
+		JoinTableRows:           ptr(true),
+		ChunkParentSize:         ptr(0),
+		ChunkSemanticPercentile: ptr(5.0),
+		ChunkSemanticBuffer:     ptr(1),
+		ChunkContextualize:      ptr(false),
+		CsvFormat:               ptr("table"),
+		HtmlReadability:         ptr(true),
+		OcrLanguages:            ptr("eng"),
+	}
+}
+
+// ParseTubSettings parses the stored string settings of a tub. Unknown keys and invalid values are reported together
+// in the returned error, the returned settings still hold every value that parsed, so settings stored before they
+// were validated can be read leniently.
+func ParseTubSettings(settings pgtype.Hstore) (TubSettings, error) {
+	var s TubSettings
+	var errs []error
+
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		val := settings[key]
+		if val == nil {
+			continue
+		}
+		var err error
+		switch key {
+		case "description":
+			s.Description = ptr(*val)
+		case "embed_model":
+			s.EmbedModel, err = parseModelSetting(key, *val)
+		case "embed_template":
+			s.EmbedTemplate = ptr(*val)
+		case "chunk_splitter":
+			s.ChunkSplitter, err = parseEnumSetting(key, *val, "markdown", "recursive", "token", "semantic", "code")
+		case "chunk_size":
+			s.ChunkSize, err = parseIntSetting(key, *val, 1)
+		case "chunk_size_unit":
+			s.ChunkSizeUnit, err = parseEnumSetting(key, *val, "characters", "tokens")
+		case "chunk_overlap":
+			s.ChunkOverlap, err = parseIntSetting(key, *val, 0)
+		case "chunk_separators":
+			s.ChunkSeparators = strings.Split(*val, ",")
+		case "chunk_heading_hierarchy":
+			s.ChunkHeadingHierarchy, err = parseBoolSetting(key, *val)
+		case "join_table_rows":
+			s.JoinTableRows, err = parseBoolSetting(key, *val)
+		case "chunk_parent_size":
+			s.ChunkParentSize, err = parseIntSetting(key, *val, 0)
+		case "chunk_semantic_percentile":
+			s.ChunkSemanticPercentile, err = parseFloatSetting(key, *val, 0, 100)
+		case "chunk_semantic_min_size":
+			s.ChunkSemanticMinSize, err = parseIntSetting(key, *val, 0)
+		case "chunk_semantic_buffer":
+			s.ChunkSemanticBuffer, err = parseIntSetting(key, *val, 0)
+		case "chunk_contextualize":
+			s.ChunkContextualize, err = parseBoolSetting(key, *val)
+		case "chunk_contextualize_model":
+			s.ChunkContextualizeModel, err = parseModelSetting(key, *val)
+		case "csv_format":
+			s.CsvFormat, err = parseEnumSetting(key, *val, "table", "records")
+		case "converters":
+			_, err = parseConverters(*val)
+			if err == nil {
+				s.Converters = ptr(*val)
+			}
+		case "html_readability":
+			s.HtmlReadability, err = parseBoolSetting(key, *val)
+		case "ocr_languages":
+			s.OcrLanguages, err = parseOcrLanguagesSetting(key, *val)
+		case "required_document_headers":
+			s.RequiredDocumentHeaders = strings.Split(*val, ",")
+		case "search_recency_field":
+			s.SearchRecencyField = ptr(*val)
+		case "search_recency_half_life":
+			s.SearchRecencyHalfLife = ptr(*val)
+		case "search_boosts":
+			s.SearchBoosts = ptr(*val)
+		default:
+			err = fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if s.ChunkSize != nil && s.ChunkOverlap != nil && *s.ChunkOverlap >= *s.ChunkSize {
+		errs = append(errs, fmt.Errorf("invalid chunk_overlap setting %d, must be smaller than chunk_size %d", *s.ChunkOverlap, *s.ChunkSize))
+	}
+	tub := Tub{Settings: settings}
+	if _, err := tub.GetSearchScoring(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := tub.GetEmbedTemplate(); err != nil {
+		errs = append(errs, err)
+	}
+	return s, errors.Join(errs...)
+}
+
+func parseIntSetting(key, val string, minimum int) (*int, error) {
+	i, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil || i < minimum {
+		return nil, fmt.Errorf("invalid %s setting %q, must be an integer of at least %d", key, val, minimum)
+	}
+	return &i, nil
+}
+
+func parseFloatSetting(key, val string, minimum, maximum float64) (*float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil || f < minimum || f > maximum {
+		return nil, fmt.Errorf("invalid %s setting %q, must be a number between %g and %g", key, val, minimum, maximum)
+	}
+	return &f, nil
+}
+
+func parseBoolSetting(key, val string) (*bool, error) {
+	b, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s setting %q, must be true or false", key, val)
+	}
+	return &b, nil
+}
+
+func parseEnumSetting(key, val string, allowed ...string) (*string, error) {
+	for _, a := range allowed {
+		if val == a {
+			return &val, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s setting %q, must be one of %s", key, val, strings.Join(allowed, ", "))
+}
+
+func parseOcrLanguagesSetting(key, val string) (*string, error) {
+	for _, lang := range strings.Split(val, "+") {
+		if lang == "" || strings.TrimLeft(lang, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+			return nil, fmt.Errorf("invalid %s setting %q, must be tesseract languages joined by +, e.g. eng+swe", key, val)
+		}
+	}
+	return &val, nil
+}
+
+// parseConverters parses the converters setting, content types and extensions mapped to the names of converters
+func parseConverters(val string) (map[string]string, error) {
+	converters := map[string]string{}
+	for _, part := range strings.Split(val, ",") {
+		key, name, found := strings.Cut(strings.TrimSpace(part), "=")
+		key, name = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(name)
+		if !found || name == "" || !(strings.HasPrefix(key, ".") && len(key) > 1 || strings.Contains(key, "/")) {
+			return nil, fmt.Errorf("invalid converters setting %q, must be content types or extensions with a converter, e.g. application/pdf=marker,.doc=libreoffice", val)
+		}
+		converters[key] = name
+	}
+	return converters, nil
+}
+
+func parseModelSetting(key, val string) (*string, error) {
+	provider, name, found := strings.Cut(val, "/")
+	if !found || provider == "" || name == "" {
+		return nil, fmt.Errorf("invalid %s setting %q, must be a model as provider/name", key, val)
+	}
+	return &val, nil
+}
+
+// Hstore returns the settings as the string values stored in Tub.Settings, unset settings are left out
+func (s TubSettings) Hstore() pgtype.Hstore {
+	h := pgtype.Hstore{}
+	set := func(key string, val *string) {
+		if val != nil {
+			h[key] = val
+		}
+	}
+	itoa := func(i *int) *string {
+		if i == nil {
+			return nil
+		}
+		return ptr(strconv.Itoa(*i))
+	}
+	btoa := func(b *bool) *string {
+		if b == nil {
+			return nil
+		}
+		return ptr(strconv.FormatBool(*b))
+	}
+	join := func(ss []string) *string {
+		if ss == nil {
+			return nil
+		}
+		return ptr(strings.Join(ss, ","))
+	}
+
+	set("description", s.Description)
+	set("embed_model", s.EmbedModel)
+	set("embed_template", s.EmbedTemplate)
+	set("chunk_splitter", s.ChunkSplitter)
+	set("chunk_size", itoa(s.ChunkSize))
+	set("chunk_size_unit", s.ChunkSizeUnit)
+	set("chunk_overlap", itoa(s.ChunkOverlap))
+	set("chunk_separators", join(s.ChunkSeparators))
+	set("chunk_heading_hierarchy", btoa(s.ChunkHeadingHierarchy))
+	set("join_table_rows", btoa(s.JoinTableRows))
+	set("chunk_parent_size", itoa(s.ChunkParentSize))
+	if s.ChunkSemanticPercentile != nil {
+		set("chunk_semantic_percentile", ptr(strconv.FormatFloat(*s.ChunkSemanticPercentile, 'f', -1, 64)))
+	}
+	set("chunk_semantic_min_size", itoa(s.ChunkSemanticMinSize))
+	set("chunk_semantic_buffer", itoa(s.ChunkSemanticBuffer))
+	set("chunk_contextualize", btoa(s.ChunkContextualize))
+	set("chunk_contextualize_model", s.ChunkContextualizeModel)
+	set("csv_format", s.CsvFormat)
+	set("converters", s.Converters)
+	set("html_readability", btoa(s.HtmlReadability))
+	set("ocr_languages", s.OcrLanguages)
+	set("required_document_headers", join(s.RequiredDocumentHeaders))
+	set("search_recency_field", s.SearchRecencyField)
+	set("search_recency_half_life", s.SearchRecencyHalfLife)
+	set("search_boosts", s.SearchBoosts)
+	return h
+}
+
+func (s TubSettings) WithDescription(description string) TubSettings {
+	s.Description = &description
+	return s
+}
+
+func (s TubSettings) WithEmbedModel(modelFQN string) TubSettings {
+	s.EmbedModel = &modelFQN
+	return s
+}
+
+func (s TubSettings) WithEmbedTemplate(tmpl string) TubSettings {
+	s.EmbedTemplate = &tmpl
+	return s
+}
+
+// WithChunkSplitter sets the splitter, one of markdown, recursive, token, semantic or code
+func (s TubSettings) WithChunkSplitter(splitter string) TubSettings {
+	s.ChunkSplitter = &splitter
+	return s
+}
+
+func (s TubSettings) WithChunkSize(size int) TubSettings {
+	s.ChunkSize = &size
+	return s
+}
+
+// WithChunkSizeUnit sets the unit of the chunk sizes, characters or tokens
+func (s TubSettings) WithChunkSizeUnit(unit string) TubSettings {
+	s.ChunkSizeUnit = &unit
+	return s
+}
+
+func (s TubSettings) WithChunkOverlap(overlap int) TubSettings {
+	s.ChunkOverlap = &overlap
+	return s
+}
+
+func (s TubSettings) WithChunkSeparators(separators ...string) TubSettings {
+	s.ChunkSeparators = separators
+	return s
+}
+
+func (s TubSettings) WithChunkHeadingHierarchy(on bool) TubSettings {
+	s.ChunkHeadingHierarchy = &on
+	return s
+}
+
+func (s TubSettings) WithJoinTableRows(on bool) TubSettings {
+	s.JoinTableRows = &on
+	return s
+}
+
+func (s TubSettings) WithChunkParentSize(size int) TubSettings {
+	s.ChunkParentSize = &size
+	return s
+}
+
+// WithSemanticChunking selects the semantic splitter with its breakpoint percentile, minimum size and buffer
+func (s TubSettings) WithSemanticChunking(percentile float64, minSize, buffer int) TubSettings {
+	s.ChunkSplitter = ptr("semantic")
+	s.ChunkSemanticPercentile = &percentile
+	s.ChunkSemanticMinSize = &minSize
+	s.ChunkSemanticBuffer = &buffer
+	return s
+}
+
+// WithChunkContextualize turns on chunk contextualization, with the default gen model when modelFQN is empty
+func (s TubSettings) WithChunkContextualize(modelFQN string) TubSettings {
+	s.ChunkContextualize = ptr(true)
+	s.ChunkContextualizeModel = nil
+	if modelFQN != "" {
+		s.ChunkContextualizeModel = &modelFQN
+	}
+	return s
+}
+
+// WithCsvFormat sets how CSV and TSV files are converted, table or records
+func (s TubSettings) WithCsvFormat(format string) TubSettings {
+	s.CsvFormat = &format
+	return s
+}
+
+// WithConverter prefers the named converter for the content type or extension, e.g. application/pdf or .pdf
+func (s TubSettings) WithConverter(typeOrExtension string, name string) TubSettings {
+	converters := s.ConverterPreferences()
+	converters[strings.ToLower(typeOrExtension)] = name
+	keys := make([]string, 0, len(converters))
+	for k := range converters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + converters[k]
+	}
+	joined := strings.Join(parts, ",")
+	s.Converters = &joined
+	return s
+}
+
+// ConverterPreferences returns the converters setting as the names of the preferred converters by content type and
+// extension, an invalid setting is left out
+func (s TubSettings) ConverterPreferences() map[string]string {
+	if s.Converters == nil {
+		return map[string]string{}
+	}
+	converters, err := parseConverters(*s.Converters)
+	if err != nil {
+		return map[string]string{}
+	}
+	return converters
+}
+
+// WithHtmlReadability turns the extraction of the main content of HTML pages on or off
+func (s TubSettings) WithHtmlReadability(on bool) TubSettings {
+	s.HtmlReadability = &on
+	return s
+}
+
+// WithOcrLanguages sets the tesseract languages scanned PDFs and images are read in, e.g. eng and swe
+func (s TubSettings) WithOcrLanguages(languages ...string) TubSettings {
+	joined := strings.Join(languages, "+")
+	s.OcrLanguages = &joined
+	return s
+}
+
+func (s TubSettings) WithRequiredDocumentHeaders(headers ...string) TubSettings {
+	s.RequiredDocumentHeaders = headers
+	return s
+}
+
+// WithSearchRecency decays search scores by the age in field, e.g. updated_at, with the half-life, e.g. 30d
+func (s TubSettings) WithSearchRecency(field string, halfLife string) TubSettings {
+	s.SearchRecencyField = &field
+	s.SearchRecencyHalfLife = &halfLife
+	return s
+}
+
+func (s TubSettings) WithSearchBoosts(boosts ...ScoreBoost) TubSettings {
+	var parts []string
+	for _, b := range boosts {
+		parts = append(parts, fmt.Sprintf("%s=%s:%g", b.Field, b.Value, b.Factor))
+	}
+	joined := strings.Join(parts, ",")
+	s.SearchBoosts = &joined
+	return s
+}
+
+// WithSettings sets the given settings on the tub, leaving its other settings as they are
+func (t Tub) WithSettings(settings TubSettings) Tub {
+	merged := pgtype.Hstore{}
+	for k, v := range t.Settings {
+		merged[k] = v
+	}
+	for k, v := range settings.Hstore() {
+		merged[k] = v
+	}
+	t.Settings = merged
+	return t
+}
+
+// GetSettings returns the typed settings of the tub, see ParseTubSettings
+func (t Tub) GetSettings() (TubSettings, error) {
+	return ParseTubSettings(t.Settings)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// ChunkContextualize reports whether the chunk_contextualize setting is on, in which case the gen model writes a
+// short context for every chunk that is stored and embedded with it
+func (t Tub) ChunkContextualize() bool {
+	if t.Settings == nil {
+		return false
+	}
+	val, ok := t.Settings["chunk_contextualize"]
+	if !ok || val == nil {
+		return false
+	}
+	b, _ := strconv.ParseBool(strings.TrimSpace(*val))
+	return b
+}
+
+// GetParentChunkSize returns the chunk_parent_size setting, 0 when the tub does not use parent/child chunking
+func (t Tub) GetParentChunkSize() int {
+	if t.Settings == nil {
+		return 0
+	}
+	val, ok := t.Settings["chunk_parent_size"]
+	if !ok || val == nil {
+		return 0
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(*val))
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// EmbedTemplateData is the data available to the embed_template tub setting
+type EmbedTemplateData struct {
+	Headers     map[string]string
+	HeadingPath string
+	Content     string
+	Context     string
+	DocumentId  string
+	ChunkId     int
+}
+
+// GetEmbedTemplate returns the parsed embed_template setting, or nil if the tub has none. The template builds the
+// text sent for embedding from each chunk, e.g. "{{.Headers.title}} > {{.HeadingPath}}\n\n{{.Content}}",
+// while the stored chunk content is left as is.
+func (t Tub) GetEmbedTemplate() (*template.Template, error) {
+	if t.Settings == nil {
+		return nil, nil
+	}
+	val, ok := t.Settings["embed_template"]
+	if !ok || val == nil || *val == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("embed_template").Option("missingkey=zero").Parse(*val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid embed_template setting: %w", err)
+	}
+	// execute once to catch references to fields that do not exist
+	_, err = RenderEmbedText(tmpl, Document{}, Chunk{Content: "content"})
+	if err != nil {
+		return nil, fmt.Errorf("invalid embed_template setting: %w", err)
+	}
+	return tmpl, nil
+}
+
+// RenderEmbedText renders the text to embed for a chunk of the document
+func RenderEmbedText(tmpl *template.Template, doc Document, chunk Chunk) (string, error) {
+	data := EmbedTemplateData{
+		Headers:     map[string]string{},
+		HeadingPath: chunk.HeadingPath,
+		Content:     chunk.Content,
+		Context:     chunk.Context,
+		DocumentId:  chunk.DocumentId,
+		ChunkId:     chunk.ChunkId,
+	}
+	for k, v := range doc.Headers {
+		if v != nil {
+			data.Headers[k] = *v
+		}
+	}
+	var sb strings.Builder
+	err := tmpl.Execute(&sb, data)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// GetSearchScoring returns the default search scoring modifiers of the tub,
+// read from the search_recency_half_life, search_recency_field and search_boosts settings
+func (t Tub) GetSearchScoring() (SearchScoring, error) {
+	var scoring SearchScoring
+	if t.Settings == nil {
+		return scoring, nil
+	}
+	if val, ok := t.Settings["search_recency_half_life"]; ok && val != nil {
+		scoring.RecencyHalfLife = *val
+	}
+	if val, ok := t.Settings["search_recency_field"]; ok && val != nil {
+		scoring.RecencyField = *val
+	}
+	if val, ok := t.Settings["search_boosts"]; ok && val != nil {
+		boosts, err := ParseScoreBoosts(*val)
+		if err != nil {
+			return scoring, fmt.Errorf("invalid search_boosts setting: %w", err)
+		}
+		scoring.Boosts = boosts
+	}
+	return scoring, scoring.Validate()
+}
+
 type Document struct {
 	DocumentId string `db:"document_id" json:"document_id" json-description:"Document uuid"`
 	TubId      string `db:"tub_id" json:"tub_id" json-description:"Tub id"`
@@ -60,11 +615,41 @@ type Document struct {
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at" json-description:"Updated at"`
 }
 
+// HeaderParentDocumentId is the header of the documents ingested from files embedded in another document, such as
+// the attachments of an email, holding the document_id of that document
+const HeaderParentDocumentId = "parent_document_id"
+
+// HeaderContentTypeDetected is the header holding the content type detected from the content of an uploaded file,
+// next to its declared content-type. Files whose declared content type and file name can not be converted are
+// converted as the detected type.
+const HeaderContentTypeDetected = "content-type-detected"
+
+// HeaderDerivedHeaders is the header listing, comma separated, the headers derived from the file of the document when
+// it was converted, such as the title of a web page or the subject of an email. They are replaced when the document
+// is converted again, headers given at upload are never replaced by derived ones
+const HeaderDerivedHeaders = "derived_headers"
+
+// HeaderArchivePath is the header of the documents expanded from an uploaded archive, holding the path of the file in
+// the archive
+const HeaderArchivePath = "archive_path"
+
+// ArchiveUpload is the result of uploading a ZIP or tar archive, which is expanded into one document per supported file
+type ArchiveUpload struct {
+	Documents []Document     `json:"documents" json-description:"The documents created, one per supported file, with the path of the file in the archive_path header"`
+	Errors    []ArchiveError `json:"errors" json-description:"The files of the archive that did not become documents"`
+}
+
+type ArchiveError struct {
+	Path  string `json:"path" json-description:"Path of the file in the archive"`
+	Error string `json:"error" json-description:"Why the file did not become a document"`
+}
+
 // FilterOperator represents a comparison operator for document filtering
 type FilterOperator string
 
 const (
 	OpEqual              FilterOperator = "$eq"  // Equal to
+	OpNotEqual           FilterOperator = "$ne"  // Not equal to, also matches documents without the field
 	OpGreaterThan        FilterOperator = "$gt"  // Greater than
 	OpGreaterThanOrEqual FilterOperator = "$gte" // Greater than or equal
 	OpLessThan           FilterOperator = "$lt"  // Less than
@@ -86,6 +671,9 @@ type FilterCondition struct {
 	Operator  FilterOperator `json:"operator"`
 	Value     string         `json:"value"`
 	ValueType ValueType      `json:"type,omitempty"` // Optional type hint for comparison, defaults to text
+	// Not negates the condition, matching every document it does not match, including those without the field.
+	// In JSON filters it is written {"$not": {"$gte": "10"}}
+	Not bool `json:"not,omitempty"`
 }
 
 // FilterValue can be either a simple string (for equality), an array of strings (for $in),
@@ -180,6 +768,19 @@ func (df *DocumentFilter) UnmarshalJSON(data []byte) error {
 
 // parseFilterCondition parses a map into a FilterValue with a Condition
 func parseFilterCondition(fieldName string, condMap map[string]interface{}) (FilterValue, error) {
+	if negated, ok := condMap["$not"]; ok {
+		inner, ok := negated.(map[string]interface{})
+		if !ok || len(condMap) != 1 {
+			return FilterValue{}, fmt.Errorf("$not must be the only key, and hold a condition object, for field %s", fieldName)
+		}
+		fv, err := parseFilterCondition(fieldName, inner)
+		if err != nil {
+			return FilterValue{}, err
+		}
+		fv.Condition.Not = !fv.Condition.Not
+		return fv, nil
+	}
+
 	var operator FilterOperator
 	var value string
 	var valueType ValueType = ValueTypeText // default to text
@@ -228,28 +829,14 @@ func (df DocumentFilter) MarshalJSON() ([]byte, error) {
 			} else if fv.Array != nil {
 				result[key] = fv.Array
 			} else if fv.Condition != nil {
-				conditionMap := map[string]string{
-					string(fv.Condition.Operator): fv.Condition.Value,
-				}
-				// Only include type if it's not the default (text)
-				if fv.Condition.ValueType != "" && fv.Condition.ValueType != ValueTypeText {
-					conditionMap["type"] = string(fv.Condition.ValueType)
-				}
-				result[key] = conditionMap
+				result[key] = filterConditionJSON(fv.Condition)
 			}
 		} else {
 			// Multiple filter values - output as array of conditions
-			conditions := make([]map[string]string, len(filterValues))
+			conditions := make([]any, len(filterValues))
 			for i, fv := range filterValues {
 				if fv.Condition != nil {
-					conditionMap := map[string]string{
-						string(fv.Condition.Operator): fv.Condition.Value,
-					}
-					// Only include type if it's not the default (text)
-					if fv.Condition.ValueType != "" && fv.Condition.ValueType != ValueTypeText {
-						conditionMap["type"] = string(fv.Condition.ValueType)
-					}
-					conditions[i] = conditionMap
+					conditions[i] = filterConditionJSON(fv.Condition)
 				}
 				// Note: Simple and Array types don't make sense in multi-value context
 			}
@@ -260,6 +847,21 @@ func (df DocumentFilter) MarshalJSON() ([]byte, error) {
 	return json.Marshal(result)
 }
 
+// filterConditionJSON is the JSON filter form of the condition, e.g. {"$gte": "10", "type": "integer"}
+func filterConditionJSON(c *FilterCondition) any {
+	conditionMap := map[string]string{
+		string(c.Operator): c.Value,
+	}
+	// Only include type if it's not the default (text)
+	if c.ValueType != "" && c.ValueType != ValueTypeText {
+		conditionMap["type"] = string(c.ValueType)
+	}
+	if c.Not {
+		return map[string]any{"$not": conditionMap}
+	}
+	return conditionMap
+}
+
 func (df DocumentFilter) WithEqual(field, value string) DocumentFilter {
 	if df == nil {
 		df = NewDocumentFilter()
@@ -354,6 +956,178 @@ type DocumentStatus struct {
 	Status string `db:"status" json:"status" json-description:"Document status" json-enum:"pending,processing,completed,failed"`
 }
 
+// FacetField requests value counts over a document header. Text fields are counted per distinct value,
+// integer and numeric fields are counted in equal width range buckets between the min and max value.
+type FacetField struct {
+	Field     string    `json:"field"`
+	ValueType ValueType `json:"type,omitempty"`
+	// Buckets is the number of range buckets for integer and numeric fields, defaults to 10
+	Buckets int `json:"buckets,omitempty"`
+}
+
+// ParseFacetFields parses a comma separated list of facets on the form field[:type[:buckets]],
+// e.g. "department,priority:integer,price:numeric:5"
+func ParseFacetFields(s string) ([]FacetField, error) {
+	var facets []FacetField
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid facet %q, expected field[:type[:buckets]]", part)
+		}
+		facet := FacetField{Field: strings.ToLower(fields[0]), ValueType: ValueTypeText}
+		if len(fields) > 1 {
+			facet.ValueType = ValueType(fields[1])
+			switch facet.ValueType {
+			case ValueTypeText, ValueTypeInteger, ValueTypeNumeric:
+			default:
+				return nil, fmt.Errorf("invalid facet %q, unknown type %q", part, fields[1])
+			}
+		}
+		if len(fields) > 2 {
+			buckets, err := strconv.Atoi(fields[2])
+			if err != nil || buckets < 1 {
+				return nil, fmt.Errorf("invalid facet %q, buckets must be a positive integer", part)
+			}
+			facet.Buckets = buckets
+		}
+		facets = append(facets, facet)
+	}
+	return facets, nil
+}
+
+type FacetValue struct {
+	Value string `db:"value" json:"value" json-description:"Header value"`
+	Count int    `db:"count" json:"count" json-description:"Number of documents with the value"`
+}
+
+type FacetRange struct {
+	From  float64 `db:"range_from" json:"from" json-description:"Lower bound of the bucket, inclusive"`
+	To    float64 `db:"range_to" json:"to" json-description:"Upper bound of the bucket, exclusive except for the last bucket"`
+	Count int     `db:"count" json:"count" json-description:"Number of documents in the bucket"`
+}
+
+// Facet holds the document counts of one requested facet field
+type Facet struct {
+	Field     string       `json:"field" json-description:"Document header"`
+	ValueType ValueType    `json:"type" json-description:"text, integer or numeric"`
+	Values    []FacetValue `json:"values,omitempty" json-description:"Counts per value, for text facets"`
+	Ranges    []FacetRange `json:"ranges,omitempty" json-description:"Counts per range bucket, for integer and numeric facets"`
+}
+
+// FacetedDocuments is the result of listing documents with facets, the page of documents and the facet counts over
+// all documents matching the filter
+type FacetedDocuments struct {
+	Documents []Document `json:"documents" json-description:"The page of documents"`
+	Facets    []Facet    `json:"facets" json-description:"Facet counts over all documents matching the filter"`
+}
+
+// FacetedChunks is the result of a search with facets, the page of chunks and the facet counts over the documents
+// of the top hits
+type FacetedChunks struct {
+	Chunks []Chunk `json:"chunks" json-description:"The page of chunks best matching the search"`
+	Facets []Facet `json:"facets" json-description:"Facet counts over the documents of the top hits"`
+}
+
+// ScoreBoost multiplies the score of chunks whose document header Field equals Value by Factor
+type ScoreBoost struct {
+	Field  string  `json:"field" json-description:"Document header to match"`
+	Value  string  `json:"value" json-description:"Header value that triggers the boost"`
+	Factor float64 `json:"factor" json-description:"Multiplier applied to the score, e.g. 2 doubles it and 0.5 halves it"`
+}
+
+// ParseScoreBoosts parses boosts on the form "source=official:2,tier=gold:1.5"
+func ParseScoreBoosts(s string) ([]ScoreBoost, error) {
+	var boosts []ScoreBoost
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fieldValue, factorStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("boost %q is missing a factor, expected field=value:factor", part)
+		}
+		field, value, ok := strings.Cut(fieldValue, "=")
+		if !ok || field == "" {
+			return nil, fmt.Errorf("boost %q is missing a field, expected field=value:factor", part)
+		}
+		factor, err := strconv.ParseFloat(factorStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("boost %q has an invalid factor: %w", part, err)
+		}
+		boosts = append(boosts, ScoreBoost{Field: strings.ToLower(field), Value: value, Factor: factor})
+	}
+	return boosts, nil
+}
+
+// SearchScoring holds the modifiers combined with vector similarity when ranking search results.
+// The final score is similarity * recency decay * the factor of every matching boost.
+type SearchScoring struct {
+	RecencyHalfLife string       `json:"recency_half_life,omitempty" json-description:"Half-life of the recency decay, e.g. 30d or 12h. Empty disables decay"`
+	RecencyField    string       `json:"recency_field,omitempty" json-description:"updated_at (default) or the name of a date header used for the decay"`
+	Boosts          []ScoreBoost `json:"boosts,omitempty" json-description:"Multiplicative boosts for header values"`
+}
+
+// Override returns the scoring with every field set in o replacing the corresponding field of s
+func (s SearchScoring) Override(o SearchScoring) SearchScoring {
+	if o.RecencyHalfLife != "" {
+		s.RecencyHalfLife = o.RecencyHalfLife
+	}
+	if o.RecencyField != "" {
+		s.RecencyField = o.RecencyField
+	}
+	if o.Boosts != nil {
+		s.Boosts = o.Boosts
+	}
+	return s
+}
+
+// HalfLife parses RecencyHalfLife, which is a Go duration or a number of days such as 30d. Zero means no decay.
+func (s SearchScoring) HalfLife() (time.Duration, error) {
+	if s.RecencyHalfLife == "" || s.RecencyHalfLife == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s.RecencyHalfLife, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid recency half-life %q: %w", s.RecencyHalfLife, err)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s.RecencyHalfLife)
+	if err != nil {
+		return 0, fmt.Errorf("invalid recency half-life %q: %w", s.RecencyHalfLife, err)
+	}
+	return d, nil
+}
+
+func (s SearchScoring) Validate() error {
+	halfLife, err := s.HalfLife()
+	if err != nil {
+		return err
+	}
+	if halfLife < 0 {
+		return fmt.Errorf("recency half-life must not be negative")
+	}
+	for _, b := range s.Boosts {
+		if b.Field == "" {
+			return fmt.Errorf("boost is missing a field")
+		}
+		if b.Factor < 0 {
+			return fmt.Errorf("boost factor for %s=%s must not be negative", b.Field, b.Value)
+		}
+	}
+	return nil
+}
+
+func (s SearchScoring) IsZero() bool {
+	return s.RecencyHalfLife == "" && len(s.Boosts) == 0
+}
+
 type Chunk struct {
 	TubId      string `db:"tub_id" json:"tub_id" json-description:"Tub id"`
 	TubName    string `db:"tub_name" json:"tub_name" json-description:"Tub name"`
@@ -361,11 +1135,156 @@ type Chunk struct {
 	ChunkId    int    `db:"chunk_id" json:"chunk_id" json-description:"Chunk identifier"`
 
 	Content string `db:"content" json:"content" json-description:"Fetched chunk content"`
+	Context string `db:"context" json:"context,omitempty" json-description:"LLM generated context situating the chunk in its document, embedded together with the content"`
+	// ContextModel is the FQN of the gen model that wrote the context, contexts are written again when the
+	// chunk_contextualize_model of the tub changes
+	ContextModel string `db:"context_model" json:"-"`
+
+	HeadingPath string `db:"heading_path" json:"heading_path,omitempty" json-description:"The markdown headings the chunk is under, joined by ' > '"`
+	StartOffset *int   `db:"start_offset" json:"start_offset,omitempty" json-description:"Byte offset in the document markdown where the chunk starts"`
+	EndOffset   *int   `db:"end_offset" json:"end_offset,omitempty" json-description:"Byte offset in the document markdown where the chunk ends"`
+	PageStart   *int   `db:"page_start" json:"page_start,omitempty" json-description:"First page of the chunk, for paged sources such as PDF"`
+	PageEnd     *int   `db:"page_end" json:"page_end,omitempty" json-description:"Last page of the chunk, for paged sources such as PDF"`
+
+	Symbol string `db:"symbol" json:"symbol,omitempty" json-description:"Top-level code symbol(s) declared in the chunk, for source code split by the code splitter"`
+
+	ParentId *int `db:"parent_id" json:"parent_id,omitempty" json-description:"Parent chunk the chunk was split from, when the tub uses parent/child chunking. Searches then return the parent content"`
+
+	ContentHash string `db:"content_hash" json:"content_hash,omitempty" json-description:"SHA-256 of the content, a chunk with unchanged content keeps its embedding when the document is re-chunked"`
+	// EmbedHash is the SHA-256 of the text last embedded for the chunk, chunks are only re-embedded when it changes
+	EmbedHash string `db:"embed_hash" json:"-"`
+
+	Similarity *float64 `db:"similarity" json:"similarity,omitempty" json-description:"Vector similarity to the query, only set for searches"`
+	Score      *float64 `db:"score" json:"score,omitempty" json-description:"Similarity combined with recency decay and boosts, only set for searches"`
 
 	CreatedAt time.Time `db:"created_at" json:"created_at" json-description:"Created at"`
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at" json-description:"Updated at"`
 }
 
+// ParentChunk is a larger passage of a document that is split into the child chunks that are embedded.
+// Searches match on the children and hand back the parent content
+type ParentChunk struct {
+	TubId      string `db:"tub_id" json:"tub_id"`
+	TubName    string `db:"tub_name" json:"tub_name"`
+	DocumentId string `db:"document_id" json:"document_id"`
+	ParentId   int    `db:"parent_id" json:"parent_id"`
+
+	Content string `db:"content" json:"content"`
+
+	HeadingPath string `db:"heading_path" json:"heading_path,omitempty"`
+	StartOffset *int   `db:"start_offset" json:"start_offset,omitempty"`
+	EndOffset   *int   `db:"end_offset" json:"end_offset,omitempty"`
+	PageStart   *int   `db:"page_start" json:"page_start,omitempty"`
+	PageEnd     *int   `db:"page_end" json:"page_end,omitempty"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ChunkPreviewRequest is chunked with candidate settings without storing anything, to tune the chunking of a tub
+type ChunkPreviewRequest struct {
+	Markdown   string        `json:"markdown,omitempty" json-description:"Markdown to chunk, either markdown or document_id is required"`
+	Filename   string        `json:"filename,omitempty" json-description:"File name of the markdown, selects the language of the code splitter"`
+	DocumentId string        `json:"document_id,omitempty" json-description:"Existing document whose markdown is chunked"`
+	Settings   pgtype.Hstore `json:"settings,omitempty" json-description:"Candidate chunk_* settings, overriding the settings of the tub"`
+}
+
+// ChunkPreview is the outcome of chunking with candidate settings
+type ChunkPreview struct {
+	Chunks  []Chunk       `json:"chunks" json-description:"The chunks the markdown is split into"`
+	Parents []ParentChunk `json:"parents,omitempty" json-description:"The parent chunks, when chunk_parent_size is set"`
+	Stats   ChunkStats    `json:"stats" json-description:"Size statistics of the chunks"`
+}
+
+// ChunkStats summarizes chunk sizes in characters and in tokens of the embedding model's tokenizer
+type ChunkStats struct {
+	Count       int     `json:"count"`
+	TotalChars  int     `json:"total_chars"`
+	MinChars    int     `json:"min_chars"`
+	MaxChars    int     `json:"max_chars"`
+	MeanChars   float64 `json:"mean_chars"`
+	P50Chars    float64 `json:"p50_chars"`
+	P95Chars    float64 `json:"p95_chars"`
+	Tokens      int     `json:"tokens" json-description:"Tokens of all chunks"`
+	MaxTokens   int     `json:"max_tokens" json-description:"Tokens of the largest chunk"`
+	Tokenizer   string  `json:"tokenizer,omitempty" json-description:"The tokenizer tokens are counted with"`
+	TokensExact bool    `json:"tokens_exact" json-description:"False when the tokenizer only approximates the embedding model's tokenizer"`
+}
+
+// TokenUsage is the number of model tokens spent on a document for a purpose, e.g. chunk-contextualize
+type TokenUsage struct {
+	TubId        string    `db:"tub_id" json:"tub_id" json-description:"Tub id"`
+	TubName      string    `db:"tub_name" json:"tub_name" json-description:"Tub name"`
+	DocumentId   string    `db:"document_id" json:"document_id,omitempty" json-description:"Document the tokens were spent on"`
+	Purpose      string    `db:"purpose" json:"purpose" json-description:"What the tokens were spent on"`
+	Model        string    `db:"model" json:"model" json-description:"Fully qualified model name"`
+	Calls        int       `db:"calls" json:"calls" json-description:"Number of model calls"`
+	InputTokens  int       `db:"input_tokens" json:"input_tokens" json-description:"Input tokens"`
+	OutputTokens int       `db:"output_tokens" json:"output_tokens" json-description:"Output tokens"`
+	TotalTokens  int       `db:"total_tokens" json:"total_tokens" json-description:"Total tokens"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at,omitempty" json-description:"Recorded at"`
+}
+
+// Reindex stages, the stage a reindex re-processes the documents of a tub from
+const (
+	ReindexStageConvert       = "convert"
+	ReindexStageChunk         = "chunk"
+	ReindexStageContextualize = "contextualize"
+	ReindexStageEmbed         = "embed"
+)
+
+// EarlierReindexStage returns the stage of a and b that re-processes the most, "" is not a stage
+func EarlierReindexStage(a, b string) string {
+	order := map[string]int{"": 0, ReindexStageEmbed: 1, ReindexStageContextualize: 2, ReindexStageChunk: 3, ReindexStageConvert: 4}
+	if order[b] > order[a] {
+		return b
+	}
+	return a
+}
+
+// Reindex statuses
+const (
+	ReindexStatusRunning   = "running"
+	ReindexStatusCompleted = "completed"
+	ReindexStatusCancelled = "cancelled"
+	ReindexStatusFailed    = "failed"
+)
+
+// Reindex is the re-processing of all documents of a tub, e.g. after its chunking or embedding settings changed.
+// Documents are scheduled on the docket a few at a time, so that a reindex does not starve new uploads
+type Reindex struct {
+	ReindexId string `db:"reindex_id" json:"reindex_id" json-description:"Reindex id"`
+	TubId     string `db:"tub_id" json:"tub_id" json-description:"Tub id"`
+	TubName   string `db:"tub_name" json:"tub_name" json-description:"Tub name"`
+	Stage     string `db:"stage" json:"stage" json-description:"The stage the documents are re-processed from, convert, chunk, contextualize or embed"`
+	Status    string `db:"status" json:"status" json-description:"running, completed, cancelled or failed"`
+
+	Total   int `db:"total" json:"total" json-description:"Number of documents to re-process"`
+	Pending int `db:"pending" json:"pending" json-description:"Documents not yet scheduled"`
+	Running int `db:"running" json:"running" json-description:"Documents scheduled and being processed"`
+	Done    int `db:"done" json:"done" json-description:"Documents re-processed"`
+	Failed  int `db:"failed" json:"failed" json-description:"Documents that failed to be re-processed"`
+
+	CreatedAt  time.Time  `db:"created_at" json:"created_at" json-description:"Created at"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updated_at" json-description:"Updated at"`
+	FinishedAt *time.Time `db:"finished_at" json:"finished_at,omitempty" json-description:"Completed, cancelled or failed at"`
+}
+
+// ReindexDocument is the state of a document in a reindex, pending, scheduled, done or failed
+type ReindexDocument struct {
+	ReindexId   string     `db:"reindex_id" json:"reindex_id"`
+	DocumentId  string     `db:"document_id" json:"document_id"`
+	Status      string     `db:"status" json:"status"`
+	Error       string     `db:"error" json:"error,omitempty"`
+	ScheduledAt *time.Time `db:"scheduled_at" json:"scheduled_at,omitempty"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// ReindexRequest starts a reindex of a tub
+type ReindexRequest struct {
+	Stage string `json:"stage,omitempty" json-description:"The stage to re-process the documents from, convert, chunk (default), contextualize or embed. Convert converts the uploaded files again, replacing markdown uploaded with them"`
+}
+
 type ChunkReference struct {
 	TubId      string `db:"tub_id" json:"tub_id" json-description:"Tub id"`
 	TubName    string `db:"tub_name" json:"tub_name" json-description:"Tub name"`
@@ -373,6 +1292,29 @@ type ChunkReference struct {
 	ChunkId    int    `db:"chunk_id" json:"chunk_id" json-description:"Chunk identifier"`
 }
 
+// SearchQuery is a single query of a batch search
+type SearchQuery struct {
+	Query  string         `json:"query" json-description:"Free text search query"`
+	Filter DocumentFilter `json:"filter,omitempty" json-description:"Optional filter on document headers"`
+	Limit  int            `json:"limit,omitempty" json-description:"Optional limit, defaults to 10"`
+	Offset int            `json:"offset,omitempty" json-description:"Optional offset"`
+
+	QueryFilter string `json:"q_filter,omitempty" json-description:"Optional filter in the query string syntax, AND-ed with filter"`
+
+	Scoring *SearchScoring `json:"scoring,omitempty" json-description:"Optional scoring modifiers, overriding the tub defaults"`
+}
+
+type BatchSearchRequest struct {
+	Queries []SearchQuery `json:"queries" json-description:"The queries to search for, embedded together in one request"`
+}
+
+// BatchSearchResult holds the hits of one query in a batch search, in the same position as the query
+type BatchSearchResult struct {
+	Query  string  `json:"query" json-description:"The query searched for"`
+	Chunks []Chunk `json:"chunks" json-description:"The chunks best matching the query"`
+	Error  string  `json:"error,omitempty" json-description:"Set if the query failed, other queries are unaffected"`
+}
+
 type HStore map[string]any
 
 func (j *HStore) Scan(value any) error {
@@ -416,3 +1358,123 @@ func (j HStore) Value() (driver.Value, error) {
 
 	return strings.Join(parts, ", "), nil
 }
+
+// EvalRelevance marks a document, or a single chunk of it when ChunkId is set, as relevant for an eval query
+type EvalRelevance struct {
+	DocumentId string `json:"document_id" json-description:"Relevant document uuid"`
+	ChunkId    *int   `json:"chunk_id,omitempty" json-description:"Optional relevant chunk, if omitted any chunk of the document counts"`
+	Grade      int    `json:"grade,omitempty" json-description:"Graded relevance used by nDCG, defaults to 1"`
+}
+
+// EvalQuery is a single query of an evaluation set together with its known relevant results
+type EvalQuery struct {
+	Query    string          `json:"query" json-description:"Free text search query"`
+	Filter   DocumentFilter  `json:"filter,omitempty" json-description:"Optional document filter applied to the search"`
+	Relevant []EvalRelevance `json:"relevant" json-description:"Documents or chunks that are relevant for the query"`
+}
+
+type EvalQueries []EvalQuery
+
+func (q *EvalQueries) Scan(value any) error {
+	return scanJSONB(value, q)
+}
+
+func (q EvalQueries) Value() (driver.Value, error) {
+	if q == nil {
+		q = EvalQueries{}
+	}
+	return valueJSONB(q)
+}
+
+// EvalSet is a named set of evaluation queries stored for a tub
+type EvalSet struct {
+	EvalSetId   string      `db:"eval_set_id" json:"eval_set_id" json-description:"Eval set id"`
+	TubId       string      `db:"tub_id" json:"tub_id" json-description:"Tub id"`
+	TubName     string      `db:"tub_name" json:"tub_name" json-description:"Tub name"`
+	EvalSetName string      `db:"eval_set_name" json:"eval_set_name" json-description:"Eval set name, unique within the tub"`
+	Queries     EvalQueries `db:"queries" json:"queries" json-description:"Evaluation queries"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at" json-description:"Created at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at" json-description:"Updated at"`
+}
+
+// EvalMetrics holds the retrieval metrics of an eval run, averaged over all queries
+type EvalMetrics struct {
+	K       int     `json:"k" json-description:"Number of search results considered per query"`
+	Queries int     `json:"queries" json-description:"Number of evaluated queries"`
+	Failed  int     `json:"failed" json-description:"Number of queries where search failed, scored as 0"`
+	Recall  float64 `json:"recall" json-description:"Mean recall@k"`
+	MRR     float64 `json:"mrr" json-description:"Mean reciprocal rank"`
+	NDCG    float64 `json:"ndcg" json-description:"Mean nDCG@k"`
+}
+
+func (m *EvalMetrics) Scan(value any) error {
+	return scanJSONB(value, m)
+}
+
+func (m EvalMetrics) Value() (driver.Value, error) {
+	return valueJSONB(m)
+}
+
+// EvalQueryResult holds the metrics of a single query in an eval run
+type EvalQueryResult struct {
+	Query          string  `json:"query" json-description:"The evaluated query"`
+	Recall         float64 `json:"recall" json-description:"Recall@k"`
+	ReciprocalRank float64 `json:"reciprocal_rank" json-description:"1/rank of the first relevant hit, 0 if none"`
+	NDCG           float64 `json:"ndcg" json-description:"nDCG@k"`
+	Error          string  `json:"error,omitempty" json-description:"Search error, if any"`
+}
+
+type EvalQueryResults []EvalQueryResult
+
+func (r *EvalQueryResults) Scan(value any) error {
+	return scanJSONB(value, r)
+}
+
+func (r EvalQueryResults) Value() (driver.Value, error) {
+	if r == nil {
+		r = EvalQueryResults{}
+	}
+	return valueJSONB(r)
+}
+
+// EvalRun is the stored outcome of running an eval set through search. The tub settings and
+// embedding model are snapshotted so that runs can be compared over time
+type EvalRun struct {
+	EvalRunId   string           `db:"eval_run_id" json:"eval_run_id" json-description:"Eval run id"`
+	EvalSetId   string           `db:"eval_set_id" json:"eval_set_id" json-description:"Eval set id"`
+	TubId       string           `db:"tub_id" json:"tub_id" json-description:"Tub id"`
+	TubName     string           `db:"tub_name" json:"tub_name" json-description:"Tub name"`
+	EvalSetName string           `db:"eval_set_name" json:"eval_set_name" json-description:"Eval set name"`
+	Settings    pgtype.Hstore    `db:"settings" json:"settings" json-description:"Tub settings at the time of the run"`
+	EmbedModel  string           `db:"embed_model" json:"embed_model" json-description:"Embedding model used for the run"`
+	Metrics     EvalMetrics      `db:"metrics" json:"metrics" json-description:"Aggregated metrics"`
+	Results     EvalQueryResults `db:"results" json:"results" json-description:"Per query metrics"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at" json-description:"Created at"`
+}
+
+// EvalRunRequest configures a run of an eval set
+type EvalRunRequest struct {
+	K int `json:"k,omitempty" json-description:"Number of search results considered per query, defaults to 10, at most 1000"`
+}
+
+func scanJSONB(value any, dest any) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	}
+	return fmt.Errorf("unsupported jsonb value type %T", value)
+}
+
+func valueJSONB(v any) (driver.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,129 @@
+package ragnar
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParseTubSettings(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name     string
+		settings pgtype.Hstore
+		wantErr  []string
+	}{
+		{
+			name: "valid",
+			settings: pgtype.Hstore{
+				"description":               str("test tub"),
+				"embed_model":               str("VoyageAI/voyage-context-3"),
+				"chunk_splitter":            str("semantic"),
+				"chunk_size":                str("400"),
+				"chunk_overlap":             str("20"),
+				"converters":                str("application/pdf=marker, .DOC=libreoffice"),
+				"chunk_semantic_percentile": str("7.5"),
+				"join_table_rows":           str("false"),
+				"ocr_languages":             str("eng+swe+chi_sim"),
+				"required_document_headers": str("title,lang"),
+				"search_boosts":             str("source=official:2"),
+			},
+		},
+		{
+			name:     "null values are ignored",
+			settings: pgtype.Hstore{"chunk_size": nil},
+		},
+		{
+			name:     "unknown key",
+			settings: pgtype.Hstore{"chunk_sise": str("400")},
+			wantErr:  []string{`unknown setting "chunk_sise"`},
+		},
+		{
+			name: "invalid values are all reported",
+			settings: pgtype.Hstore{
+				"chunk_size":     str("abc"),
+				"chunk_splitter": str("words"),
+				"embed_model":    str("voyage-context-3"),
+				"ocr_languages":  str("eng,swe"),
+			},
+			wantErr: []string{"invalid chunk_size", "invalid chunk_splitter", "invalid embed_model", "invalid ocr_languages"},
+		},
+		{
+			name:     "overlap not smaller than size",
+			settings: pgtype.Hstore{"chunk_size": str("100"), "chunk_overlap": str("100")},
+			wantErr:  []string{"invalid chunk_overlap"},
+		},
+		{
+			name:     "converters",
+			settings: pgtype.Hstore{"converters": str("pdf=marker")},
+			wantErr:  []string{"invalid converters"},
+		},
+		{
+			name:     "search boosts",
+			settings: pgtype.Hstore{"search_boosts": str("source=official")},
+			wantErr:  []string{"invalid search_boosts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTubSettings(tt.settings)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTubSettingsHstore(t *testing.T) {
+	settings := TubSettings{}.
+		WithEmbedModel("VoyageAI/voyage-3").
+		WithChunkSize(300).
+		WithChunkSeparators("\n\n", "\n").
+		WithJoinTableRows(false).
+		WithSemanticChunking(10, 50, 2).
+		WithRequiredDocumentHeaders("title").
+		WithHtmlReadability(false).
+		WithOcrLanguages("eng", "swe").
+		WithConverter("application/pdf", "marker").
+		WithConverter(".doc", "libreoffice").
+		WithSearchBoosts(ScoreBoost{Field: "source", Value: "official", Factor: 1.5})
+
+	h := settings.Hstore()
+	if got := *h["chunk_semantic_percentile"]; got != "10" {
+		t.Errorf("chunk_semantic_percentile = %q, want 10", got)
+	}
+	if got := *h["search_boosts"]; got != "source=official:1.5" {
+		t.Errorf("search_boosts = %q, want source=official:1.5", got)
+	}
+
+	parsed, err := ParseTubSettings(h)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, settings) {
+		t.Errorf("round trip got %+v, want %+v", parsed, settings)
+	}
+	if got := parsed.ConverterPreferences(); !reflect.DeepEqual(got, map[string]string{"application/pdf": "marker", ".doc": "libreoffice"}) {
+		t.Errorf("converter preferences = %v", got)
+	}
+
+	description := "kept"
+	tub := Tub{Settings: pgtype.Hstore{"description": &description}}.WithSettings(TubSettings{}.WithChunkSize(100))
+	if len(tub.Settings) != 2 || *tub.Settings["chunk_size"] != "100" {
+		t.Errorf("WithSettings did not merge settings, got %v", tub.Settings)
+	}
+}